@@ -0,0 +1,38 @@
+// Command schemagen writes the JSON Schemas of the n8n messages, generated
+// from the types in internal/contract, so that workflows can be checked
+// against them without reading Go.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"ringtonic-backend/internal/contract"
+)
+
+func main() {
+	outDir := flag.String("out", "schemas", "Directory to write the schemas to")
+	flag.Parse()
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", *outDir, err)
+		os.Exit(1)
+	}
+
+	for _, schema := range []*contract.Schema{contract.JobRequestSchema, contract.CallbackSchema} {
+		data, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode %s: %v\n", schema.ID, err)
+			os.Exit(1)
+		}
+		path := filepath.Join(*outDir, schema.ID)
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Println("Wrote", path)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
@@ -11,17 +12,25 @@ import (
 	"time"
 
 	"ringtonic-backend/internal/api"
+	"ringtonic-backend/internal/bundle"
 	"ringtonic-backend/internal/config"
 	"ringtonic-backend/internal/files"
 	"ringtonic-backend/internal/jobs"
+	"ringtonic-backend/internal/links"
 	applog "ringtonic-backend/internal/log"
 	"ringtonic-backend/internal/n8n"
+	"ringtonic-backend/internal/privacy"
+	"ringtonic-backend/internal/processor"
+	"ringtonic-backend/internal/quota"
+	"ringtonic-backend/internal/signing"
 	"ringtonic-backend/internal/store"
 )
 
 func main() {
 	// Parse command line flags
 	migrate := flag.Bool("migrate", false, "Run database migrations and exit")
+	reconcile := flag.Bool("reconcile", false, "Reconcile storage with the database, print a report and exit")
+	dryRun := flag.Bool("dry-run", false, "With -reconcile, report changes without applying them")
 	flag.Parse()
 
 	// Load configuration
@@ -52,21 +61,188 @@ func main() {
 	}
 
 	// Initialize file storage
-	fileManager := files.New(cfg.StoragePath, logger)
+	fileManager, err := newFileManager(cfg, logger)
+	if err != nil {
+		logger.Error("Failed to initialize file storage", "error", err)
+		os.Exit(1)
+	}
+	fileManager.SetMaxFileSize(cfg.MaxFileSize)
+
+	// Storage reconciliation
+	cleanupOptions := files.CleanupOptions{
+		GracePeriod:     cfg.ReconcileGracePeriod,
+		SourceRetention: cfg.SourceRetention,
+		DryRun:          cfg.ReconcileDryRun || *dryRun,
+	}
+
+	if *reconcile {
+		report, err := fileManager.CleanupOldFiles(database, cleanupOptions)
+		if err != nil {
+			logger.Error("Storage reconciliation failed", "error", err)
+			os.Exit(1)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+
+	stopReconciler := make(chan struct{})
+	if cfg.ReconcileInterval > 0 {
+		go fileManager.ScheduleCleanup(database, cleanupOptions, cfg.ReconcileInterval, stopReconciler)
+	}
+
+	// Webhooks to n8n and its callbacks are signed with the shared secrets
+	webhookKeys, err := webhookKeyring(cfg)
+	if err != nil {
+		logger.Error("Invalid webhook secrets", "error", err)
+		os.Exit(1)
+	}
+	webhookSigner := n8n.NewSigner(webhookKeys, cfg.WebhookSignatureTolerance, cfg.WebhookLegacyToken)
+	logger.Info("Webhook secrets loaded", "primary", webhookKeys.PrimaryID(), "key_ids", webhookKeys.IDs())
+	if cfg.WebhookLegacyToken {
+		logger.Warn("Legacy webhook tokens are enabled; callbacks are accepted without signatures")
+	}
+
+	// URLs handed to clients and workers are built from the base URLs
+	baseLinks, err := links.New(cfg.PublicBaseURL, cfg.CallbackBaseURL)
+	if err != nil {
+		logger.Error("Invalid base URL", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Base URLs configured", "public", cfg.PublicBaseURL, "callback", cfg.CallbackBaseURL)
+
+	// Initialize the job processor
+	var jobProcessor jobs.ProcessorInterface
+	var localProcessor *processor.Local
+	var n8nBreaker *n8n.Breaker
+	var n8nClient *n8n.Client
+	switch cfg.Processor {
+	case "n8n":
+		endpoints := []n8n.Endpoint{{URL: cfg.N8NWebhookURL, Weight: 1}}
+		if cfg.N8NWebhookURLs != "" {
+			endpoints, err = n8n.ParseEndpoints(cfg.N8NWebhookURLs)
+			if err != nil {
+				logger.Error("Invalid n8n webhook URLs", "error", err)
+				os.Exit(1)
+			}
+		}
+		n8nClient, err = n8n.NewBalanced(n8n.BalancerConfig{
+			Endpoints: endpoints,
+			Strategy:  cfg.N8NBalancing,
+			EjectFor:  cfg.N8NEjectDuration,
+		}, webhookSigner, logger)
+		if err != nil {
+			logger.Error("Invalid n8n balancing configuration", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Dispatching jobs to n8n", "endpoints", len(endpoints), "balancing", cfg.N8NBalancing)
+		if cfg.N8NBreakerThreshold > 0 {
+			// Without an explicit health URL every instance is probed, and
+			// the circuit closes as soon as any of them is back
+			healthURLs := []string{cfg.N8NHealthURL}
+			if cfg.N8NHealthURL == "" {
+				healthURLs, err = n8n.DefaultHealthURLs(endpoints)
+				if err != nil {
+					logger.Error("Invalid n8n webhook URL", "error", err)
+					os.Exit(1)
+				}
+			}
+			n8nBreaker = n8n.NewBreaker(n8n.BreakerConfig{
+				FailureThreshold: cfg.N8NBreakerThreshold,
+				ProbeInterval:    cfg.N8NBreakerProbeInterval,
+				HealthURLs:       healthURLs,
+			}, logger)
+			n8nClient.SetBreaker(n8nBreaker)
+		}
+		jobProcessor = n8nClient
+	case "local":
+		localProcessor = processor.NewLocal(processor.LocalConfig{
+			YTDLPPath:   cfg.LocalProcessor.YTDLPPath,
+			FFmpegPath:  cfg.LocalProcessor.FFmpegPath,
+			WorkDir:     cfg.LocalProcessor.WorkDir,
+			Timeout:     cfg.LocalProcessor.Timeout,
+			Concurrency: cfg.LocalProcessor.Concurrency,
+		}, logger)
+		jobProcessor = localProcessor
+		logger.Info("Processing jobs locally", "yt_dlp", cfg.LocalProcessor.YTDLPPath, "ffmpeg", cfg.LocalProcessor.FFmpegPath)
+	default:
+		logger.Error("Unknown processor", "processor", cfg.Processor)
+		os.Exit(1)
+	}
+
+	// Initialize download URL signing and per-job worker tokens
+	downloadKeys := signing.RandomKeyring()
+	if cfg.DownloadSigningKeys != "" {
+		downloadKeys, err = signing.ParseKeyring(cfg.DownloadSigningKeys)
+		if err != nil {
+			logger.Error("Invalid download signing keys", "error", err)
+			os.Exit(1)
+		}
+	} else {
+		logger.Warn("DOWNLOAD_SIGNING_KEYS not set, download URLs and worker tokens will not survive a restart")
+	}
+	downloadSigner := signing.NewDownloadSigner(downloadKeys, cfg.DownloadURLTTL)
+	uploadTokens := signing.NewJobTokens(downloadKeys, signing.PurposeUpload)
+	sourceTokens := signing.NewJobTokens(downloadKeys, signing.PurposeSource)
 
-	// Initialize n8n client
-	n8nClient := n8n.New(cfg.N8NWebhookURL, cfg.N8NWebhookSecret, logger)
+	// Initialize storage quotas
+	quotaGuard := quota.New(database, fileManager, quota.Limits{
+		UserBytes:            cfg.UserQuotaBytes,
+		UserFiles:            cfg.UserQuotaFiles,
+		LowWatermarkPercent:  cfg.DiskLowWatermarkPercent,
+		HighWatermarkPercent: cfg.DiskHighWatermarkPercent,
+	}, logger)
 
 	// Initialize job manager
-	jobManager := jobs.New(database, n8nClient, logger)
+	jobManager := jobs.New(database, jobProcessor, logger)
+	if localProcessor != nil {
+		localProcessor.SetCompleter(jobManager)
+	}
+	jobManager.SetAdmission(quotaGuard)
+	jobManager.SetFileStore(fileManager)
+	jobManager.SetURLSigner(downloadSigner)
+	jobManager.SetLinks(baseLinks)
+	jobManager.SetUploadTokens(uploadTokens)
+	jobManager.SetPreviewRetention(cfg.PreviewRetention)
+	jobManager.SetAssetUploadWindow(cfg.AssetUploadWindow)
+	jobManager.SetSourceUploads(sourceTokens, jobs.SourceLimits{
+		MaxBytes:    cfg.MaxSourceUploadBytes,
+		MaxDuration: cfg.MaxSourceDuration,
+	})
+
+	// Dispatch jobs left queued by a previous process
+	jobManager.Resume()
+
+	// Initialize data request manager and resume interrupted requests
+	privacyManager := privacy.New(database, fileManager, logger)
+	go privacyManager.Resume()
+
+	// Initialize ZIP bundles of several ringtones
+	bundleBuilder := bundle.New(database, fileManager, bundle.Limits{
+		MaxItems: cfg.BundleMaxItems,
+		MaxBytes: cfg.BundleMaxBytes,
+	}, logger)
 
 	// Initialize API server
 	server := api.New(&api.Config{
-		Database:      database,
-		FileManager:   fileManager,
-		JobManager:    jobManager,
-		Logger:        logger,
-		WebhookSecret: cfg.N8NWebhookSecret,
+		Database:       database,
+		FileManager:    fileManager,
+		JobManager:     jobManager,
+		PrivacyManager: privacyManager,
+		Bundles:        bundleBuilder,
+		DownloadSigner: downloadSigner,
+		UploadTokens:   uploadTokens,
+		SourceTokens:   sourceTokens,
+		Quota:          quotaGuard,
+		Logger:         logger,
+		WebhookSigner:  webhookSigner,
+		AdminToken:     cfg.AdminToken,
+
+		AllowUnsignedDownloads: cfg.AllowUnsignedDownloads,
+		Breaker:                n8nBreaker,
+		N8NClient:              n8nClient,
+		Links:                  baseLinks,
 	})
 	
 
@@ -94,6 +270,7 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down server...")
+	close(stopReconciler)
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -105,5 +282,43 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Wait for dispatches in flight; jobs still queued are resumed on the
+	// next start
+	jobManager.Stop()
+
 	logger.Info("Server exited successfully")
 }
+
+// newFileManager creates the file manager for the configured storage backend
+func newFileManager(cfg *config.Config, logger *applog.Logger) (*files.Manager, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return files.New(cfg.StoragePath, logger), nil
+	case "s3":
+		backend, err := files.NewS3Backend(files.S3Config{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			Prefix:          cfg.S3.Prefix,
+			PathStyle:       cfg.S3.PathStyle,
+		})
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Using S3 storage backend", "endpoint", cfg.S3.Endpoint, "bucket", cfg.S3.Bucket)
+		return files.NewWithBackend(backend, cfg.S3.RedirectTTL, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.StorageBackend)
+	}
+}
+
+// webhookKeyring returns the keyring of webhook secrets. A single
+// N8N_WEBHOOK_SECRET is treated as one key with the ID "default".
+func webhookKeyring(cfg *config.Config) (*signing.Keyring, error) {
+	if cfg.N8NWebhookSecrets != "" {
+		return signing.ParseKeyring(cfg.N8NWebhookSecrets)
+	}
+	return signing.NewKeyring(signing.Key{ID: "default", Secret: []byte(cfg.N8NWebhookSecret)})
+}
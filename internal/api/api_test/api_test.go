@@ -1,11 +1,22 @@
 package api_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,16 +24,23 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"ringtonic-backend/internal/api"
+	"ringtonic-backend/internal/bundle"
+	"ringtonic-backend/internal/contract"
 	"ringtonic-backend/internal/files"
 	"ringtonic-backend/internal/jobs"
+	"ringtonic-backend/internal/links"
 	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/media"
 	"ringtonic-backend/internal/n8n"
+	"ringtonic-backend/internal/privacy"
+	"ringtonic-backend/internal/quota"
+	"ringtonic-backend/internal/signing"
 	"ringtonic-backend/internal/store"
 )
 
 func setupTestServer(t *testing.T) (*api.Server, func()) {
 	// Create temporary database
-	dbPath := "./test_api.db"
+	dbPath := filepath.Join(t.TempDir(), "test_api.db")
 	database, err := store.New(dbPath)
 	require.NoError(t, err)
 
@@ -30,35 +48,86 @@ func setupTestServer(t *testing.T) (*api.Server, func()) {
 	require.NoError(t, err)
 
 	// Create temp storage directory
-	storageDir := "./test_storage"
-	err = os.MkdirAll(storageDir, 0755)
-	require.NoError(t, err)
+	storageDir := t.TempDir()
 
 	// Initialize components
 	logger := log.New("error") // Reduce noise in tests
 	fileManager := files.New(storageDir, logger)
-	n8nClient := n8n.New("http://test:5678/webhook", "test-secret", logger)
+	webhookSigner := testWebhookSigner("current:test-secret,previous:old-secret", 5*time.Minute, false)
+	n8nClient := n8n.New("http://test:5678/webhook", webhookSigner, logger)
+	testLinks, err := links.New("https://ringtonic.example", "http://backend:8080")
+	require.NoError(t, err)
 	jobManager := jobs.New(database, n8nClient, logger)
+	jobManager.SetFileStore(fileManager)
+	jobManager.SetURLSigner(testDownloadSigner(time.Hour))
+	jobManager.SetLinks(testLinks)
+	privacyManager := privacy.New(database, fileManager, logger)
+	quotaGuard := quota.New(database, fileManager, quota.Limits{UserFiles: 2}, logger)
+	jobManager.SetAdmission(quotaGuard)
+	jobManager.SetSourceUploads(testJobTokens(signing.PurposeSource), jobs.SourceLimits{
+		MaxBytes:    1 << 20,
+		MaxDuration: time.Minute,
+	})
 
 	// Create server
 	server := api.New(&api.Config{
-		Database:      database,
-		FileManager:   fileManager,
-		JobManager:    jobManager,
-		Logger:        logger,
-		WebhookSecret: "test-secret",
+		Database:       database,
+		FileManager:    fileManager,
+		JobManager:     jobManager,
+		PrivacyManager: privacyManager,
+		Bundles:        bundle.New(database, fileManager, bundle.Limits{MaxItems: 3, MaxBytes: 1 << 20}, logger),
+		DownloadSigner: testDownloadSigner(time.Hour),
+		UploadTokens:   testJobTokens(signing.PurposeUpload),
+		SourceTokens:   testJobTokens(signing.PurposeSource),
+		Quota:          quotaGuard,
+		Logger:         logger,
+		WebhookSigner:  webhookSigner,
+		N8NClient:      n8nClient,
+		Links:          testLinks,
+		AdminToken:     "test-admin-token",
 	})
 
 	// Cleanup function
+	// Dispatches outlive the request that started them, so they are stopped
+	// before the database is closed; the directories are removed by the
+	// testing package
 	cleanup := func() {
+		jobManager.Stop()
 		database.Close()
-		os.Remove(dbPath)
-		os.RemoveAll(storageDir)
 	}
 
 	return server, cleanup
 }
 
+// signCallback signs a callback the way the workflow does
+func signCallback(req *http.Request, body []byte) {
+	testWebhookSigner("current:test-secret", time.Minute, false).Sign(req.Header, body)
+}
+
+func testWebhookSigner(secrets string, tolerance time.Duration, legacyToken bool) *n8n.Signer {
+	keys, err := signing.ParseKeyring(secrets)
+	if err != nil {
+		panic(err)
+	}
+	return n8n.NewSigner(keys, tolerance, legacyToken)
+}
+
+func testDownloadSigner(ttl time.Duration) *signing.DownloadSigner {
+	keys, err := signing.ParseKeyring("test:download-secret")
+	if err != nil {
+		panic(err)
+	}
+	return signing.NewDownloadSigner(keys, ttl)
+}
+
+func testJobTokens(purpose string) *signing.JobTokens {
+	keys, err := signing.ParseKeyring("test:token-secret")
+	if err != nil {
+		panic(err)
+	}
+	return signing.NewJobTokens(keys, purpose)
+}
+
 func TestHealthEndpoint(t *testing.T) {
 	server, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -78,6 +147,45 @@ func TestHealthEndpoint(t *testing.T) {
 	assert.Equal(t, "1.0.0", response.Version)
 }
 
+func TestHealthEndpointCircuitOpen(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	breaker := n8n.NewBreaker(n8n.BreakerConfig{
+		FailureThreshold: 1,
+		ProbeInterval:    time.Hour,
+		HealthURLs:       []string{"http://test:5678/healthz"},
+	}, log.New("error"))
+	server.Config().Breaker = breaker
+
+	get := func(path string, response interface{}) {
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), response))
+	}
+
+	var health api.HealthResponse
+	get("/healthz", &health)
+	assert.Equal(t, "healthy", health.Status)
+	require.NotNil(t, health.N8N)
+	assert.Equal(t, n8n.CircuitClosed, health.N8N.State)
+
+	// While n8n is down the backend stays up, but reports it
+	breaker.RecordFailure()
+	health = api.HealthResponse{}
+	get("/healthz", &health)
+	assert.Equal(t, "degraded", health.Status)
+	assert.Equal(t, n8n.CircuitOpen, health.N8N.State)
+	assert.NotNil(t, health.N8N.OpenedAt)
+
+	var metrics api.MetricsResponse
+	get("/metrics", &metrics)
+	require.NotNil(t, metrics.N8N)
+	assert.Equal(t, n8n.CircuitOpen, metrics.N8N.State)
+	assert.Equal(t, int64(1), metrics.N8N.Trips)
+}
+
 func TestCreateRingtone(t *testing.T) {
 	server, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -108,7 +216,7 @@ func TestCreateRingtone(t *testing.T) {
 
 	assert.NotEmpty(t, response.JobID)
 	assert.Equal(t, "queued", response.Status)
-	assert.Contains(t, response.PollURL, response.JobID)
+	assert.Equal(t, "https://ringtonic.example/api/v1/job-status/"+response.JobID, response.PollURL)
 }
 
 func TestCreateRingtoneInvalidURL(t *testing.T) {
@@ -196,13 +304,18 @@ func TestN8NCallback(t *testing.T) {
 	err := server.Config().Database.CreateJob(job)
 	require.NoError(t, err)
 
+	// n8n writes the produced file into shared storage
+	err = server.Config().FileManager.SaveFile("test-job-123.mp3", bytes.NewReader([]byte("audio")))
+	require.NoError(t, err)
+
 	// Test callback
 	callbackBody := jobs.CallbackRequest{
-		JobID:    "test-job-123",
-		Status:   "completed",
-		FilePath: stringPtr("test-job-123.mp3"),
-		Metadata: map[string]interface{}{
-			"duration": 25.0,
+		SchemaVersion: contract.SchemaVersion,
+		JobID:         "test-job-123",
+		Status:        "completed",
+		FilePath:      stringPtr("test-job-123.mp3"),
+		Metadata: &contract.CallbackMetadata{
+			Duration: floatPtr(25.0),
 		},
 	}
 
@@ -211,7 +324,7 @@ func TestN8NCallback(t *testing.T) {
 
 	req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Webhook-Token", "test-secret")
+	signCallback(req, body)
 	w := httptest.NewRecorder()
 
 	server.Routes().ServeHTTP(w, req)
@@ -228,54 +341,1813 @@ func TestN8NCallback(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, ringtone)
 	assert.Equal(t, "test-job-123.mp3", ringtone.FileName)
+	require.NotNil(t, ringtone.BlobHash)
+	assert.Equal(t, files.BlobKey(*ringtone.BlobHash), ringtone.FilePath)
+	// Files that cannot be decoded complete without loudness
+	assert.Nil(t, ringtone.LoudnessLUFS)
+
+	// The status response carries a signed download URL
+	req = httptest.NewRequest("GET", "/api/v1/job-status/test-job-123", nil)
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	var status jobs.JobStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.DownloadURL)
+	assert.Contains(t, *status.DownloadURL, "sig=")
+
+	// The file is served from the blob store under its download name
+	req = httptest.NewRequest("GET", *status.DownloadURL, nil)
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "audio", w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "test-job-123.mp3")
 }
 
-func TestN8NCallbackUnauthorized(t *testing.T) {
+func TestDownloadRequiresValidSignature(t *testing.T) {
 	server, cleanup := setupTestServer(t)
 	defer cleanup()
 
-	callbackBody := jobs.CallbackRequest{
-		JobID:  "test-job-123",
-		Status: "completed",
+	createUserRingtone(t, server, "job-a", "user-1")
+
+	download := func(url string) (int, string) {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		var response api.ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Code
 	}
 
-	body, err := json.Marshal(callbackBody)
+	userID := "user-1"
+	otherUser := "user-2"
+	signed := testDownloadSigner(time.Hour).SignDownloadURL("job-a.mp3", "job-a", &userID)
+	tampered := signed[:len(signed)-1] + "0"
+	if signed[len(signed)-1] == '0' {
+		tampered = signed[:len(signed)-1] + "1"
+	}
+
+	code, _ := download(signed)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, errCode := download("/download/job-a.mp3")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "MISSING_SIGNATURE", errCode)
+
+	code, errCode = download(tampered)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "INVALID_SIGNATURE", errCode)
+
+	// A URL signed for another owner does not verify
+	code, errCode = download(testDownloadSigner(time.Hour).SignDownloadURL("job-a.mp3", "job-a", &otherUser))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "INVALID_SIGNATURE", errCode)
+
+	code, errCode = download(testDownloadSigner(-time.Minute).SignDownloadURL("job-a.mp3", "job-a", &userID))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "URL_EXPIRED", errCode)
+
+	// The compatibility flag re-enables unsigned links, but not bad signatures
+	server.Config().AllowUnsignedDownloads = true
+	code, _ = download("/download/job-a.mp3")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = download(tampered)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Newer URLs have no unsigned clients, so the flag does not cover them
+	ringtone, err := server.Config().Database.GetRingtoneByJobID("job-a")
 	require.NoError(t, err)
+	for _, url := range []string{
+		fmt.Sprintf("/api/v1/ringtones/bundle?ids=%d", ringtone.ID),
+		fmt.Sprintf("/api/v1/ringtones/%d/preview", ringtone.ID),
+		fmt.Sprintf("/api/v1/ringtones/%d/waveform", ringtone.ID),
+	} {
+		code, errCode = download(url)
+		assert.Equal(t, http.StatusForbidden, code, url)
+		assert.Equal(t, "MISSING_SIGNATURE", errCode, url)
+	}
+}
 
-	req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Webhook-Token", "wrong-secret")
+func TestN8NCallbackDeduplicatesContent(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	db := server.Config().Database
+	fileManager := server.Config().FileManager
+
+	for _, jobID := range []string{"job-a", "job-b"} {
+		job := &store.Job{
+			ID:        jobID,
+			SourceURL: "https://www.youtube.com/watch?v=test",
+			Status:    store.StatusProcessing,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		require.NoError(t, db.CreateJob(job))
+		require.NoError(t, fileManager.SaveFile(jobID+".mp3", bytes.NewReader([]byte("identical audio"))))
+
+		body, err := json.Marshal(jobs.CallbackRequest{
+			SchemaVersion: contract.SchemaVersion,
+			JobID:         jobID,
+			Status:        "completed",
+			FilePath:      stringPtr(jobID + ".mp3"),
+		})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+		signCallback(req, body)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	ringtoneA, err := db.GetRingtoneByJobID("job-a")
+	require.NoError(t, err)
+	ringtoneB, err := db.GetRingtoneByJobID("job-b")
+	require.NoError(t, err)
+
+	// Both ringtones share one blob and the original files are gone
+	assert.Equal(t, *ringtoneA.BlobHash, *ringtoneB.BlobHash)
+	assert.False(t, fileManager.FileExists("job-a.mp3"))
+	assert.False(t, fileManager.FileExists("job-b.mp3"))
+
+	refs, err := db.GetBlobRefCount(*ringtoneA.BlobHash)
+	require.NoError(t, err)
+	assert.Equal(t, 2, refs)
+
+	// The blob survives until its last reference is deleted
+	released, err := db.DeleteRingtone(ringtoneA.ID)
+	require.NoError(t, err)
+	assert.Nil(t, released)
+
+	released, err = db.DeleteRingtone(ringtoneB.ID)
+	require.NoError(t, err)
+	require.NotNil(t, released)
+	assert.Equal(t, *ringtoneB.BlobHash, *released)
+}
+
+func TestN8NCallbackFileNames(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	createJob := func(jobID string) {
+		require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+			ID:        jobID,
+			SourceURL: "https://www.youtube.com/watch?v=test",
+			Status:    store.StatusProcessing,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}))
+	}
+	callback := func(jobID, filePath string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(jobs.CallbackRequest{SchemaVersion: contract.SchemaVersion, JobID: jobID, Status: "completed", FilePath: &filePath})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		signCallback(req, body)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// Paths leading out of storage are rejected
+	createJob("job-hostile")
+	for _, filePath := range []string{"../test_api.db", "/etc/passwd", "a/../../secret.mp3"} {
+		w := callback("job-hostile", filePath)
+		assert.Equal(t, http.StatusBadRequest, w.Code, filePath)
+
+		var response api.ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "INVALID_FILE_PATH", response.Code)
+	}
+	ringtone, err := server.Config().Database.GetRingtoneByJobID("job-hostile")
+	require.NoError(t, err)
+	assert.Nil(t, ringtone)
+
+	// Workflow file names are kept for display only
+	createJob("job-unicode")
+	require.NoError(t, server.Config().FileManager.SaveFile("out/Café — tone.mp3", bytes.NewReader([]byte("audio"))))
+	assert.Equal(t, http.StatusOK, callback("job-unicode", "out/Café — tone.mp3").Code)
+
+	ringtone, err = server.Config().Database.GetRingtoneByJobID("job-unicode")
+	require.NoError(t, err)
+	require.NotNil(t, ringtone)
+	assert.Equal(t, "job-unicode.mp3", ringtone.FileName)
+	require.NotNil(t, ringtone.DisplayName)
+	assert.Equal(t, "Café — tone.mp3", *ringtone.DisplayName)
+
+	downloadURL := server.Config().DownloadSigner.SignDownloadURL(ringtone.FileName, "job-unicode", nil)
+	req := httptest.NewRequest("GET", downloadURL, nil)
 	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t,
+		`attachment; filename="Caf_ _ tone.mp3"; filename*=UTF-8''Caf%C3%A9%20%E2%80%94%20tone.mp3`,
+		w.Header().Get("Content-Disposition"))
+}
+
+func TestN8NCallbackVerifiesChecksum(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-sum",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	require.NoError(t, server.Config().FileManager.SaveFile("job-sum.mp3", bytes.NewReader([]byte("audio"))))
+
+	callback := func(sum string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(jobs.CallbackRequest{
+			SchemaVersion: contract.SchemaVersion,
+			JobID:         "job-sum",
+			Status:        "completed",
+			FilePath:      stringPtr("job-sum.mp3"),
+			SHA256:        &sum,
+		})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		signCallback(req, body)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// A corrupted file is rejected and left in place for a retry
+	w := callback(strings.Repeat("0", 64))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var response api.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "CHECKSUM_MISMATCH", response.Code)
+	assert.True(t, server.Config().FileManager.FileExists("job-sum.mp3"))
+
+	job, err := server.Config().Database.GetJob("job-sum")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusProcessing, job.Status)
+
+	// The matching checksum is stored with the ringtone and reported to clients
+	const audioSHA256 = "6ed8919ce20490a5e3ad8630a4fab69475297abd07db73918dd5f36fcfaeb11b"
+	assert.Equal(t, http.StatusOK, callback(audioSHA256).Code)
 
+	req := httptest.NewRequest("GET", "/api/v1/job-status/job-sum", nil)
+	w = httptest.NewRecorder()
 	server.Routes().ServeHTTP(w, req)
+	var status jobs.JobStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.SHA256)
+	assert.Equal(t, audioSHA256, *status.SHA256)
+}
 
-	assert.Equal(t, http.StatusUnauthorized, w.Code)
+func TestN8NCallbackWritesTags(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-tags",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	fileManager := server.Config().FileManager
+	require.NoError(t, fileManager.SaveFile("job-tags.mp3", bytes.NewReader([]byte("audio"))))
+	cover := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, []byte("jpeg")...)
+	require.NoError(t, fileManager.SaveFile("job-tags.jpg", bytes.NewReader(cover)))
+
+	body, err := json.Marshal(jobs.CallbackRequest{
+		SchemaVersion: contract.SchemaVersion,
+		JobID:         "job-tags",
+		Status:        "completed",
+		FilePath:      stringPtr("job-tags.mp3"),
+		Metadata: &contract.CallbackMetadata{
+			Title:     "Theme Song",
+			Uploader:  "Some Channel",
+			CoverPath: "job-tags.jpg",
+		},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+	signCallback(req, body)
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("GET", "/api/v1/job-status/job-tags", nil)
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	var status jobs.JobStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.DownloadURL)
+	require.NotNil(t, status.SHA256)
+
+	// The download carries an ID3v2.4 tag ahead of the audio, and the
+	// reported checksum is that of the tagged file
+	req = httptest.NewRequest("GET", *status.DownloadURL, nil)
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	content := w.Body.Bytes()
+	assert.True(t, bytes.HasPrefix(content, []byte("ID3\x04")))
+	assert.True(t, bytes.HasSuffix(content, []byte("audio")))
+	assert.Contains(t, string(content), "Theme Song")
+	assert.Contains(t, string(content), "Some Channel")
+	assert.Contains(t, string(content), "https://www.youtube.com/watch?v=test")
+	assert.True(t, bytes.Contains(content, cover))
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), *status.SHA256)
 }
 
-func TestMetrics(t *testing.T) {
+func TestN8NCallbackMeasuresLoudness(t *testing.T) {
 	server, cleanup := setupTestServer(t)
 	defer cleanup()
 
-	req := httptest.NewRequest("GET", "/metrics", nil)
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-loud",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	// Two seconds of a square wave at half scale
+	wav := testWAV(8000*2, 0)
+	for i := 0; i < 8000*2; i++ {
+		wav = append(wav, []byte{0xC0, 0xC0, 0x40, 0x40}[i%4])
+	}
+	require.NoError(t, server.Config().FileManager.SaveFile("job-loud.wav", bytes.NewReader(wav)))
+
+	body, err := json.Marshal(jobs.CallbackRequest{
+		SchemaVersion: contract.SchemaVersion,
+		JobID:         "job-loud",
+		Status:        "completed",
+		FilePath:      stringPtr("job-loud.wav"),
+	})
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+	signCallback(req, body)
 	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	ringtone, err := server.Config().Database.GetRingtoneByJobID("job-loud")
+	require.NoError(t, err)
+	require.NotNil(t, ringtone.LoudnessLUFS)
+	require.NotNil(t, ringtone.TruePeakDBTP)
 
+	req = httptest.NewRequest("GET", "/api/v1/job-status/job-loud", nil)
+	w = httptest.NewRecorder()
 	server.Routes().ServeHTTP(w, req)
+	var status jobs.JobStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.Loudness)
+	assert.Equal(t, *ringtone.LoudnessLUFS, status.Loudness.IntegratedLUFS)
+	assert.Less(t, status.Loudness.IntegratedLUFS, 0.0)
+	// Samples sit at half scale, so the true peak is at least -6dBTP
+	assert.GreaterOrEqual(t, status.Loudness.TruePeakDBTP, -6.1)
+}
 
-	assert.Equal(t, http.StatusOK, w.Code)
+func TestCreateRingtoneInvalidTargetLoudness(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
 
-	var response api.MetricsResponse
-	err := json.Unmarshal(w.Body.Bytes(), &response)
+	target := -3.0
+	body, err := json.Marshal(jobs.CreateJobRequest{
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{Normalize: true, TargetLUFS: &target},
+	})
 	require.NoError(t, err)
 
-	assert.NotNil(t, response.JobStats)
-	assert.NotEmpty(t, response.Uptime)
+	req := httptest.NewRequest("POST", "/api/v1/create-ringtone", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_TARGET_LUFS")
 }
 
-// Helper functions
-func intPtr(i int) *int {
-	return &i
+func TestN8NUpload(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, jobID := range []string{"job-raw", "job-multipart"} {
+		require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+			ID:        jobID,
+			SourceURL: "https://www.youtube.com/watch?v=test",
+			Status:    store.StatusProcessing,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}))
+	}
+	token := testJobTokens(signing.PurposeUpload).Token("job-raw")
+
+	upload := func(jobID, token string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/n8n-upload/"+jobID, body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+	const audioSHA256 = "6ed8919ce20490a5e3ad8630a4fab69475297abd07db73918dd5f36fcfaeb11b"
+
+	// Tokens are bound to their job
+	assert.Equal(t, http.StatusUnauthorized, upload("job-multipart", token, strings.NewReader("audio"), nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, upload("job-raw", "", strings.NewReader("audio"), nil).Code)
+
+	// A corrupted upload leaves the job pending
+	w := upload("job-raw", token, strings.NewReader("audi0"), map[string]string{"X-Content-SHA256": audioSHA256})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	job, err := server.Config().Database.GetJob("job-raw")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusProcessing, job.Status)
+
+	// A streamed body completes the job
+	w = upload("job-raw", token, strings.NewReader("audio"), map[string]string{
+		"Content-Type":     "audio/mpeg",
+		"X-File-Name":      "tone.mp3",
+		"X-Content-SHA256": audioSHA256,
+		"X-Duration":       "25.5",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	var response api.UploadResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, audioSHA256, response.SHA256)
+	assert.Equal(t, int64(5), response.SizeBytes)
+
+	job, err = server.Config().Database.GetJob("job-raw")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusCompleted, job.Status)
+	ringtone, err := server.Config().Database.GetRingtoneByJobID("job-raw")
+	require.NoError(t, err)
+	require.NotNil(t, ringtone)
+	assert.Equal(t, "job-raw.mp3", ringtone.FileName)
+	assert.Equal(t, 25, *ringtone.DurationSeconds)
+
+	// Finished jobs do not accept further uploads
+	w = upload("job-raw", token, strings.NewReader("audio"), nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Multipart uploads carry fields ahead of the file
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("sha256", audioSHA256))
+	part, err := form.CreateFormFile("file", "Café.mp3")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("audio"))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	w = upload("job-multipart", testJobTokens(signing.PurposeUpload).Token("job-multipart"), &body, map[string]string{
+		"Content-Type": form.FormDataContentType(),
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	ringtone, err = server.Config().Database.GetRingtoneByJobID("job-multipart")
+	require.NoError(t, err)
+	require.NotNil(t, ringtone)
+	assert.Equal(t, "Café.mp3", ringtone.DownloadName())
+
+	// Both jobs share the one stored copy
+	refs, err := server.Config().Database.GetBlobRefCount(audioSHA256)
+	require.NoError(t, err)
+	assert.Equal(t, 2, refs)
 }
 
-func stringPtr(s string) *string {
-	return &s
+// slowBody streams content in chunks, pausing between them
+func slowBody(content []byte, chunks int, pause time.Duration) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		size := (len(content) + chunks - 1) / chunks
+		for len(content) > 0 {
+			n := min(size, len(content))
+			if _, err := writer.Write(content[:n]); err != nil {
+				return
+			}
+			content = content[n:]
+			time.Sleep(pause)
+		}
+		writer.Close()
+	}()
+	return reader
+}
+
+func TestUploadsOutlastReadTimeout(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// Uploads take longer to arrive than the server's timeouts allow
+	httpServer := httptest.NewUnstartedServer(server.Routes())
+	httpServer.Config.ReadTimeout = 100 * time.Millisecond
+	httpServer.Config.WriteTimeout = 100 * time.Millisecond
+	httpServer.Start()
+	defer httpServer.Close()
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-slow",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	req, err := http.NewRequest("POST", httpServer.URL+"/api/v1/n8n-upload/job-slow", slowBody([]byte("audio"), 5, 60*time.Millisecond))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testJobTokens(signing.PurposeUpload).Token("job-slow"))
+	req.Header.Set("X-File-Name", "tone.mp3")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "clip.wav")
+	require.NoError(t, err)
+	_, err = part.Write(testWAV(8000*2, 8000*2))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req, err = http.NewRequest("POST", httpServer.URL+"/api/v1/create-ringtone/upload", slowBody(body.Bytes(), 5, 60*time.Millisecond))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+}
+
+func TestRingtonePreview(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-preview",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		UserID:    stringPtr("user-1"),
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	token := testJobTokens(signing.PurposeUpload).Token("job-preview")
+
+	upload := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-File-Name", "tone.mp3")
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+	get := func(url string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// The final file is delivered first
+	assert.Equal(t, http.StatusConflict, upload("/api/v1/n8n-upload/job-preview/preview", "preview audio").Code)
+	require.Equal(t, http.StatusOK, upload("/api/v1/n8n-upload/job-preview", "final audio").Code)
+	require.Equal(t, http.StatusOK, upload("/api/v1/n8n-upload/job-preview/preview", "preview audio").Code)
+
+	w := get("/api/v1/job-status/job-preview", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var status jobs.JobStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.PreviewURL)
+	require.NotNil(t, status.DownloadURL)
+
+	// Previews play inline, seek, and stay out of shared caches
+	w = get(*status.PreviewURL, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "preview audio", w.Body.String())
+	assert.Equal(t, "audio/mpeg", w.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Disposition"), "inline;"))
+	assert.Contains(t, w.Header().Get("Cache-Control"), "private")
+
+	w = get(*status.PreviewURL, map[string]string{"Range": "bytes=8-"})
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "audio", w.Body.String())
+
+	// Preview URLs are signed like downloads
+	ringtone, err := server.Config().Database.GetRingtoneByJobID("job-preview")
+	require.NoError(t, err)
+	w = get(fmt.Sprintf("/api/v1/ringtones/%d/preview", ringtone.ID), nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusForbidden, get(testDownloadSigner(time.Hour).SignPreviewURL(ringtone.ID, "job-preview", stringPtr("user-2")), nil).Code)
+
+	// Only downloads of the final file are counted, once per download
+	require.Equal(t, http.StatusOK, get(*status.DownloadURL, nil).Code)
+	require.Equal(t, http.StatusPartialContent, get(*status.DownloadURL, map[string]string{"Range": "bytes=6-"}).Code)
+
+	ringtone, err = server.Config().Database.GetRingtoneByJobID("job-preview")
+	require.NoError(t, err)
+	assert.Equal(t, 1, ringtone.DownloadCount)
+
+	w = get("/metrics", nil)
+	var metrics api.MetricsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &metrics))
+	assert.Equal(t, int64(1), metrics.Downloads)
+}
+
+func TestRingtoneFormats(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-formats",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	token := testJobTokens(signing.PurposeUpload).Token("job-formats")
+
+	upload := func(path, fileName, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-File-Name", fileName)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	// Other formats follow the primary file
+	assert.Equal(t, http.StatusConflict, upload("/api/v1/n8n-upload/job-formats/formats/m4r", "tone.m4r", "m4r audio").Code)
+	require.Equal(t, http.StatusOK, upload("/api/v1/n8n-upload/job-formats", "tone.mp3", "mp3 audio").Code)
+	require.Equal(t, http.StatusOK, upload("/api/v1/n8n-upload/job-formats/formats/m4r", "tone.m4r", "m4r audio").Code)
+	assert.Equal(t, http.StatusConflict, upload("/api/v1/n8n-upload/job-formats/formats/mp3", "tone.mp3", "mp3 audio").Code)
+	assert.Equal(t, http.StatusBadRequest, upload("/api/v1/n8n-upload/job-formats/formats/exe", "tone.exe", "binary").Code)
+
+	w := get("/api/v1/job-status/job-formats")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status jobs.JobStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.DownloadURL)
+	require.Len(t, status.Downloads, 2)
+	assert.Equal(t, *status.DownloadURL, status.Downloads["mp3"])
+
+	w = get(status.Downloads["mp3"])
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mp3 audio", w.Body.String())
+	assert.Equal(t, "audio/mpeg", w.Header().Get("Content-Type"))
+
+	w = get(status.Downloads["m4r"])
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "m4r audio", w.Body.String())
+	assert.Equal(t, "audio/x-m4r", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".m4r")
+
+	w = get(strings.Replace(status.Downloads["m4r"], "format=m4r", "format=ogg", 1))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "FORMAT_NOT_FOUND")
+
+	// Unknown formats are refused when the job is created
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/create-ringtone",
+		strings.NewReader(`{"source_url":"https://www.youtube.com/watch?v=test","options":{"formats":["m4r","exe"]}}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "UNSUPPORTED_FORMAT")
+}
+
+// testWAV returns a mono 8-bit 8 kHz WAV file whose header declares the
+// given number of data bytes, of which only the first are included
+func testWAV(dataBytes uint32, included int) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36)+dataBytes)
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, []uint32{16})
+	binary.Write(&b, binary.LittleEndian, []uint16{1, 1})
+	binary.Write(&b, binary.LittleEndian, []uint32{8000, 8000})
+	binary.Write(&b, binary.LittleEndian, []uint16{1, 8})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, dataBytes)
+	b.Write(bytes.Repeat([]byte{0x80}, included))
+	return b.Bytes()
+}
+
+func TestCreateRingtoneUpload(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	create := func(fileName string, content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		require.NoError(t, form.WriteField("user_id", "uploader"))
+		require.NoError(t, form.WriteField("options", `{"start_seconds":1,"duration_seconds":4,"fade_in":true}`))
+		part, err := form.CreateFormFile("file", fileName)
+		require.NoError(t, err)
+		_, err = part.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, form.Close())
+
+		req := httptest.NewRequest("POST", "/api/v1/create-ringtone/upload", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// Content is sniffed, whatever the file is called
+	assert.Equal(t, http.StatusUnsupportedMediaType, create("song.mp3", []byte("<html>not audio</html>")).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, create("long.wav", testWAV(8000*120, 8000)).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, create("big.wav", testWAV(1<<20, 1<<20)).Code)
+
+	wav := testWAV(8000*2, 8000*2)
+	w := create("clip.bin", wav)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var response jobs.CreateJobResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	job, err := server.Config().Database.GetJob(response.JobID)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, "uploader", *job.UserID)
+	assert.Equal(t, "http://backend:8080/api/v1/sources/"+response.JobID, job.SourceURL)
+	require.NotNil(t, job.SourceMIMEType)
+	assert.Equal(t, "audio/wav", *job.SourceMIMEType)
+	assert.Equal(t, int64(len(wav)), *job.SourceSizeBytes)
+	// The stored payload carries the options but not the source token
+	assert.Contains(t, *job.N8NPayload, `"start_seconds":1`)
+	assert.NotContains(t, *job.N8NPayload, "token")
+
+	source := func(jobID, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/sources/"+jobID+"?token="+token, nil)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, source(response.JobID, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, source(response.JobID, testJobTokens(signing.PurposeUpload).Token(response.JobID)).Code)
+
+	w = source(response.JobID, testJobTokens(signing.PurposeSource).Token(response.JobID))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, wav, w.Body.Bytes())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename="source.wav"`)
+}
+
+func TestSuggestTrim(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// Thirty seconds of silence with a loud stretch from 10s to 20s
+	wav := testWAV(8000*30, 8000*10)
+	for i := 0; i < 8000*10; i++ {
+		wav = append(wav, []byte{0xF0, 0x10}[i/8%2])
+	}
+	wav = append(wav, bytes.Repeat([]byte{0x80}, 8000*10)...)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("options", `{"duration_seconds":10}`))
+	part, err := form.CreateFormFile("file", "song.wav")
+	require.NoError(t, err)
+	_, err = part.Write(wav)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+	req := httptest.NewRequest("POST", "/api/v1/create-ringtone/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created jobs.CreateJobResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	suggest := func(jobID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/jobs/"+jobID+"/suggest-trim", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// Without a body the job's own duration is used
+	w = suggest(created.JobID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response jobs.SuggestTrimResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 10, response.WindowSeconds)
+	assert.InDelta(t, 30.0, response.DurationSeconds, 0.1)
+	require.Len(t, response.Candidates, 3)
+	assert.Equal(t, 10, response.Candidates[0].StartSeconds)
+	assert.Equal(t, 20, response.Candidates[0].EndSeconds)
+	assert.Greater(t, response.Candidates[0].Score, response.Candidates[1].Score)
+
+	w = suggest(created.JobID, `{"duration_seconds":20,"count":1}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	response = jobs.SuggestTrimResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 20, response.WindowSeconds)
+	assert.Len(t, response.Candidates, 1)
+
+	w = suggest(created.JobID, `{"duration_seconds":600}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_TRIM_REQUEST")
+
+	// Sources fetched from a URL never reach the backend
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-url",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusQueued,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	w = suggest("job-url", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "SOURCE_NOT_AVAILABLE")
+
+	assert.Equal(t, http.StatusNotFound, suggest("missing", "").Code)
+}
+
+func TestCreateRingtoneAutoStart(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// Twenty seconds of silence with a loud stretch from 8s to 13s
+	wav := testWAV(8000*20, 8000*8)
+	for i := 0; i < 8000*5; i++ {
+		wav = append(wav, []byte{0xF0, 0x10}[i/8%2])
+	}
+	wav = append(wav, bytes.Repeat([]byte{0x80}, 8000*7)...)
+
+	upload := func(options string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		require.NoError(t, form.WriteField("options", options))
+		part, err := form.CreateFormFile("file", "song.wav")
+		require.NoError(t, err)
+		_, err = part.Write(wav)
+		require.NoError(t, err)
+		require.NoError(t, form.Close())
+		req := httptest.NewRequest("POST", "/api/v1/create-ringtone/upload", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// The start is resolved to the best window before the job is dispatched
+	w := upload(`{"start_seconds":"auto","duration_seconds":5}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created jobs.CreateJobResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	job, err := server.Config().Database.GetJob(created.JobID)
+	require.NoError(t, err)
+	require.NotNil(t, job.N8NPayload)
+	assert.Contains(t, *job.N8NPayload, `"start_seconds":8`)
+	assert.NotContains(t, *job.N8NPayload, "auto")
+
+	w = upload(`{"start_seconds":"auto","duration_seconds":600}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_TRIM_REQUEST")
+
+	// Sources fetched from a URL cannot be analyzed
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/create-ringtone",
+		strings.NewReader(`{"source_url":"https://www.youtube.com/watch?v=test","options":{"start_seconds":"auto"}}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "AUTO_START_UNAVAILABLE")
+
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/create-ringtone",
+		strings.NewReader(`{"source_url":"https://www.youtube.com/watch?v=test","options":{"start_seconds":"soon"}}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_JSON")
+}
+
+func TestRingtoneWaveform(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	deliver := func(jobID, fileName string, content []byte) *store.Ringtone {
+		require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+			ID:        jobID,
+			SourceURL: "https://www.youtube.com/watch?v=test",
+			UserID:    stringPtr("user-1"),
+			Status:    store.StatusProcessing,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}))
+		req := httptest.NewRequest("POST", "/api/v1/n8n-upload/"+jobID, bytes.NewReader(content))
+		req.Header.Set("Authorization", "Bearer "+testJobTokens(signing.PurposeUpload).Token(jobID))
+		req.Header.Set("X-File-Name", fileName)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		ringtone, err := server.Config().Database.GetRingtoneByJobID(jobID)
+		require.NoError(t, err)
+		return ringtone
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// Two seconds of silence
+	ringtone := deliver("job-waveform", "tone.wav", testWAV(16000, 16000))
+
+	w := get("/api/v1/job-status/job-waveform")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status jobs.JobStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.WaveformURL)
+
+	w = get(*status.WaveformURL + "&buckets=4")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Cache-Control"), "private")
+	var waveform api.WaveformResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &waveform))
+	assert.Equal(t, ringtone.ID, waveform.RingtoneID)
+	assert.Equal(t, "ringtone", waveform.Source)
+	assert.Equal(t, 8000, waveform.SampleRate)
+	assert.InDelta(t, 2.0, waveform.DurationSeconds, 0.001)
+	assert.Equal(t, media.Levels{0, 0, 0, 0}, waveform.Peaks)
+
+	// The result is cached next to the file and served in binary on request
+	_, err := server.Config().FileManager.ReadSidecar(ringtone.StorageKey(), "waveform-4.bin")
+	require.NoError(t, err)
+
+	w = get(*status.WaveformURL + "&buckets=4&format=binary")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	decoded := &media.Waveform{}
+	require.NoError(t, decoded.UnmarshalBinary(w.Body.Bytes()))
+	assert.Equal(t, waveform.Waveform, decoded)
+
+	assert.Equal(t, http.StatusBadRequest, get(*status.WaveformURL+"&buckets=0").Code)
+	assert.Equal(t, http.StatusBadRequest, get(*status.WaveformURL+"&source=elsewhere").Code)
+	assert.Equal(t, http.StatusNotFound, get(*status.WaveformURL+"&source=original").Code)
+
+	// Waveform URLs are signed like downloads
+	assert.Equal(t, http.StatusForbidden, get(fmt.Sprintf("/api/v1/ringtones/%d/waveform", ringtone.ID)).Code)
+	assert.Equal(t, http.StatusNotFound, get(testDownloadSigner(time.Hour).SignWaveformURL(9999, "job-waveform", stringPtr("user-1"))).Code)
+
+	// Formats that cannot be decoded are reported as such
+	other := deliver("job-waveform-other", "tone.mp3", []byte("not audio"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, get(testDownloadSigner(time.Hour).SignWaveformURL(other.ID, "job-waveform-other", stringPtr("user-1"))).Code)
+}
+
+func TestN8NCallbackUnauthorized(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	callbackBody := jobs.CallbackRequest{
+		SchemaVersion: contract.SchemaVersion,
+		JobID:         "test-job-123",
+		Status:        "completed",
+	}
+
+	body, err := json.Marshal(callbackBody)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Token", "wrong-secret")
+	w := httptest.NewRecorder()
+
+	server.Routes().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestN8NCallbackPerJobURL(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, id := range []string{"job-a", "job-b"} {
+		require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+			ID:        id,
+			SourceURL: "https://www.youtube.com/watch?v=test",
+			Status:    store.StatusProcessing,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}))
+	}
+	send := func(path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		signCallback(req, body)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// The job named in the URL need not be echoed in the body
+	w := send("/api/v1/n8n-callback/job-a", []byte(`{"schema_version":1,"status":"failed","metadata":{"error":"gone"}}`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	job, err := server.Config().Database.GetJob("job-a")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusFailed, job.Status)
+
+	// A body naming another job is rejected rather than trusted
+	w = send("/api/v1/n8n-callback/job-b", []byte(`{"schema_version":1,"job_id":"job-a","status":"failed"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "JOB_ID_MISMATCH")
+	job, err = server.Config().Database.GetJob("job-b")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusProcessing, job.Status)
+
+	// The shared callback URL still needs the job in the body
+	w = send("/api/v1/n8n-callback", []byte(`{"schema_version":1,"status":"failed"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "MISSING_JOB_ID")
+}
+
+func TestN8NCallbackToken(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	sum := sha256.Sum256([]byte("job-token"))
+	hash := hex.EncodeToString(sum[:])
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:                "job-tokened",
+		SourceURL:         "https://www.youtube.com/watch?v=test",
+		Status:            store.StatusProcessing,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		CallbackTokenHash: &hash,
+	}))
+	send := func(token string) *httptest.ResponseRecorder {
+		body := []byte(`{"schema_version":1,"status":"failed"}`)
+		req := httptest.NewRequest("POST", "/api/v1/n8n-callback/job-tokened", bytes.NewReader(body))
+		if token != "" {
+			req.Header.Set("X-Callback-Token", token)
+		}
+		signCallback(req, body)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// A valid signature is not enough without the job's own token
+	w := send("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "MISSING_CALLBACK_TOKEN")
+	w = send("another-job-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_CALLBACK_TOKEN")
+
+	job, err := server.Config().Database.GetJob("job-tokened")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusProcessing, job.Status)
+
+	w = send("job-token")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	job, err = server.Config().Database.GetJob("job-tokened")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusFailed, job.Status)
+
+	// The token is spent once the outcome is reported
+	w = send("job-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_CALLBACK_TOKEN")
+}
+
+func TestN8NCallbackConcurrent(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	sum := sha256.Sum256([]byte("job-token"))
+	hash := hex.EncodeToString(sum[:])
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:                "job-racing",
+		SourceURL:         "https://www.youtube.com/watch?v=test",
+		Status:            store.StatusProcessing,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		CallbackTokenHash: &hash,
+	}))
+	require.NoError(t, server.Config().FileManager.SaveFile("job-racing.mp3", bytes.NewReader([]byte("audio"))))
+
+	// Replays of one token race each other, completing and failing the job
+	const racers = 8
+	codes := make(chan int, racers)
+	var wg sync.WaitGroup
+	for i := 0; i < racers; i++ {
+		body := []byte(`{"schema_version":1,"status":"completed","file_path":"job-racing.mp3"}`)
+		if i%2 == 1 {
+			body = []byte(`{"schema_version":1,"status":"failed"}`)
+		}
+		req := httptest.NewRequest("POST", "/api/v1/n8n-callback/job-racing", bytes.NewReader(body))
+		req.Header.Set("X-Callback-Token", "job-token")
+		signCallback(req, body)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			server.Routes().ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	accepted := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			accepted++
+		}
+	}
+	assert.Equal(t, 1, accepted)
+
+	// The job finished once, with at most the one ringtone
+	job, err := server.Config().Database.GetJob("job-racing")
+	require.NoError(t, err)
+	require.NotNil(t, job.CallbackTokenHash)
+	assert.Empty(t, *job.CallbackTokenHash)
+	ringtones, err := server.Config().Database.ListRingtonesByJobID("job-racing")
+	require.NoError(t, err)
+	if job.Status == store.StatusCompleted {
+		assert.Len(t, ringtones, 1)
+	} else {
+		assert.Equal(t, store.StatusFailed, job.Status)
+		assert.Empty(t, ringtones)
+	}
+}
+
+func TestN8NUploadCallbackToken(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	sum := sha256.Sum256([]byte("job-token"))
+	hash := hex.EncodeToString(sum[:])
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:                "job-uploaded",
+		SourceURL:         "https://www.youtube.com/watch?v=test",
+		Status:            store.StatusProcessing,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		CallbackTokenHash: &hash,
+	}))
+	upload := func(callbackToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/n8n-upload/job-uploaded", strings.NewReader("audio"))
+		req.Header.Set("Authorization", "Bearer "+testJobTokens(signing.PurposeUpload).Token("job-uploaded"))
+		req.Header.Set("X-File-Name", "tone.mp3")
+		if callbackToken != "" {
+			req.Header.Set("X-Callback-Token", callbackToken)
+		}
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// Completing a job by upload takes its callback token as well
+	w := upload("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "MISSING_CALLBACK_TOKEN")
+	w = upload("job-token")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// which is then spent, for uploads and callbacks alike
+	w = upload("job-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_CALLBACK_TOKEN")
+	body := []byte(`{"schema_version":1,"status":"failed"}`)
+	req := httptest.NewRequest("POST", "/api/v1/n8n-callback/job-uploaded", bytes.NewReader(body))
+	req.Header.Set("X-Callback-Token", "job-token")
+	signCallback(req, body)
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	job, err := server.Config().Database.GetJob("job-uploaded")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusCompleted, job.Status)
+}
+
+func TestRingtoneAssetUploadWindow(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-window",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	completed, err := server.Config().Database.CompleteJob(&store.Ringtone{
+		JobID:     "job-window",
+		FileName:  "job-window.mp3",
+		FilePath:  "job-window.mp3",
+		Format:    "mp3",
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+	})
+	require.NoError(t, err)
+	require.True(t, completed)
+
+	upload := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader("audio"))
+		req.Header.Set("Authorization", "Bearer "+testJobTokens(signing.PurposeUpload).Token("job-window"))
+		req.Header.Set("X-File-Name", "tone.ogg")
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// Renditions are accepted only for a while after the job completes
+	server.Config().JobManager.SetAssetUploadWindow(time.Hour)
+	for _, path := range []string{"/api/v1/n8n-upload/job-window/preview", "/api/v1/n8n-upload/job-window/formats/ogg"} {
+		w := upload(path)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+		assert.Contains(t, w.Body.String(), "UPLOAD_TOKEN_EXPIRED", path)
+	}
+
+	server.Config().JobManager.SetAssetUploadWindow(3 * time.Hour)
+	w := upload("/api/v1/n8n-upload/job-window/formats/ogg")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestN8NCallbackRejectsUnknownFields(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	body := []byte(`{"schema_version":1,"jobId":"test-job-123","status":"completed"}`)
+	req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+	signCallback(req, body)
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_CALLBACK")
+	assert.Contains(t, w.Body.String(), "unknown field jobId")
+}
+
+func TestN8NCallbackSignatures(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-signed",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	body, err := json.Marshal(jobs.CallbackRequest{SchemaVersion: contract.SchemaVersion, JobID: "job-signed", Status: "failed"})
+	require.NoError(t, err)
+
+	send := func(header http.Header, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	header := http.Header{}
+	testWebhookSigner("current:test-secret", time.Minute, false).Sign(header, body)
+	assert.Equal(t, http.StatusOK, send(header, body).Code)
+
+	// The same request cannot be replayed
+	w := send(header, body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "REPLAYED_NONCE")
+
+	// Signatures cover the body
+	header = http.Header{}
+	testWebhookSigner("current:test-secret", time.Minute, false).Sign(header, body)
+	w = send(header, []byte(`{"schema_version":1,"job_id":"job-signed","status":"completed"}`))
+	assert.Contains(t, w.Body.String(), "INVALID_WEBHOOK_SIGNATURE")
+
+	// Requests signed with another secret or too long ago are rejected
+	header = http.Header{}
+	testWebhookSigner("other:other-secret", time.Minute, false).Sign(header, body)
+	assert.Contains(t, send(header, body).Body.String(), "INVALID_WEBHOOK_SIGNATURE")
+
+	stale := testWebhookSigner("current:test-secret", time.Minute, false)
+	stale.SetClock(func() time.Time { return time.Now().Add(-10 * time.Minute) })
+	header = http.Header{}
+	stale.Sign(header, body)
+	assert.Contains(t, send(header, body).Body.String(), "STALE_TIMESTAMP")
+
+	// The static token is only accepted in legacy mode
+	header = http.Header{"X-Webhook-Token": {"test-secret"}}
+	assert.Contains(t, send(header, body).Body.String(), "MISSING_WEBHOOK_SIGNATURE")
+
+	server.Config().WebhookSigner = testWebhookSigner("current:test-secret", 5*time.Minute, true)
+	assert.Contains(t, send(http.Header{"X-Webhook-Token": {"wrong-secret"}}, body).Body.String(), "INVALID_WEBHOOK_SIGNATURE")
+	// The first callback finished the job, so reopen it
+	require.NoError(t, server.Config().Database.UpdateJobStatus("job-signed", store.StatusProcessing, nil))
+	assert.Equal(t, http.StatusOK, send(header, body).Code)
+}
+
+func TestN8NCallbackSecretRotation(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-rotated",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+
+	send := func(signer *n8n.Signer, withKeyID bool) int {
+		// Each accepted callback finishes the job, so reopen it first
+		require.NoError(t, server.Config().Database.UpdateJobStatus("job-rotated", store.StatusProcessing, nil))
+		body := []byte(`{"schema_version":1,"job_id":"job-rotated","status":"failed"}`)
+		req := httptest.NewRequest("POST", "/api/v1/n8n-callback", bytes.NewReader(body))
+		signer.Sign(req.Header, body)
+		if !withKeyID {
+			req.Header.Del(n8n.HeaderKeyID)
+		}
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Callbacks are accepted under any active secret, named or not
+	assert.Equal(t, http.StatusOK, send(testWebhookSigner("current:test-secret", time.Minute, false), true))
+	assert.Equal(t, http.StatusOK, send(testWebhookSigner("previous:old-secret", time.Minute, false), true))
+	assert.Equal(t, http.StatusOK, send(testWebhookSigner("previous:old-secret", time.Minute, false), false))
+
+	// A named key must be the one the request was signed with
+	assert.Equal(t, http.StatusUnauthorized, send(testWebhookSigner("current:old-secret", time.Minute, false), true))
+	assert.Equal(t, http.StatusUnauthorized, send(testWebhookSigner("retired:retired-secret", time.Minute, false), false))
+
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	var metrics api.MetricsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &metrics))
+	assert.Equal(t, map[string]int64{"current": 1, "previous": 2}, metrics.WebhookKeys)
+}
+
+func TestMetrics(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+
+	server.Routes().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response api.MetricsResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	assert.NotNil(t, response.JobStats)
+	assert.NotEmpty(t, response.Uptime)
+	require.NotNil(t, response.Storage)
+	assert.True(t, response.Storage.AcceptingJobs)
+	assert.Equal(t, 2, response.Storage.Limits.UserFiles)
+	require.Len(t, response.N8NEndpoints, 1)
+	assert.Equal(t, "http://test:5678/webhook", response.N8NEndpoints[0].URL)
+}
+
+func TestCreateRingtoneQuotaExceeded(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	createUserRingtone(t, server, "job-a", "user-1")
+	createUserRingtone(t, server, "job-b", "user-1")
+
+	createJob := func(userID string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(jobs.CreateJobRequest{
+			SourceURL: "https://www.youtube.com/watch?v=test",
+			UserID:    &userID,
+		})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/api/v1/create-ringtone", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+
+	// The user at their file quota is rejected
+	w := createJob("user-1")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var response api.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "QUOTA_EXCEEDED", response.Code)
+
+	// Other users are unaffected, until their jobs in progress reach the quota
+	w = createJob("user-2")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = createJob("user-2")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = createJob("user-2")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Concurrent requests cannot overrun the quota together
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = createJob("user-3").Code
+		}(i)
+	}
+	wg.Wait()
+	accepted := 0
+	for _, code := range codes {
+		if code == http.StatusAccepted {
+			accepted++
+		}
+	}
+	assert.Equal(t, 2, accepted)
+
+	// Existing ringtones can still be downloaded
+	ringtone, err := server.Config().Database.GetRingtoneByJobID("job-a")
+	require.NoError(t, err)
+	downloadURL := server.Config().DownloadSigner.SignDownloadURL(ringtone.FileName, "job-a", stringPtr("user-1"))
+	req := httptest.NewRequest("GET", downloadURL, nil)
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// admitOnce admits the first job only, as when a concurrent job takes the
+// last place in the quota between an upload's two admission checks
+type admitOnce struct {
+	admitted atomic.Bool
+}
+
+func (a *admitOnce) AdmitJob(userID *string) error {
+	if a.admitted.CompareAndSwap(false, true) {
+		return nil
+	}
+	return quota.ErrQuotaExceeded
+}
+
+func TestCreateRingtoneUploadRejectedLeavesNoSource(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.Config().JobManager.SetAdmission(&admitOnce{})
+
+	wav := testWAV(8000*2, 8000*2)
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("user_id", "uploader"))
+	part, err := form.CreateFormFile("file", "clip.wav")
+	require.NoError(t, err)
+	_, err = part.Write(wav)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest("POST", "/api/v1/create-ringtone/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "QUOTA_EXCEEDED")
+
+	// The stored source is deleted with the rejected job
+	sum := sha256.Sum256(wav)
+	assert.False(t, server.Config().FileManager.FileExists(files.BlobKey(hex.EncodeToString(sum[:]))))
+}
+
+func createUserRingtone(t *testing.T, server *api.Server, jobID, userID string) {
+	job := &store.Job{
+		ID:        jobID,
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		UserID:    &userID,
+		Status:    store.StatusCompleted,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	require.NoError(t, server.Config().Database.CreateJob(job))
+
+	ringtone := &store.Ringtone{
+		JobID:     jobID,
+		FileName:  jobID + ".mp3",
+		FilePath:  jobID + ".mp3",
+		Format:    "mp3",
+		CreatedAt: time.Now(),
+	}
+	require.NoError(t, server.Config().Database.CreateRingtone(ringtone))
+	require.NoError(t, server.Config().FileManager.SaveFile(ringtone.FileName, bytes.NewReader([]byte("audio-"+jobID))))
+}
+
+func waitForDataRequest(t *testing.T, server *api.Server, requestID string) privacy.Receipt {
+	var receipt privacy.Receipt
+	require.Eventually(t, func() bool {
+		req := httptest.NewRequest("GET", "/api/v1/data-requests/"+requestID, nil)
+		req.Header.Set("Authorization", "Bearer test-admin-token")
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			return false
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &receipt))
+		return receipt.Status == store.DataRequestCompleted
+	}, 2*time.Second, 10*time.Millisecond)
+	return receipt
+}
+
+func TestUserErasure(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	createUserRingtone(t, server, "job-a", "user-1")
+	createUserRingtone(t, server, "job-b", "user-1")
+	createUserRingtone(t, server, "job-c", "user-2")
+
+	req := httptest.NewRequest("POST", "/api/v1/users/user-1/erasure", nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var accepted privacy.Receipt
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	assert.Equal(t, privacy.SubjectHash("user-1"), accepted.SubjectHash)
+
+	receipt := waitForDataRequest(t, server, accepted.RequestID)
+	assert.Equal(t, 2, receipt.JobsProcessed)
+	assert.Equal(t, 2, receipt.RingtonesProcessed)
+	assert.Equal(t, 2, receipt.FilesProcessed)
+	assert.NotNil(t, receipt.CompletedAt)
+
+	db := server.Config().Database
+	job, err := db.GetJob("job-a")
+	require.NoError(t, err)
+	assert.Nil(t, job.UserID)
+	assert.Equal(t, store.ErasedSourceURL, job.SourceURL)
+
+	ringtone, err := db.GetRingtoneByJobID("job-a")
+	require.NoError(t, err)
+	assert.Nil(t, ringtone)
+	assert.False(t, server.Config().FileManager.FileExists("job-a.mp3"))
+
+	// Other users are untouched
+	assert.True(t, server.Config().FileManager.FileExists("job-c.mp3"))
+}
+
+func TestUserExport(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	createUserRingtone(t, server, "job-a", "user-1")
+
+	req := httptest.NewRequest("POST", "/api/v1/users/user-1/export", nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var accepted privacy.Receipt
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+
+	receipt := waitForDataRequest(t, server, accepted.RequestID)
+	require.NotNil(t, receipt.DownloadURL)
+	assert.Equal(t, 1, receipt.FilesProcessed)
+
+	req = httptest.NewRequest("GET", *receipt.DownloadURL, nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	// Personal data must not be kept by shared caches
+	assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	assert.Contains(t, names, "records.json")
+	assert.Contains(t, names, "audio/job-a/job-a.mp3")
+}
+
+func TestUserErasureDeletesExports(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	createUserRingtone(t, server, "job-a", "user-1")
+
+	dataRequest := func(path string) privacy.Receipt {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer test-admin-token")
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var accepted privacy.Receipt
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+		return waitForDataRequest(t, server, accepted.RequestID)
+	}
+
+	export := dataRequest("/api/v1/users/user-1/export")
+	require.NotNil(t, export.DownloadURL)
+	archive := "exports/" + export.RequestID + ".zip"
+	assert.True(t, server.Config().FileManager.FileExists(archive))
+
+	// The export no longer carries the user ID, but is erased with the rest
+	erasure := dataRequest("/api/v1/users/user-1/erasure")
+	assert.Equal(t, 2, erasure.FilesProcessed)
+	assert.False(t, server.Config().FileManager.FileExists(archive))
+
+	exportRequest, err := server.Config().Database.GetDataRequest(export.RequestID)
+	require.NoError(t, err)
+	assert.Nil(t, exportRequest.ArtifactPath)
+
+	req := httptest.NewRequest("GET", *export.DownloadURL, nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestUserErasureFailsJobsInProgress(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	userID := "user-1"
+	sum := sha256.Sum256([]byte("job-token"))
+	hash := hex.EncodeToString(sum[:])
+	db := server.Config().Database
+	require.NoError(t, db.CreateJob(&store.Job{
+		ID:                "job-running",
+		SourceURL:         "https://www.youtube.com/watch?v=test",
+		UserID:            &userID,
+		Status:            store.StatusProcessing,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		CallbackTokenHash: &hash,
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v1/users/user-1/erasure", nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var accepted privacy.Receipt
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	receipt := waitForDataRequest(t, server, accepted.RequestID)
+	assert.Equal(t, 1, receipt.JobsProcessed)
+
+	job, err := db.GetJob("job-running")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusFailed, job.Status)
+	require.NotNil(t, job.ErrorMessage)
+	assert.Equal(t, store.ErasedJobError, *job.ErrorMessage)
+
+	// A late result from n8n cannot recreate the erased user's ringtone
+	body := []byte(`{"schema_version":1,"status":"completed","file_path":"job-running.mp3"}`)
+	req = httptest.NewRequest("POST", "/api/v1/n8n-callback/job-running", bytes.NewReader(body))
+	req.Header.Set("X-Callback-Token", "job-token")
+	signCallback(req, body)
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "INVALID_CALLBACK_TOKEN")
+
+	ringtone, err := db.GetRingtoneByJobID("job-running")
+	require.NoError(t, err)
+	assert.Nil(t, ringtone)
+}
+
+func TestRingtoneBundle(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	createUserRingtone(t, server, "job-a", "user-1")
+	createUserRingtone(t, server, "job-b", "user-1")
+	createUserRingtone(t, server, "job-c", "user-2")
+
+	createBundle := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/ringtones/bundle", strings.NewReader(body)))
+		return w
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	w := createBundle(`{"job_ids":["job-a","job-b"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response api.BundleResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Ringtones)
+	assert.Equal(t, int64(len("audio-job-a")+len("audio-job-b")), response.SizeBytes)
+
+	w = get(response.BundleURL)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "ringtones.zip")
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	contents := map[string]string{}
+	for _, file := range archive.File {
+		rc, err := file.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		contents[file.Name] = string(data)
+	}
+	assert.Equal(t, "audio-job-a", contents["job-a.mp3"])
+	assert.Equal(t, "audio-job-b", contents["job-b.mp3"])
+
+	var manifest bundle.Manifest
+	require.NoError(t, json.Unmarshal([]byte(contents[bundle.ManifestFile]), &manifest))
+	require.Len(t, manifest.Ringtones, 2)
+	assert.Equal(t, "job-a", manifest.Ringtones[0].JobID)
+	assert.Equal(t, "job-a", manifest.Ringtones[0].Title)
+	require.NotNil(t, manifest.Ringtones[0].SourceURL)
+	assert.Equal(t, "https://www.youtube.com/watch?v=test", *manifest.Ringtones[0].SourceURL)
+
+	// Bundled ringtones count as downloaded
+	ringtone, err := server.Config().Database.GetRingtoneByJobID("job-b")
+	require.NoError(t, err)
+	assert.Equal(t, 1, ringtone.DownloadCount)
+
+	// A resumed download is not counted again
+	req := httptest.NewRequest("GET", response.BundleURL, nil)
+	req.Header.Set("Range", "bytes=100-")
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	ringtone, err = server.Config().Database.GetRingtoneByJobID("job-b")
+	require.NoError(t, err)
+	assert.Equal(t, 1, ringtone.DownloadCount)
+
+	// Bundle URLs must be signed for exactly their ringtones
+	parsed, err := url.Parse(response.BundleURL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, get("/api/v1/ringtones/bundle?ids="+parsed.Query().Get("ids")).Code)
+	query := parsed.Query()
+	query.Set("ids", fmt.Sprintf("%s,%d", query.Get("ids"), ringtone.ID+1))
+	assert.Equal(t, http.StatusForbidden, get("/api/v1/ringtones/bundle?"+query.Encode()).Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/ringtones/bundle?ids=1,x").Code)
+
+	// Only one owner's completed ringtones can be bundled, within the limits
+	w = createBundle(`{"job_ids":["job-a","job-c"]}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "OWNERSHIP_MISMATCH")
+	assert.Equal(t, http.StatusNotFound, createBundle(`{"job_ids":["job-a","missing"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, createBundle(`{"job_ids":[]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, createBundle(`{"job_ids":["a","b","c","d"]}`).Code)
+
+	require.NoError(t, server.Config().Database.CreateJob(&store.Job{
+		ID:        "job-queued",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		UserID:    stringPtr("user-1"),
+		Status:    store.StatusQueued,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	assert.Equal(t, http.StatusConflict, createBundle(`{"job_ids":["job-a","job-queued"]}`).Code)
+
+	large := bytes.Repeat([]byte{0}, 1<<20)
+	require.NoError(t, server.Config().FileManager.SaveFile("job-c.mp3", bytes.NewReader(large)))
+	createUserRingtone(t, server, "job-d", "user-2")
+	require.NoError(t, server.Config().FileManager.SaveFile("job-d.mp3", bytes.NewReader(large)))
+	w = createBundle(`{"job_ids":["job-c","job-d"]}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "BUNDLE_TOO_LARGE")
+}
+
+func TestDataRequestsRequireAdminToken(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/v1/users/user-1/erasure", nil)
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// Helper functions
+func intPtr(i int) *int {
+	return &i
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
 }
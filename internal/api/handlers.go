@@ -1,8 +1,15 @@
 package api
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,18 +17,44 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 
+	"ringtonic-backend/internal/bundle"
+	"ringtonic-backend/internal/contract"
 	"ringtonic-backend/internal/files"
 	"ringtonic-backend/internal/jobs"
+	"ringtonic-backend/internal/links"
 	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/media"
+	"ringtonic-backend/internal/n8n"
+	"ringtonic-backend/internal/privacy"
+	"ringtonic-backend/internal/quota"
+	"ringtonic-backend/internal/signing"
 	"ringtonic-backend/internal/store"
 )
 // Config holds server configuration
 type Config struct {
-	Database      *store.Store
-	FileManager   *files.Manager
-	JobManager    *jobs.Manager
-	Logger        *log.Logger
-	WebhookSecret string
+	Database       *store.Store
+	FileManager    *files.Manager
+	JobManager     *jobs.Manager
+	PrivacyManager *privacy.Manager
+	Bundles        *bundle.Builder
+	DownloadSigner *signing.DownloadSigner
+	UploadTokens   *signing.JobTokens
+	SourceTokens   *signing.JobTokens
+	Quota          *quota.Guard
+	Logger         *log.Logger
+	WebhookSigner  *n8n.Signer
+	AdminToken     string
+	// AllowUnsignedDownloads keeps plain /download/{filename} links working
+	// while clients migrate to signed URLs
+	AllowUnsignedDownloads bool
+	// Breaker reports whether n8n is reachable; nil when jobs are not sent
+	// to n8n or no breaker is configured
+	Breaker *n8n.Breaker
+	// N8NClient reports the state of each n8n endpoint; nil when jobs are
+	// not sent to n8n
+	N8NClient *n8n.Client
+	// Links makes the URLs in responses absolute; nil leaves them relative
+	Links *links.Builder
 }
 
 
@@ -43,12 +76,23 @@ type HealthResponse struct {
 	Status    string    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
 	Version   string    `json:"version"`
+	// N8N is the state of the circuit breaker in front of n8n
+	N8N *n8n.BreakerStatus `json:"n8n,omitempty"`
 }
 
 // MetricsResponse represents basic metrics
 type MetricsResponse struct {
 	JobStats map[string]int `json:"job_stats"`
 	Uptime   string         `json:"uptime"`
+	Storage  *quota.Status  `json:"storage,omitempty"`
+	// Downloads counts downloads of final files; previews are not counted
+	Downloads int64 `json:"downloads"`
+	// WebhookKeys counts the callbacks verified under each webhook key ID
+	// since the server started
+	WebhookKeys map[string]int64 `json:"webhook_keys,omitempty"`
+	// N8N is the state of the circuit breaker in front of n8n
+	N8N          *n8n.BreakerStatus   `json:"n8n,omitempty"`
+	N8NEndpoints []n8n.EndpointStatus `json:"n8n_endpoints,omitempty"`
 }
 
 var startTime = time.Now() //right now
@@ -93,8 +137,28 @@ func (s *Server) Routes() http.Handler {
 	// API routes
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Post("/create-ringtone", s.handleCreateRingtone)
+		r.Post("/create-ringtone/upload", s.handleCreateRingtoneUpload)
 		r.Get("/job-status/{jobID}", s.handleJobStatus)
+		r.Post("/jobs/{jobID}/suggest-trim", s.handleSuggestTrim)
 		r.Post("/n8n-callback", s.handleN8NCallback)
+		r.Post("/n8n-callback/{jobID}", s.handleN8NCallback)
+		r.Post("/n8n-upload/{jobID}", s.handleN8NUpload)
+		r.Post("/n8n-upload/{jobID}/preview", s.handleN8NPreviewUpload)
+		r.Post("/n8n-upload/{jobID}/formats/{format}", s.handleN8NFormatUpload)
+		r.Post("/ringtones/bundle", s.handleCreateBundle)
+		r.Get("/ringtones/bundle", s.handleBundle)
+		r.Get("/ringtones/{ringtoneID}/preview", s.handlePreview)
+		r.Get("/ringtones/{ringtoneID}/waveform", s.handleWaveform)
+		r.Get("/sources/{jobID}", s.handleSource)
+
+		// Admin-only user data requests
+		r.Group(func(r chi.Router) {
+			r.Use(s.requireAdmin)
+			r.Post("/users/{userID}/erasure", s.handleRequestErasure)
+			r.Post("/users/{userID}/export", s.handleRequestExport)
+			r.Get("/data-requests/{requestID}", s.handleDataRequestStatus)
+			r.Get("/data-requests/{requestID}/download", s.handleDataRequestDownload)
+		})
 	})
 
 	// File downloads
@@ -123,6 +187,30 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// requireAdmin rejects requests without the admin bearer token. Admin
+// endpoints are disabled entirely when no token is configured.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken == "" {
+			s.writeError(w, "Admin endpoints are disabled", "ADMIN_DISABLED", http.StatusForbidden)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			s.writeError(w, "Missing admin token", "MISSING_TOKEN", http.StatusUnauthorized)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			s.writeError(w, "Invalid admin token", "INVALID_TOKEN", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	response := HealthResponse{
@@ -131,6 +219,15 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		Version:   "1.0.0",
 	}
 
+	// The backend itself is up, but jobs wait while n8n is down
+	if s.config.Breaker != nil {
+		status := s.config.Breaker.Status()
+		response.N8N = &status
+		if status.State == n8n.CircuitOpen {
+			response.Status = "degraded"
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -143,15 +240,46 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	downloads, err := s.config.Database.GetDownloadCount()
+	if err != nil {
+		s.writeError(w, "Failed to get metrics", "METRICS_ERROR", http.StatusInternalServerError)
+		return
+	}
+
 	response := MetricsResponse{
-		JobStats: jobStats,
-		Uptime:   time.Since(startTime).String(),
+		JobStats:  jobStats,
+		Uptime:    time.Since(startTime).String(),
+		Downloads: downloads,
+	}
+
+	if s.config.Quota != nil {
+		storage, err := s.config.Quota.Status()
+		if err != nil {
+			s.config.Logger.Error("Failed to get storage usage", "error", err)
+		}
+		response.Storage = storage
+	}
+
+	if s.config.WebhookSigner != nil {
+		response.WebhookKeys = s.config.WebhookSigner.KeyUsage()
+	}
+
+	if s.config.Breaker != nil {
+		status := s.config.Breaker.Status()
+		response.N8N = &status
+	}
+
+	if s.config.N8NClient != nil {
+		response.N8NEndpoints = s.config.N8NClient.Endpoints()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// targetLoudnessMessage describes valid loudness targets in error responses
+var targetLoudnessMessage = fmt.Sprintf("target_lufs must be between %g and %g and requires normalize", jobs.MinTargetLUFS, jobs.MaxTargetLUFS)
+
 // handleCreateRingtone handles ringtone creation requests
 func (s *Server) handleCreateRingtone(w http.ResponseWriter, r *http.Request) {
 	var req jobs.CreateJobRequest
@@ -174,6 +302,26 @@ func (s *Server) handleCreateRingtone(w http.ResponseWriter, r *http.Request) {
 
 	// Create job
 	response, err := s.config.JobManager.CreateJob(&req)
+	if errors.Is(err, jobs.ErrUnsupportedFormat) {
+		s.writeError(w, fmt.Sprintf("Output formats must be among %s", strings.Join(jobs.SupportedFormats, ", ")), "UNSUPPORTED_FORMAT", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, jobs.ErrInvalidTargetLoudness) {
+		s.writeError(w, targetLoudnessMessage, "INVALID_TARGET_LUFS", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, jobs.ErrAutoStartUnavailable) {
+		s.writeError(w, strings.TrimPrefix(err.Error(), jobs.ErrAutoStartUnavailable.Error()+": "), "AUTO_START_UNAVAILABLE", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, quota.ErrQuotaExceeded) {
+		s.writeError(w, "Storage quota exceeded", "QUOTA_EXCEEDED", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, quota.ErrStorageLow) {
+		s.writeError(w, "Service is not accepting new jobs", "STORAGE_LOW", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		s.config.Logger.Error("Failed to create job", "error", err, "source_url", req.SourceURL)
 		s.writeError(w, "Failed to create job", "JOB_CREATION_ERROR", http.StatusInternalServerError)
@@ -185,6 +333,97 @@ func (s *Server) handleCreateRingtone(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleCreateRingtoneUpload creates a job from an uploaded audio or video
+// file. The body is multipart/form-data; the optional "user_id" and
+// "options" fields must precede the "file" part, which is streamed.
+func (s *Server) handleCreateRingtoneUpload(w http.ResponseWriter, r *http.Request) {
+	if s.config.SourceTokens == nil {
+		s.writeError(w, "Source uploads are disabled", "UPLOADS_DISABLED", http.StatusNotFound)
+		return
+	}
+	liftUploadDeadlines(w)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		s.writeError(w, "Expected a multipart/form-data body", "INVALID_UPLOAD", http.StatusBadRequest)
+		return
+	}
+
+	var req jobs.CreateUploadJobRequest
+	var content io.Reader
+	for content == nil {
+		part, err := reader.NextPart()
+		if err != nil {
+			s.writeError(w, "Upload has no file part", "MISSING_FILE", http.StatusBadRequest)
+			return
+		}
+
+		switch part.FormName() {
+		case "file":
+			req.FileName = part.FileName()
+			content = part
+		case "user_id":
+			value, err := io.ReadAll(io.LimitReader(part, 256))
+			if err != nil {
+				s.writeError(w, "Invalid multipart body", "INVALID_UPLOAD", http.StatusBadRequest)
+				return
+			}
+			if userID := strings.TrimSpace(string(value)); userID != "" {
+				req.UserID = &userID
+			}
+		case "options":
+			var options store.JobOptions
+			if err := json.NewDecoder(io.LimitReader(part, 64*1024)).Decode(&options); err != nil {
+				s.writeError(w, "Invalid options JSON", "INVALID_JSON", http.StatusBadRequest)
+				return
+			}
+			req.Options = &options
+		}
+	}
+
+	response, err := s.config.JobManager.CreateUploadJob(&req, content)
+	switch {
+	case errors.Is(err, jobs.ErrSourceUploadsDisabled):
+		s.writeError(w, "Source uploads are disabled", "UPLOADS_DISABLED", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrUnsupportedFormat):
+		s.writeError(w, fmt.Sprintf("Output formats must be among %s", strings.Join(jobs.SupportedFormats, ", ")), "UNSUPPORTED_FORMAT", http.StatusBadRequest)
+		return
+	case errors.Is(err, jobs.ErrInvalidTargetLoudness):
+		s.writeError(w, targetLoudnessMessage, "INVALID_TARGET_LUFS", http.StatusBadRequest)
+		return
+	case errors.Is(err, jobs.ErrAutoStartUnavailable):
+		s.writeError(w, strings.TrimPrefix(err.Error(), jobs.ErrAutoStartUnavailable.Error()+": "), "AUTO_START_UNAVAILABLE", http.StatusBadRequest)
+		return
+	case errors.Is(err, jobs.ErrInvalidTrimRequest):
+		s.writeError(w, strings.TrimPrefix(err.Error(), jobs.ErrInvalidTrimRequest.Error()+": "), "INVALID_TRIM_REQUEST", http.StatusBadRequest)
+		return
+	case errors.Is(err, quota.ErrQuotaExceeded):
+		s.writeError(w, "Storage quota exceeded", "QUOTA_EXCEEDED", http.StatusForbidden)
+		return
+	case errors.Is(err, quota.ErrStorageLow):
+		s.writeError(w, "Service is not accepting new jobs", "STORAGE_LOW", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, jobs.ErrSourceTooLarge):
+		s.writeError(w, "File exceeds maximum size", "FILE_TOO_LARGE", http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, jobs.ErrUnsupportedSource):
+		s.writeError(w, "File is not a supported audio or video format", "UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, jobs.ErrSourceTooLong):
+		s.writeError(w, "File exceeds maximum duration", "SOURCE_TOO_LONG", http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		s.config.Logger.Error("Failed to create upload job", "error", err, "file_name", req.FileName)
+		s.writeError(w, "Failed to create job", "JOB_CREATION_ERROR", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
 // handleJobStatus handles job status requests
 func (s *Server) handleJobStatus(w http.ResponseWriter, r *http.Request) {
 	jobID := chi.URLParam(r, "jobID")
@@ -208,26 +447,128 @@ func (s *Server) handleJobStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleSuggestTrim proposes windows of a job's uploaded source to cut the
+// ringtone at, so the trimmer can jump straight to them. The body is
+// optional; without it the defaults apply.
+func (s *Server) handleSuggestTrim(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	var req jobs.SuggestTrimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.writeError(w, "Invalid JSON payload", "INVALID_JSON", http.StatusBadRequest)
+		return
+	}
+
+	response, err := s.config.JobManager.SuggestTrim(jobID, &req)
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		s.writeError(w, "Job not found", "JOB_NOT_FOUND", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrInvalidTrimRequest):
+		s.writeError(w, strings.TrimPrefix(err.Error(), jobs.ErrInvalidTrimRequest.Error()+": "), "INVALID_TRIM_REQUEST", http.StatusBadRequest)
+		return
+	case errors.Is(err, jobs.ErrSourceNotAvailable):
+		s.writeError(w, "Only jobs created from an uploaded file can be analyzed", "SOURCE_NOT_AVAILABLE", http.StatusConflict)
+		return
+	case errors.Is(err, media.ErrUnsupported):
+		s.writeError(w, "Trim suggestions are only available for WAV and MP3 audio", "UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, files.ErrNotExist):
+		s.writeError(w, "File not found", "FILE_NOT_FOUND", http.StatusNotFound)
+		return
+	case err != nil:
+		s.config.Logger.Error("Failed to suggest trim", "error", err, "job_id", jobID)
+		s.writeError(w, "Failed to analyze source", "TRIM_ERROR", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxCallbackBytes bounds callback bodies, which carry paths and metadata
+// rather than audio
+const maxCallbackBytes = 1 << 20
+
+// verifyWebhookSignature checks that a callback was signed by the workflow
+// and is not a replay, writing the error response if it is not
+func (s *Server) verifyWebhookSignature(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	err := s.config.WebhookSigner.Verify(r.Header, body)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, n8n.ErrMissingSignature):
+		s.writeError(w, "Missing webhook signature", "MISSING_WEBHOOK_SIGNATURE", http.StatusUnauthorized)
+	case errors.Is(err, n8n.ErrStaleTimestamp):
+		s.writeError(w, "Webhook timestamp is outside the allowed window", "STALE_TIMESTAMP", http.StatusUnauthorized)
+	case errors.Is(err, n8n.ErrReplayedNonce):
+		s.writeError(w, "Webhook nonce has already been used", "REPLAYED_NONCE", http.StatusUnauthorized)
+	default:
+		s.writeError(w, "Invalid webhook signature", "INVALID_WEBHOOK_SIGNATURE", http.StatusUnauthorized)
+	}
+	s.config.Logger.Warn("Rejected callback", "error", err, "remote_addr", r.RemoteAddr)
+	return false
+}
+
+// verifyCallbackToken checks that a callback carries its job's own token,
+// writing an error response if not. A leaked webhook secret alone is then
+// not enough to report the outcome of any job, so rejections are logged as
+// security events.
+func (s *Server) verifyCallbackToken(w http.ResponseWriter, r *http.Request, jobID string) bool {
+	err := s.config.JobManager.VerifyCallbackToken(jobID, r.Header.Get(contract.CallbackTokenHeader))
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, jobs.ErrMissingCallbackToken):
+		s.writeError(w, "Missing callback token", "MISSING_CALLBACK_TOKEN", http.StatusUnauthorized)
+	case errors.Is(err, jobs.ErrInvalidCallbackToken):
+		s.writeError(w, "Invalid callback token", "INVALID_CALLBACK_TOKEN", http.StatusUnauthorized)
+	default:
+		s.config.Logger.Error("Failed to verify callback token", "error", err, "job_id", jobID)
+		s.writeError(w, "Failed to process callback", "CALLBACK_ERROR", http.StatusInternalServerError)
+		return false
+	}
+	s.config.Logger.Warn("Security event: rejected callback token",
+		"event", "callback_token_rejected", "reason", err.Error(), "job_id", jobID, "remote_addr", r.RemoteAddr)
+	return false
+}
+
 // handleN8NCallback handles n8n callback requests
 func (s *Server) handleN8NCallback(w http.ResponseWriter, r *http.Request) {
-	// Verify webhook token
-	token := r.Header.Get("X-Webhook-Token")
-	if token == "" {
-		s.writeError(w, "Missing webhook token", "MISSING_TOKEN", http.StatusUnauthorized)
+	// The signature covers the raw body, so it is read in full first
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBytes))
+	if err != nil {
+		s.writeError(w, "Callback body too large", "PAYLOAD_TOO_LARGE", http.StatusRequestEntityTooLarge)
 		return
 	}
 
-	if token != s.config.WebhookSecret {
-		s.writeError(w, "Invalid webhook token", "INVALID_TOKEN", http.StatusUnauthorized)
+	if !s.verifyWebhookSignature(w, r, body) {
 		return
 	}
 
-	var req jobs.CallbackRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	// Callbacks are decoded strictly, so a workflow that drifts from the
+	// contract is told how instead of having fields silently ignored
+	req, err := contract.DecodeCallback(body)
+	var invalid *contract.ValidationError
+	if errors.As(err, &invalid) {
+		s.writeError(w, "Callback does not match schema: "+strings.Join(invalid.Problems, "; "), "INVALID_CALLBACK", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
 		s.writeError(w, "Invalid JSON payload", "INVALID_JSON", http.StatusBadRequest)
 		return
 	}
 
+	// Per-job callback URLs name the job, so the body need not. The route
+	// without a job ID remains for jobs dispatched before it existed.
+	if jobID := chi.URLParam(r, "jobID"); jobID != "" {
+		if req.JobID != "" && req.JobID != jobID {
+			s.writeError(w, "job_id does not match the callback URL", "JOB_ID_MISMATCH", http.StatusBadRequest)
+			return
+		}
+		req.JobID = jobID
+	}
+
 	// Validate request
 	if req.JobID == "" {
 		s.writeError(w, "job_id is required", "MISSING_JOB_ID", http.StatusBadRequest)
@@ -239,8 +580,39 @@ func (s *Server) handleN8NCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.verifyCallbackToken(w, r, req.JobID) {
+		return
+	}
+
 	// Process callback
-	if err := s.config.JobManager.HandleCallback(&req); err != nil {
+	err = s.config.JobManager.HandleCallback(req)
+	if errors.Is(err, jobs.ErrJobNotPending) {
+		// A job finishes once, so its token was used by another callback
+		s.config.Logger.Warn("Security event: callback for finished job",
+			"event", "callback_token_reused", "job_id", req.JobID, "remote_addr", r.RemoteAddr)
+		s.writeError(w, "Job is already finished", "JOB_NOT_PENDING", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, jobs.ErrInvalidFilePath) {
+		s.config.Logger.Warn("Rejected callback file path", "error", err, "job_id", req.JobID)
+		s.writeError(w, "Invalid file_path", "INVALID_FILE_PATH", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, jobs.ErrUnsupportedFormat) {
+		s.writeError(w, "Unsupported output format", "UNSUPPORTED_FORMAT", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, files.ErrChecksumMismatch) {
+		s.config.Logger.Warn("Rejected callback file", "error", err, "job_id", req.JobID)
+		s.writeError(w, "File does not match sha256", "CHECKSUM_MISMATCH", http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, files.ErrFileTooLarge) {
+		s.config.Logger.Warn("Rejected callback file", "error", err, "job_id", req.JobID)
+		s.writeError(w, "File exceeds maximum size", "FILE_TOO_LARGE", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
 		s.config.Logger.Error("Failed to handle callback", "error", err, "job_id", req.JobID)
 		s.writeError(w, "Failed to process callback", "CALLBACK_ERROR", http.StatusInternalServerError)
 		return
@@ -250,6 +622,302 @@ func (s *Server) handleN8NCallback(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"ok"}`))
 }
 
+// UploadResponse represents the response to a completed upload
+type UploadResponse struct {
+	Status    string `json:"status"`
+	JobID     string `json:"job_id"`
+	SHA256    string `json:"sha256"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// handleN8NUpload receives produced audio from a worker and completes the
+// job. The body is either multipart/form-data with a "file" part, or the
+// audio itself. Either way it is streamed into storage without buffering.
+func (s *Server) handleN8NUpload(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	if !s.verifyUploadToken(w, r, jobID) {
+		return
+	}
+	// Completing the job is a terminal callback, so it takes the job's
+	// one-time callback token too
+	if !s.verifyCallbackToken(w, r, jobID) {
+		return
+	}
+
+	upload, content, ok := s.readUpload(w, r, jobID)
+	if !ok {
+		return
+	}
+
+	ringtone, err := s.config.JobManager.HandleUpload(upload, content)
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		s.writeError(w, "Job not found", "JOB_NOT_FOUND", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrJobNotPending):
+		s.writeError(w, "Job is already finished", "JOB_NOT_PENDING", http.StatusConflict)
+		return
+	case errors.Is(err, files.ErrChecksumMismatch):
+		s.writeError(w, "File does not match sha256", "CHECKSUM_MISMATCH", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, files.ErrFileTooLarge):
+		s.writeError(w, "File exceeds maximum size", "FILE_TOO_LARGE", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		s.config.Logger.Error("Failed to handle upload", "error", err, "job_id", jobID)
+		s.writeError(w, "Failed to process upload", "UPLOAD_ERROR", http.StatusInternalServerError)
+		return
+	}
+
+	response := UploadResponse{
+		Status:    "ok",
+		JobID:     jobID,
+		SHA256:    *ringtone.BlobHash,
+		SizeBytes: *ringtone.SizeBytes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleN8NPreviewUpload receives a preview rendition from a worker for a
+// job whose final file has already been delivered. The body is read as by
+// handleN8NUpload.
+func (s *Server) handleN8NPreviewUpload(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	if !s.verifyUploadToken(w, r, jobID) {
+		return
+	}
+
+	upload, content, ok := s.readUpload(w, r, jobID)
+	if !ok {
+		return
+	}
+
+	asset, err := s.config.JobManager.HandlePreviewUpload(upload, content)
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		s.writeError(w, "Job not found", "JOB_NOT_FOUND", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrJobNotCompleted):
+		s.writeError(w, "Job has no final file yet", "JOB_NOT_COMPLETED", http.StatusConflict)
+		return
+	case errors.Is(err, jobs.ErrUploadWindowClosed):
+		s.writeError(w, "Upload token has expired", "UPLOAD_TOKEN_EXPIRED", http.StatusUnauthorized)
+		return
+	case errors.Is(err, files.ErrChecksumMismatch):
+		s.writeError(w, "File does not match sha256", "CHECKSUM_MISMATCH", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, files.ErrFileTooLarge):
+		s.writeError(w, "File exceeds maximum size", "FILE_TOO_LARGE", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		s.config.Logger.Error("Failed to handle preview upload", "error", err, "job_id", jobID)
+		s.writeError(w, "Failed to process upload", "UPLOAD_ERROR", http.StatusInternalServerError)
+		return
+	}
+
+	response := UploadResponse{
+		Status:    "ok",
+		JobID:     jobID,
+		SHA256:    asset.BlobHash,
+		SizeBytes: asset.SizeBytes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleN8NFormatUpload receives a rendition in an additional output format
+// from a worker for a job whose final file has already been delivered. The
+// body is read as by handleN8NUpload.
+func (s *Server) handleN8NFormatUpload(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	if !s.verifyUploadToken(w, r, jobID) {
+		return
+	}
+
+	upload, content, ok := s.readUpload(w, r, jobID)
+	if !ok {
+		return
+	}
+
+	asset, err := s.config.JobManager.HandleFormatUpload(upload, chi.URLParam(r, "format"), content)
+	switch {
+	case errors.Is(err, jobs.ErrUnsupportedFormat):
+		s.writeError(w, "Unsupported output format", "UNSUPPORTED_FORMAT", http.StatusBadRequest)
+		return
+	case errors.Is(err, jobs.ErrJobNotFound):
+		s.writeError(w, "Job not found", "JOB_NOT_FOUND", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrJobNotCompleted):
+		s.writeError(w, "Job has no final file yet", "JOB_NOT_COMPLETED", http.StatusConflict)
+		return
+	case errors.Is(err, jobs.ErrUploadWindowClosed):
+		s.writeError(w, "Upload token has expired", "UPLOAD_TOKEN_EXPIRED", http.StatusUnauthorized)
+		return
+	case errors.Is(err, jobs.ErrJobNotPending):
+		s.writeError(w, "Format was delivered as the final file", "JOB_NOT_PENDING", http.StatusConflict)
+		return
+	case errors.Is(err, files.ErrChecksumMismatch):
+		s.writeError(w, "File does not match sha256", "CHECKSUM_MISMATCH", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, files.ErrFileTooLarge):
+		s.writeError(w, "File exceeds maximum size", "FILE_TOO_LARGE", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		s.config.Logger.Error("Failed to handle format upload", "error", err, "job_id", jobID)
+		s.writeError(w, "Failed to process upload", "UPLOAD_ERROR", http.StatusInternalServerError)
+		return
+	}
+
+	response := UploadResponse{
+		Status:    "ok",
+		JobID:     jobID,
+		SHA256:    asset.BlobHash,
+		SizeBytes: asset.SizeBytes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// verifyUploadToken checks a worker's bearer token for a job, writing an
+// error response if it is not acceptable
+func (s *Server) verifyUploadToken(w http.ResponseWriter, r *http.Request, jobID string) bool {
+	if s.config.UploadTokens == nil {
+		s.writeError(w, "Uploads are disabled", "UPLOADS_DISABLED", http.StatusNotFound)
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		s.writeError(w, "Missing upload token", "MISSING_TOKEN", http.StatusUnauthorized)
+		return false
+	}
+	if !s.config.UploadTokens.Verify(jobID, token) {
+		s.writeError(w, "Invalid upload token", "INVALID_TOKEN", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// liftUploadDeadlines clears the server's read and write deadlines for a
+// request whose body is a large upload. Such bodies may take longer to
+// arrive than the server's timeouts, and are bounded by size limits instead.
+func liftUploadDeadlines(w http.ResponseWriter) {
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+}
+
+// readUpload reads the fields of a worker upload and returns the content to
+// stream, writing an error response if the body is malformed. Multipart
+// fields must precede the file part.
+func (s *Server) readUpload(w http.ResponseWriter, r *http.Request, jobID string) (*jobs.UploadRequest, io.Reader, bool) {
+	liftUploadDeadlines(w)
+
+	upload := &jobs.UploadRequest{
+		JobID:    jobID,
+		FileName: r.Header.Get("X-File-Name"),
+		SHA256:   r.Header.Get("X-Content-SHA256"),
+	}
+	duration := r.Header.Get("X-Duration")
+
+	var content io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			s.writeError(w, "Invalid multipart body", "INVALID_UPLOAD", http.StatusBadRequest)
+			return nil, nil, false
+		}
+
+		// Fields are read until the file part, which is streamed
+		content = nil
+		for content == nil {
+			part, err := reader.NextPart()
+			if err != nil {
+				s.writeError(w, "Upload has no file part", "MISSING_FILE", http.StatusBadRequest)
+				return nil, nil, false
+			}
+
+			switch part.FormName() {
+			case "file":
+				if part.FileName() != "" {
+					upload.FileName = part.FileName()
+				}
+				content = part
+			case "sha256", "duration":
+				value, err := io.ReadAll(io.LimitReader(part, 256))
+				if err != nil {
+					s.writeError(w, "Invalid multipart body", "INVALID_UPLOAD", http.StatusBadRequest)
+					return nil, nil, false
+				}
+				if part.FormName() == "sha256" {
+					upload.SHA256 = strings.TrimSpace(string(value))
+				} else {
+					duration = strings.TrimSpace(string(value))
+				}
+			}
+		}
+	}
+
+	if duration != "" {
+		seconds, err := strconv.ParseFloat(duration, 64)
+		if err != nil || seconds < 0 {
+			s.writeError(w, "Invalid duration", "INVALID_UPLOAD", http.StatusBadRequest)
+			return nil, nil, false
+		}
+		durationInt := int(seconds)
+		upload.DurationSeconds = &durationInt
+	}
+
+	return upload, content, true
+}
+
+// handleSource serves an uploaded source file to the workflow. The token in
+// the query string is valid for a single job only.
+func (s *Server) handleSource(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	if s.config.SourceTokens == nil {
+		s.writeError(w, "Source uploads are disabled", "UPLOADS_DISABLED", http.StatusNotFound)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		s.writeError(w, "Missing source token", "MISSING_TOKEN", http.StatusUnauthorized)
+		return
+	}
+	if !s.config.SourceTokens.Verify(jobID, token) {
+		s.writeError(w, "Invalid source token", "INVALID_TOKEN", http.StatusUnauthorized)
+		return
+	}
+
+	job, err := s.config.Database.GetJob(jobID)
+	if err != nil {
+		s.config.Logger.Error("Failed to get job", "error", err, "job_id", jobID)
+		s.writeError(w, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError)
+		return
+	}
+	if job == nil || job.SourceBlobHash == nil {
+		s.writeError(w, "File not found", "FILE_NOT_FOUND", http.StatusNotFound)
+		return
+	}
+
+	name := "source"
+	if job.SourceMIMEType != nil {
+		name += media.Extension(*job.SourceMIMEType)
+	}
+	if err := s.config.FileManager.ServeFileAs(w, r, files.BlobKey(*job.SourceBlobHash), name); err != nil {
+		s.config.Logger.Error("Failed to serve source file", "error", err, "job_id", jobID)
+	}
+}
+
 // handleDownload handles file download requests
 func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	filename := chi.URLParam(r, "filename")
@@ -284,13 +952,493 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verify the URL signature
+	if !s.verifyDownloadURL(w, r, ringtone.FileName, job) {
+		return
+	}
+
+	// Select the requested output format; the signature covers them all
+	key, downloadName := ringtone.StorageKey(), ringtone.DownloadName()
+	if format := r.URL.Query().Get("format"); format != "" && format != ringtone.Format {
+		asset, err := s.config.Database.GetRingtoneAsset(ringtone.ID, store.FormatAsset(format))
+		if err != nil {
+			s.config.Logger.Error("Failed to get format", "error", err, "filename", filename, "format", format)
+			s.writeError(w, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError)
+			return
+		}
+		if asset == nil {
+			s.writeError(w, "Format not available", "FORMAT_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+		key = asset.FilePath
+		downloadName = strings.TrimSuffix(downloadName, path.Ext(downloadName)) + "." + format
+	}
+
+	// Count downloads once, not for every range a client resumes from
+	if isInitialRequest(r) {
+		if err := s.config.Database.IncrementDownloadCount(ringtone.ID); err != nil {
+			s.config.Logger.Error("Failed to count download", "error", err, "filename", filename)
+		}
+	}
+
 	// Serve file
-	if err := s.config.FileManager.ServeFile(w, r, filename); err != nil {
+	if err := s.config.FileManager.ServeFileAs(w, r, key, downloadName); err != nil {
 		s.config.Logger.Error("Failed to serve file", "error", err, "filename", filename)
 		// Error response already handled by ServeFile
 	}
 }
 
+// handlePreview streams a ringtone's preview rendition for playback. Unlike
+// downloads, previews are served inline, support seeking, are not cached
+// publicly and are not counted as downloads.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	ringtone, job, ok := s.lookupRingtone(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.verifyPreviewURL(w, r, ringtone.ID, job) {
+		return
+	}
+
+	preview, err := s.config.Database.GetRingtoneAsset(ringtone.ID, store.AssetPreview)
+	if err != nil {
+		s.config.Logger.Error("Failed to get preview", "error", err, "ringtone_id", ringtone.ID)
+		s.writeError(w, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError)
+		return
+	}
+	if preview == nil {
+		s.writeError(w, "Preview not found", "PREVIEW_NOT_FOUND", http.StatusNotFound)
+		return
+	}
+
+	if err := s.config.FileManager.ServeInline(w, r, preview.FilePath, preview.FileName); err != nil {
+		s.config.Logger.Error("Failed to serve preview", "error", err, "ringtone_id", ringtone.ID)
+	}
+}
+
+// WaveformResponse represents waveform data for a ringtone
+type WaveformResponse struct {
+	RingtoneID int    `json:"ringtone_id"`
+	Source     string `json:"source"`
+	*media.Waveform
+}
+
+// defaultWaveformBuckets is the waveform resolution when none is requested
+const defaultWaveformBuckets = 800
+
+// handleWaveform serves peak and RMS levels of a ringtone's audio, or of the
+// uploaded source it was cut from, for drawing in the trimmer. Waveforms are
+// computed on first request and cached next to the audio file.
+func (s *Server) handleWaveform(w http.ResponseWriter, r *http.Request) {
+	ringtone, job, ok := s.lookupRingtone(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.verifyWaveformURL(w, r, ringtone.ID, job) {
+		return
+	}
+
+	buckets := defaultWaveformBuckets
+	if value := r.URL.Query().Get("buckets"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > media.MaxWaveformBuckets {
+			s.writeError(w, fmt.Sprintf("buckets must be between 1 and %d", media.MaxWaveformBuckets), "INVALID_BUCKETS", http.StatusBadRequest)
+			return
+		}
+		buckets = n
+	}
+
+	source := r.URL.Query().Get("source")
+	key := ringtone.StorageKey()
+	switch source {
+	case "", "ringtone":
+		source = "ringtone"
+	case "original":
+		if job.SourceBlobHash == nil {
+			s.writeError(w, "Job has no uploaded source", "SOURCE_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+		key = files.BlobKey(*job.SourceBlobHash)
+	default:
+		s.writeError(w, "source must be ringtone or original", "INVALID_SOURCE", http.StatusBadRequest)
+		return
+	}
+
+	waveform, err := s.config.FileManager.Waveform(key, buckets)
+	switch {
+	case errors.Is(err, media.ErrUnsupported):
+		s.writeError(w, "Waveforms are only available for WAV and MP3 audio", "UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, files.ErrNotExist):
+		s.writeError(w, "File not found", "FILE_NOT_FOUND", http.StatusNotFound)
+		return
+	case err != nil:
+		s.config.Logger.Error("Failed to compute waveform", "error", err, "ringtone_id", ringtone.ID)
+		s.writeError(w, "Failed to compute waveform", "WAVEFORM_ERROR", http.StatusInternalServerError)
+		return
+	}
+
+	// Stored content never changes, so neither does its waveform
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+
+	if r.URL.Query().Get("format") == "binary" || r.Header.Get("Accept") == "application/octet-stream" {
+		encoded, err := waveform.MarshalBinary()
+		if err != nil {
+			s.writeError(w, "Failed to encode waveform", "WAVEFORM_ERROR", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(encoded)
+		return
+	}
+
+	response := WaveformResponse{
+		RingtoneID: ringtone.ID,
+		Source:     source,
+		Waveform:   waveform,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// BundleRequest names the jobs whose ringtones to bundle
+type BundleRequest struct {
+	JobIDs []string `json:"job_ids"`
+}
+
+// BundleResponse describes a bundle and the URL it is downloaded from
+type BundleResponse struct {
+	BundleURL string `json:"bundle_url"`
+	Ringtones int    `json:"ringtones"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// handleCreateBundle checks that a set of completed jobs may be downloaded
+// together and returns the signed URL of their bundle. Job IDs authorize
+// access like they do for job status; the signature then binds the bundle
+// to the jobs' common owner.
+func (s *Server) handleCreateBundle(w http.ResponseWriter, r *http.Request) {
+	if s.config.Bundles == nil {
+		s.writeError(w, "Bundles are disabled", "BUNDLES_DISABLED", http.StatusNotFound)
+		return
+	}
+
+	var req BundleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, "Invalid JSON payload", "INVALID_JSON", http.StatusBadRequest)
+		return
+	}
+
+	bundle, err := s.config.Bundles.ResolveJobs(req.JobIDs)
+	if !s.checkBundle(w, err) {
+		return
+	}
+
+	bundleURL := "/api/v1/ringtones/bundle?ids=" + joinIDs(bundle.RingtoneIDs())
+	if s.config.DownloadSigner != nil {
+		bundleURL = s.config.DownloadSigner.SignBundleURL(bundle.RingtoneIDs(), bundle.Owner)
+	}
+
+	response := BundleResponse{
+		BundleURL: s.publicURL(bundleURL),
+		Ringtones: len(bundle.Items),
+		SizeBytes: bundle.SizeBytes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleBundle streams a ZIP archive of several ringtones and a manifest
+// describing them. The URL must be a signed bundle_url.
+func (s *Server) handleBundle(w http.ResponseWriter, r *http.Request) {
+	if s.config.Bundles == nil {
+		s.writeError(w, "Bundles are disabled", "BUNDLES_DISABLED", http.StatusNotFound)
+		return
+	}
+
+	var ids []int
+	for _, field := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			s.writeError(w, "ids must be a comma-separated list of ringtone IDs", "INVALID_IDS", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	// Ownership is checked again, as jobs may have changed hands since the
+	// URL was signed
+	bundle, err := s.config.Bundles.ResolveRingtones(ids)
+	if !s.checkBundle(w, err) {
+		return
+	}
+
+	if s.config.DownloadSigner != nil {
+		err := s.config.DownloadSigner.VerifyBundleURL(ids, bundle.Owner, r.URL.Query())
+		if !s.checkSignature(w, err, "bundle "+joinIDs(ids), bundle.Items[0].Job, false) {
+			return
+		}
+	}
+
+	// Count downloads once, not for every retry of a range
+	if isInitialRequest(r) {
+		for _, item := range bundle.Items {
+			if err := s.config.Database.IncrementDownloadCount(item.Ringtone.ID); err != nil {
+				s.config.Logger.Error("Failed to count download", "error", err, "ringtone_id", item.Ringtone.ID)
+			}
+		}
+	}
+
+	// Bundles may take longer to stream than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="ringtones.zip"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	// Once streaming has started, errors can only cut the archive short
+	if n, err := s.config.Bundles.Write(w, bundle); err != nil {
+		s.config.Logger.Error("Failed to stream bundle", "error", err, "files_written", n)
+	}
+}
+
+// checkBundle maps a bundle resolution error to an error response
+func (s *Server) checkBundle(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, bundle.ErrEmpty):
+		s.writeError(w, "A bundle needs at least one ringtone", "EMPTY_BUNDLE", http.StatusBadRequest)
+	case errors.Is(err, bundle.ErrTooManyItems):
+		s.writeError(w, err.Error(), "TOO_MANY_RINGTONES", http.StatusBadRequest)
+	case errors.Is(err, bundle.ErrNotFound):
+		s.writeError(w, err.Error(), "RINGTONE_NOT_FOUND", http.StatusNotFound)
+	case errors.Is(err, bundle.ErrNotCompleted):
+		s.writeError(w, err.Error(), "JOB_NOT_COMPLETED", http.StatusConflict)
+	case errors.Is(err, bundle.ErrOwnerMismatch):
+		s.writeError(w, "Ringtones belong to different users", "OWNERSHIP_MISMATCH", http.StatusForbidden)
+	case errors.Is(err, bundle.ErrTooLarge):
+		s.writeError(w, err.Error(), "BUNDLE_TOO_LARGE", http.StatusRequestEntityTooLarge)
+	default:
+		s.config.Logger.Error("Failed to resolve bundle", "error", err)
+		s.writeError(w, "Failed to build bundle", "BUNDLE_ERROR", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// joinIDs formats ringtone IDs as a comma-separated list
+func joinIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// lookupRingtone loads the ringtone named in the URL and its job, writing an
+// error response if it does not exist or its job is not completed
+func (s *Server) lookupRingtone(w http.ResponseWriter, r *http.Request) (*store.Ringtone, *store.Job, bool) {
+	ringtoneID, err := strconv.Atoi(chi.URLParam(r, "ringtoneID"))
+	if err != nil {
+		s.writeError(w, "Ringtone not found", "RINGTONE_NOT_FOUND", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	ringtone, err := s.config.Database.GetRingtone(ringtoneID)
+	if err != nil {
+		s.config.Logger.Error("Failed to get ringtone", "error", err, "ringtone_id", ringtoneID)
+		s.writeError(w, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if ringtone == nil {
+		s.writeError(w, "Ringtone not found", "RINGTONE_NOT_FOUND", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	job, err := s.config.Database.GetJob(ringtone.JobID)
+	if err != nil {
+		s.config.Logger.Error("Failed to get job", "error", err, "job_id", ringtone.JobID)
+		s.writeError(w, "Internal server error", "INTERNAL_ERROR", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if job == nil || job.Status != store.StatusCompleted {
+		s.writeError(w, "File not available", "FILE_NOT_AVAILABLE", http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	return ringtone, job, true
+}
+
+// isInitialRequest reports whether a request fetches a file from its start,
+// as opposed to resuming or seeking with a range
+func isInitialRequest(r *http.Request) bool {
+	rangeHeader := r.Header.Get("Range")
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// handleRequestErasure handles user data erasure requests
+func (s *Server) handleRequestErasure(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	req, err := s.config.PrivacyManager.RequestErasure(userID)
+	if err != nil {
+		s.config.Logger.Error("Failed to create erasure request", "error", err)
+		s.writeError(w, "Failed to create erasure request", "DATA_REQUEST_ERROR", http.StatusInternalServerError)
+		return
+	}
+
+	s.startDataRequest(w, req)
+}
+
+// handleRequestExport handles user data export requests
+func (s *Server) handleRequestExport(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	req, err := s.config.PrivacyManager.RequestExport(userID)
+	if err != nil {
+		s.config.Logger.Error("Failed to create export request", "error", err)
+		s.writeError(w, "Failed to create export request", "DATA_REQUEST_ERROR", http.StatusInternalServerError)
+		return
+	}
+
+	s.startDataRequest(w, req)
+}
+
+// startDataRequest runs a data request in the background and responds with its receipt
+func (s *Server) startDataRequest(w http.ResponseWriter, req *store.DataRequest) {
+	go func() {
+		if err := s.config.PrivacyManager.Run(req.ID); err != nil {
+			s.config.Logger.Error("Data request failed", "request_id", req.ID, "error", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(s.receipt(req))
+}
+
+// receipt builds the receipt for a data request with an absolute download URL
+func (s *Server) receipt(req *store.DataRequest) *privacy.Receipt {
+	receipt := privacy.NewReceipt(req)
+	if receipt.DownloadURL != nil {
+		downloadURL := s.publicURL(*receipt.DownloadURL)
+		receipt.DownloadURL = &downloadURL
+	}
+	return receipt
+}
+
+// handleDataRequestStatus handles data request receipt lookups
+func (s *Server) handleDataRequestStatus(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.lookupDataRequest(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.receipt(req))
+}
+
+// handleDataRequestDownload serves a completed export archive
+func (s *Server) handleDataRequestDownload(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.lookupDataRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if req.Kind != store.DataRequestExport || req.Status != store.DataRequestCompleted || req.ArtifactPath == nil {
+		s.writeError(w, "Export not available", "EXPORT_NOT_AVAILABLE", http.StatusConflict)
+		return
+	}
+
+	if err := s.config.FileManager.ServePrivate(w, r, *req.ArtifactPath); err != nil {
+		s.config.Logger.Error("Failed to serve export", "error", err, "request_id", req.ID)
+	}
+}
+
+// lookupDataRequest loads the data request named in the URL, writing an error response if it cannot
+func (s *Server) lookupDataRequest(w http.ResponseWriter, r *http.Request) (*store.DataRequest, bool) {
+	requestID := chi.URLParam(r, "requestID")
+
+	req, err := s.config.PrivacyManager.Get(requestID)
+	if err != nil {
+		s.config.Logger.Error("Failed to get data request", "error", err, "request_id", requestID)
+		s.writeError(w, "Failed to get data request", "DATA_REQUEST_ERROR", http.StatusInternalServerError)
+		return nil, false
+	}
+	if req == nil {
+		s.writeError(w, "Data request not found", "DATA_REQUEST_NOT_FOUND", http.StatusNotFound)
+		return nil, false
+	}
+
+	return req, true
+}
+
+// verifyDownloadURL checks the signature of a download request, writing an
+// error response if it is not acceptable
+func (s *Server) verifyDownloadURL(w http.ResponseWriter, r *http.Request, filename string, job *store.Job) bool {
+	if s.config.DownloadSigner == nil {
+		return true
+	}
+
+	err := s.config.DownloadSigner.VerifyDownloadURL(filename, job.ID, job.UserID, r.URL.Query())
+	return s.checkSignature(w, err, filename, job, s.config.AllowUnsignedDownloads)
+}
+
+// verifyPreviewURL checks the signature of a preview request, writing an
+// error response if it is not acceptable
+func (s *Server) verifyPreviewURL(w http.ResponseWriter, r *http.Request, ringtoneID int, job *store.Job) bool {
+	if s.config.DownloadSigner == nil {
+		return true
+	}
+
+	err := s.config.DownloadSigner.VerifyPreviewURL(ringtoneID, job.ID, job.UserID, r.URL.Query())
+	return s.checkSignature(w, err, fmt.Sprintf("preview %d", ringtoneID), job, false)
+}
+
+// verifyWaveformURL checks the signature of a waveform request, writing an
+// error response if it is not acceptable
+func (s *Server) verifyWaveformURL(w http.ResponseWriter, r *http.Request, ringtoneID int, job *store.Job) bool {
+	if s.config.DownloadSigner == nil {
+		return true
+	}
+
+	err := s.config.DownloadSigner.VerifyWaveformURL(ringtoneID, job.ID, job.UserID, r.URL.Query())
+	return s.checkSignature(w, err, fmt.Sprintf("waveform %d", ringtoneID), job, false)
+}
+
+// checkSignature maps a signature verification result to an error response.
+// allowUnsigned accepts URLs without a signature, which only legacy
+// /download/{filename} links may lack.
+func (s *Server) checkSignature(w http.ResponseWriter, err error, resource string, job *store.Job, allowUnsigned bool) bool {
+	switch err {
+	case nil:
+		return true
+	case signing.ErrMissingSignature:
+		if allowUnsigned {
+			return true
+		}
+		s.writeError(w, "Download URL is not signed", "MISSING_SIGNATURE", http.StatusForbidden)
+	case signing.ErrExpired:
+		s.writeError(w, "Download URL has expired", "URL_EXPIRED", http.StatusForbidden)
+	default:
+		s.config.Logger.Warn("Invalid download signature", "resource", resource, "job_id", job.ID)
+		s.writeError(w, "Invalid download URL signature", "INVALID_SIGNATURE", http.StatusForbidden)
+	}
+	return false
+}
+
+// publicURL resolves a path handed to clients
+func (s *Server) publicURL(path string) string {
+	if s.config.Links == nil {
+		return path
+	}
+	return s.config.Links.Public(path)
+}
+
 // writeError writes an error response
 func (s *Server) writeError(w http.ResponseWriter, message, code string, statusCode int) {
 	response := ErrorResponse{
@@ -0,0 +1,324 @@
+package bundle
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/store"
+)
+
+var (
+	// ErrEmpty is returned when a bundle names no ringtones
+	ErrEmpty = errors.New("bundle is empty")
+	// ErrTooManyItems is returned when a bundle names more ringtones than allowed
+	ErrTooManyItems = errors.New("bundle has too many ringtones")
+	// ErrTooLarge is returned when a bundle's files exceed the size limit
+	ErrTooLarge = errors.New("bundle exceeds maximum size")
+	// ErrNotFound is returned when a job or ringtone in a bundle does not exist
+	ErrNotFound = errors.New("ringtone not found")
+	// ErrNotCompleted is returned when a job in a bundle has not completed
+	ErrNotCompleted = errors.New("job is not completed")
+	// ErrOwnerMismatch is returned when a bundle's ringtones belong to different users
+	ErrOwnerMismatch = errors.New("ringtones belong to different users")
+)
+
+// ManifestFile names the manifest inside bundle archives
+const ManifestFile = "manifest.json"
+
+// StoreInterface defines the database operations needed for bundles
+type StoreInterface interface {
+	GetJob(id string) (*store.Job, error)
+	GetRingtone(id int) (*store.Ringtone, error)
+	GetRingtoneByJobID(jobID string) (*store.Ringtone, error)
+}
+
+// FileStoreInterface defines the file operations needed for bundles
+type FileStoreInterface interface {
+	OpenFile(filename string) (io.ReadCloser, error)
+	GetFileSize(filename string) (int64, error)
+}
+
+// Limits bounds the bundles that may be built; zero disables a limit
+type Limits struct {
+	MaxItems int
+	MaxBytes int64
+}
+
+// Builder resolves and writes ZIP bundles of several ringtones. Bundles
+// are streamed straight from storage, so no archive is ever held in memory
+// or on disk.
+type Builder struct {
+	store  StoreInterface
+	files  FileStoreInterface
+	limits Limits
+	logger *log.Logger
+}
+
+// Item is a ringtone included in a bundle
+type Item struct {
+	Job       *store.Job
+	Ringtone  *store.Ringtone
+	SizeBytes int64
+}
+
+// Bundle is a set of completed ringtones belonging to one owner
+type Bundle struct {
+	Owner     *string
+	Items     []*Item
+	SizeBytes int64
+}
+
+// RingtoneIDs returns the IDs of the bundle's ringtones in archive order
+func (b *Bundle) RingtoneIDs() []int {
+	ids := make([]int, len(b.Items))
+	for i, item := range b.Items {
+		ids[i] = item.Ringtone.ID
+	}
+	return ids
+}
+
+// Manifest describes the contents of a bundle archive
+type Manifest struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Ringtones []ManifestEntry `json:"ringtones"`
+}
+
+// ManifestEntry describes one ringtone in a bundle archive. Jobs created
+// from uploaded files have no source link.
+type ManifestEntry struct {
+	RingtoneID      int     `json:"ringtone_id"`
+	JobID           string  `json:"job_id"`
+	Title           string  `json:"title"`
+	File            string  `json:"file"`
+	Format          string  `json:"format"`
+	DurationSeconds *int    `json:"duration_seconds,omitempty"`
+	SizeBytes       int64   `json:"size_bytes"`
+	SHA256          *string `json:"sha256,omitempty"`
+	SourceURL       *string `json:"source_url,omitempty"`
+}
+
+// New creates a bundle builder
+func New(store StoreInterface, files FileStoreInterface, limits Limits, logger *log.Logger) *Builder {
+	return &Builder{
+		store:  store,
+		files:  files,
+		limits: limits,
+		logger: logger,
+	}
+}
+
+// ResolveJobs builds a bundle of the ringtones of completed jobs. Repeated
+// job IDs are included once.
+func (b *Builder) ResolveJobs(jobIDs []string) (*Bundle, error) {
+	if err := b.checkCount(len(jobIDs)); err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{}
+	seen := make(map[string]bool, len(jobIDs))
+	for _, jobID := range jobIDs {
+		if seen[jobID] {
+			continue
+		}
+		seen[jobID] = true
+
+		job, err := b.store.GetJob(jobID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get job: %w", err)
+		}
+		if job == nil {
+			return nil, fmt.Errorf("%w: job %s", ErrNotFound, jobID)
+		}
+		if job.Status != store.StatusCompleted {
+			return nil, fmt.Errorf("%w: job %s", ErrNotCompleted, jobID)
+		}
+		ringtone, err := b.store.GetRingtoneByJobID(jobID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ringtone: %w", err)
+		}
+		if ringtone == nil {
+			return nil, fmt.Errorf("%w: job %s", ErrNotFound, jobID)
+		}
+		if err := b.add(bundle, job, ringtone); err != nil {
+			return nil, err
+		}
+	}
+	return bundle, nil
+}
+
+// ResolveRingtones builds a bundle of ringtones by ID, checking them the
+// same way as ResolveJobs. Repeated IDs are included once.
+func (b *Builder) ResolveRingtones(ringtoneIDs []int) (*Bundle, error) {
+	if err := b.checkCount(len(ringtoneIDs)); err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{}
+	seen := make(map[int]bool, len(ringtoneIDs))
+	for _, id := range ringtoneIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		ringtone, err := b.store.GetRingtone(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ringtone: %w", err)
+		}
+		if ringtone == nil {
+			return nil, fmt.Errorf("%w: ringtone %d", ErrNotFound, id)
+		}
+		job, err := b.store.GetJob(ringtone.JobID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get job: %w", err)
+		}
+		if job == nil {
+			return nil, fmt.Errorf("%w: ringtone %d", ErrNotFound, id)
+		}
+		if job.Status != store.StatusCompleted {
+			return nil, fmt.Errorf("%w: job %s", ErrNotCompleted, job.ID)
+		}
+		if err := b.add(bundle, job, ringtone); err != nil {
+			return nil, err
+		}
+	}
+	return bundle, nil
+}
+
+// checkCount checks the number of requested ringtones against the limits
+func (b *Builder) checkCount(n int) error {
+	if n == 0 {
+		return ErrEmpty
+	}
+	if b.limits.MaxItems > 0 && n > b.limits.MaxItems {
+		return fmt.Errorf("%w (%d)", ErrTooManyItems, b.limits.MaxItems)
+	}
+	return nil
+}
+
+// add appends a ringtone to a bundle, enforcing a single owner and the size
+// limit
+func (b *Builder) add(bundle *Bundle, job *store.Job, ringtone *store.Ringtone) error {
+	if len(bundle.Items) == 0 {
+		bundle.Owner = job.UserID
+	} else if !sameOwner(bundle.Owner, job.UserID) {
+		return ErrOwnerMismatch
+	}
+
+	var size int64
+	if ringtone.SizeBytes != nil {
+		size = *ringtone.SizeBytes
+	} else {
+		var err error
+		size, err = b.files.GetFileSize(ringtone.StorageKey())
+		if err != nil {
+			return fmt.Errorf("failed to get file size: %w", err)
+		}
+	}
+
+	bundle.SizeBytes += size
+	if b.limits.MaxBytes > 0 && bundle.SizeBytes > b.limits.MaxBytes {
+		return fmt.Errorf("%w (%d bytes)", ErrTooLarge, b.limits.MaxBytes)
+	}
+	bundle.Items = append(bundle.Items, &Item{Job: job, Ringtone: ringtone, SizeBytes: size})
+	return nil
+}
+
+// sameOwner reports whether two jobs have the same owner
+func sameOwner(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// Write streams a bundle's archive to w and returns the number of audio
+// files included. Audio is already compressed, so files are stored as they
+// are. Files that have gone missing from storage are left out of the
+// archive and its manifest.
+func (b *Builder) Write(w io.Writer, bundle *Bundle) (int, error) {
+	zw := zip.NewWriter(w)
+
+	manifest := &Manifest{CreatedAt: time.Now().UTC(), Ringtones: []ManifestEntry{}}
+	names := make(map[string]bool, len(bundle.Items))
+	for _, item := range bundle.Items {
+		ringtone := item.Ringtone
+		file, err := b.files.OpenFile(ringtone.StorageKey())
+		if err != nil {
+			b.logger.Warn("Skipping missing file in bundle", "job_id", item.Job.ID, "file_name", ringtone.FileName)
+			continue
+		}
+
+		name := uniqueName(path.Base(ringtone.DownloadName()), names)
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Store,
+			Modified: ringtone.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, file)
+		}
+		file.Close()
+		if err != nil {
+			return len(manifest.Ringtones), err
+		}
+
+		manifest.Ringtones = append(manifest.Ringtones, newManifestEntry(item, name))
+	}
+
+	manifestFile, err := zw.Create(ManifestFile)
+	if err != nil {
+		return len(manifest.Ringtones), err
+	}
+	encoder := json.NewEncoder(manifestFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return len(manifest.Ringtones), err
+	}
+
+	return len(manifest.Ringtones), zw.Close()
+}
+
+// newManifestEntry describes a ringtone stored in an archive under name
+func newManifestEntry(item *Item, name string) ManifestEntry {
+	ringtone := item.Ringtone
+	entry := ManifestEntry{
+		RingtoneID:      ringtone.ID,
+		JobID:           item.Job.ID,
+		Title:           strings.TrimSuffix(name, path.Ext(name)),
+		File:            name,
+		Format:          ringtone.Format,
+		DurationSeconds: ringtone.DurationSeconds,
+		SizeBytes:       item.SizeBytes,
+		SHA256:          ringtone.BlobHash,
+	}
+	// Uploaded sources are served by the backend with a per-job token, so
+	// their stored URL is no use outside of it
+	if item.Job.SourceBlobHash == nil && item.Job.SourceURL != store.ErasedSourceURL {
+		sourceURL := item.Job.SourceURL
+		entry.SourceURL = &sourceURL
+	}
+	return entry
+}
+
+// uniqueName returns name, numbered if it is already taken in an archive,
+// and marks the result as taken
+func uniqueName(name string, taken map[string]bool) string {
+	if name == ManifestFile {
+		name = "ringtone-" + name
+	}
+	unique := name
+	ext := path.Ext(name)
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	taken[unique] = true
+	return unique
+}
@@ -2,32 +2,176 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 // the struct for Configurations !
 type Config struct {
-	Port             string
-	DBPath           string
-	StoragePath      string
+	Port           string
+	DBPath         string
+	StoragePath    string
+	StorageBackend string
+	// MaxFileSize limits the size of stored files in bytes; zero disables it
+	MaxFileSize      int64
+	S3               S3Config
 	N8NWebhookURL    string
 	N8NWebhookSecret string
 	LogLevel         string
+	AdminToken       string
+	// PublicBaseURL is where clients reach the backend; poll and download
+	// URLs are built from it
+	PublicBaseURL string
+	// CallbackBaseURL is where workers such as n8n reach the backend;
+	// callback, upload and source URLs are built from it
+	CallbackBaseURL string
+	// WebhookSignatureTolerance is how far a signed webhook's timestamp may
+	// be from the clock
+	WebhookSignatureTolerance time.Duration
+	// WebhookLegacyToken also accepts and sends the static secret in
+	// X-Webhook-Token, for workflows that do not sign requests yet
+	WebhookLegacyToken bool
+	// N8NWebhookSecrets is a comma-separated list of kid:secret pairs,
+	// primary first, replacing N8NWebhookSecret when set
+	N8NWebhookSecrets string
+	// N8NBreakerThreshold is the number of consecutive failed webhooks that
+	// stop dispatch until n8n's health check passes; zero disables it
+	N8NBreakerThreshold     int
+	N8NBreakerProbeInterval time.Duration
+	// N8NHealthURL defaults to /healthz on the webhook's host
+	N8NHealthURL string
+	// N8NWebhookURLs is a comma-separated list of webhook URLs, each
+	// optionally followed by "*weight", replacing N8NWebhookURL when set
+	N8NWebhookURLs string
+	// N8NBalancing is "round_robin" or "least_in_flight"
+	N8NBalancing string
+	// N8NEjectDuration is how long a failing webhook endpoint is passed over
+	N8NEjectDuration time.Duration
+	// Processor selects who processes jobs: "n8n" or "local"
+	Processor      string
+	LocalProcessor LocalProcessorConfig
+	// DownloadSigningKeys is a comma-separated list of kid:secret pairs, primary first
+	DownloadSigningKeys    string
+	DownloadURLTTL         time.Duration
+	AllowUnsignedDownloads bool
+	// ReconcileInterval schedules storage reconciliation; zero disables it
+	ReconcileInterval    time.Duration
+	ReconcileGracePeriod time.Duration
+	ReconcileDryRun      bool
+	// SourceRetention limits how long uploaded sources are kept after their
+	// job finishes; zero keeps them
+	SourceRetention time.Duration
+	// Storage quotas and disk watermarks; zero disables a limit
+	UserQuotaBytes           int64
+	UserQuotaFiles           int
+	DiskLowWatermarkPercent  float64
+	DiskHighWatermarkPercent float64
+	// Limits for uploaded source files; zero disables a limit
+	MaxSourceUploadBytes int64
+	MaxSourceDuration    time.Duration
+	// PreviewRetention limits how long preview renditions are kept; zero keeps them
+	PreviewRetention time.Duration
+	// AssetUploadWindow limits how long after completion a job's preview and
+	// additional formats may be uploaded; zero leaves it open
+	AssetUploadWindow time.Duration
+	// Limits for ZIP bundles of several ringtones; zero disables a limit
+	BundleMaxItems int
+	BundleMaxBytes int64
+}
+
+// LocalProcessorConfig holds settings for processing jobs in-process
+type LocalProcessorConfig struct {
+	YTDLPPath   string
+	FFmpegPath  string
+	WorkDir     string
+	Timeout     time.Duration
+	Concurrency int
+}
+
+// S3Config holds settings for the S3-compatible storage backend
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Prefix          string
+	PathStyle       bool
+	// RedirectTTL enables presigned download redirects when positive
+	RedirectTTL time.Duration
 }
 
- 
 func Load() *Config {
 	/* This contains the env setup , here it will return  the retrieved cred from .env or default value*/
+	port := getEnv("BACKEND_PORT", "8081")
 	return &Config{
-		Port:             getEnv("BACKEND_PORT", "8081"),
-		DBPath:           getEnv("DB_PATH", "./data/ringtonic.db"),
-		StoragePath:      getEnv("STORAGE_PATH", "./storage"),
+		Port:           port,
+		DBPath:         getEnv("DB_PATH", "./data/ringtonic.db"),
+		StoragePath:    getEnv("STORAGE_PATH", "./storage"),
+		StorageBackend: getEnv("STORAGE_BACKEND", "local"),
+		MaxFileSize:    getEnvInt64("MAX_FILE_SIZE_BYTES", 50<<20),
+		S3: S3Config{
+			Endpoint:        getEnv("S3_ENDPOINT", ""),
+			Region:          getEnv("S3_REGION", "us-east-1"),
+			Bucket:          getEnv("S3_BUCKET", ""),
+			AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+			Prefix:          getEnv("S3_PREFIX", ""),
+			PathStyle:       getEnvBool("S3_PATH_STYLE", true),
+			RedirectTTL:     getEnvDuration("S3_REDIRECT_TTL", 0),
+		},
 		N8NWebhookURL:    getEnv("N8N_WEBHOOK_URL", "http://n8n:5678/webhook/ringtonic"),
 		N8NWebhookSecret: getEnv("N8N_WEBHOOK_SECRET", "your-secure-secret-here"),
 		LogLevel:         getEnv("LOG_LEVEL", "info"),
+		AdminToken:       getEnv("ADMIN_API_TOKEN", ""),
+
+		PublicBaseURL:   getEnv("PUBLIC_BASE_URL", "http://localhost:"+port),
+		CallbackBaseURL: getEnv("CALLBACK_BASE_URL", "http://backend:"+port),
+
+		WebhookSignatureTolerance: getEnvDuration("WEBHOOK_SIGNATURE_TOLERANCE", 5*time.Minute),
+		WebhookLegacyToken:        getEnvBool("WEBHOOK_LEGACY_TOKEN", false),
+		N8NWebhookSecrets:         getEnv("N8N_WEBHOOK_SECRETS", ""),
+		N8NBreakerThreshold:       int(getEnvInt64("N8N_BREAKER_THRESHOLD", 5)),
+		N8NBreakerProbeInterval:   getEnvDuration("N8N_BREAKER_PROBE_INTERVAL", 30*time.Second),
+		N8NHealthURL:              getEnv("N8N_HEALTH_URL", ""),
+		N8NWebhookURLs:            getEnv("N8N_WEBHOOK_URLS", ""),
+		N8NBalancing:              getEnv("N8N_BALANCING", "round_robin"),
+		N8NEjectDuration:          getEnvDuration("N8N_EJECT_DURATION", 30*time.Second),
+
+		Processor: getEnv("PROCESSOR", "n8n"),
+		LocalProcessor: LocalProcessorConfig{
+			YTDLPPath:   getEnv("LOCAL_YTDLP_PATH", "yt-dlp"),
+			FFmpegPath:  getEnv("LOCAL_FFMPEG_PATH", "ffmpeg"),
+			WorkDir:     getEnv("LOCAL_WORK_DIR", ""),
+			Timeout:     getEnvDuration("LOCAL_PROCESSOR_TIMEOUT", 10*time.Minute),
+			Concurrency: int(getEnvInt64("LOCAL_PROCESSOR_CONCURRENCY", 2)),
+		},
+
+		DownloadSigningKeys:    getEnv("DOWNLOAD_SIGNING_KEYS", ""),
+		DownloadURLTTL:         getEnvDuration("DOWNLOAD_URL_TTL", time.Hour),
+		AllowUnsignedDownloads: getEnvBool("ALLOW_UNSIGNED_DOWNLOADS", false),
+
+		ReconcileInterval:    getEnvDuration("RECONCILE_INTERVAL", 6*time.Hour),
+		ReconcileGracePeriod: getEnvDuration("RECONCILE_GRACE_PERIOD", 24*time.Hour),
+		ReconcileDryRun:      getEnvBool("RECONCILE_DRY_RUN", false),
+		SourceRetention:      getEnvDuration("SOURCE_RETENTION", 24*time.Hour),
+
+		UserQuotaBytes:           getEnvInt64("USER_QUOTA_BYTES", 0),
+		UserQuotaFiles:           int(getEnvInt64("USER_QUOTA_FILES", 0)),
+		DiskLowWatermarkPercent:  getEnvFloat("DISK_LOW_WATERMARK_PERCENT", 5),
+		DiskHighWatermarkPercent: getEnvFloat("DISK_HIGH_WATERMARK_PERCENT", 10),
+
+		MaxSourceUploadBytes: getEnvInt64("MAX_SOURCE_UPLOAD_BYTES", 100<<20),
+		MaxSourceDuration:    getEnvDuration("MAX_SOURCE_DURATION", 10*time.Minute),
+
+		PreviewRetention:  getEnvDuration("PREVIEW_RETENTION", 7*24*time.Hour),
+		AssetUploadWindow: getEnvDuration("ASSET_UPLOAD_WINDOW", time.Hour),
+
+		BundleMaxItems: int(getEnvInt64("BUNDLE_MAX_ITEMS", 50)),
+		BundleMaxBytes: getEnvInt64("BUNDLE_MAX_BYTES", 200<<20),
 	}
 }
 
-
 func getEnv(key, defaultValue string) string {
 	// getEnv returns the value of an environment variable or a default value
 	if value := os.Getenv(key); value != "" {
@@ -35,3 +179,35 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvBool returns a boolean environment variable or a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDuration returns a duration environment variable (e.g. "15m") or a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvInt64 returns an integer environment variable or a default value
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvFloat returns a floating point environment variable or a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
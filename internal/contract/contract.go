@@ -0,0 +1,105 @@
+// Package contract defines the messages exchanged with the n8n workflow:
+// the job request the backend sends and the callback the workflow sends
+// back. Both carry a schema version, and callbacks are decoded strictly so
+// that a workflow change which renames, drops or adds a field is rejected
+// with a list of the differences instead of being silently ignored.
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"ringtonic-backend/internal/store"
+)
+
+// SchemaVersion is the version of the messages defined here. Fields may be
+// added to it as long as they are optional; anything else needs a new
+// version.
+const SchemaVersion = 1
+
+// CallbackTokenHeader carries a job's callback token on its callbacks
+const CallbackTokenHeader = "X-Callback-Token"
+
+// JobRequest is the webhook payload sent to the workflow for each job
+type JobRequest struct {
+	SchemaVersion int               `json:"schema_version" enum:"1"`
+	JobID         string            `json:"job_id"`
+	SourceURL     string            `json:"source_url"`
+	Options       *store.JobOptions `json:"options"`
+	CallbackURL   string            `json:"callback_url"`
+	// CallbackToken authorizes callbacks for this job only, sent back in
+	// X-Callback-Token. It is revoked once the job's outcome is reported.
+	CallbackToken string `json:"callback_token,omitempty"`
+	// UploadURL and UploadToken let the workflow upload its result instead
+	// of writing it to shared storage
+	UploadURL   string `json:"upload_url,omitempty"`
+	UploadToken string `json:"upload_token,omitempty"`
+}
+
+// NewJobRequest creates a job request of the current schema version
+func NewJobRequest(jobID, sourceURL string, options *store.JobOptions, callbackURL string) *JobRequest {
+	return &JobRequest{
+		SchemaVersion: SchemaVersion,
+		JobID:         jobID,
+		SourceURL:     sourceURL,
+		Options:       options,
+		CallbackURL:   callbackURL,
+	}
+}
+
+// Callback reports the outcome of a job. JobID may be left out of callbacks
+// sent to the job's own callback URL, which names the job.
+type Callback struct {
+	SchemaVersion int               `json:"schema_version" enum:"1"`
+	JobID         string            `json:"job_id,omitempty"`
+	Status        string            `json:"status" enum:"completed,failed"`
+	FilePath      *string           `json:"file_path,omitempty"`
+	SHA256        *string           `json:"sha256,omitempty"`
+	PreviewPath   *string           `json:"preview_path,omitempty"`
+	PreviewSHA256 *string           `json:"preview_sha256,omitempty"`
+	Outputs       []CallbackOutput  `json:"outputs,omitempty"`
+	Metadata      *CallbackMetadata `json:"metadata,omitempty"`
+}
+
+// CallbackOutput is a rendition of a completed job in an additional output
+// format, delivered alongside the file at file_path
+type CallbackOutput struct {
+	Format   string  `json:"format"`
+	FilePath string  `json:"file_path"`
+	SHA256   *string `json:"sha256,omitempty"`
+}
+
+// CallbackMetadata describes the produced audio, or why it was not produced
+type CallbackMetadata struct {
+	Duration      *float64 `json:"duration,omitempty"`
+	FileSize      *int64   `json:"file_size,omitempty"`
+	Title         string   `json:"title,omitempty"`
+	OriginalTitle string   `json:"original_title,omitempty"`
+	Artist        string   `json:"artist,omitempty"`
+	Uploader      string   `json:"uploader,omitempty"`
+	SourceURL     string   `json:"source_url,omitempty"`
+	CoverPath     string   `json:"cover_path,omitempty"`
+	// Error is the reason a failed job failed
+	Error string `json:"error,omitempty"`
+}
+
+// Schemas of the messages, generated from their types
+var (
+	JobRequestSchema = Generate(JobRequest{}, "job-request.v1.schema.json", "n8n job request")
+	CallbackSchema   = Generate(Callback{}, "callback.v1.schema.json", "n8n callback")
+)
+
+// DecodeCallback decodes a callback, rejecting it with a *ValidationError
+// if it does not match CallbackSchema
+func DecodeCallback(data []byte) (*Callback, error) {
+	if err := CallbackSchema.Validate(data); err != nil {
+		return nil, err
+	}
+
+	var callback Callback
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&callback); err != nil {
+		return nil, fmt.Errorf("failed to decode callback: %w", err)
+	}
+	return &callback, nil
+}
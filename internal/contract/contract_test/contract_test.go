@@ -0,0 +1,144 @@
+package contract_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ringtonic-backend/internal/contract"
+	"ringtonic-backend/internal/store"
+)
+
+// samples returns the sample messages in a testdata directory
+func samples(t *testing.T, dir string) map[string][]byte {
+	paths, err := filepath.Glob(filepath.Join("testdata", dir, "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	samples := make(map[string][]byte)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		samples[filepath.Base(path)] = data
+	}
+	return samples
+}
+
+func TestCallbackSamples(t *testing.T) {
+	for name, data := range samples(t, "callbacks") {
+		t.Run(name, func(t *testing.T) {
+			callback, err := contract.DecodeCallback(data)
+			require.NoError(t, err)
+			assert.Equal(t, contract.SchemaVersion, callback.SchemaVersion)
+			assert.NotEmpty(t, callback.Status)
+		})
+	}
+}
+
+func TestJobRequestSamples(t *testing.T) {
+	for name, data := range samples(t, "job-requests") {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, contract.JobRequestSchema.Validate(data))
+
+			// Samples survive a round trip through the Go type unchanged
+			var request contract.JobRequest
+			require.NoError(t, json.Unmarshal(data, &request))
+			encoded, err := json.Marshal(request)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(data), string(encoded))
+		})
+	}
+}
+
+func TestJobRequestMatchesSchema(t *testing.T) {
+	duration := 20
+	request := contract.NewJobRequest("job-1", "https://www.youtube.com/watch?v=test",
+		&store.JobOptions{DurationSeconds: &duration, Format: "m4r", Formats: []string{"m4r", "ogg"}},
+		"http://backend:8080/api/v1/n8n-callback")
+	request.UploadURL = "http://backend:8080/api/v1/n8n-upload/job-1"
+	request.UploadToken = "token"
+	request.CallbackToken = "callback-token"
+
+	data, err := json.Marshal(request)
+	require.NoError(t, err)
+	assert.NoError(t, contract.JobRequestSchema.Validate(data))
+	assert.Contains(t, string(data), `"schema_version":1`)
+
+	// Jobs without options still match
+	data, err = json.Marshal(contract.NewJobRequest("job-2", "https://example.com", nil, "http://backend:8080/api/v1/n8n-callback"))
+	require.NoError(t, err)
+	assert.NoError(t, contract.JobRequestSchema.Validate(data))
+}
+
+func TestDecodeCallbackRejectsDrift(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		problems []string
+	}{
+		{
+			name:     "unknown and missing fields",
+			body:     `{"schema_version":1,"jobId":"job-1","filePath":"job-1.mp3"}`,
+			problems: []string{"missing field status", "unknown field filePath", "unknown field jobId"},
+		},
+		{
+			name:     "missing version",
+			body:     `{"job_id":"job-1","status":"failed"}`,
+			problems: []string{"missing field schema_version"},
+		},
+		{
+			name:     "future version",
+			body:     `{"schema_version":2,"job_id":"job-1","status":"failed"}`,
+			problems: []string{"schema_version must be one of 1"},
+		},
+		{
+			name:     "unknown status",
+			body:     `{"schema_version":1,"job_id":"job-1","status":"done"}`,
+			problems: []string{"status must be one of completed, failed"},
+		},
+		{
+			name: "nested fields",
+			body: `{"schema_version":1,"job_id":"job-1","status":"completed","file_path":"job-1.mp3",` +
+				`"outputs":[{"file_path":"job-1.ogg"}],"metadata":{"duration":"20","bitrate":128}}`,
+			problems: []string{
+				"unknown field metadata.bitrate",
+				"metadata.duration must be number or null, not string",
+				"missing field outputs[0].format",
+			},
+		},
+		{
+			name:     "not an object",
+			body:     `["job-1"]`,
+			problems: []string{"message must be object, not array"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := contract.DecodeCallback([]byte(tt.body))
+			var invalid *contract.ValidationError
+			require.ErrorAs(t, err, &invalid)
+			assert.Equal(t, tt.problems, invalid.Problems)
+		})
+	}
+
+	_, err := contract.DecodeCallback([]byte(`{"schema_version":1,`))
+	assert.ErrorIs(t, err, contract.ErrMalformed)
+}
+
+func TestSchemaFilesAreCurrent(t *testing.T) {
+	// The committed schemas are what workflows are checked against, so they
+	// must be regenerated whenever the types change
+	for _, schema := range []*contract.Schema{contract.JobRequestSchema, contract.CallbackSchema} {
+		want, err := json.MarshalIndent(schema, "", "  ")
+		require.NoError(t, err)
+
+		got, err := os.ReadFile(filepath.Join("..", "..", "..", "schemas", schema.ID))
+		require.NoError(t, err)
+		assert.Equal(t, string(want)+"\n", string(got), "schemas/%s is stale; run make schemas", schema.ID)
+	}
+}
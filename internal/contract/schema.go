@@ -0,0 +1,240 @@
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformed is returned when a message is not valid JSON
+var ErrMalformed = errors.New("message is not valid JSON")
+
+// schemaDialect is the JSON Schema draft the generated schemas declare
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema needed to describe the messages:
+// types, object properties, required fields, array items and enums.
+// Objects never allow properties they do not declare.
+type Schema struct {
+	Dialect              string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 []string           `json:"type"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+}
+
+// ValidationError lists every way a message differs from its schema
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "message does not match schema: " + strings.Join(e.Problems, "; ")
+}
+
+// Generate derives the schema of a struct type from its fields and their
+// json tags. Fields without omitempty are required, pointers and slices
+// may be null, and an enum tag lists a field's allowed values.
+func Generate(v interface{}, id, title string) *Schema {
+	schema := generate(reflect.TypeOf(v), "")
+	schema.Dialect = schemaDialect
+	schema.ID = id
+	schema.Title = title
+	return schema
+}
+
+func generate(t reflect.Type, enum string) *Schema {
+	schema := &Schema{}
+	nullable := false
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		schema.Type = []string{"object"}
+		schema.Properties = make(map[string]*Schema)
+		closed := false
+		schema.AdditionalProperties = &closed
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			schema.Properties[name] = generate(field.Type, field.Tag.Get("enum"))
+			if !strings.Contains(options, "omitempty") {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+	case reflect.Slice:
+		schema.Type = []string{"array"}
+		schema.Items = generate(t.Elem(), "")
+		nullable = true
+	case reflect.String:
+		schema.Type = []string{"string"}
+	case reflect.Bool:
+		schema.Type = []string{"boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema.Type = []string{"integer"}
+	case reflect.Float32, reflect.Float64:
+		schema.Type = []string{"number"}
+	default:
+		panic(fmt.Sprintf("contract: no schema for %s", t))
+	}
+
+	if enum != "" {
+		for _, value := range strings.Split(enum, ",") {
+			if schema.Type[0] == "integer" {
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					panic(fmt.Sprintf("contract: invalid integer enum %q", value))
+				}
+				schema.Enum = append(schema.Enum, n)
+			} else {
+				schema.Enum = append(schema.Enum, value)
+			}
+		}
+	}
+	if nullable {
+		schema.Type = append(schema.Type, "null")
+	}
+	return schema
+}
+
+// Validate checks a JSON message against the schema, returning
+// ErrMalformed or a *ValidationError listing every problem found
+func (s *Schema) Validate(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("%w: trailing data", ErrMalformed)
+	}
+
+	var problems []string
+	s.validate("", value, &problems)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (s *Schema) validate(path string, value interface{}, problems *[]string) {
+	kind := jsonType(value)
+	if !s.allows(kind) {
+		*problems = append(*problems, fmt.Sprintf("%s must be %s, not %s", describe(path), strings.Join(s.Type, " or "), kind))
+		return
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				*problems = append(*problems, fmt.Sprintf("missing field %s", join(path, name)))
+			}
+		}
+		// Report fields in a stable order
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*problems = append(*problems, fmt.Sprintf("unknown field %s", join(path, name)))
+				}
+				continue
+			}
+			property.validate(join(path, name), value[name], problems)
+		}
+	case []interface{}:
+		for i, item := range value {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+		}
+	}
+
+	if len(s.Enum) > 0 && kind != "null" && !s.enumerates(value) {
+		allowed := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			allowed[i] = fmt.Sprint(v)
+		}
+		*problems = append(*problems, fmt.Sprintf("%s must be one of %s", describe(path), strings.Join(allowed, ", ")))
+	}
+}
+
+// allows reports whether the schema accepts a JSON type. Integers are
+// numbers too.
+func (s *Schema) allows(kind string) bool {
+	for _, t := range s.Type {
+		if t == kind || (t == "number" && kind == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// enumerates reports whether a value is one of the schema's enum values
+func (s *Schema) enumerates(value interface{}) bool {
+	for _, allowed := range s.Enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// join appends a property name to a path
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// describe names a path in a problem
+func describe(path string) string {
+	if path == "" {
+		return "message"
+	}
+	return path
+}
@@ -0,0 +1,126 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotExist is returned by backends when an object does not exist
+var ErrNotExist = errors.New("object does not exist")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend is the object storage layer behind Manager. Keys are
+// slash-separated paths relative to the storage root.
+type Backend interface {
+	// Put stores content under key, replacing any existing object.
+	// size is the content length, or -1 if unknown.
+	Put(key string, content io.Reader, size int64) error
+	// Get opens an object for reading
+	Get(key string) (io.ReadCloser, error)
+	// GetRange opens length bytes of an object starting at offset.
+	// A negative length reads to the end of the object.
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+	// Stat returns information about an object
+	Stat(key string) (*ObjectInfo, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(key string) error
+	// List returns all objects whose key starts with prefix
+	List(prefix string) ([]ObjectInfo, error)
+}
+
+// Presigner is implemented by backends that can hand out temporary direct
+// download URLs, letting the server redirect instead of proxying content
+type Presigner interface {
+	PresignGet(key, contentDisposition string, expires time.Duration) (string, error)
+}
+
+// Toucher is implemented by backends that can mark an object as modified
+// now without rewriting it
+type Toucher interface {
+	Touch(key string) error
+}
+
+// DiskUsage describes the capacity of the filesystem behind a backend
+type DiskUsage struct {
+	TotalBytes uint64 `json:"total_bytes"`
+	FreeBytes  uint64 `json:"free_bytes"`
+}
+
+// DiskReporter is implemented by backends whose capacity is bounded by a
+// local filesystem. Object stores do not implement it.
+type DiskReporter interface {
+	DiskUsage() (*DiskUsage, error)
+}
+
+// objectReader adapts a backend object to io.ReadSeeker using range reads,
+// so http.ServeContent can serve byte ranges without buffering the object
+type objectReader struct {
+	backend Backend
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+// Read reads from the current offset, opening a range stream on demand
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		body, err := o.backend.GetRange(o.key, o.offset, o.size-o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Seek moves the offset, dropping any open range stream
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = o.offset + offset
+	case io.SeekEnd:
+		target = o.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if target < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if target != o.offset {
+		o.Close()
+		o.offset = target
+	}
+	return target, nil
+}
+
+// Close closes the open range stream, if any
+func (o *objectReader) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
@@ -0,0 +1,173 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// blobReuseWindow is how long a blob handed out again by StoreBlob is kept
+// from deletion, giving the caller time to commit its reference
+const blobReuseWindow = 5 * time.Minute
+
+// Blob is a content-addressed object, stored once per distinct content
+type Blob struct {
+	Hash string
+	Size int64
+	Key  string
+	// Deduplicated is set when identical content was already stored
+	Deduplicated bool
+}
+
+// BlobKey returns the storage key for a SHA-256 content hash
+func BlobKey(hash string) string {
+	return path.Join("blobs", hash[:2], hash)
+}
+
+// StoreBlob stores content under its SHA-256 hash. Content is spooled to a
+// temporary file while it is hashed, and only uploaded to the backend if no
+// blob with the same hash exists yet. The size limit and checksum in opts
+// are applied as in SaveFileWithOptions.
+func (m *Manager) StoreBlob(content io.Reader, opts SaveOptions) (*Blob, error) {
+	spool, err := os.CreateTemp("", "ringtonic-blob-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	limit := m.maxFileSize
+	if opts.Unbounded {
+		limit = 0
+	}
+
+	checked := newCheckedReader(content, limit, opts.ExpectedSHA256)
+	size, err := io.Copy(spool, checked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob content: %w", err)
+	}
+
+	hash := checked.Sum()
+	blob := &Blob{Hash: hash, Size: size, Key: BlobKey(hash)}
+
+	if reused, err := m.reuseBlob(blob.Key); err != nil {
+		return nil, err
+	} else if reused {
+		blob.Deduplicated = true
+		m.logger.Info("Blob already stored", "hash", hash, "size", size)
+		return blob, nil
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind spool file: %w", err)
+	}
+	if err := m.backend.Put(blob.Key, spool, size); err != nil {
+		return nil, fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	m.logger.Info("Blob stored", "hash", hash, "size", size)
+	return blob, nil
+}
+
+// IngestFile moves a file written directly into storage (for example by an
+// n8n worker sharing the volume) into the content-addressed blob store.
+// The file is left in place if it fails the size limit or checksum.
+func (m *Manager) IngestFile(filename, expectedSHA256 string) (*Blob, error) {
+	file, err := m.backend.Get(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	blob, err := m.StoreBlob(file, SaveOptions{ExpectedSHA256: expectedSHA256})
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.backend.Delete(filename); err != nil {
+		m.logger.Warn("Failed to remove ingested file", "filename", filename, "error", err)
+	}
+
+	return blob, nil
+}
+
+// reuseBlob reports whether a blob is already stored. An existing blob is
+// protected from deletion until its new reference can be committed: it is
+// touched, so reconciliation counts it as recently written, and held back
+// from DeleteBlob for blobReuseWindow.
+func (m *Manager) reuseBlob(key string) (bool, error) {
+	m.blobMu.Lock()
+	defer m.blobMu.Unlock()
+
+	if _, err := m.backend.Stat(key); err == ErrNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check blob: %w", err)
+	}
+
+	if toucher, ok := m.backend.(Toucher); ok {
+		if err := toucher.Touch(key); err != nil {
+			m.logger.Warn("Failed to touch reused blob", "key", key, "error", err.Error())
+		}
+	}
+
+	now := time.Now()
+	for reusedKey, at := range m.reused {
+		if now.Sub(at) >= blobReuseWindow {
+			delete(m.reused, reusedKey)
+		}
+	}
+	m.reused[key] = now
+	return true, nil
+}
+
+// deleteUnlessReused removes an unreferenced object unless StoreBlob has
+// handed it out again since the caller found it unreferenced: within
+// blobReuseWindow, or after modifiedBefore if that is set. It reports
+// whether the object was deleted.
+func (m *Manager) deleteUnlessReused(key string, modifiedBefore time.Time) (bool, error) {
+	m.blobMu.Lock()
+	defer m.blobMu.Unlock()
+
+	if at, ok := m.reused[key]; ok && time.Since(at) < blobReuseWindow {
+		return false, nil
+	}
+	if !modifiedBefore.IsZero() {
+		info, err := m.backend.Stat(key)
+		if err == ErrNotExist {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to check file: %w", err)
+		}
+		if info.ModTime.After(modifiedBefore) {
+			return false, nil
+		}
+	}
+
+	if err := m.backend.Delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteBlob removes a blob's content. Callers must only do so once the
+// blob's last reference has been released. A blob that StoreBlob has just
+// handed out again is kept, as its new reference may not be committed yet;
+// reconciliation removes it later if that reference never arrives.
+func (m *Manager) DeleteBlob(hash string) error {
+	key := BlobKey(hash)
+	deleted, err := m.deleteUnlessReused(key, time.Time{})
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		m.logger.Info("Blob reused, left for reconciliation", "hash", hash)
+		return nil
+	}
+
+	m.logger.Info("File deleted", "filename", key)
+	return nil
+}
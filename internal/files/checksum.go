@@ -0,0 +1,79 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrFileTooLarge is returned when content exceeds the maximum file size
+	ErrFileTooLarge = errors.New("file exceeds maximum size")
+	// ErrChecksumMismatch is returned when content does not match its expected SHA-256
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// SaveOptions controls how SaveFileWithOptions writes content
+type SaveOptions struct {
+	// ExpectedSHA256, if set, must match the content before it is stored
+	ExpectedSHA256 string
+	// Unbounded lifts the maximum file size, for archives assembled by the
+	// service itself
+	Unbounded bool
+}
+
+// SavedFile describes content written by SaveFileWithOptions
+type SavedFile struct {
+	Key    string
+	Size   int64
+	SHA256 string
+}
+
+// checkedReader hashes content as it is read and enforces a size limit.
+// If an expected checksum is set, reaching the end of content that does not
+// match it is reported as an error instead of io.EOF, so that a backend
+// aborts the write before the content becomes visible.
+type checkedReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	size     int64
+	limit    int64
+	expected string
+}
+
+// newCheckedReader wraps r. A limit of zero or less disables the size check.
+func newCheckedReader(r io.Reader, limit int64, expectedSHA256 string) *checkedReader {
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	return &checkedReader{
+		r:        r,
+		hash:     sha256.New(),
+		limit:    limit,
+		expected: strings.ToLower(expectedSHA256),
+	}
+}
+
+// Read implements io.Reader
+func (c *checkedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.size += int64(n)
+	if c.limit > 0 && c.size > c.limit {
+		return 0, fmt.Errorf("%w (%d bytes)", ErrFileTooLarge, c.limit)
+	}
+	c.hash.Write(p[:n])
+
+	if err == io.EOF && c.expected != "" && c.Sum() != c.expected {
+		return n, fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, c.expected, c.Sum())
+	}
+	return n, err
+}
+
+// Sum returns the hex-encoded SHA-256 of the content read so far
+func (c *checkedReader) Sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
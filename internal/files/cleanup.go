@@ -0,0 +1,246 @@
+package files
+
+import (
+	"fmt"
+	"time"
+
+	"ringtonic-backend/internal/store"
+)
+
+// ReferenceStore provides the database records that reference stored files
+type ReferenceStore interface {
+	ListRingtones() ([]*store.Ringtone, error)
+	ListDataRequestArtifacts() ([]string, error)
+	ListJobSources() ([]string, error)
+	ListExpiredJobSources(finishedBefore time.Time) ([]string, error)
+	ExpireJobSource(jobID string) (bool, error)
+	ListRingtoneAssets() ([]*store.RingtoneAsset, error)
+	ExpireRingtoneAssets(now time.Time) (int, error)
+	MarkJobDegraded(jobID, reason string) (bool, error)
+}
+
+// CleanupOptions controls a storage reconciliation run
+type CleanupOptions struct {
+	// GracePeriod protects recently written files that may not have a
+	// database row yet, such as n8n output awaiting its callback
+	GracePeriod time.Duration
+	// SourceRetention limits how long uploaded sources are kept after their
+	// job finishes; zero keeps them as long as the job
+	SourceRetention time.Duration
+	// DryRun reports what would change without deleting files or flagging jobs
+	DryRun bool
+}
+
+// OrphanFile is a stored file that no database row references
+type OrphanFile struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Deleted bool      `json:"deleted"`
+}
+
+// MissingFile is a ringtone row whose file is not in storage
+type MissingFile struct {
+	RingtoneID int    `json:"ringtone_id"`
+	JobID      string `json:"job_id"`
+	Key        string `json:"key"`
+}
+
+// CleanupReport describes the outcome of a reconciliation run
+type CleanupReport struct {
+	StartedAt      time.Time     `json:"started_at"`
+	FinishedAt     time.Time     `json:"finished_at"`
+	DryRun         bool          `json:"dry_run"`
+	FilesScanned   int           `json:"files_scanned"`
+	BytesScanned   int64         `json:"bytes_scanned"`
+	Orphans        []OrphanFile  `json:"orphans"`
+	OrphanBytes    int64         `json:"orphan_bytes"`
+	OrphansInGrace int           `json:"orphans_in_grace"`
+	Missing        []MissingFile `json:"missing"`
+	JobsDegraded   int           `json:"jobs_degraded"`
+	AssetsExpired  int           `json:"assets_expired"`
+	SourcesExpired int           `json:"sources_expired"`
+	Errors         []string      `json:"errors,omitempty"`
+}
+
+// CleanupOldFiles reconciles storage with the database. Ringtone assets and
+// uploaded sources past their retention are dropped, files that no row references and that
+// are older than the grace period are deleted, and completed jobs whose
+// files are missing are marked as degraded.
+func (m *Manager) CleanupOldFiles(refs ReferenceStore, opts CleanupOptions) (*CleanupReport, error) {
+	report := &CleanupReport{
+		StartedAt: time.Now(),
+		DryRun:    opts.DryRun,
+		Orphans:   []OrphanFile{},
+		Missing:   []MissingFile{},
+	}
+
+	// Drop assets past their retention so that their content is reclaimed
+	if !opts.DryRun {
+		expired, err := refs.ExpireRingtoneAssets(report.StartedAt)
+		if err != nil {
+			return nil, err
+		}
+		report.AssetsExpired = expired
+	}
+	if opts.SourceRetention > 0 {
+		m.expireSources(refs, report, report.StartedAt.Add(-opts.SourceRetention), opts.DryRun)
+	}
+
+	// Collect everything the database references
+	ringtones, err := refs.ListRingtones()
+	if err != nil {
+		return nil, err
+	}
+	artifacts, err := refs.ListDataRequestArtifacts()
+	if err != nil {
+		return nil, err
+	}
+	sources, err := refs.ListJobSources()
+	if err != nil {
+		return nil, err
+	}
+	assets, err := refs.ListRingtoneAssets()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, ringtone := range ringtones {
+		referenced[ringtone.StorageKey()] = true
+	}
+	for _, artifact := range artifacts {
+		referenced[artifact] = true
+	}
+	for _, hash := range sources {
+		referenced[BlobKey(hash)] = true
+	}
+	for _, asset := range assets {
+		// Only left over in a dry run, which reports what expiry would reclaim
+		if asset.ExpiresAt != nil && !asset.ExpiresAt.After(report.StartedAt) {
+			report.AssetsExpired++
+			continue
+		}
+		referenced[asset.FilePath] = true
+	}
+
+	// Walk storage
+	objects, err := m.backend.List("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage: %w", err)
+	}
+
+	stored := make(map[string]bool, len(objects))
+	cutoff := report.StartedAt.Add(-opts.GracePeriod)
+	for _, obj := range objects {
+		stored[obj.Key] = true
+		report.FilesScanned++
+		report.BytesScanned += obj.Size
+
+		if referenced[obj.Key] {
+			continue
+		}
+		if owner, ok := sidecarOwner(obj.Key); ok && referenced[owner] {
+			continue
+		}
+		if obj.ModTime.After(cutoff) {
+			report.OrphansInGrace++
+			continue
+		}
+
+		orphan := OrphanFile{Key: obj.Key, Size: obj.Size, ModTime: obj.ModTime}
+		if !opts.DryRun {
+			// A blob may have been reused since references were listed
+			deleted, err := m.deleteUnlessReused(obj.Key, cutoff)
+			switch {
+			case err != nil:
+				report.Errors = append(report.Errors, fmt.Sprintf("delete %s: %v", obj.Key, err))
+			case !deleted:
+				report.OrphansInGrace++
+				continue
+			default:
+				orphan.Deleted = true
+			}
+		}
+		report.Orphans = append(report.Orphans, orphan)
+		report.OrphanBytes += obj.Size
+	}
+
+	// Flag rows whose files are gone
+	degraded := make(map[string]bool)
+	for _, ringtone := range ringtones {
+		key := ringtone.StorageKey()
+		if stored[key] {
+			continue
+		}
+
+		report.Missing = append(report.Missing, MissingFile{RingtoneID: ringtone.ID, JobID: ringtone.JobID, Key: key})
+		if opts.DryRun || degraded[ringtone.JobID] {
+			continue
+		}
+		changed, err := refs.MarkJobDegraded(ringtone.JobID, "Stored file is missing: "+key)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("degrade job %s: %v", ringtone.JobID, err))
+			continue
+		}
+		degraded[ringtone.JobID] = true
+		if changed {
+			report.JobsDegraded++
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	m.logger.Info("Storage reconciliation finished",
+		"dry_run", opts.DryRun,
+		"files_scanned", report.FilesScanned,
+		"orphans", len(report.Orphans),
+		"orphan_bytes", report.OrphanBytes,
+		"missing", len(report.Missing),
+		"jobs_degraded", report.JobsDegraded,
+		"assets_expired", report.AssetsExpired,
+		"sources_expired", report.SourcesExpired,
+		"errors", len(report.Errors),
+	)
+	return report, nil
+}
+
+// expireSources drops the uploaded sources of jobs that finished before
+// cutoff, so that their content is reclaimed
+func (m *Manager) expireSources(refs ReferenceStore, report *CleanupReport, cutoff time.Time, dryRun bool) {
+	jobIDs, err := refs.ListExpiredJobSources(cutoff)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("list expired sources: %v", err))
+		return
+	}
+	if dryRun {
+		report.SourcesExpired = len(jobIDs)
+		return
+	}
+	for _, jobID := range jobIDs {
+		expired, err := refs.ExpireJobSource(jobID)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("expire source of job %s: %v", jobID, err))
+			continue
+		}
+		if expired {
+			report.SourcesExpired++
+		}
+	}
+}
+
+// ScheduleCleanup runs CleanupOldFiles every interval until stop is closed
+func (m *Manager) ScheduleCleanup(refs ReferenceStore, opts CleanupOptions, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := m.CleanupOldFiles(refs, opts); err != nil {
+				m.logger.Error("Storage reconciliation failed", "error", err)
+			}
+		}
+	}
+}
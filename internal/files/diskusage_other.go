@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package files
+
+import "errors"
+
+// statDisk is not supported on this platform
+func statDisk(path string) (uint64, uint64, error) {
+	return 0, 0, errors.New("disk usage is not supported on this platform")
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package files
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// statDisk returns the total and available bytes of the filesystem holding path
+func statDisk(path string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("failed to stat filesystem: %w", err)
+	}
+	return uint64(st.Blocks) * uint64(st.Bsize), uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -1,168 +1,234 @@
 package files
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"ringtonic-backend/internal/log"
 )
 
 // Manager handles file operations
 type Manager struct {
-	basePath string
-	logger   *log.Logger
+	backend     Backend
+	redirectTTL time.Duration
+	maxFileSize int64
+	logger      *log.Logger
+
+	// blobMu orders StoreBlob's reuse of existing blobs against their
+	// deletion; reused records when each blob was last handed out again
+	blobMu sync.Mutex
+	reused map[string]time.Time
 }
 
-// New creates a new file manager
+// New creates a new file manager backed by the local filesystem
 func New(basePath string, logger *log.Logger) *Manager {
+	return NewWithBackend(NewLocalBackend(basePath), 0, logger)
+}
+
+// NewWithBackend creates a new file manager on top of a storage backend.
+// If redirectTTL is positive and the backend can presign URLs, downloads
+// are redirected to the object store instead of being streamed through.
+func NewWithBackend(backend Backend, redirectTTL time.Duration, logger *log.Logger) *Manager {
 	return &Manager{
-		basePath: basePath,
-		logger:   logger,
+		backend:     backend,
+		redirectTTL: redirectTTL,
+		logger:      logger,
+		reused:      make(map[string]time.Time),
 	}
 }
 
-// EnsureDirectory creates the storage directory if it doesn't exist
-func (m *Manager) EnsureDirectory() error {
-	if err := os.MkdirAll(m.basePath, 0755); err != nil {
-		return fmt.Errorf("failed to create storage directory: %w", err)
-	}
-	return nil
+// SetMaxFileSize limits the size of files written to storage. Zero or less
+// disables the limit.
+func (m *Manager) SetMaxFileSize(size int64) {
+	m.maxFileSize = size
 }
 
-// GetFilePath returns the full path for a filename
-func (m *Manager) GetFilePath(filename string) string {
-	return filepath.Join(m.basePath, filename)
+// Backend returns the underlying storage backend
+func (m *Manager) Backend() Backend {
+	return m.backend
+}
+
+// DiskUsage reports the capacity of local storage. It returns nil if the
+// backend is not bounded by a local filesystem.
+func (m *Manager) DiskUsage() (*DiskUsage, error) {
+	reporter, ok := m.backend.(DiskReporter)
+	if !ok {
+		return nil, nil
+	}
+	return reporter.DiskUsage()
 }
 
 // FileExists checks if a file exists
 func (m *Manager) FileExists(filename string) bool {
-	path := m.GetFilePath(filename)
-	_, err := os.Stat(path)
+	_, err := m.backend.Stat(filename)
 	return err == nil
 }
 
 // ServeFile serves a file with appropriate headers
 func (m *Manager) ServeFile(w http.ResponseWriter, r *http.Request, filename string) error {
-	filePath := m.GetFilePath(filename)
+	return m.ServeFileAs(w, r, filename, path.Base(filename))
+}
+
+// ServeFileAs serves a file under a different download name
+func (m *Manager) ServeFileAs(w http.ResponseWriter, r *http.Request, filename, downloadName string) error {
+	return m.serveFile(w, r, filename, downloadName, ContentDisposition(downloadName), "public, max-age=3600")
+}
+
+// ServeInline serves a file for playback in the browser. Range requests are
+// supported, and the response is not stored by shared caches.
+func (m *Manager) ServeInline(w http.ResponseWriter, r *http.Request, filename, name string) error {
+	return m.serveFile(w, r, filename, name, InlineDisposition(name), "private, max-age=300")
+}
+
+// ServePrivate serves a file of personal data as an attachment that no
+// cache may store
+func (m *Manager) ServePrivate(w http.ResponseWriter, r *http.Request, filename string) error {
+	name := path.Base(filename)
+	return m.serveFile(w, r, filename, name, ContentDisposition(name), "private, no-store")
+}
 
-	// Check if file exists
-	if !m.FileExists(filename) {
+// serveFile serves a file with the given Content-Disposition and
+// Cache-Control headers
+func (m *Manager) serveFile(w http.ResponseWriter, r *http.Request, filename, downloadName, disposition, cacheControl string) error {
+	// Get file info
+	fileInfo, err := m.backend.Stat(filename)
+	if err == ErrNotExist || errors.Is(err, ErrInvalidKey) {
 		http.NotFound(w, r)
 		return fmt.Errorf("file not found: %s", filename)
 	}
-
-	// Open file
-	file, err := os.Open(filePath)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return fmt.Errorf("failed to open file: %w", err)
+		return fmt.Errorf("failed to get file info: %w", err)
 	}
-	defer file.Close()
 
-	// Get file info
-	fileInfo, err := file.Stat()
+	// Redirect to the object store when it can serve the file directly
+	if presigner, ok := m.backend.(Presigner); ok && m.redirectTTL > 0 {
+		location, err := presigner.PresignGet(filename, disposition, m.redirectTTL)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return fmt.Errorf("failed to presign download: %w", err)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, location, http.StatusFound)
+		m.logger.Info("File download redirected", "filename", filename)
+		return nil
+	}
+
+	// Open file, seeking natively where the backend allows it
+	var content io.ReadSeeker
+	body, err := m.backend.Get(filename)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return fmt.Errorf("failed to get file info: %w", err)
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		content = seeker
+	} else {
+		body.Close()
+		body = &objectReader{backend: m.backend, key: filename, size: fileInfo.Size}
+		content = body.(io.ReadSeeker)
 	}
+	defer body.Close()
 
 	// Set headers
-	m.setFileHeaders(w, filename, fileInfo.Size())
+	m.setFileHeaders(w, downloadName, fileInfo.Size, disposition, cacheControl)
 
 	// Serve file content
-	http.ServeContent(w, r, filename, fileInfo.ModTime(), file)
+	http.ServeContent(w, r, downloadName, fileInfo.ModTime, content)
 
-	m.logger.Info("File served", "filename", filename, "size", fileInfo.Size())
+	m.logger.Info("File served", "filename", filename, "size", fileInfo.Size)
 	return nil
 }
 
-// setFileHeaders sets appropriate headers for file downloads
-func (m *Manager) setFileHeaders(w http.ResponseWriter, filename string, size int64) {
-	// Set content type based on file extension
-	contentType := mime.TypeByExtension(filepath.Ext(filename))
-	if contentType == "" {
-		if strings.HasSuffix(strings.ToLower(filename), ".mp3") {
-			contentType = "audio/mpeg"
-		} else if strings.HasSuffix(strings.ToLower(filename), ".m4a") {
-			contentType = "audio/mp4"
-		} else {
-			contentType = "application/octet-stream"
-		}
+// audioContentTypes maps ringtone file extensions to content types, which
+// system MIME tables often lack or disagree on
+var audioContentTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".m4r":  "audio/x-m4r",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg; codecs=opus",
+	".wav":  "audio/wav",
+}
+
+// contentType returns the content type of a file from its extension
+func contentType(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if value, ok := audioContentTypes[ext]; ok {
+		return value
+	}
+	if value := mime.TypeByExtension(ext); value != "" {
+		return value
 	}
+	return "application/octet-stream"
+}
 
-	w.Header().Set("Content-Type", contentType)
+// setFileHeaders sets appropriate headers for file downloads
+func (m *Manager) setFileHeaders(w http.ResponseWriter, filename string, size int64, disposition, cacheControl string) {
+	w.Header().Set("Content-Type", contentType(filename))
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	w.Header().Set("Cache-Control", "public, max-age=3600") // Cache for 1 hour
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Cache-Control", cacheControl)
 }
 
-// SaveFile saves uploaded file content
-func (m *Manager) SaveFile(filename string, content io.Reader) error {
-	if err := m.EnsureDirectory(); err != nil {
-		return err
+// OpenFile opens a stored file for reading
+func (m *Manager) OpenFile(filename string) (io.ReadCloser, error) {
+	file, err := m.backend.Get(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
+	return file, nil
+}
 
-	filePath := m.GetFilePath(filename)
+// SaveFile saves uploaded file content
+func (m *Manager) SaveFile(filename string, content io.Reader) error {
+	_, err := m.SaveFileWithOptions(filename, content, SaveOptions{})
+	return err
+}
 
-	// Create file
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+// SaveFileWithOptions saves file content, computing its SHA-256 while it is
+// streamed. The backend makes the file visible only once it is complete, so
+// content that exceeds the size limit or fails the checksum is never stored.
+func (m *Manager) SaveFileWithOptions(filename string, content io.Reader, opts SaveOptions) (*SavedFile, error) {
+	limit := m.maxFileSize
+	if opts.Unbounded {
+		limit = 0
 	}
-	defer file.Close()
 
-	// Copy content
-	_, err = io.Copy(file, content)
-	if err != nil {
-		return fmt.Errorf("failed to write file content: %w", err)
+	checked := newCheckedReader(content, limit, opts.ExpectedSHA256)
+	if err := m.backend.Put(filename, checked, -1); err != nil {
+		return nil, err
 	}
 
-	m.logger.Info("File saved", "filename", filename, "path", filePath)
-	return nil
+	saved := &SavedFile{Key: filename, Size: checked.size, SHA256: checked.Sum()}
+	m.logger.Info("File saved", "filename", filename, "size", saved.Size, "sha256", saved.SHA256)
+	return saved, nil
 }
 
 // DeleteFile removes a file
 func (m *Manager) DeleteFile(filename string) error {
-	filePath := m.GetFilePath(filename)
-
-	if err := os.Remove(filePath); err != nil {
-		if os.IsNotExist(err) {
-			return nil // File already doesn't exist
-		}
-		return fmt.Errorf("failed to delete file: %w", err)
+	if err := m.backend.Delete(filename); err != nil {
+		return err
 	}
 
 	m.logger.Info("File deleted", "filename", filename)
 	return nil
 }
 
-// CleanupOldFiles removes files older than the specified number of days
-// This is a stub implementation for future cleanup policy
-func (m *Manager) CleanupOldFiles(days int) error {
-	// TODO: Implement cleanup policy
-	// This would:
-	// 1. Find files older than X days
-	// 2. Check if they have corresponding database entries
-	// 3. Remove orphaned files
-	// 4. Log cleanup activities
-
-	m.logger.Info("File cleanup requested", "days", days)
-	return nil
-}
-
 // GetFileSize returns the size of a file in bytes
 func (m *Manager) GetFileSize(filename string) (int64, error) {
-	filePath := m.GetFilePath(filename)
-
-	fileInfo, err := os.Stat(filePath)
+	fileInfo, err := m.backend.Stat(filename)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	return fileInfo.Size(), nil
+	return fileInfo.Size, nil
 }
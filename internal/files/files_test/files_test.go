@@ -0,0 +1,507 @@
+package files_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ringtonic-backend/internal/files"
+	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/store"
+)
+
+func setupS3Backend(t *testing.T) (*files.S3Backend, func()) {
+	server := httptest.NewServer(newFakeS3("ringtones"))
+
+	backend, err := files.NewS3Backend(files.S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "ringtones",
+		AccessKeyID:     "test-key",
+		SecretAccessKey: "test-secret",
+		Prefix:          "prod",
+		PathStyle:       true,
+	})
+	require.NoError(t, err)
+
+	return backend, server.Close
+}
+
+func testBackend(t *testing.T, backend files.Backend) {
+	content := "0123456789abcdef"
+	require.NoError(t, backend.Put("jobs/a.mp3", strings.NewReader(content), -1))
+	require.NoError(t, backend.Put("jobs/b.mp3", strings.NewReader("b"), 1))
+	require.NoError(t, backend.Put("other.mp3", strings.NewReader("c"), 1))
+
+	// Get
+	body, err := backend.Get("jobs/a.mp3")
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	// Range reads
+	body, err = backend.GetRange("jobs/a.mp3", 4, 6)
+	require.NoError(t, err)
+	data, err = io.ReadAll(body)
+	body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "456789", string(data))
+
+	body, err = backend.GetRange("jobs/a.mp3", 10, -1)
+	require.NoError(t, err)
+	data, err = io.ReadAll(body)
+	body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(data))
+
+	// Stat
+	info, err := backend.Stat("jobs/a.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size)
+	assert.False(t, info.ModTime.IsZero())
+
+	_, err = backend.Stat("missing.mp3")
+	assert.Equal(t, files.ErrNotExist, err)
+
+	// List
+	objects, err := backend.List("jobs/")
+	require.NoError(t, err)
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	assert.ElementsMatch(t, []string{"jobs/a.mp3", "jobs/b.mp3"}, keys)
+
+	// Delete, including a missing object
+	require.NoError(t, backend.Delete("jobs/a.mp3"))
+	require.NoError(t, backend.Delete("jobs/a.mp3"))
+	_, err = backend.Get("jobs/a.mp3")
+	assert.Equal(t, files.ErrNotExist, err)
+}
+
+func TestLocalBackend(t *testing.T) {
+	dir := t.TempDir()
+	testBackend(t, files.NewLocalBackend(dir))
+}
+
+func TestS3Backend(t *testing.T) {
+	backend, cleanup := setupS3Backend(t)
+	defer cleanup()
+
+	testBackend(t, backend)
+}
+
+func TestServeFileStreamsFromS3(t *testing.T) {
+	backend, cleanup := setupS3Backend(t)
+	defer cleanup()
+
+	manager := files.NewWithBackend(backend, 0, log.New("error"))
+	require.NoError(t, manager.SaveFile("job.mp3", strings.NewReader("0123456789")))
+
+	// Full download
+	req := httptest.NewRequest("GET", "/download/job.mp3", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, manager.ServeFile(w, req, "job.mp3"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.Equal(t, "audio/mpeg", w.Header().Get("Content-Type"))
+
+	// Range download
+	req = httptest.NewRequest("GET", "/download/job.mp3", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w = httptest.NewRecorder()
+	require.NoError(t, manager.ServeFile(w, req, "job.mp3"))
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "2345", w.Body.String())
+
+	// Missing file
+	req = httptest.NewRequest("GET", "/download/missing.mp3", nil)
+	w = httptest.NewRecorder()
+	assert.Error(t, manager.ServeFile(w, req, "missing.mp3"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServeFileRedirectsToS3(t *testing.T) {
+	backend, cleanup := setupS3Backend(t)
+	defer cleanup()
+
+	manager := files.NewWithBackend(backend, 5*time.Minute, log.New("error"))
+	require.NoError(t, manager.SaveFile("job.mp3", strings.NewReader("audio")))
+
+	req := httptest.NewRequest("GET", "/download/job.mp3", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, manager.ServeFileAs(w, req, "job.mp3", "My Ringtone.mp3"))
+	require.Equal(t, http.StatusFound, w.Code)
+
+	location := w.Header().Get("Location")
+	assert.Contains(t, location, "X-Amz-Signature=")
+	assert.Contains(t, location, "X-Amz-Expires=300")
+
+	// The presigned URL is usable against the object store
+	resp, err := http.Get(location)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "audio", string(data))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "My Ringtone.mp3")
+}
+
+func TestCleanupOldFilesReconcilesStorage(t *testing.T) {
+	dir := t.TempDir()
+	database, err := store.New(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	storageDir := filepath.Join(dir, "storage")
+	manager := files.New(storageDir, log.New("error"))
+
+	// A job whose file is present and one whose file has gone missing
+	for _, jobID := range []string{"present", "missing"} {
+		require.NoError(t, database.CreateJob(&store.Job{
+			ID:        jobID,
+			SourceURL: "https://example.com",
+			Status:    store.StatusCompleted,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}))
+		require.NoError(t, database.CreateRingtone(&store.Ringtone{
+			JobID:     jobID,
+			FileName:  jobID + ".mp3",
+			FilePath:  jobID + ".mp3",
+			Format:    "mp3",
+			CreatedAt: time.Now(),
+		}))
+	}
+	require.NoError(t, manager.SaveFile("present.mp3", strings.NewReader("audio")))
+
+	// An old orphan and a fresh one still inside the grace period
+	require.NoError(t, manager.SaveFile("old-orphan.mp3", strings.NewReader("orphan")))
+	require.NoError(t, manager.SaveFile("new-orphan.mp3", strings.NewReader("orphan")))
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(storageDir, "old-orphan.mp3"), old, old))
+
+	// Sidecars live as long as the file they belong to
+	require.NoError(t, manager.WriteSidecar("present.mp3", "waveform-8.bin", []byte("peaks")))
+	require.NoError(t, manager.WriteSidecar("old-orphan.mp3", "waveform-8.bin", []byte("peaks")))
+	for _, key := range []string{files.SidecarKey("present.mp3", "waveform-8.bin"), files.SidecarKey("old-orphan.mp3", "waveform-8.bin")} {
+		require.NoError(t, os.Chtimes(filepath.Join(storageDir, key), old, old))
+	}
+
+	opts := files.CleanupOptions{GracePeriod: 24 * time.Hour, DryRun: true}
+
+	// Dry run reports without changing anything
+	report, err := manager.CleanupOldFiles(database, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.FilesScanned)
+	require.Len(t, report.Orphans, 2)
+	assert.Equal(t, "old-orphan.mp3", report.Orphans[0].Key)
+	assert.False(t, report.Orphans[0].Deleted)
+	assert.Equal(t, 1, report.OrphansInGrace)
+	require.Len(t, report.Missing, 1)
+	assert.Equal(t, "missing", report.Missing[0].JobID)
+	assert.Equal(t, 0, report.JobsDegraded)
+	assert.True(t, manager.FileExists("old-orphan.mp3"))
+
+	// A real run deletes the orphan and degrades the job
+	opts.DryRun = false
+	report, err = manager.CleanupOldFiles(database, opts)
+	require.NoError(t, err)
+	require.Len(t, report.Orphans, 2)
+	assert.True(t, report.Orphans[0].Deleted)
+	assert.Equal(t, 1, report.JobsDegraded)
+	assert.Empty(t, report.Errors)
+
+	assert.False(t, manager.FileExists("old-orphan.mp3"))
+	assert.True(t, manager.FileExists("new-orphan.mp3"))
+	assert.True(t, manager.FileExists("present.mp3"))
+	_, err = manager.ReadSidecar("present.mp3", "waveform-8.bin")
+	assert.NoError(t, err)
+	_, err = manager.ReadSidecar("old-orphan.mp3", "waveform-8.bin")
+	assert.ErrorIs(t, err, files.ErrNotExist)
+
+	job, err := database.GetJob("missing")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusDegraded, job.Status)
+
+	job, err = database.GetJob("present")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusCompleted, job.Status)
+}
+
+func TestCleanupOldFilesExpiresSources(t *testing.T) {
+	dir := t.TempDir()
+	database, err := store.New(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	storageDir := filepath.Join(dir, "storage")
+	manager := files.New(storageDir, log.New("error"))
+
+	// A job that finished long ago and a pending one, each with a source
+	old := time.Now().Add(-48 * time.Hour)
+	sources := map[string]*files.Blob{}
+	for jobID, status := range map[string]string{"finished": store.StatusFailed, "pending": store.StatusQueued} {
+		blob, err := manager.StoreBlob(strings.NewReader("source of "+jobID), files.SaveOptions{})
+		require.NoError(t, err)
+		require.NoError(t, os.Chtimes(filepath.Join(storageDir, blob.Key), old, old))
+		sources[jobID] = blob
+		require.NoError(t, database.CreateJob(&store.Job{
+			ID:              jobID,
+			SourceURL:       "http://backend:8080/api/v1/sources/" + jobID,
+			Status:          status,
+			CreatedAt:       old,
+			UpdatedAt:       old,
+			SourceBlobHash:  &blob.Hash,
+			SourceSizeBytes: &blob.Size,
+		}))
+	}
+
+	opts := files.CleanupOptions{GracePeriod: 24 * time.Hour, SourceRetention: 24 * time.Hour, DryRun: true}
+
+	// Dry run reports without changing anything
+	report, err := manager.CleanupOldFiles(database, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.SourcesExpired)
+	assert.Empty(t, report.Orphans)
+
+	// A real run drops the finished job's source and reclaims its content
+	opts.DryRun = false
+	report, err = manager.CleanupOldFiles(database, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.SourcesExpired)
+	require.Len(t, report.Orphans, 1)
+	assert.Equal(t, sources["finished"].Key, report.Orphans[0].Key)
+	assert.Empty(t, report.Errors)
+
+	assert.False(t, manager.FileExists(sources["finished"].Key))
+	assert.True(t, manager.FileExists(sources["pending"].Key))
+	job, err := database.GetJob("finished")
+	require.NoError(t, err)
+	assert.Nil(t, job.SourceBlobHash)
+}
+
+func TestCleanKeyRejectsHostileNames(t *testing.T) {
+	hostile := []string{
+		"",
+		".",
+		"../secret.mp3",
+		"a/../../secret.mp3",
+		"/etc/passwd",
+		"..\\..\\windows\\win.ini",
+		"tone\x00.mp3",
+		"tone\n.mp3",
+		"\xff\xfe.mp3",
+	}
+	for _, key := range hostile {
+		_, err := files.CleanKey(key)
+		assert.ErrorIs(t, err, files.ErrInvalidKey, "key %q", key)
+	}
+
+	clean, err := files.CleanKey("exports/./a//b.zip")
+	require.NoError(t, err)
+	assert.Equal(t, "exports/a/b.zip", clean)
+}
+
+func TestStorageAndDisplayNames(t *testing.T) {
+	assert.Equal(t, "job-1.m4r", files.StorageName("job-1", ".M4R"))
+	assert.Equal(t, "job-1.mp3", files.StorageName("job-1", ".mp3;rm -rf"))
+	assert.Equal(t, "job_1___.mp3", files.StorageName("job/1/..", ""))
+
+	assert.Equal(t, "tone.mp3", files.DisplayName("../../tone.mp3"))
+	assert.Equal(t, "tone.mp3", files.DisplayName("C:\\music\\tone.mp3"))
+	assert.Equal(t, "evil.mp3", files.DisplayName("ev\"il\r\n.mp3"))
+	assert.Equal(t, "htaccess", files.DisplayName(".htaccess"))
+	assert.Equal(t, "ringtone", files.DisplayName(".."))
+	assert.Equal(t, "Café — tone.mp3", files.DisplayName("Café — tone.mp3"))
+
+	long := files.DisplayName(strings.Repeat("é", 300) + ".mp3")
+	assert.Equal(t, 128, len([]rune(long)))
+	assert.True(t, strings.HasSuffix(long, ".mp3"))
+}
+
+func TestContentDisposition(t *testing.T) {
+	assert.Equal(t, `attachment; filename="tone.mp3"`, files.ContentDisposition("tone.mp3"))
+	assert.Equal(t,
+		`attachment; filename="Caf_ _ tone.mp3"; filename*=UTF-8''Caf%C3%A9%20%E2%80%94%20tone.mp3`,
+		files.ContentDisposition("Café — tone.mp3"))
+	assert.Equal(t,
+		`attachment; filename="100_.mp3"; filename*=UTF-8''100%25.mp3`,
+		files.ContentDisposition("100%.mp3"))
+	assert.Equal(t,
+		`attachment; filename="x.mp3; filename=evil.exe"`,
+		files.ContentDisposition("x.mp3\"; filename=\"evil.exe"))
+}
+
+func TestLocalBackendConfinesPaths(t *testing.T) {
+	dir := t.TempDir()
+	storage := filepath.Join(dir, "storage")
+	outside := filepath.Join(dir, "outside")
+	require.NoError(t, os.MkdirAll(storage, 0755))
+	require.NoError(t, os.MkdirAll(outside, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+
+	backend := files.NewLocalBackend(storage)
+
+	// Traversal in the key itself
+	_, err := backend.Get("../outside/secret.txt")
+	assert.ErrorIs(t, err, files.ErrInvalidKey)
+	assert.ErrorIs(t, backend.Put("../outside/new.txt", strings.NewReader("x"), -1), files.ErrInvalidKey)
+
+	// Links leading out of storage
+	require.NoError(t, os.Symlink(outside, filepath.Join(storage, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(storage, "secret.mp3")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "missing.txt"), filepath.Join(storage, "dangling.mp3")))
+
+	_, err = backend.Get("escape/secret.txt")
+	assert.ErrorIs(t, err, files.ErrInvalidKey)
+	_, err = backend.Stat("secret.mp3")
+	assert.ErrorIs(t, err, files.ErrInvalidKey)
+	assert.ErrorIs(t, backend.Put("escape/new.txt", strings.NewReader("x"), -1), files.ErrInvalidKey)
+	assert.ErrorIs(t, backend.Put("dangling.mp3", strings.NewReader("x"), -1), files.ErrInvalidKey)
+	assert.NoFileExists(t, filepath.Join(outside, "new.txt"))
+	assert.NoFileExists(t, filepath.Join(outside, "missing.txt"))
+
+	// Links that stay inside storage keep working
+	require.NoError(t, backend.Put("real/tone.mp3", strings.NewReader("audio"), -1))
+	require.NoError(t, os.Symlink(filepath.Join(storage, "real"), filepath.Join(storage, "alias")))
+	info, err := backend.Stat("alias/tone.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+
+	// Hostile names are not served
+	manager := files.NewWithBackend(backend, 0, log.New("error"))
+	req := httptest.NewRequest("GET", "/download/secret.mp3", nil)
+	w := httptest.NewRecorder()
+	assert.Error(t, manager.ServeFile(w, req, "secret.mp3"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSaveFileIsAtomicAndChecksummed(t *testing.T) {
+	dir := t.TempDir()
+	manager := files.New(dir, log.New("error"))
+	manager.SetMaxFileSize(10)
+
+	// The checksum is computed while streaming
+	saved, err := manager.SaveFileWithOptions("tone.mp3", strings.NewReader("audio"), files.SaveOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), saved.Size)
+	assert.Equal(t, "6ed8919ce20490a5e3ad8630a4fab69475297abd07db73918dd5f36fcfaeb11b", saved.SHA256)
+
+	// A matching checksum is accepted, in either case
+	_, err = manager.SaveFileWithOptions("checked.mp3", strings.NewReader("audio"), files.SaveOptions{
+		ExpectedSHA256: strings.ToUpper(saved.SHA256),
+	})
+	require.NoError(t, err)
+
+	// A mismatched checksum never becomes visible, even over an existing file
+	_, err = manager.SaveFileWithOptions("checked.mp3", strings.NewReader("other"), files.SaveOptions{
+		ExpectedSHA256: saved.SHA256,
+	})
+	assert.ErrorIs(t, err, files.ErrChecksumMismatch)
+	data, err := os.ReadFile(filepath.Join(dir, "checked.mp3"))
+	require.NoError(t, err)
+	assert.Equal(t, "audio", string(data))
+
+	// Oversized content is rejected unless explicitly unbounded
+	err = manager.SaveFile("large.mp3", strings.NewReader(strings.Repeat("x", 11)))
+	assert.ErrorIs(t, err, files.ErrFileTooLarge)
+	assert.False(t, manager.FileExists("large.mp3"))
+	_, err = manager.SaveFileWithOptions("export.zip", strings.NewReader(strings.Repeat("x", 11)), files.SaveOptions{Unbounded: true})
+	assert.NoError(t, err)
+
+	// Failed writes leave no temporary files behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"tone.mp3", "checked.mp3", "export.zip"}, names)
+
+	// Blobs are verified before they are stored
+	_, err = manager.StoreBlob(strings.NewReader("audio"), files.SaveOptions{ExpectedSHA256: strings.Repeat("0", 64)})
+	assert.ErrorIs(t, err, files.ErrChecksumMismatch)
+	blob, err := manager.StoreBlob(strings.NewReader("audio"), files.SaveOptions{ExpectedSHA256: saved.SHA256})
+	require.NoError(t, err)
+	assert.Equal(t, saved.SHA256, blob.Hash)
+}
+
+func TestReusedBlobOutlivesRelease(t *testing.T) {
+	dir := t.TempDir()
+	database, err := store.New(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	storageDir := filepath.Join(dir, "storage")
+	manager := files.New(storageDir, log.New("error"))
+
+	blob, err := manager.StoreBlob(strings.NewReader("shared audio"), files.SaveOptions{})
+	require.NoError(t, err)
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(storageDir, blob.Key), old, old))
+
+	// Storing the same content again refreshes the blob
+	reused, err := manager.StoreBlob(strings.NewReader("shared audio"), files.SaveOptions{})
+	require.NoError(t, err)
+	assert.True(t, reused.Deduplicated)
+	info, err := os.Stat(filepath.Join(storageDir, blob.Key))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+
+	// Until the new reference is committed, the blob looks unreferenced,
+	// but neither a release of the old reference nor reconciliation
+	// removes it
+	require.NoError(t, manager.DeleteBlob(blob.Hash))
+	assert.True(t, manager.FileExists(blob.Key))
+
+	report, err := manager.CleanupOldFiles(database, files.CleanupOptions{GracePeriod: time.Hour})
+	require.NoError(t, err)
+	assert.Empty(t, report.Orphans)
+	assert.Equal(t, 1, report.OrphansInGrace)
+	assert.True(t, manager.FileExists(blob.Key))
+
+	// Blobs that are not being reused are deleted straight away
+	other, err := files.New(storageDir, log.New("error")).StoreBlob(strings.NewReader("other audio"), files.SaveOptions{})
+	require.NoError(t, err)
+	require.NoError(t, manager.DeleteBlob(other.Hash))
+	assert.False(t, manager.FileExists(other.Key))
+}
+
+func TestConcurrentBlobReuseAndDelete(t *testing.T) {
+	// However a release and a reuse of the same content interleave, the
+	// reused blob is in storage once its new reference is committed
+	for i := 0; i < 20; i++ {
+		manager := files.New(t.TempDir(), log.New("error"))
+		blob, err := manager.StoreBlob(strings.NewReader("shared audio"), files.SaveOptions{})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, manager.DeleteBlob(blob.Hash))
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := manager.StoreBlob(strings.NewReader("shared audio"), files.SaveOptions{})
+			assert.NoError(t, err)
+		}()
+		wg.Wait()
+
+		assert.True(t, manager.FileExists(blob.Key))
+	}
+}
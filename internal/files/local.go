@@ -0,0 +1,278 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalBackend stores objects as files under a base directory
+type LocalBackend struct {
+	basePath string
+}
+
+// NewLocalBackend creates a backend rooted at basePath
+func NewLocalBackend(basePath string) *LocalBackend {
+	return &LocalBackend{basePath: basePath}
+}
+
+// path returns the filesystem path for a key. The key is validated, and the
+// path is rejected if symbolic links would resolve it outside the base
+// directory.
+func (b *LocalBackend) path(key string) (string, error) {
+	clean, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(b.basePath, filepath.FromSlash(clean))
+
+	root, err := filepath.EvalSymlinks(b.basePath)
+	if os.IsNotExist(err) {
+		// Nothing has been stored yet, so there are no links to follow
+		return filePath, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve storage directory: %w", err)
+	}
+
+	// Resolve the longest part of the path that exists. A link whose target
+	// is missing is refused, since creating the file would follow it.
+	base := filepath.Clean(b.basePath)
+	existing, rest := filePath, ""
+	resolved, err := filepath.EvalSymlinks(existing)
+	for os.IsNotExist(err) && existing != base {
+		if _, lstatErr := os.Lstat(existing); lstatErr == nil {
+			return "", fmt.Errorf("%w: %q is a dangling link", ErrInvalidKey, key)
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+		resolved, err = filepath.EvalSymlinks(existing)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve file path: %w", err)
+	}
+
+	if !within(root, filepath.Join(resolved, rest)) {
+		return "", fmt.Errorf("%w: %q resolves outside storage", ErrInvalidKey, key)
+	}
+	return filePath, nil
+}
+
+// within reports whether target is root or a path below it
+func within(root, target string) bool {
+	rootAbs, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	targetAbs, err := filepath.Abs(target)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(rootAbs, targetAbs)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Put writes content to the file for key. Content is written to a temporary
+// file in the same directory, synced, and renamed into place, so readers
+// never observe a partially written file.
+func (b *LocalBackend) Put(key string, content io.Reader, size int64) error {
+	filePath, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(filePath)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, ".tmp-"+filepath.Base(filePath)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	tmpPath := file.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			file.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := io.Copy(file, content); err != nil {
+		return fmt.Errorf("failed to write file content: %w", err)
+	}
+	if err := file.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	committed = true
+
+	// Persist the rename itself
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync storage directory: %w", err)
+	}
+
+	return nil
+}
+
+// syncDir flushes a directory's entries to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Get opens the file for key. The returned reader is an *os.File and
+// therefore also an io.ReadSeeker.
+func (b *LocalBackend) Get(key string) (io.ReadCloser, error) {
+	filePath, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// GetRange opens a byte range of the file for key
+func (b *LocalBackend) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	body, err := b.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file := body.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+// Stat returns information about the file for key
+func (b *LocalBackend) Stat(key string) (*ObjectInfo, error) {
+	filePath, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if fileInfo.IsDir() {
+		return nil, ErrNotExist
+	}
+
+	return &ObjectInfo{Key: key, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}, nil
+}
+
+// Touch sets the modification time of the file for key to now
+func (b *LocalBackend) Touch(key string) error {
+	filePath, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(filePath, now, now); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotExist
+		}
+		return fmt.Errorf("failed to touch file: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the file for key
+func (b *LocalBackend) Delete(key string) error {
+	filePath, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// List walks the base directory and returns the files under prefix
+func (b *LocalBackend) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	err := filepath.Walk(b.basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.basePath, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	return objects, nil
+}
+
+// DiskUsage reports the capacity of the filesystem holding the base directory
+func (b *LocalBackend) DiskUsage() (*DiskUsage, error) {
+	if err := os.MkdirAll(b.basePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	total, free, err := statDisk(b.basePath)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskUsage{TotalBytes: total, FreeBytes: free}, nil
+}
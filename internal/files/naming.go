@@ -0,0 +1,150 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidKey is returned for storage keys that could resolve outside of
+// the storage root
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// maxDisplayNameLength is the maximum length of a display name in runes
+const maxDisplayNameLength = 128
+
+// defaultDisplayName is used when nothing usable is left of a file name
+const defaultDisplayName = "ringtone"
+
+// CleanKey validates a storage key and returns it in canonical form. Keys
+// are relative, slash-separated paths; absolute paths, ".." segments,
+// backslashes, control characters and invalid UTF-8 are rejected.
+func CleanKey(key string) (string, error) {
+	if key == "" || !utf8.ValidString(key) || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+
+	clean := path.Clean(key)
+	if clean == "." {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return clean, nil
+}
+
+// StorageName returns the server-generated storage name for a job's output.
+// Only the extension is taken from the producer, and only if it is a short
+// alphanumeric one.
+func StorageName(jobID, ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if ext == "" || len(ext) > 8 || strings.IndexFunc(ext, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) >= 0 {
+		ext = "mp3"
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, jobID)
+	return name + "." + ext
+}
+
+// DisplayName reduces an untrusted file name to a name that is safe to show
+// to users and to offer as a download name. Directories, control characters
+// and quotes are stripped; Unicode letters are kept.
+func DisplayName(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+
+	if runes := []rune(name); len(runes) > maxDisplayNameLength {
+		ext := path.Ext(name)
+		if len([]rune(ext)) >= maxDisplayNameLength {
+			ext = ""
+		}
+		name = string(runes[:maxDisplayNameLength-len([]rune(ext))]) + ext
+	}
+
+	if name == "" {
+		return defaultDisplayName
+	}
+	return name
+}
+
+// ContentDisposition formats an attachment Content-Disposition header as
+// described in RFC 6266. Names that are not plain ASCII are sent in a
+// filename* parameter, with an ASCII approximation as the fallback.
+func ContentDisposition(name string) string {
+	return disposition("attachment", name)
+}
+
+// InlineDisposition formats an inline Content-Disposition header, for
+// content meant to be played in the browser rather than saved
+func InlineDisposition(name string) string {
+	return disposition("inline", name)
+}
+
+// disposition formats a Content-Disposition header of the given type
+func disposition(dispositionType, name string) string {
+	name = DisplayName(name)
+
+	fallback := strings.Map(func(r rune) rune {
+		if r >= 0x80 || r == '%' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if fallback == name {
+		return fmt.Sprintf(`%s; filename="%s"`, dispositionType, name)
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, dispositionType, fallback, encodeExtValue(name))
+}
+
+// encodeExtValue percent-encodes a value for an RFC 8187 ext-value
+func encodeExtValue(value string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+// isAttrChar reports whether c may appear unencoded in an ext-value
+func isAttrChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
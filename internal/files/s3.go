@@ -0,0 +1,419 @@
+package files
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3-compatible backend such as MinIO
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every key, allowing several deployments to share a bucket
+	Prefix string
+	// PathStyle addresses the bucket as endpoint/bucket rather than bucket.endpoint
+	PathStyle bool
+}
+
+// S3Backend stores objects in an S3-compatible bucket using the S3 REST API
+// with AWS Signature Version 4
+type S3Backend struct {
+	config     S3Config
+	endpoint   *url.URL
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+)
+
+// NewS3Backend creates a new S3 backend
+func NewS3Backend(config S3Config) (*S3Backend, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	return &S3Backend{
+		config:   config,
+		endpoint: endpoint,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Minute,
+		},
+		now: time.Now,
+	}, nil
+}
+
+// Put uploads an object. Content of unknown size is spooled to a temporary
+// file first, because S3 requires a Content-Length on PUT.
+func (b *S3Backend) Put(key string, content io.Reader, size int64) error {
+	if size < 0 {
+		spool, err := os.CreateTemp("", "ringtonic-s3-*")
+		if err != nil {
+			return fmt.Errorf("failed to create spool file: %w", err)
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		size, err = io.Copy(spool, content)
+		if err != nil {
+			return fmt.Errorf("failed to spool upload: %w", err)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind spool file: %w", err)
+		}
+		content = spool
+	}
+
+	req, err := b.newRequest(http.MethodPut, key, nil, io.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType := mime.TypeByExtension(extension(key)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads an object
+func (b *S3Backend) Get(key string) (io.ReadCloser, error) {
+	return b.GetRange(key, 0, -1)
+}
+
+// GetRange downloads a byte range of an object
+func (b *S3Backend) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	req, err := b.newRequest(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 || length >= 0 {
+		rangeHeader := fmt.Sprintf("bytes=%d-", offset)
+		if length >= 0 {
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat returns object metadata using a HEAD request
+func (b *S3Backend) Stat(key string) (*ObjectInfo, error) {
+	req, err := b.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Delete removes an object
+func (b *S3Backend) Delete(key string) error {
+	req, err := b.newRequest(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(req)
+	if err == ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult is the ListObjectsV2 response body
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns all objects under prefix, following continuation tokens
+func (b *S3Backend) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	continuation := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", b.objectKey(prefix))
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+
+		req, err := b.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := b.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object listing: %w", err)
+		}
+
+		for _, item := range result.Contents {
+			key := item.Key
+			if b.config.Prefix != "" {
+				key = strings.TrimPrefix(key, b.config.Prefix+"/")
+			}
+			objects = append(objects, ObjectInfo{Key: key, Size: item.Size, ModTime: item.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuation = result.NextContinuationToken
+	}
+}
+
+// PresignGet returns a time-limited GET URL for an object that makes the
+// object store answer with the given Content-Disposition
+func (b *S3Backend) PresignGet(key, contentDisposition string, expires time.Duration) (string, error) {
+	now := b.now().UTC()
+	target := b.objectURL(key)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", b.config.AccessKeyID+"/"+b.scope(now))
+	query.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if contentDisposition != "" {
+		query.Set("response-content-disposition", contentDisposition)
+	}
+	target.RawQuery = canonicalQuery(query)
+
+	header := http.Header{}
+	header.Set("Host", target.Host)
+	signature := b.signature(http.MethodGet, target, header, []string{"host"}, s3UnsignedPayload, now)
+
+	target.RawQuery += "&X-Amz-Signature=" + signature
+	return target.String(), nil
+}
+
+// objectKey applies the configured prefix to a key
+func (b *S3Backend) objectKey(key string) string {
+	if b.config.Prefix == "" {
+		return key
+	}
+	return b.config.Prefix + "/" + key
+}
+
+// objectURL returns the URL of an object, or of the bucket if key is empty
+func (b *S3Backend) objectURL(key string) *url.URL {
+	target := *b.endpoint
+	objectPath := ""
+	if key != "" {
+		objectPath = "/" + b.objectKey(key)
+	}
+
+	if b.config.PathStyle {
+		target.Path = "/" + b.config.Bucket + objectPath
+	} else {
+		target.Host = b.config.Bucket + "." + target.Host
+		target.Path = objectPath
+		if target.Path == "" {
+			target.Path = "/"
+		}
+	}
+	target.RawPath = encodePath(target.Path)
+	return &target
+}
+
+// newRequest builds a signed request for an object or, with an empty key, the bucket
+func (b *S3Backend) newRequest(method, key string, query url.Values, body io.ReadCloser) (*http.Request, error) {
+	target := b.objectURL(key)
+	if query != nil {
+		target.RawQuery = canonicalQuery(query)
+	}
+
+	req, err := http.NewRequest(method, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Body = body
+	}
+
+	now := b.now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	req.Header.Set("Host", target.Host)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	signature := b.signature(method, target, req.Header, signedHeaders, s3UnsignedPayload, now)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, b.config.AccessKeyID, b.scope(now), strings.Join(signedHeaders, ";"), signature))
+
+	return req, nil
+}
+
+// do sends a request and maps error statuses
+func (b *S3Backend) do(req *http.Request) (*http.Response, error) {
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotExist
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return resp, nil
+}
+
+// scope returns the credential scope for a signing time
+func (b *S3Backend) scope(t time.Time) string {
+	return fmt.Sprintf("%s/%s/s3/aws4_request", t.Format(s3DateFormat), b.config.Region)
+}
+
+// signature computes the SigV4 signature of a request
+func (b *S3Backend) signature(method string, target *url.URL, header http.Header, signedHeaders []string, payloadHash string, t time.Time) string {
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(header.Get(name)) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		target.EscapedPath(),
+		target.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		t.Format(s3TimeFormat),
+		b.scope(t),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.config.SecretAccessKey), t.Format(s3DateFormat))
+	key = hmacSHA256(key, b.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// hmacSHA256 computes an HMAC-SHA256 digest
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by key, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// encodePath URI-encodes every segment of a path
+func encodePath(p string) string {
+	return uriEncode(p, false)
+}
+
+// uriEncode percent-encodes everything except unreserved characters.
+// Slashes are kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			encoded.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+// extension returns the extension of a slash-separated key
+func extension(key string) string {
+	if i := strings.LastIndexByte(key, '.'); i >= 0 && !strings.Contains(key[i:], "/") {
+		return key[i:]
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"ringtonic-backend/internal/media"
+)
+
+// sidecarSeparator joins the key of a stored file and the name of derived
+// data cached next to it. Storage reconciliation keeps a sidecar for as long
+// as the file it belongs to is referenced.
+const sidecarSeparator = "~"
+
+// SidecarKey returns the storage key of derived data cached next to a file
+func SidecarKey(key, name string) string {
+	return key + sidecarSeparator + name
+}
+
+// sidecarOwner returns the key of the file a sidecar belongs to
+func sidecarOwner(key string) (string, bool) {
+	i := strings.LastIndex(key, sidecarSeparator)
+	if i <= 0 {
+		return "", false
+	}
+	return key[:i], true
+}
+
+// ReadSidecar returns data cached next to a stored file, or ErrNotExist
+func (m *Manager) ReadSidecar(key, name string) ([]byte, error) {
+	body, err := m.backend.Get(SidecarKey(key, name))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// WriteSidecar caches data next to a stored file
+func (m *Manager) WriteSidecar(key, name string, data []byte) error {
+	return m.backend.Put(SidecarKey(key, name), bytes.NewReader(data), int64(len(data)))
+}
+
+// OpenSeeker opens a stored file for random access, using range reads on
+// backends that cannot seek natively
+func (m *Manager) OpenSeeker(key string) (io.ReadSeekCloser, error) {
+	body, err := m.backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := body.(io.ReadSeekCloser); ok {
+		return seeker, nil
+	}
+	body.Close()
+
+	info, err := m.backend.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	return &objectReader{backend: m.backend, key: key, size: info.Size}, nil
+}
+
+// Waveform returns the waveform of a stored audio file, computing it on
+// first use and caching it in a sidecar. Files that cannot be decoded
+// return media.ErrUnsupported.
+func (m *Manager) Waveform(key string, buckets int) (*media.Waveform, error) {
+	name := "waveform-" + strconv.Itoa(buckets) + ".bin"
+
+	cached, err := m.ReadSidecar(key, name)
+	if err == nil {
+		waveform := &media.Waveform{}
+		if err := waveform.UnmarshalBinary(cached); err == nil {
+			return waveform, nil
+		}
+		m.logger.Warn("Discarding invalid waveform cache", "key", key, "buckets", buckets)
+	} else if err != ErrNotExist {
+		return nil, fmt.Errorf("failed to read waveform cache: %w", err)
+	}
+
+	content, err := m.OpenSeeker(key)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	waveform, err := media.ComputeWaveform(content, buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failed cache write only costs a recomputation
+	encoded, err := waveform.MarshalBinary()
+	if err == nil {
+		err = m.WriteSidecar(key, name, encoded)
+	}
+	if err != nil {
+		m.logger.Warn("Failed to cache waveform", "key", key, "error", err)
+	}
+
+	m.logger.Info("Waveform computed", "key", key, "buckets", buckets)
+	return waveform, nil
+}
+
+// Loudness measures the EBU R128 loudness of a stored audio file. Files
+// that cannot be decoded return media.ErrUnsupported.
+func (m *Manager) Loudness(key string) (*media.Loudness, error) {
+	content, err := m.OpenSeeker(key)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return media.MeasureLoudness(content)
+}
+
+// SuggestTrims ranks windows of a stored audio file for cutting a
+// ringtone. Files that cannot be decoded return media.ErrUnsupported.
+func (m *Manager) SuggestTrims(key string, window time.Duration, count int) (*media.TrimAnalysis, error) {
+	content, err := m.OpenSeeker(key)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return media.SuggestTrims(content, window, count)
+}
@@ -1,13 +1,30 @@
 package jobs
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
+	"ringtonic-backend/internal/contract"
+	"ringtonic-backend/internal/files"
+	"ringtonic-backend/internal/links"
 	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/media"
 	"ringtonic-backend/internal/store"
 )
 
@@ -17,20 +34,171 @@ type StoreInterface interface {
 	GetJob(id string) (*store.Job, error)
 	UpdateJobStatus(id, status string, errorMessage *string) error
 	IncrementJobAttempts(id string) error
-	CreateRingtone(ringtone *store.Ringtone) error
+	StartJob(id string) (bool, error)
+	SetJobEndpoint(id, endpoint string) error
+	ListQueuedJobs() ([]*store.Job, error)
+	ResetCallbackToken(id, tokenHash string) (bool, error)
 	GetRingtoneByJobID(jobID string) (*store.Ringtone, error)
+	CompleteJob(ringtone *store.Ringtone) (bool, error)
+	FailJob(id string, errorMessage *string) (bool, error)
+	PutRingtoneAsset(asset *store.RingtoneAsset) error
+	GetRingtoneAsset(ringtoneID int, kind string) (*store.RingtoneAsset, error)
+	GetRingtoneAssets(ringtoneID int) ([]*store.RingtoneAsset, error)
 }
 
-// N8NClientInterface defines the interface for n8n operations
-type N8NClientInterface interface {
-	TriggerWebhook(payload map[string]interface{}) error
+var (
+	// ErrInvalidFilePath is returned when a callback names a file outside of storage
+	ErrInvalidFilePath = errors.New("invalid file_path")
+	// ErrJobNotFound is returned when a job does not exist
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobNotPending is returned when output is delivered for a job that
+	// has already completed or failed
+	ErrJobNotPending = errors.New("job is not pending")
+	// ErrJobNotCompleted is returned when a rendition is delivered for a job
+	// that has no ringtone yet
+	ErrJobNotCompleted = errors.New("job is not completed")
+	// ErrSourceUploadsDisabled is returned when source uploads are not configured
+	ErrSourceUploadsDisabled = errors.New("source uploads are disabled")
+	// ErrSourceTooLarge is returned when an uploaded source exceeds the size limit
+	ErrSourceTooLarge = errors.New("source file exceeds maximum size")
+	// ErrUnsupportedSource is returned when an uploaded source is not audio or video
+	ErrUnsupportedSource = errors.New("source file is not a supported audio or video format")
+	// ErrSourceTooLong is returned when an uploaded source exceeds the duration limit
+	ErrSourceTooLong = errors.New("source file exceeds maximum duration")
+	// ErrUnsupportedFormat is returned when a job requests or a worker
+	// delivers an output format that is not supported
+	ErrUnsupportedFormat = errors.New("unsupported output format")
+	// ErrInvalidTargetLoudness is returned when a job's loudness target is
+	// out of range or given without normalization
+	ErrInvalidTargetLoudness = errors.New("invalid target loudness")
+	// ErrSourceNotAvailable is returned when a job's source audio is not
+	// held by the backend, as for jobs created from a URL
+	ErrSourceNotAvailable = errors.New("source audio is not available")
+	// ErrInvalidTrimRequest is returned when a trim suggestion asks for an
+	// unsupported duration or number of candidates
+	ErrInvalidTrimRequest = errors.New("invalid trim request")
+	// ErrAutoStartUnavailable is returned when a job asks for its start to
+	// be chosen automatically but its source cannot be analyzed
+	ErrAutoStartUnavailable = errors.New("automatic start is not available")
+	// ErrMissingCallbackToken is returned when a callback for a job with a
+	// callback token carries none
+	ErrMissingCallbackToken = errors.New("missing callback token")
+	// ErrInvalidCallbackToken is returned when a callback carries a token
+	// that is not its job's, or one that has been revoked
+	ErrInvalidCallbackToken = errors.New("invalid callback token")
+	// ErrUploadWindowClosed is returned when a rendition is uploaded for a
+	// job that completed longer ago than the asset upload window
+	ErrUploadWindowClosed = errors.New("upload window is closed")
+)
+
+// maxCoverBytes bounds cover art embedded in tags
+const maxCoverBytes = 5 << 20
+
+// Loudness targets for normalization, in LUFS. The default suits phone
+// speakers, in line with streaming services' mobile targets.
+const (
+	DefaultTargetLUFS = -16.0
+	MinTargetLUFS     = -30.0
+	MaxTargetLUFS     = -9.0
+)
+
+// Bounds of trim suggestions. Without a requested duration, the job's own
+// duration option or DefaultTrimSeconds is used.
+const (
+	DefaultTrimSeconds    = 30
+	MinTrimSeconds        = 5
+	MaxTrimSeconds        = 60
+	DefaultTrimCandidates = 3
+	MaxTrimCandidates     = 10
+)
+
+// SupportedFormats are the output formats a job may request
+var SupportedFormats = []string{"mp3", "m4r", "m4a", "ogg", "opus", "wav"}
+
+// ProcessorInterface dispatches jobs for processing. Dispatch only hands the
+// job over; processors report the outcome through HandleCallback or
+// HandleUpload, like the n8n workflow does.
+type ProcessorInterface interface {
+	Process(request *contract.JobRequest) error
+}
+
+// RoutingProcessorInterface is implemented by processors that spread jobs
+// over several endpoints. The endpoint that accepted a job is recorded on it.
+type RoutingProcessorInterface interface {
+	ProcessRouted(request *contract.JobRequest) (string, error)
+}
+
+// AvailabilityInterface is implemented by processors that can be down for a
+// while, such as the n8n client while its circuit breaker is open. Jobs
+// wait in the queue rather than spend their dispatch attempts meanwhile.
+type AvailabilityInterface interface {
+	// Available returns a channel that is closed while the processor
+	// accepts work
+	Available() <-chan struct{}
+}
+
+// FileStoreInterface defines the interface for file storage operations
+type FileStoreInterface interface {
+	IngestFile(filename, expectedSHA256 string) (*files.Blob, error)
+	StoreBlob(content io.Reader, opts files.SaveOptions) (*files.Blob, error)
+	DeleteBlob(hash string) error
+	OpenFile(filename string) (io.ReadCloser, error)
+	Loudness(key string) (*media.Loudness, error)
+	SuggestTrims(key string, window time.Duration, count int) (*media.TrimAnalysis, error)
+}
+
+// URLSignerInterface defines the interface for signing download URLs
+type URLSignerInterface interface {
+	SignDownloadURL(filename, jobID string, userID *string) string
+	SignPreviewURL(ringtoneID int, jobID string, userID *string) string
+	SignWaveformURL(ringtoneID int, jobID string, userID *string) string
+}
+
+// JobTokenInterface issues per-job tokens that authorize a worker to act on
+// a single job
+type JobTokenInterface interface {
+	Token(jobID string) string
+}
+
+// AdmissionInterface decides whether new jobs may be accepted
+type AdmissionInterface interface {
+	AdmitJob(userID *string) error
 }
 
 // Manager handles job lifecycle management
 type Manager struct {
 	store     StoreInterface
-	n8nClient N8NClientInterface
-	logger    *log.Logger
+	processor ProcessorInterface
+	files     FileStoreInterface
+	signer    URLSignerInterface
+	links     *links.Builder
+	admission AdmissionInterface
+	uploads   JobTokenInterface
+	sources   JobTokenInterface
+	limits    SourceLimits
+	// previewRetention limits how long previews are kept; zero keeps them
+	previewRetention time.Duration
+	// assetUploadWindow limits how long after completion renditions may be
+	// uploaded; zero leaves it open
+	assetUploadWindow time.Duration
+	// admitMu serializes admission with the creation of the admitted job,
+	// so concurrent requests cannot all pass a quota that only one fits
+	admitMu sync.Mutex
+	// dispatchMu guards stopped, so no dispatch starts once Stop waits for
+	// the running ones
+	dispatchMu sync.Mutex
+	dispatches sync.WaitGroup
+	stopped    bool
+	stop       chan struct{}
+	logger     *log.Logger
+}
+
+// SourceLimits bounds uploaded source files
+type SourceLimits struct {
+	MaxBytes int64
+	// MaxDuration is only enforced for formats whose duration can be read
+	// without decoding
+	MaxDuration time.Duration
 }
 
 // CreateJobRequest represents the request to create a new job
@@ -40,6 +208,15 @@ type CreateJobRequest struct {
 	Options   *store.JobOptions `json:"options,omitempty"`
 }
 
+// CreateUploadJobRequest represents the request to create a job from an
+// uploaded source file
+type CreateUploadJobRequest struct {
+	// FileName is the client's name for the file, used for logging only
+	FileName string
+	UserID   *string
+	Options  *store.JobOptions
+}
+
 // CreateJobResponse represents the response for job creation
 type CreateJobResponse struct {
 	JobID   string `json:"job_id"`
@@ -47,113 +224,557 @@ type CreateJobResponse struct {
 	PollURL string `json:"poll_url"`
 }
 
-// JobStatusResponse represents the response for job status queries
+// JobStatusResponse represents the response for job status queries.
+// Downloads maps each available output format to its download URL.
 type JobStatusResponse struct {
-	JobID       string    `json:"job_id"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	DownloadURL *string   `json:"download_url,omitempty"`
-	Error       *string   `json:"error,omitempty"`
+	JobID       string            `json:"job_id"`
+	Status      string            `json:"status"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	DownloadURL *string           `json:"download_url,omitempty"`
+	Downloads   map[string]string `json:"downloads,omitempty"`
+	PreviewURL  *string           `json:"preview_url,omitempty"`
+	WaveformURL *string           `json:"waveform_url,omitempty"`
+	SHA256      *string           `json:"sha256,omitempty"`
+	Loudness    *media.Loudness   `json:"loudness,omitempty"`
+	Error       *string           `json:"error,omitempty"`
 }
 
-// CallbackRequest represents the n8n callback payload
-type CallbackRequest struct {
-	JobID    string                 `json:"job_id"`
-	Status   string                 `json:"status"`
-	FilePath *string                `json:"file_path,omitempty"`
-	Metadata map[string]interface{} `json:"metadata,omitempty"`
+// UploadRequest describes produced audio uploaded by a worker
+type UploadRequest struct {
+	JobID string
+	// FileName is the worker's name for the file, used for display only
+	FileName        string
+	SHA256          string
+	DurationSeconds *int
 }
 
-// New creates a new job manager
-func New(store StoreInterface, n8nClient N8NClientInterface, logger *log.Logger) *Manager {
+// CallbackRequest represents the n8n callback payload
+type CallbackRequest = contract.Callback
+
+// CallbackOutput is a rendition of a completed job in an additional output
+// format, delivered alongside the file at file_path
+type CallbackOutput = contract.CallbackOutput
+
+// New creates a new job manager dispatching jobs to processor
+func New(store StoreInterface, processor ProcessorInterface, logger *log.Logger) *Manager {
 	return &Manager{
 		store:     store,
-		n8nClient: n8nClient,
+		processor: processor,
+		stop:      make(chan struct{}),
 		logger:    logger,
 	}
 }
 
+// SetFileStore enables content-addressed storage of produced files. Without
+// a file store, completed callbacks record the reported file path as-is.
+func (m *Manager) SetFileStore(files FileStoreInterface) {
+	m.files = files
+}
+
+// SetURLSigner makes job status responses carry signed, expiring download
+// URLs instead of plain ones
+func (m *Manager) SetURLSigner(signer URLSignerInterface) {
+	m.signer = signer
+}
+
+// SetLinks makes the URLs handed to clients and workers absolute. Without
+// it, they are paths relative to the backend.
+func (m *Manager) SetLinks(links *links.Builder) {
+	m.links = links
+}
+
+// SetAdmission makes CreateJob reject jobs that the admission check refuses,
+// such as jobs over a user's storage quota
+func (m *Manager) SetAdmission(admission AdmissionInterface) {
+	m.admission = admission
+}
+
+// SetUploadTokens lets workers upload produced audio instead of writing it
+// to shared storage. Each job's payload then carries an upload URL and a
+// token valid for that job only.
+func (m *Manager) SetUploadTokens(uploads JobTokenInterface) {
+	m.uploads = uploads
+}
+
+// SetAssetUploadWindow limits how long after a job completes its preview and
+// additional formats may still be uploaded with its upload token. Zero
+// leaves uploads open for as long as the ringtone exists.
+func (m *Manager) SetAssetUploadWindow(window time.Duration) {
+	m.assetUploadWindow = window
+}
+
+// SetPreviewRetention limits how long preview renditions are kept after they
+// are produced. Zero keeps them as long as their ringtone.
+func (m *Manager) SetPreviewRetention(retention time.Duration) {
+	m.previewRetention = retention
+}
+
+// SetSourceUploads enables CreateUploadJob. Uploaded sources are served back
+// to the workflow at a URL authorized by a token valid for that job only.
+func (m *Manager) SetSourceUploads(sources JobTokenInterface, limits SourceLimits) {
+	m.sources = sources
+	m.limits = limits
+}
+
 // CreateJob creates a new ringtone generation job
 func (m *Manager) CreateJob(req *CreateJobRequest) (*CreateJobResponse, error) {
-	// Generate job ID
+	options, err := normalizeOptions(req.Options)
+	if err != nil {
+		return nil, err
+	}
+	if options.AutoStart {
+		return nil, fmt.Errorf("%w: start_seconds \"auto\" needs an uploaded source", ErrAutoStartUnavailable)
+	}
+
+	job := &store.Job{
+		ID:        uuid.New().String(),
+		SourceURL: req.SourceURL,
+		UserID:    req.UserID,
+	}
+	return m.createJob(job, options, req.SourceURL)
+}
+
+// CreateUploadJob creates a ringtone generation job from an uploaded audio or
+// video file. The content is identified by sniffing, never by the client's
+// name or type, and is stored as a source blob that the workflow downloads
+// from the backend.
+func (m *Manager) CreateUploadJob(req *CreateUploadJobRequest, content io.Reader) (*CreateJobResponse, error) {
+	if m.sources == nil || m.files == nil {
+		return nil, ErrSourceUploadsDisabled
+	}
+	options, err := normalizeOptions(req.Options)
+	if err != nil {
+		return nil, err
+	}
+	// Admission is checked again when the job is created; checking it now
+	// spares users over their quota the upload
+	if m.admission != nil {
+		if err := m.admission.AdmitJob(req.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Spool the upload so it can be probed before it is stored
+	spool, err := os.CreateTemp("", "source-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	reader := content
+	if m.limits.MaxBytes > 0 {
+		reader = io.LimitReader(content, m.limits.MaxBytes+1)
+	}
+	size, err := io.Copy(spool, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file: %w", err)
+	}
+	if m.limits.MaxBytes > 0 && size > m.limits.MaxBytes {
+		return nil, fmt.Errorf("%w (%d bytes)", ErrSourceTooLarge, m.limits.MaxBytes)
+	}
+
+	info, err := media.Probe(spool)
+	if err != nil {
+		if errors.Is(err, media.ErrUnsupported) {
+			return nil, ErrUnsupportedSource
+		}
+		return nil, fmt.Errorf("failed to probe source file: %w", err)
+	}
+	if m.limits.MaxDuration > 0 && info.Duration > m.limits.MaxDuration {
+		return nil, fmt.Errorf("%w (%s)", ErrSourceTooLong, m.limits.MaxDuration)
+	}
+	if options.AutoStart {
+		if err := resolveAutoStart(spool, options); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind source file: %w", err)
+	}
+	// The size was already checked against the source limit, which may be
+	// larger than the limit for produced files
+	blob, err := m.files.StoreBlob(spool, files.SaveOptions{Unbounded: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store source file: %w", err)
+	}
+
 	jobID := uuid.New().String()
+	sourceURL := m.internalURL(links.SourcePath(jobID))
+	mimeType := info.MIMEType
+	job := &store.Job{
+		ID:              jobID,
+		SourceURL:       sourceURL,
+		UserID:          req.UserID,
+		SourceBlobHash:  &blob.Hash,
+		SourceSizeBytes: &blob.Size,
+		SourceMIMEType:  &mimeType,
+	}
+
+	m.logger.Info("Source uploaded", "job_id", jobID, "file_name", req.FileName, "mime_type", mimeType, "size_bytes", blob.Size)
 
-	// Set default options if not provided
-	if req.Options == nil {
-		req.Options = &store.JobOptions{
-			Format: "mp3",
+	// The source token is a credential, so it is sent but not stored
+	response, err := m.createJob(job, options, sourceURL+"?token="+m.sources.Token(jobID))
+	if err != nil && !blob.Deduplicated {
+		// Nothing references content stored for a job that was not
+		// created, such as one a concurrent job pushed over the quota
+		if deleteErr := m.files.DeleteBlob(blob.Hash); deleteErr != nil {
+			m.logger.Warn("Failed to delete source of rejected job", "job_id", jobID, "hash", blob.Hash, "error", deleteErr)
 		}
 	}
-	if req.Options.Format == "" {
-		req.Options.Format = "mp3"
+	return response, err
+}
+
+// normalizeOptions fills in default options and checks the requested output
+// formats and loudness target. Format is the primary format, delivered as
+// the ringtone's file; Formats lists every format, primary first.
+func normalizeOptions(options *store.JobOptions) (*store.JobOptions, error) {
+	normalized := &store.JobOptions{}
+	if options != nil {
+		*normalized = *options
 	}
 
-	// Create n8n payload
-	n8nPayload := map[string]interface{}{
-		"job_id":       jobID,
-		"source_url":   req.SourceURL,
-		"options":      req.Options,
-		"callback_url": fmt.Sprintf("http://backend:8080/api/v1/n8n-callback"),
+	requested := normalized.Formats
+	if normalized.Format != "" {
+		requested = append([]string{normalized.Format}, requested...)
+	}
+	if len(requested) == 0 {
+		requested = []string{"mp3"}
+	}
+
+	formats := make([]string, 0, len(requested))
+	for _, format := range requested {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if !slices.Contains(SupportedFormats, format) {
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+		}
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+
+	normalized.Format = formats[0]
+	normalized.Formats = formats
+
+	switch {
+	case normalized.Normalize && normalized.TargetLUFS == nil:
+		target := DefaultTargetLUFS
+		normalized.TargetLUFS = &target
+	case normalized.TargetLUFS != nil && !normalized.Normalize:
+		return nil, fmt.Errorf("%w: target_lufs requires normalize", ErrInvalidTargetLoudness)
+	}
+	if target := normalized.TargetLUFS; target != nil && (*target < MinTargetLUFS || *target > MaxTargetLUFS) {
+		return nil, fmt.Errorf("%w: %g LUFS is outside %g to %g", ErrInvalidTargetLoudness, *target, MinTargetLUFS, MaxTargetLUFS)
+	}
+	return normalized, nil
+}
+
+// outputFormat returns the format of a produced file from its name, falling
+// back to mp3 for names without a supported extension
+func outputFormat(fileName string) string {
+	format := strings.ToLower(strings.TrimPrefix(path.Ext(fileName), "."))
+	if slices.Contains(SupportedFormats, format) {
+		return format
 	}
+	return "mp3"
+}
+
+// SuggestTrimRequest asks for the best windows of a job's source audio.
+// Zero values take the defaults.
+type SuggestTrimRequest struct {
+	DurationSeconds int `json:"duration_seconds,omitempty"`
+	Count           int `json:"count,omitempty"`
+}
+
+// SuggestTrimResponse ranks windows of a job's source audio, best first
+type SuggestTrimResponse struct {
+	JobID string `json:"job_id"`
+	// WindowSeconds is the length of each candidate window
+	WindowSeconds int `json:"window_seconds"`
+	*media.TrimAnalysis
+}
 
-	payloadJSON, err := json.Marshal(n8nPayload)
+// SuggestTrim analyzes the uploaded source of a job and proposes windows to
+// cut it at, scored by energy, onset density and repetition. Only uploaded
+// sources are held by the backend; other jobs return ErrSourceNotAvailable.
+func (m *Manager) SuggestTrim(jobID string, req *SuggestTrimRequest) (*SuggestTrimResponse, error) {
+	job, err := m.store.GetJob(jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	if job.SourceBlobHash == nil || m.files == nil {
+		return nil, ErrSourceNotAvailable
+	}
+
+	window := req.DurationSeconds
+	if window == 0 {
+		window = DefaultTrimSeconds
+		if options := jobOptions(job); options != nil && options.DurationSeconds != nil {
+			window = *options.DurationSeconds
+		}
+	}
+	if window < MinTrimSeconds || window > MaxTrimSeconds {
+		return nil, fmt.Errorf("%w: duration_seconds must be between %d and %d", ErrInvalidTrimRequest, MinTrimSeconds, MaxTrimSeconds)
+	}
+	count := req.Count
+	if count == 0 {
+		count = DefaultTrimCandidates
+	}
+	if count < 1 || count > MaxTrimCandidates {
+		return nil, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidTrimRequest, MaxTrimCandidates)
+	}
+
+	analysis, err := m.files.SuggestTrims(files.BlobKey(*job.SourceBlobHash), time.Duration(window)*time.Second, count)
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Info("Trim suggested", "job_id", jobID, "window_seconds", window, "candidates", len(analysis.Candidates))
+	return &SuggestTrimResponse{JobID: jobID, WindowSeconds: window, TrimAnalysis: analysis}, nil
+}
+
+// resolveAutoStart starts a job asking for start_seconds "auto" at the best
+// window of its source, ranked as for SuggestTrim
+func resolveAutoStart(source io.ReadSeeker, options *store.JobOptions) error {
+	window := DefaultTrimSeconds
+	if options.DurationSeconds != nil {
+		window = *options.DurationSeconds
+	}
+	if window < MinTrimSeconds || window > MaxTrimSeconds {
+		return fmt.Errorf("%w: duration_seconds must be between %d and %d", ErrInvalidTrimRequest, MinTrimSeconds, MaxTrimSeconds)
+	}
+
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind source file: %w", err)
+	}
+	analysis, err := media.SuggestTrims(source, time.Duration(window)*time.Second, 1)
+	if errors.Is(err, media.ErrUnsupported) {
+		return fmt.Errorf("%w: start_seconds \"auto\" needs WAV or MP3 audio", ErrAutoStartUnavailable)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to analyze source file: %w", err)
+	}
+
+	start := analysis.Candidates[0].StartSeconds
+	options.StartSeconds = &start
+	return nil
+}
+
+// jobOptions returns the options a job was dispatched with, or nil if its
+// payload cannot be read
+func jobOptions(job *store.Job) *store.JobOptions {
+	if job.N8NPayload == nil {
+		return nil
+	}
+	var payload struct {
+		Options *store.JobOptions `json:"options"`
+	}
+	if err := json.Unmarshal([]byte(*job.N8NPayload), &payload); err != nil {
+		return nil
+	}
+	return payload.Options
+}
+
+// OpenSource opens the uploaded source file of a job. It returns nil for
+// jobs whose source is a URL.
+func (m *Manager) OpenSource(jobID string) (io.ReadCloser, error) {
+	job, err := m.store.GetJob(jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	if job.SourceBlobHash == nil || m.files == nil {
+		return nil, nil
+	}
+	return m.files.OpenFile(files.BlobKey(*job.SourceBlobHash))
+}
+
+// createJob stores a queued job and dispatches it to the processor.
+// payloadSourceURL is the source URL sent to the workflow, which may carry
+// credentials that must not be stored with the job. The job is admitted
+// and stored under admitMu, so it counts against its user's quota before
+// the next job is admitted.
+func (m *Manager) createJob(job *store.Job, options *store.JobOptions, payloadSourceURL string) (*CreateJobResponse, error) {
+	m.admitMu.Lock()
+	defer m.admitMu.Unlock()
+	if m.admission != nil {
+		if err := m.admission.AdmitJob(job.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create n8n payload
+	request := contract.NewJobRequest(job.ID, job.SourceURL, options, m.internalURL(links.CallbackPath(job.ID)))
+
+	payloadJSON, err := json.Marshal(request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal n8n payload: %w", err)
 	}
 
 	payloadStr := string(payloadJSON)
 
-	// Create job in database
-	job := &store.Job{
-		ID:         jobID,
-		SourceURL:  req.SourceURL,
-		UserID:     req.UserID,
-		Status:     store.StatusQueued,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-		Attempts:   0,
-		N8NPayload: &payloadStr,
+	// Tokens are credentials, so they are sent but not stored. Only the
+	// callback token's hash is kept, to check callbacks against.
+	callbackToken, callbackTokenHash, err := newCallbackToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create callback token: %w", err)
 	}
+	job.CallbackTokenHash = &callbackTokenHash
+	request.CallbackToken = callbackToken
+	request.SourceURL = payloadSourceURL
+	m.attachUploadToken(request)
+
+	// Create job in database
+	now := time.Now()
+	job.Status = store.StatusQueued
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	job.Attempts = 0
+	job.N8NPayload = &payloadStr
 
 	if err := m.store.CreateJob(job); err != nil {
 		return nil, fmt.Errorf("failed to create job in database: %w", err)
 	}
 
-	m.logger.Info("Job created", "job_id", jobID, "source_url", req.SourceURL)
+	m.logger.Info("Job created", "job_id", job.ID, "source_url", job.SourceURL)
 
-	// Trigger n8n workflow asynchronously
-	go m.triggerN8NWorkflow(jobID, n8nPayload)
+	// Dispatch asynchronously
+	m.dispatch(job.ID, request)
 
 	return &CreateJobResponse{
-		JobID:   jobID,
+		JobID:   job.ID,
 		Status:  store.StatusQueued,
-		PollURL: fmt.Sprintf("/api/v1/job-status/%s", jobID),
+		PollURL: m.publicURL(links.PollPath(job.ID)),
 	}, nil
 }
 
-// triggerN8NWorkflow triggers the n8n workflow with retry logic
-func (m *Manager) triggerN8NWorkflow(jobID string, payload map[string]interface{}) {
+// attachUploadToken lets the workflow upload the job's result, if worker
+// uploads are enabled
+func (m *Manager) attachUploadToken(request *contract.JobRequest) {
+	if m.uploads != nil {
+		request.UploadURL = m.internalURL(links.UploadPath(request.JobID))
+		request.UploadToken = m.uploads.Token(request.JobID)
+	}
+}
+
+// Resume dispatches every job left queued by a previous process, whose
+// dispatch was lost with it. Tokens are not stored, so each job is sent
+// with fresh ones; its previous callback token is revoked, in case the
+// lost dispatch reached the processor after all.
+func (m *Manager) Resume() {
+	queued, err := m.store.ListQueuedJobs()
+	if err != nil {
+		m.logger.Error("Failed to list queued jobs", "error", err)
+		return
+	}
+
+	for _, job := range queued {
+		if err := m.resumeJob(job); err != nil {
+			m.logger.Error("Failed to resume job", "job_id", job.ID, "error", err)
+		}
+	}
+}
+
+// resumeJob rebuilds a queued job's request from its stored payload and
+// dispatches it
+func (m *Manager) resumeJob(job *store.Job) error {
+	if job.N8NPayload == nil {
+		return fmt.Errorf("job has no payload")
+	}
+	var request contract.JobRequest
+	if err := json.Unmarshal([]byte(*job.N8NPayload), &request); err != nil {
+		return fmt.Errorf("failed to decode payload: %w", err)
+	}
+
+	callbackToken, callbackTokenHash, err := newCallbackToken()
+	if err != nil {
+		return fmt.Errorf("failed to create callback token: %w", err)
+	}
+	reset, err := m.store.ResetCallbackToken(job.ID, callbackTokenHash)
+	if err != nil {
+		return err
+	}
+	if !reset {
+		// The job moved on while the queue was being read
+		return nil
+	}
+
+	request.CallbackToken = callbackToken
+	if job.SourceBlobHash != nil && m.sources != nil {
+		request.SourceURL = job.SourceURL + "?token=" + m.sources.Token(job.ID)
+	}
+	m.attachUploadToken(&request)
+
+	m.logger.Info("Job resumed", "job_id", job.ID, "attempts", job.Attempts)
+	m.dispatch(job.ID, &request)
+	return nil
+}
+
+// dispatch starts dispatching a job in the background, unless the manager
+// is stopped; the job then stays queued for Resume
+func (m *Manager) dispatch(jobID string, request *contract.JobRequest) {
+	m.dispatchMu.Lock()
+	defer m.dispatchMu.Unlock()
+	if m.stopped {
+		return
+	}
+	m.dispatches.Add(1)
+	go func() {
+		defer m.dispatches.Done()
+		m.dispatchJob(jobID, request)
+	}()
+}
+
+// Stop ends dispatching and waits for dispatches in flight to return.
+// Jobs waiting for a retry or for the processor are left queued, to be
+// resumed by the next process.
+func (m *Manager) Stop() {
+	m.dispatchMu.Lock()
+	if !m.stopped {
+		m.stopped = true
+		close(m.stop)
+	}
+	m.dispatchMu.Unlock()
+	m.dispatches.Wait()
+}
+
+// dispatchJob hands a job to the processor with retry logic
+func (m *Manager) dispatchJob(jobID string, request *contract.JobRequest) {
 	logger := m.logger.WithJobID(jobID)
 
 	const maxAttempts = 3
 	const baseDelay = time.Second
 
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		logger.Info("Triggering n8n workflow", "attempt", attempt)
-
-		// Increment attempts in database
-		if err := m.store.IncrementJobAttempts(jobID); err != nil {
-			logger.Error("Failed to increment job attempts", "error", err)
+		if !m.waitForProcessor(logger) {
+			logger.Info("Dispatch stopped, job stays queued")
+			return
 		}
+		logger.Info("Dispatching job", "attempt", attempt)
 
-		// Trigger webhook
-		if err := m.n8nClient.TriggerWebhook(payload); err != nil {
-			logger.Error("Failed to trigger n8n webhook", "error", err, "attempt", attempt)
+		endpoint, err := m.process(request)
+		if err != nil {
+			logger.Error("Failed to dispatch job", "error", err, "attempt", attempt)
+
+			// Failures while the processor is down, including webhooks its
+			// open circuit refused, are not the job's fault, so the attempt
+			// is neither counted nor used up but retried once it is back
+			if !m.processorAvailable() {
+				attempt--
+				continue
+			}
+			m.countAttempt(logger, jobID)
 
 			if attempt == maxAttempts {
 				// Mark job as failed after max attempts
-				errorMsg := fmt.Sprintf("Failed to trigger n8n after %d attempts: %v", maxAttempts, err)
+				errorMsg := fmt.Sprintf("Failed to dispatch job after %d attempts: %v", maxAttempts, err)
 				if updateErr := m.store.UpdateJobStatus(jobID, store.StatusFailed, &errorMsg); updateErr != nil {
 					logger.Error("Failed to update job status to failed", "error", updateErr)
 				}
@@ -163,20 +784,87 @@ func (m *Manager) triggerN8NWorkflow(jobID string, payload map[string]interface{
 			// Exponential backoff
 			delay := baseDelay * time.Duration(1<<(attempt-1))
 			logger.Info("Retrying after delay", "delay", delay)
-			time.Sleep(delay)
+			select {
+			case <-time.After(delay):
+			case <-m.stop:
+				logger.Info("Dispatch stopped, job stays queued")
+				return
+			}
 			continue
 		}
 
-		// Success - update job status to processing
-		if err := m.store.UpdateJobStatus(jobID, store.StatusProcessing, nil); err != nil {
+		m.countAttempt(logger, jobID)
+		if endpoint != "" {
+			if err := m.store.SetJobEndpoint(jobID, endpoint); err != nil {
+				logger.Error("Failed to record job endpoint", "error", err)
+			}
+		}
+
+		// Success - update job status to processing, unless the processor
+		// has already reported back
+		started, err := m.store.StartJob(jobID)
+		switch {
+		case err != nil:
 			logger.Error("Failed to update job status to processing", "error", err)
-		} else {
-			logger.Info("n8n workflow triggered successfully")
+		case started:
+			logger.Info("Job dispatched successfully", "endpoint", endpoint)
+		default:
+			logger.Info("Job finished before its dispatch returned")
 		}
 		return
 	}
 }
 
+// process hands a job to the processor, returning the endpoint that
+// accepted it if the processor has several
+func (m *Manager) process(request *contract.JobRequest) (string, error) {
+	if router, ok := m.processor.(RoutingProcessorInterface); ok {
+		return router.ProcessRouted(request)
+	}
+	return "", m.processor.Process(request)
+}
+
+// countAttempt records a dispatch attempt that reached the processor
+func (m *Manager) countAttempt(logger *log.Logger, jobID string) {
+	if err := m.store.IncrementJobAttempts(jobID); err != nil {
+		logger.Error("Failed to increment job attempts", "error", err)
+	}
+}
+
+// waitForProcessor blocks until the processor accepts work, reporting
+// false if the manager is stopped first
+func (m *Manager) waitForProcessor(logger *log.Logger) bool {
+	select {
+	case <-m.stop:
+		return false
+	default:
+	}
+	if m.processorAvailable() {
+		return true
+	}
+	logger.Info("Processor unavailable, job stays queued")
+	select {
+	case <-m.processor.(AvailabilityInterface).Available():
+		return true
+	case <-m.stop:
+		return false
+	}
+}
+
+// processorAvailable reports whether the processor accepts work
+func (m *Manager) processorAvailable() bool {
+	availability, ok := m.processor.(AvailabilityInterface)
+	if !ok {
+		return true
+	}
+	select {
+	case <-availability.Available():
+		return true
+	default:
+		return false
+	}
+}
+
 // GetJobStatus retrieves the current status of a job
 func (m *Manager) GetJobStatus(jobID string) (*JobStatusResponse, error) {
 	job, err := m.store.GetJob(jobID)
@@ -203,17 +891,84 @@ func (m *Manager) GetJobStatus(jobID string) (*JobStatusResponse, error) {
 			m.logger.Error("Failed to get ringtone for completed job", "job_id", jobID, "error", err)
 		} else if ringtone != nil {
 			downloadURL := fmt.Sprintf("/download/%s", ringtone.FileName)
+			if m.signer != nil {
+				downloadURL = m.signer.SignDownloadURL(ringtone.FileName, job.ID, job.UserID)
+			}
+			downloadURL = m.publicURL(downloadURL)
 			response.DownloadURL = &downloadURL
+			response.Downloads = map[string]string{ringtone.Format: downloadURL}
+			response.SHA256 = ringtone.BlobHash
+			if ringtone.LoudnessLUFS != nil && ringtone.TruePeakDBTP != nil && ringtone.LoudnessRangeLU != nil {
+				response.Loudness = &media.Loudness{
+					IntegratedLUFS: *ringtone.LoudnessLUFS,
+					TruePeakDBTP:   *ringtone.TruePeakDBTP,
+					RangeLU:        *ringtone.LoudnessRangeLU,
+				}
+			}
+
+			assets, err := m.store.GetRingtoneAssets(ringtone.ID)
+			if err != nil {
+				m.logger.Error("Failed to get formats for completed job", "job_id", jobID, "error", err)
+			}
+			for _, asset := range assets {
+				if format, ok := asset.Format(); ok {
+					response.Downloads[format] = withQuery(downloadURL, "format", format)
+				}
+			}
+
+			waveformURL := fmt.Sprintf("/api/v1/ringtones/%d/waveform", ringtone.ID)
+			if m.signer != nil {
+				waveformURL = m.signer.SignWaveformURL(ringtone.ID, job.ID, job.UserID)
+			}
+			waveformURL = m.publicURL(waveformURL)
+			response.WaveformURL = &waveformURL
+
+			preview, err := m.store.GetRingtoneAsset(ringtone.ID, store.AssetPreview)
+			if err != nil {
+				m.logger.Error("Failed to get preview for completed job", "job_id", jobID, "error", err)
+			} else if preview != nil {
+				previewURL := fmt.Sprintf("/api/v1/ringtones/%d/preview", ringtone.ID)
+				if m.signer != nil {
+					previewURL = m.signer.SignPreviewURL(ringtone.ID, job.ID, job.UserID)
+				}
+				previewURL = m.publicURL(previewURL)
+				response.PreviewURL = &previewURL
+			}
 		}
 	}
 
 	return response, nil
 }
 
-// HandleCallback processes n8n callback
+// publicURL resolves a path handed to clients
+func (m *Manager) publicURL(path string) string {
+	if m.links == nil {
+		return path
+	}
+	return m.links.Public(path)
+}
+
+// internalURL resolves a path handed to workers
+func (m *Manager) internalURL(path string) string {
+	if m.links == nil {
+		return path
+	}
+	return m.links.Internal(path)
+}
+
+// withQuery appends a query parameter to a URL path
+func withQuery(rawURL, key, value string) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// HandleCallback processes a processor's callback
 func (m *Manager) HandleCallback(req *CallbackRequest) error {
 	logger := m.logger.WithJobID(req.JobID)
-	logger.Info("Processing n8n callback", "status", req.Status)
+	logger.Info("Processing callback", "status", req.Status)
 
 	// Get job from database
 	job, err := m.store.GetJob(req.JobID)
@@ -224,10 +979,15 @@ func (m *Manager) HandleCallback(req *CallbackRequest) error {
 	if job == nil {
 		return fmt.Errorf("job not found")
 	}
+	if job.N8NEndpoint != nil {
+		logger.Info("Job was dispatched to endpoint", "endpoint", *job.N8NEndpoint)
+	}
 
+	// The job's callback token is revoked as it finishes, in the same
+	// update, so a token reused concurrently finishes the job only once
 	switch req.Status {
 	case store.StatusCompleted:
-		return m.handleCompletedCallback(req, logger)
+		return m.handleCompletedCallback(req, job, logger)
 	case store.StatusFailed:
 		return m.handleFailedCallback(req, logger)
 	default:
@@ -235,58 +995,514 @@ func (m *Manager) HandleCallback(req *CallbackRequest) error {
 	}
 }
 
+// VerifyCallbackToken checks that a callback carries its job's callback
+// token. Jobs created before callback tokens, and jobs that do not exist,
+// are left for HandleCallback to deal with.
+func (m *Manager) VerifyCallbackToken(jobID, token string) error {
+	job, err := m.store.GetJob(jobID)
+	if err != nil {
+		return fmt.Errorf("failed to get job: %w", err)
+	}
+	if job == nil || job.CallbackTokenHash == nil {
+		return nil
+	}
+
+	if token == "" {
+		return ErrMissingCallbackToken
+	}
+	// A revoked token has an empty hash, which no token matches
+	hash := hashCallbackToken(token)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(*job.CallbackTokenHash)) != 1 {
+		return ErrInvalidCallbackToken
+	}
+	return nil
+}
+
+// newCallbackToken creates a random callback token and its hash
+func newCallbackToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	return token, hashCallbackToken(token), nil
+}
+
+// hashCallbackToken returns the hex SHA-256 of a callback token
+func hashCallbackToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // handleCompletedCallback handles successful job completion
-func (m *Manager) handleCompletedCallback(req *CallbackRequest, logger *log.Logger) error {
+func (m *Manager) handleCompletedCallback(req *CallbackRequest, job *store.Job, logger *log.Logger) error {
 	if req.FilePath == nil {
 		return fmt.Errorf("file_path is required for completed status")
 	}
+	if job.Status != store.StatusQueued && job.Status != store.StatusProcessing {
+		return ErrJobNotPending
+	}
 
 	// Extract metadata
 	var duration *int
-	if req.Metadata != nil {
-		if d, ok := req.Metadata["duration"].(float64); ok {
-			durationInt := int(d)
-			duration = &durationInt
+	if req.Metadata != nil && req.Metadata.Duration != nil {
+		durationInt := int(*req.Metadata.Duration)
+		duration = &durationInt
+	}
+
+	// The path comes from the workflow and is only trusted once it is known
+	// to stay inside storage
+	sourcePath, err := files.CleanKey(*req.FilePath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFilePath, err)
+	}
+	displayName := files.DisplayName(sourcePath)
+
+	var previewPath string
+	if req.PreviewPath != nil {
+		previewPath, err = files.CleanKey(*req.PreviewPath)
+		if err != nil {
+			return fmt.Errorf("%w: preview_path: %v", ErrInvalidFilePath, err)
 		}
 	}
 
+	outputs := make([]CallbackOutput, len(req.Outputs))
+	for i, output := range req.Outputs {
+		if !slices.Contains(SupportedFormats, output.Format) {
+			return fmt.Errorf("%w: outputs: %q", ErrUnsupportedFormat, output.Format)
+		}
+		output.FilePath, err = files.CleanKey(output.FilePath)
+		if err != nil {
+			return fmt.Errorf("%w: outputs: %v", ErrInvalidFilePath, err)
+		}
+		outputs[i] = output
+	}
+
+	tags := m.callbackTags(req, job, logger)
+
 	// Create ringtone record
 	ringtone := &store.Ringtone{
 		JobID:           req.JobID,
-		FileName:        *req.FilePath,
-		FilePath:        *req.FilePath,
-		Format:          "mp3", // TODO: Extract from metadata or filename
+		FileName:        sourcePath,
+		FilePath:        sourcePath,
+		Format:          outputFormat(displayName),
 		DurationSeconds: duration,
+		DisplayName:     &displayName,
 		CreatedAt:       time.Now(),
 	}
 
-	if err := m.store.CreateRingtone(ringtone); err != nil {
-		return fmt.Errorf("failed to create ringtone record: %w", err)
+	// Move the produced file into the blob store under a name of our own,
+	// keeping the workflow's file name for display only
+	if m.files != nil {
+		expectedSHA256 := ""
+		if req.SHA256 != nil {
+			expectedSHA256 = *req.SHA256
+		}
+		blob, err := m.files.IngestFile(sourcePath, expectedSHA256)
+		if err != nil {
+			return fmt.Errorf("failed to store produced file: %w", err)
+		}
+		logger.Info("Produced file stored", "hash", blob.Hash, "deduplicated", blob.Deduplicated)
+
+		// The ringtone records the tagged file, so its checksum is that of
+		// the file users download
+		blob = m.tagBlob(blob, ringtone.Format, tags, logger)
+		ringtone.FileName = files.StorageName(req.JobID, path.Ext(displayName))
+		ringtone.FilePath = blob.Key
+		ringtone.BlobHash = &blob.Hash
+		ringtone.SizeBytes = &blob.Size
+		m.measureLoudness(ringtone, logger)
+	}
+
+	// If the job finished concurrently, the blob is left without a reference
+	// and storage reconciliation removes it
+	completed, err := m.store.CompleteJob(ringtone)
+	if err != nil {
+		return err
+	}
+	if !completed {
+		return ErrJobNotPending
+	}
+
+	// The ringtone is usable without its preview, so a bad preview is only logged
+	if previewPath != "" && m.files != nil {
+		expectedSHA256 := ""
+		if req.PreviewSHA256 != nil {
+			expectedSHA256 = *req.PreviewSHA256
+		}
+		blob, err := m.files.IngestFile(previewPath, expectedSHA256)
+		if err == nil {
+			_, err = m.putPreview(ringtone, blob, previewPath)
+		}
+		if err != nil {
+			logger.Error("Failed to store preview", "preview_path", previewPath, "error", err)
+		}
 	}
 
-	// Update job status
-	if err := m.store.UpdateJobStatus(req.JobID, store.StatusCompleted, nil); err != nil {
-		return fmt.Errorf("failed to update job status: %w", err)
+	// Likewise for renditions in additional formats
+	for _, output := range outputs {
+		if m.files == nil || output.Format == ringtone.Format {
+			continue
+		}
+		expectedSHA256 := ""
+		if output.SHA256 != nil {
+			expectedSHA256 = *output.SHA256
+		}
+		blob, err := m.files.IngestFile(output.FilePath, expectedSHA256)
+		if err == nil {
+			_, err = m.putFormat(ringtone, m.tagBlob(blob, output.Format, tags, logger), output.Format)
+		}
+		if err != nil {
+			logger.Error("Failed to store output format", "format", output.Format, "file_path", output.FilePath, "error", err)
+		}
 	}
 
 	logger.Info("Job completed successfully", "file_path", *req.FilePath)
 	return nil
 }
 
+// callbackTags builds the tags written into a job's audio from callback
+// metadata: "title" (or "original_title"), "artist" (or "uploader") and
+// "cover_path", an image the workflow wrote into storage. The comment holds
+// "source_url", or else the job's own source URL unless it was an upload.
+// It returns nil if the metadata names no title, artist or cover, leaving
+// the files untouched.
+func (m *Manager) callbackTags(req *CallbackRequest, job *store.Job, logger *log.Logger) *media.Tags {
+	metadata := req.Metadata
+	if metadata == nil {
+		metadata = &contract.CallbackMetadata{}
+	}
+	tags := &media.Tags{
+		Title:   firstNonBlank(metadata.Title, metadata.OriginalTitle),
+		Artist:  firstNonBlank(metadata.Artist, metadata.Uploader),
+		Comment: firstNonBlank(metadata.SourceURL),
+	}
+
+	// Tags are a nicety, so a cover that cannot be used is only logged
+	if coverPath := firstNonBlank(metadata.CoverPath); coverPath != "" && m.files != nil {
+		cover, err := m.readCover(coverPath)
+		if err != nil {
+			logger.Warn("Ignoring cover art", "cover_path", coverPath, "error", err)
+		}
+		tags.Cover = cover
+	}
+
+	if tags.Title == "" && tags.Artist == "" && tags.Cover == nil {
+		return nil
+	}
+	if tags.Comment == "" && job.SourceBlobHash == nil {
+		tags.Comment = job.SourceURL
+	}
+	return tags
+}
+
+// firstNonBlank returns the first of values that is not blank, trimmed
+func firstNonBlank(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// readCover reads cover art from storage. The path is validated like
+// file_path; the image is left in place for storage reconciliation.
+func (m *Manager) readCover(coverPath string) ([]byte, error) {
+	key, err := files.CleanKey(coverPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFilePath, err)
+	}
+	file, err := m.files.OpenFile(key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cover, err := io.ReadAll(io.LimitReader(file, maxCoverBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cover art: %w", err)
+	}
+	if len(cover) > maxCoverBytes {
+		return nil, fmt.Errorf("cover art exceeds %d bytes", maxCoverBytes)
+	}
+	if media.CoverMIMEType(cover) == "" {
+		return nil, fmt.Errorf("cover art is not a JPEG or PNG image")
+	}
+	return cover, nil
+}
+
+// tagBlob writes tags into a stored blob's audio and stores the result as a
+// new blob. The blob is returned unchanged if there are no tags, its format
+// cannot be tagged, or tagging fails; the untagged content is left to
+// storage reconciliation once nothing references it.
+func (m *Manager) tagBlob(blob *files.Blob, format string, tags *media.Tags, logger *log.Logger) *files.Blob {
+	if tags == nil || !media.CanTag(format) {
+		return blob
+	}
+
+	tagged, err := m.writeTags(blob, format, tags)
+	if err != nil {
+		logger.Error("Failed to write tags", "format", format, "hash", blob.Hash, "error", err)
+		return blob
+	}
+
+	logger.Info("Tags written", "format", format, "hash", tagged.Hash, "untagged_hash", blob.Hash)
+	return tagged
+}
+
+// writeTags stores a tagged copy of a blob. Tagging MP4 files needs random
+// access, so the blob is spooled to a temporary file first.
+func (m *Manager) writeTags(blob *files.Blob, format string, tags *media.Tags) (*files.Blob, error) {
+	file, err := m.files.OpenFile(blob.Key)
+	if err != nil {
+		return nil, err
+	}
+	spool, err := os.CreateTemp("", "tags-*")
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	_, err = io.Copy(spool, file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool audio: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := media.WriteTags(pw, spool, format, tags)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	tagged, err := m.files.StoreBlob(pr, files.SaveOptions{})
+	pr.CloseWithError(err)
+	if writeErr := <-written; writeErr != nil {
+		return nil, writeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tagged, nil
+}
+
+// measureLoudness records the EBU R128 loudness of a ringtone's stored
+// file. Only WAV and MP3 files can be decoded; ringtones that cannot be
+// measured are recorded without loudness.
+func (m *Manager) measureLoudness(ringtone *store.Ringtone, logger *log.Logger) {
+	loudness, err := m.files.Loudness(ringtone.FilePath)
+	switch {
+	case errors.Is(err, media.ErrUnsupported), errors.Is(err, media.ErrTooQuiet):
+		logger.Info("Loudness not measured", "format", ringtone.Format, "reason", err)
+		return
+	case err != nil:
+		logger.Warn("Failed to measure loudness", "error", err)
+		return
+	}
+
+	ringtone.LoudnessLUFS = &loudness.IntegratedLUFS
+	ringtone.TruePeakDBTP = &loudness.TruePeakDBTP
+	ringtone.LoudnessRangeLU = &loudness.RangeLU
+	logger.Info("Loudness measured", "integrated_lufs", loudness.IntegratedLUFS, "true_peak_dbtp", loudness.TruePeakDBTP, "range_lu", loudness.RangeLU)
+}
+
 // handleFailedCallback handles job failure
 func (m *Manager) handleFailedCallback(req *CallbackRequest, logger *log.Logger) error {
 	errorMessage := "Job failed in n8n workflow"
-	if req.Metadata != nil {
-		if msg, ok := req.Metadata["error"].(string); ok {
-			errorMessage = msg
-		}
+	if req.Metadata != nil && req.Metadata.Error != "" {
+		errorMessage = req.Metadata.Error
 	}
 
-	// Update job status
-	if err := m.store.UpdateJobStatus(req.JobID, store.StatusFailed, &errorMessage); err != nil {
-		return fmt.Errorf("failed to update job status: %w", err)
+	failed, err := m.store.FailJob(req.JobID, &errorMessage)
+	if err != nil {
+		return err
+	}
+	if !failed {
+		return ErrJobNotPending
 	}
 
 	logger.Error("Job failed", "error", errorMessage)
 	return nil
 }
+
+// HandleUpload stores audio uploaded by a worker and completes its job. The
+// ringtone is recorded and the job marked completed in one transaction.
+func (m *Manager) HandleUpload(req *UploadRequest, content io.Reader) (*store.Ringtone, error) {
+	logger := m.logger.WithJobID(req.JobID)
+
+	if m.files == nil {
+		return nil, fmt.Errorf("uploads require a file store")
+	}
+
+	job, err := m.store.GetJob(req.JobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	if job.Status != store.StatusQueued && job.Status != store.StatusProcessing {
+		return nil, ErrJobNotPending
+	}
+
+	blob, err := m.files.StoreBlob(content, files.SaveOptions{ExpectedSHA256: req.SHA256})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store uploaded file: %w", err)
+	}
+
+	displayName := files.DisplayName(req.FileName)
+	ringtone := &store.Ringtone{
+		JobID:           req.JobID,
+		FileName:        files.StorageName(req.JobID, path.Ext(displayName)),
+		FilePath:        blob.Key,
+		Format:          outputFormat(displayName),
+		DurationSeconds: req.DurationSeconds,
+		BlobHash:        &blob.Hash,
+		SizeBytes:       &blob.Size,
+		DisplayName:     &displayName,
+		CreatedAt:       time.Now(),
+	}
+	m.measureLoudness(ringtone, logger)
+
+	// If the job finished concurrently, the blob is left without a reference
+	// and storage reconciliation removes it
+	completed, err := m.store.CompleteJob(ringtone)
+	if err != nil {
+		return nil, err
+	}
+	if !completed {
+		return nil, ErrJobNotPending
+	}
+
+	logger.Info("Job completed by upload", "hash", blob.Hash, "size", blob.Size, "deduplicated", blob.Deduplicated)
+	return ringtone, nil
+}
+
+// HandlePreviewUpload stores a preview rendition uploaded by a worker for a
+// completed job. The final file must be delivered first.
+func (m *Manager) HandlePreviewUpload(req *UploadRequest, content io.Reader) (*store.RingtoneAsset, error) {
+	logger := m.logger.WithJobID(req.JobID)
+
+	if m.files == nil {
+		return nil, fmt.Errorf("uploads require a file store")
+	}
+
+	ringtone, err := m.uploadableRingtone(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := m.files.StoreBlob(content, files.SaveOptions{ExpectedSHA256: req.SHA256})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store uploaded preview: %w", err)
+	}
+
+	asset, err := m.putPreview(ringtone, blob, req.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Preview stored by upload", "hash", blob.Hash, "size", blob.Size)
+	return asset, nil
+}
+
+// HandleFormatUpload stores a rendition of a completed job in an additional
+// output format. The final file must be delivered first, in the job's
+// primary format.
+func (m *Manager) HandleFormatUpload(req *UploadRequest, format string, content io.Reader) (*store.RingtoneAsset, error) {
+	logger := m.logger.WithJobID(req.JobID)
+
+	if m.files == nil {
+		return nil, fmt.Errorf("uploads require a file store")
+	}
+	if !slices.Contains(SupportedFormats, format) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+
+	ringtone, err := m.uploadableRingtone(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+	// The primary format was delivered with the ringtone and is final
+	if format == ringtone.Format {
+		return nil, ErrJobNotPending
+	}
+
+	blob, err := m.files.StoreBlob(content, files.SaveOptions{ExpectedSHA256: req.SHA256})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store uploaded format: %w", err)
+	}
+
+	asset, err := m.putFormat(ringtone, blob, format)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Output format stored by upload", "format", format, "hash", blob.Hash, "size", blob.Size)
+	return asset, nil
+}
+
+// uploadableRingtone returns the ringtone of a completed job that still
+// accepts renditions
+func (m *Manager) uploadableRingtone(jobID string) (*store.Ringtone, error) {
+	job, err := m.store.GetJob(jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	ringtone, err := m.store.GetRingtoneByJobID(jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ringtone: %w", err)
+	}
+	if ringtone == nil || job.Status != store.StatusCompleted {
+		return nil, ErrJobNotCompleted
+	}
+	if m.assetUploadWindow > 0 && time.Since(ringtone.CreatedAt) > m.assetUploadWindow {
+		return nil, ErrUploadWindowClosed
+	}
+	return ringtone, nil
+}
+
+// putPreview records a stored blob as a ringtone's preview, replacing any
+// earlier one. The worker's file name only supplies the extension.
+func (m *Manager) putPreview(ringtone *store.Ringtone, blob *files.Blob, fileName string) (*store.RingtoneAsset, error) {
+	name := files.StorageName(ringtone.JobID+"-preview", path.Ext(files.DisplayName(fileName)))
+	return m.putAsset(ringtone, blob, store.AssetPreview, name, m.previewRetention)
+}
+
+// putFormat records a stored blob as a ringtone's rendition in format,
+// replacing any earlier one. Renditions are kept as long as the ringtone.
+func (m *Manager) putFormat(ringtone *store.Ringtone, blob *files.Blob, format string) (*store.RingtoneAsset, error) {
+	name := files.StorageName(ringtone.JobID, "."+format)
+	return m.putAsset(ringtone, blob, store.FormatAsset(format), name, 0)
+}
+
+// putAsset records a stored blob as a ringtone asset of the given kind,
+// expiring after retention unless it is zero
+func (m *Manager) putAsset(ringtone *store.Ringtone, blob *files.Blob, kind, fileName string, retention time.Duration) (*store.RingtoneAsset, error) {
+	asset := &store.RingtoneAsset{
+		RingtoneID: ringtone.ID,
+		Kind:       kind,
+		FileName:   fileName,
+		FilePath:   blob.Key,
+		BlobHash:   blob.Hash,
+		SizeBytes:  blob.Size,
+		CreatedAt:  time.Now(),
+	}
+	if retention > 0 {
+		expiresAt := asset.CreatedAt.Add(retention)
+		asset.ExpiresAt = &expiresAt
+	}
+
+	if err := m.store.PutRingtoneAsset(asset); err != nil {
+		return nil, fmt.Errorf("failed to record %s: %w", kind, err)
+	}
+	return asset, nil
+}
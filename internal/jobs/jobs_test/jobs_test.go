@@ -1,6 +1,11 @@
 package jobs_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -8,7 +13,9 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"ringtonic-backend/internal/contract"
 	"ringtonic-backend/internal/jobs"
+	"ringtonic-backend/internal/links"
 	"ringtonic-backend/internal/log"
 	"ringtonic-backend/internal/store"
 )
@@ -41,6 +48,29 @@ func (m *MockStore) IncrementJobAttempts(id string) error {
 	return args.Error(0)
 }
 
+func (m *MockStore) StartJob(id string) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStore) SetJobEndpoint(id, endpoint string) error {
+	args := m.Called(id, endpoint)
+	return args.Error(0)
+}
+
+func (m *MockStore) ListQueuedJobs() ([]*store.Job, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*store.Job), args.Error(1)
+}
+
+func (m *MockStore) ResetCallbackToken(id, tokenHash string) (bool, error) {
+	args := m.Called(id, tokenHash)
+	return args.Bool(0), args.Error(1)
+}
+
 func (m *MockStore) CreateRingtone(ringtone *store.Ringtone) error {
 	args := m.Called(ringtone)
 	return args.Error(0)
@@ -62,6 +92,37 @@ func (m *MockStore) GetRingtoneByFileName(fileName string) (*store.Ringtone, err
 	return args.Get(0).(*store.Ringtone), args.Error(1)
 }
 
+func (m *MockStore) CompleteJob(ringtone *store.Ringtone) (bool, error) {
+	args := m.Called(ringtone)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStore) FailJob(id string, errorMessage *string) (bool, error) {
+	args := m.Called(id, errorMessage)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStore) PutRingtoneAsset(asset *store.RingtoneAsset) error {
+	args := m.Called(asset)
+	return args.Error(0)
+}
+
+func (m *MockStore) GetRingtoneAsset(ringtoneID int, kind string) (*store.RingtoneAsset, error) {
+	args := m.Called(ringtoneID, kind)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*store.RingtoneAsset), args.Error(1)
+}
+
+func (m *MockStore) GetRingtoneAssets(ringtoneID int) ([]*store.RingtoneAsset, error) {
+	args := m.Called(ringtoneID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*store.RingtoneAsset), args.Error(1)
+}
+
 func (m *MockStore) GetJobStats() (map[string]int, error) {
 	args := m.Called()
 	return args.Get(0).(map[string]int), args.Error(1)
@@ -77,13 +138,13 @@ func (m *MockStore) Migrate() error {
 	return args.Error(0)
 }
 
-// MockN8NClient implements jobs.N8NClientInterface for testing
+// MockN8NClient implements jobs.ProcessorInterface for testing
 type MockN8NClient struct {
 	mock.Mock
 }
 
-func (m *MockN8NClient) TriggerWebhook(payload map[string]interface{}) error {
-	args := m.Called(payload)
+func (m *MockN8NClient) Process(request *contract.JobRequest) error {
+	args := m.Called(request)
 	return args.Error(0)
 }
 
@@ -103,8 +164,8 @@ func TestCreateJob(t *testing.T) {
 	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Return(nil)
 	// Expectations for the async goroutine
 	mockStore.On("IncrementJobAttempts", mock.AnythingOfType("string")).Return(nil)
-	mockN8N.On("TriggerWebhook", mock.AnythingOfType("map[string]interface {}")).Return(nil)
-	mockStore.On("UpdateJobStatus", mock.AnythingOfType("string"), "processing", (*string)(nil)).Return(nil)
+	mockN8N.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(nil)
+	mockStore.On("StartJob", mock.AnythingOfType("string")).Return(true, nil)
 
 	req := &jobs.CreateJobRequest{
 		SourceURL: "https://www.youtube.com/watch?v=test",
@@ -178,6 +239,11 @@ func TestGetJobStatusCompleted(t *testing.T) {
 
 	mockStore.On("GetJob", "test-job").Return(job, nil)
 	mockStore.On("GetRingtoneByJobID", "test-job").Return(ringtone, nil)
+	mockStore.On("GetRingtoneAsset", 0, store.AssetPreview).Return(nil, nil)
+	mockStore.On("GetRingtoneAssets", 0).Return([]*store.RingtoneAsset{
+		{Kind: store.AssetPreview, FileName: "test-job-preview.mp3"},
+		{Kind: store.FormatAsset("m4r"), FileName: "test-job.m4r"},
+	}, nil)
 
 	response, err := manager.GetJobStatus("test-job")
 
@@ -185,6 +251,10 @@ func TestGetJobStatusCompleted(t *testing.T) {
 	assert.Equal(t, "completed", response.Status)
 	require.NotNil(t, response.DownloadURL)
 	assert.Equal(t, "/download/test-job.mp3", *response.DownloadURL)
+	assert.Equal(t, map[string]string{
+		"mp3": "/download/test-job.mp3",
+		"m4r": "/download/test-job.mp3?format=m4r",
+	}, response.Downloads)
 
 	mockStore.AssertExpectations(t)
 }
@@ -202,16 +272,16 @@ func TestHandleCallbackCompleted(t *testing.T) {
 		Status: store.StatusProcessing,
 	}
 	mockStore.On("GetJob", "test-job").Return(job, nil)
-	mockStore.On("CreateRingtone", mock.AnythingOfType("*store.Ringtone")).Return(nil)
-	mockStore.On("UpdateJobStatus", "test-job", store.StatusCompleted, (*string)(nil)).Return(nil)
+	mockStore.On("CompleteJob", mock.AnythingOfType("*store.Ringtone")).Return(true, nil)
 
 	filePath := "test-job.mp3"
+	duration := 30.0
 	req := &jobs.CallbackRequest{
 		JobID:    "test-job",
 		Status:   store.StatusCompleted,
 		FilePath: &filePath,
-		Metadata: map[string]interface{}{
-			"duration": 30.0,
+		Metadata: &contract.CallbackMetadata{
+			Duration: &duration,
 		},
 	}
 
@@ -235,13 +305,13 @@ func TestHandleCallbackFailed(t *testing.T) {
 	}
 	errorMsg := "Processing failed"
 	mockStore.On("GetJob", "test-job").Return(job, nil)
-	mockStore.On("UpdateJobStatus", "test-job", store.StatusFailed, &errorMsg).Return(nil)
+	mockStore.On("FailJob", "test-job", &errorMsg).Return(true, nil)
 
 	req := &jobs.CallbackRequest{
 		JobID:  "test-job",
 		Status: store.StatusFailed,
-		Metadata: map[string]interface{}{
-			"error": errorMsg,
+		Metadata: &contract.CallbackMetadata{
+			Error: errorMsg,
 		},
 	}
 
@@ -250,3 +320,419 @@ func TestHandleCallbackFailed(t *testing.T) {
 	require.NoError(t, err)
 	mockStore.AssertExpectations(t)
 }
+
+func TestHandleCallbackFinishedJob(t *testing.T) {
+	mockStore := &MockStore{}
+	manager := jobs.New(mockStore, &MockN8NClient{}, log.New("error"))
+
+	filePath := "test-job.mp3"
+	completed := &jobs.CallbackRequest{JobID: "test-job", Status: store.StatusCompleted, FilePath: &filePath}
+	failed := &jobs.CallbackRequest{JobID: "test-job", Status: store.StatusFailed}
+
+	// A job that has already finished takes no further callbacks
+	mockStore.On("GetJob", "test-job").Return(&store.Job{ID: "test-job", Status: store.StatusCompleted}, nil).Once()
+	assert.ErrorIs(t, manager.HandleCallback(completed), jobs.ErrJobNotPending)
+	mockStore.AssertNotCalled(t, "CompleteJob", mock.Anything)
+
+	// Nor does one that finishes while the callback is handled
+	mockStore.On("GetJob", "test-job").Return(&store.Job{ID: "test-job", Status: store.StatusProcessing}, nil)
+	mockStore.On("CompleteJob", mock.AnythingOfType("*store.Ringtone")).Return(false, nil)
+	mockStore.On("FailJob", "test-job", mock.Anything).Return(false, nil)
+	assert.ErrorIs(t, manager.HandleCallback(completed), jobs.ErrJobNotPending)
+	assert.ErrorIs(t, manager.HandleCallback(failed), jobs.ErrJobNotPending)
+}
+
+func TestCreateJobFormats(t *testing.T) {
+	mockStore := &MockStore{}
+	mockN8N := &MockN8NClient{}
+	manager := jobs.New(mockStore, mockN8N, log.New("error"))
+
+	var created *store.Job
+	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Run(func(args mock.Arguments) {
+		created = args.Get(0).(*store.Job)
+	}).Return(nil)
+	mockStore.On("IncrementJobAttempts", mock.AnythingOfType("string")).Return(nil)
+	mockN8N.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(nil)
+	mockStore.On("StartJob", mock.AnythingOfType("string")).Return(true, nil)
+
+	// The primary format comes first, duplicates are dropped
+	_, err := manager.CreateJob(&jobs.CreateJobRequest{
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{Format: "M4R", Formats: []string{"ogg", "m4r", "mp3"}},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, created.N8NPayload)
+	assert.Contains(t, *created.N8NPayload, `"format":"m4r","formats":["m4r","ogg","mp3"]`)
+
+	_, err = manager.CreateJob(&jobs.CreateJobRequest{
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{Formats: []string{"mp3", "exe"}},
+	})
+	assert.ErrorIs(t, err, jobs.ErrUnsupportedFormat)
+
+	// Wait for the dispatch goroutine
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestCreateJobNormalize(t *testing.T) {
+	mockStore := &MockStore{}
+	mockN8N := &MockN8NClient{}
+	manager := jobs.New(mockStore, mockN8N, log.New("error"))
+
+	var created *store.Job
+	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Run(func(args mock.Arguments) {
+		created = args.Get(0).(*store.Job)
+	}).Return(nil)
+	mockStore.On("IncrementJobAttempts", mock.AnythingOfType("string")).Return(nil)
+	mockN8N.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(nil)
+	mockStore.On("StartJob", mock.AnythingOfType("string")).Return(true, nil)
+
+	// Normalizing without a target uses the default
+	_, err := manager.CreateJob(&jobs.CreateJobRequest{
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{Normalize: true},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, *created.N8NPayload, `"normalize":true,"target_lufs":-16`)
+
+	target := -14.0
+	_, err = manager.CreateJob(&jobs.CreateJobRequest{
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{Normalize: true, TargetLUFS: &target},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, *created.N8NPayload, `"normalize":true,"target_lufs":-14`)
+
+	// Targets out of range or without normalize are rejected
+	tooLoud, tooQuiet := -5.0, -40.0
+	for _, options := range []*store.JobOptions{
+		{Normalize: true, TargetLUFS: &tooLoud},
+		{Normalize: true, TargetLUFS: &tooQuiet},
+		{TargetLUFS: &target},
+	} {
+		_, err = manager.CreateJob(&jobs.CreateJobRequest{
+			SourceURL: "https://www.youtube.com/watch?v=test",
+			Options:   options,
+		})
+		assert.ErrorIs(t, err, jobs.ErrInvalidTargetLoudness)
+	}
+
+	// Wait for the dispatch goroutine
+	time.Sleep(100 * time.Millisecond)
+}
+
+// unavailableProcessor is a processor that is down until ready is closed
+type unavailableProcessor struct {
+	MockN8NClient
+	ready chan struct{}
+}
+
+func (p *unavailableProcessor) Available() <-chan struct{} {
+	return p.ready
+}
+
+func TestCreateJobWaitsForProcessor(t *testing.T) {
+	mockStore := &MockStore{}
+	processor := &unavailableProcessor{ready: make(chan struct{})}
+	manager := jobs.New(mockStore, processor, log.New("error"))
+
+	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Return(nil)
+
+	response, err := manager.CreateJob(&jobs.CreateJobRequest{SourceURL: "https://www.youtube.com/watch?v=test"})
+	require.NoError(t, err)
+	assert.Equal(t, "queued", response.Status)
+
+	// While the processor is down, the job is neither dispatched nor
+	// charged an attempt
+	time.Sleep(50 * time.Millisecond)
+	mockStore.AssertNotCalled(t, "IncrementJobAttempts", response.JobID)
+	processor.AssertNotCalled(t, "Process", mock.Anything)
+
+	mockStore.On("IncrementJobAttempts", response.JobID).Return(nil)
+	processor.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(nil)
+	started := make(chan struct{})
+	mockStore.On("StartJob", response.JobID).Return(true, nil).Run(func(mock.Arguments) { close(started) })
+	close(processor.ready)
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("job was not dispatched once the processor was back")
+	}
+	mockStore.AssertExpectations(t)
+	processor.AssertExpectations(t)
+}
+
+// breakerProcessor is a processor whose circuit can open and close
+type breakerProcessor struct {
+	MockN8NClient
+	mu    sync.Mutex
+	ready chan struct{}
+}
+
+func newBreakerProcessor() *breakerProcessor {
+	ready := make(chan struct{})
+	close(ready)
+	return &breakerProcessor{ready: ready}
+}
+
+func (p *breakerProcessor) Available() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ready
+}
+
+func (p *breakerProcessor) openCircuit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ready = make(chan struct{})
+}
+
+func (p *breakerProcessor) closeCircuit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	close(p.ready)
+}
+
+func TestDispatchDoesNotCountAttemptsWhileCircuitIsOpen(t *testing.T) {
+	mockStore := &MockStore{}
+	processor := newBreakerProcessor()
+	manager := jobs.New(mockStore, processor, log.New("error"))
+
+	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Return(nil)
+	// The webhook fails and opens the circuit
+	processor.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(errors.New("n8n circuit is open")).
+		Once().Run(func(mock.Arguments) { processor.openCircuit() })
+
+	response, err := manager.CreateJob(&jobs.CreateJobRequest{SourceURL: "https://www.youtube.com/watch?v=test"})
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	mockStore.AssertNotCalled(t, "IncrementJobAttempts", response.JobID)
+
+	mockStore.On("IncrementJobAttempts", response.JobID).Return(nil)
+	processor.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(nil)
+	started := make(chan struct{})
+	mockStore.On("StartJob", response.JobID).Return(true, nil).Run(func(mock.Arguments) { close(started) })
+	processor.closeCircuit()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("job was not dispatched once the circuit closed")
+	}
+	// Only the attempt that reached the processor counts
+	mockStore.AssertNumberOfCalls(t, "IncrementJobAttempts", 1)
+	processor.AssertNumberOfCalls(t, "Process", 2)
+}
+
+// prefixTokens issues a job's token as a fixed prefix and its ID
+type prefixTokens string
+
+func (p prefixTokens) Token(jobID string) string {
+	return string(p) + jobID
+}
+
+func TestResumeDispatchesQueuedJobs(t *testing.T) {
+	mockStore := &MockStore{}
+	mockN8N := &MockN8NClient{}
+	manager := jobs.New(mockStore, mockN8N, log.New("error"))
+	manager.SetUploadTokens(prefixTokens("upload-"))
+	manager.SetSourceUploads(prefixTokens("source-"), jobs.SourceLimits{})
+
+	start := 12
+	payload, err := json.Marshal(contract.NewJobRequest("job-upload", "http://backend:8080/api/v1/sources/job-upload",
+		&store.JobOptions{StartSeconds: &start, Format: "mp3", Formats: []string{"mp3"}},
+		"http://backend:8080/api/v1/n8n-callback/job-upload"))
+	require.NoError(t, err)
+	payloadStr := string(payload)
+	hash := "abc123"
+
+	mockStore.On("ListQueuedJobs").Return([]*store.Job{
+		{ID: "job-upload", SourceURL: "http://backend:8080/api/v1/sources/job-upload", Status: store.StatusQueued,
+			N8NPayload: &payloadStr, SourceBlobHash: &hash},
+		{ID: "job-started", Status: store.StatusQueued, N8NPayload: &payloadStr},
+		{ID: "job-broken", Status: store.StatusQueued},
+	}, nil)
+
+	var tokenHash string
+	mockStore.On("ResetCallbackToken", "job-upload", mock.AnythingOfType("string")).Return(true, nil).Run(func(args mock.Arguments) {
+		tokenHash = args.String(1)
+	})
+	// A job that moved on meanwhile is left alone
+	mockStore.On("ResetCallbackToken", "job-started", mock.AnythingOfType("string")).Return(false, nil)
+
+	dispatched := make(chan *contract.JobRequest, 1)
+	mockStore.On("IncrementJobAttempts", "job-upload").Return(nil)
+	mockN8N.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(nil).Run(func(args mock.Arguments) {
+		dispatched <- args.Get(0).(*contract.JobRequest)
+	})
+	mockStore.On("StartJob", "job-upload").Return(true, nil)
+
+	manager.Resume()
+
+	var request *contract.JobRequest
+	select {
+	case request = <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("queued job was not dispatched")
+	}
+	assert.Equal(t, "job-upload", request.JobID)
+	require.NotNil(t, request.Options.StartSeconds)
+	assert.Equal(t, 12, *request.Options.StartSeconds)
+	// Tokens are issued afresh, replacing the stored callback token
+	sum := sha256.Sum256([]byte(request.CallbackToken))
+	assert.Equal(t, hex.EncodeToString(sum[:]), tokenHash)
+	assert.Equal(t, "http://backend:8080/api/v1/sources/job-upload?token=source-job-upload", request.SourceURL)
+	assert.Equal(t, "upload-job-upload", request.UploadToken)
+
+	time.Sleep(50 * time.Millisecond)
+	mockN8N.AssertNumberOfCalls(t, "Process", 1)
+}
+
+func TestStopEndsDispatches(t *testing.T) {
+	mockStore := &MockStore{}
+	mockN8N := &MockN8NClient{}
+	manager := jobs.New(mockStore, mockN8N, log.New("error"))
+
+	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Return(nil)
+	attempted := make(chan struct{})
+	mockN8N.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(errors.New("connection refused")).
+		Once().Run(func(mock.Arguments) { close(attempted) })
+	mockStore.On("IncrementJobAttempts", mock.AnythingOfType("string")).Return(nil)
+
+	_, err := manager.CreateJob(&jobs.CreateJobRequest{SourceURL: "https://www.youtube.com/watch?v=test"})
+	require.NoError(t, err)
+	<-attempted
+
+	// Stopping does not wait out the retry delay, and the job stays queued
+	stopped := make(chan struct{})
+	go func() {
+		manager.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Stop waited for the retry delay")
+	}
+	mockN8N.AssertNumberOfCalls(t, "Process", 1)
+	mockStore.AssertNotCalled(t, "UpdateJobStatus", mock.Anything, mock.Anything, mock.Anything)
+
+	// Jobs created once stopped are left queued for the next process
+	response, err := manager.CreateJob(&jobs.CreateJobRequest{SourceURL: "https://www.youtube.com/watch?v=test"})
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusQueued, response.Status)
+	time.Sleep(50 * time.Millisecond)
+	mockN8N.AssertNumberOfCalls(t, "Process", 1)
+}
+
+// routingProcessor is a processor that reports the endpoint of each job
+type routingProcessor struct {
+	MockN8NClient
+}
+
+func (p *routingProcessor) ProcessRouted(request *contract.JobRequest) (string, error) {
+	args := p.Called(request)
+	return args.String(0), args.Error(1)
+}
+
+func TestCreateJobRecordsEndpoint(t *testing.T) {
+	mockStore := &MockStore{}
+	processor := &routingProcessor{}
+	manager := jobs.New(mockStore, processor, log.New("error"))
+
+	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Return(nil)
+	mockStore.On("IncrementJobAttempts", mock.AnythingOfType("string")).Return(nil)
+	processor.On("ProcessRouted", mock.AnythingOfType("*contract.JobRequest")).Return("http://n8n-b:5678/webhook/ringtonic", nil)
+	mockStore.On("SetJobEndpoint", mock.AnythingOfType("string"), "http://n8n-b:5678/webhook/ringtonic").Return(nil)
+	started := make(chan struct{})
+	mockStore.On("StartJob", mock.AnythingOfType("string")).Return(true, nil).Run(func(mock.Arguments) { close(started) })
+
+	_, err := manager.CreateJob(&jobs.CreateJobRequest{SourceURL: "https://www.youtube.com/watch?v=test"})
+	require.NoError(t, err)
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("job was not dispatched")
+	}
+	mockStore.AssertExpectations(t)
+	processor.AssertExpectations(t)
+	processor.AssertNotCalled(t, "Process", mock.Anything)
+}
+
+func TestCreateJobLinks(t *testing.T) {
+	mockStore := &MockStore{}
+	mockN8N := &MockN8NClient{}
+	manager := jobs.New(mockStore, mockN8N, log.New("error"))
+	jobLinks, err := links.New("https://ringtonic.example/app/", "http://backend:8081")
+	require.NoError(t, err)
+	manager.SetLinks(jobLinks)
+
+	dispatched := make(chan *contract.JobRequest, 1)
+	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Return(nil)
+	mockStore.On("IncrementJobAttempts", mock.AnythingOfType("string")).Return(nil)
+	mockN8N.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(nil).Run(func(args mock.Arguments) {
+		dispatched <- args.Get(0).(*contract.JobRequest)
+	})
+	mockStore.On("StartJob", mock.AnythingOfType("string")).Return(true, nil)
+
+	response, err := manager.CreateJob(&jobs.CreateJobRequest{SourceURL: "https://www.youtube.com/watch?v=test"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://ringtonic.example/app/api/v1/job-status/"+response.JobID, response.PollURL)
+
+	select {
+	case request := <-dispatched:
+		// Each job gets its own callback URL on the internal base URL
+		assert.Equal(t, "http://backend:8081/api/v1/n8n-callback/"+response.JobID, request.CallbackURL)
+	case <-time.After(time.Second):
+		t.Fatal("job was not dispatched")
+	}
+}
+
+func TestCallbackTokens(t *testing.T) {
+	mockStore := &MockStore{}
+	mockN8N := &MockN8NClient{}
+	manager := jobs.New(mockStore, mockN8N, log.New("error"))
+
+	var job *store.Job
+	dispatched := make(chan *contract.JobRequest, 1)
+	mockStore.On("CreateJob", mock.AnythingOfType("*store.Job")).Return(nil).Run(func(args mock.Arguments) {
+		job = args.Get(0).(*store.Job)
+	})
+	mockStore.On("IncrementJobAttempts", mock.AnythingOfType("string")).Return(nil)
+	mockN8N.On("Process", mock.AnythingOfType("*contract.JobRequest")).Return(nil).Run(func(args mock.Arguments) {
+		dispatched <- args.Get(0).(*contract.JobRequest)
+	})
+	mockStore.On("StartJob", mock.AnythingOfType("string")).Return(true, nil)
+
+	_, err := manager.CreateJob(&jobs.CreateJobRequest{SourceURL: "https://www.youtube.com/watch?v=test"})
+	require.NoError(t, err)
+	var request *contract.JobRequest
+	select {
+	case request = <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("job was not dispatched")
+	}
+
+	// The token is sent to the workflow, but only its hash is stored
+	require.NotEmpty(t, request.CallbackToken)
+	require.NotNil(t, job.CallbackTokenHash)
+	assert.NotContains(t, *job.CallbackTokenHash, request.CallbackToken)
+	assert.NotContains(t, *job.N8NPayload, request.CallbackToken)
+
+	mockStore.On("GetJob", job.ID).Return(job, nil)
+	assert.NoError(t, manager.VerifyCallbackToken(job.ID, request.CallbackToken))
+	assert.ErrorIs(t, manager.VerifyCallbackToken(job.ID, ""), jobs.ErrMissingCallbackToken)
+	assert.ErrorIs(t, manager.VerifyCallbackToken(job.ID, "not-the-token"), jobs.ErrInvalidCallbackToken)
+
+	// Finishing the job revokes the token
+	revoked := ""
+	job.CallbackTokenHash = &revoked
+	assert.ErrorIs(t, manager.VerifyCallbackToken(job.ID, request.CallbackToken), jobs.ErrInvalidCallbackToken)
+
+	// Jobs from before callback tokens are left to the webhook signature
+	mockStore.On("GetJob", "job-legacy").Return(&store.Job{ID: "job-legacy"}, nil)
+	assert.NoError(t, manager.VerifyCallbackToken("job-legacy", ""))
+}
@@ -0,0 +1,80 @@
+// Package links builds the absolute URLs the backend hands out: public URLs
+// for clients, such as poll and download URLs, and internal URLs for
+// workers, such as each job's callback URL.
+package links
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Builder resolves paths against the public and internal base URLs
+type Builder struct {
+	public   string
+	internal string
+}
+
+// New creates a Builder. Both base URLs must be absolute http or https URLs
+// and may end in a path prefix, e.g. when the backend sits behind a reverse
+// proxy that serves it under one.
+func New(publicBaseURL, internalBaseURL string) (*Builder, error) {
+	public, err := parseBase(publicBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public base URL: %w", err)
+	}
+	internal, err := parseBase(internalBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid callback base URL: %w", err)
+	}
+	return &Builder{public: public, internal: internal}, nil
+}
+
+// parseBase validates a base URL and strips its trailing slash
+func parseBase(raw string) (string, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("%q must be an http or https URL", raw)
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("%q has no host", raw)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("%q must not have a query or fragment", raw)
+	}
+	return strings.TrimSuffix(raw, "/"), nil
+}
+
+// Public resolves an absolute path, which may carry a query, against the
+// public base URL
+func (b *Builder) Public(path string) string {
+	return b.public + path
+}
+
+// Internal resolves an absolute path against the internal base URL
+func (b *Builder) Internal(path string) string {
+	return b.internal + path
+}
+
+// PollPath is the path of a job's status
+func PollPath(jobID string) string {
+	return "/api/v1/job-status/" + url.PathEscape(jobID)
+}
+
+// CallbackPath is the path a job's outcome is reported to
+func CallbackPath(jobID string) string {
+	return "/api/v1/n8n-callback/" + url.PathEscape(jobID)
+}
+
+// UploadPath is the path a job's produced audio is uploaded to
+func UploadPath(jobID string) string {
+	return "/api/v1/n8n-upload/" + url.PathEscape(jobID)
+}
+
+// SourcePath is the path an uploaded source is served from
+func SourcePath(jobID string) string {
+	return "/api/v1/sources/" + url.PathEscape(jobID)
+}
@@ -0,0 +1,35 @@
+package links_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ringtonic-backend/internal/links"
+)
+
+func TestBuilder(t *testing.T) {
+	builder, err := links.New("https://ringtonic.example/", "http://backend:8081")
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://ringtonic.example/api/v1/job-status/job-1", builder.Public(links.PollPath("job-1")))
+	assert.Equal(t, "https://ringtonic.example/download/a.mp3?sig=x", builder.Public("/download/a.mp3?sig=x"))
+	assert.Equal(t, "http://backend:8081/api/v1/n8n-callback/job-1", builder.Internal(links.CallbackPath("job-1")))
+	assert.Equal(t, "http://backend:8081/api/v1/n8n-upload/job-1", builder.Internal(links.UploadPath("job-1")))
+	assert.Equal(t, "http://backend:8081/api/v1/sources/job-1", builder.Internal(links.SourcePath("job-1")))
+
+	// Path prefixes are kept
+	builder, err = links.New("https://example.com/ringtonic", "http://backend:8081/")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/ringtonic/api/v1/job-status/job-1", builder.Public(links.PollPath("job-1")))
+}
+
+func TestBuilderRejectsInvalidBaseURLs(t *testing.T) {
+	for _, base := range []string{"", "/relative", "ftp://example.com", "https://", "https://example.com/?a=b"} {
+		_, err := links.New(base, "http://backend:8081")
+		assert.Error(t, err, base)
+		_, err = links.New("https://example.com", base)
+		assert.Error(t, err, base)
+	}
+}
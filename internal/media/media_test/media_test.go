@@ -0,0 +1,354 @@
+package media_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ringtonic-backend/internal/media"
+)
+
+// box builds an ISO base media box
+func box(boxType string, body []byte) []byte {
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], boxType)
+	return append(b, body...)
+}
+
+func TestProbe(t *testing.T) {
+	// 16-bit stereo 44.1 kHz WAV with two seconds of data
+	var wav bytes.Buffer
+	wav.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+	binary.Write(&wav, binary.LittleEndian, []uint32{16})
+	binary.Write(&wav, binary.LittleEndian, []uint16{1, 2})
+	binary.Write(&wav, binary.LittleEndian, []uint32{44100, 44100 * 4})
+	binary.Write(&wav, binary.LittleEndian, []uint16{4, 16})
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, uint32(44100*4*2))
+	wav.Write(make([]byte, 44100*4*2))
+
+	// 100 MPEG-1 Layer III frames at 128 kbit/s and 44.1 kHz behind an ID3 tag
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	mp3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x0A"), make([]byte, 10)...)
+	mp3 = append(mp3, bytes.Repeat(frame, 100)...)
+
+	// FLAC STREAMINFO declaring 3 seconds at 48 kHz
+	flac := []byte("fLaC\x80\x00\x00\x22")
+	info := make([]byte, 34)
+	info[10], info[11], info[12] = 48000>>12, (48000>>4)&0xFF, (48000&0x0F)<<4
+	binary.BigEndian.PutUint32(info[14:18], 3*48000)
+	flac = append(flac, info...)
+
+	// MP4 with a version 0 movie header declaring 30 seconds at 1000 Hz
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:20], 30000)
+	mp4 := append(box("ftyp", []byte("M4A \x00\x00\x00\x00")), box("free", make([]byte, 16))...)
+	mp4 = append(mp4, box("moov", box("mvhd", mvhd))...)
+
+	tests := []struct {
+		name     string
+		content  []byte
+		mimeType string
+		duration time.Duration
+	}{
+		{"wav", wav.Bytes(), "audio/wav", 2 * time.Second},
+		{"mp3", mp3, "audio/mpeg", 2612 * time.Millisecond},
+		{"flac", flac, "audio/flac", 3 * time.Second},
+		{"mp4", mp4, "audio/mp4", 30 * time.Second},
+		{"webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x01, 0x00}, "video/webm", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probed, err := media.Probe(bytes.NewReader(tt.content))
+			require.NoError(t, err)
+			assert.Equal(t, tt.mimeType, probed.MIMEType)
+			assert.InDelta(t, tt.duration.Seconds(), probed.Duration.Seconds(), 0.01)
+			assert.NotEmpty(t, media.Extension(probed.MIMEType))
+		})
+	}
+}
+
+func TestProbeRejectsOtherContent(t *testing.T) {
+	for _, content := range [][]byte{
+		nil,
+		[]byte("<!DOCTYPE html><html></html>"),
+		[]byte("%PDF-1.7"),
+		{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'},
+	} {
+		_, err := media.Probe(bytes.NewReader(content))
+		assert.ErrorIs(t, err, media.ErrUnsupported)
+	}
+}
+
+// testWAV16 returns a 16-bit mono WAV file holding the given samples
+func testWAV16(sampleRate int, samples []int16) []byte {
+	var wav bytes.Buffer
+	wav.WriteString("RIFF")
+	binary.Write(&wav, binary.LittleEndian, uint32(36+2*len(samples)))
+	wav.WriteString("WAVEfmt ")
+	binary.Write(&wav, binary.LittleEndian, []uint32{16})
+	binary.Write(&wav, binary.LittleEndian, []uint16{1, 1})
+	binary.Write(&wav, binary.LittleEndian, []uint32{uint32(sampleRate), uint32(sampleRate * 2)})
+	binary.Write(&wav, binary.LittleEndian, []uint16{2, 16})
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, uint32(2*len(samples)))
+	binary.Write(&wav, binary.LittleEndian, samples)
+	return wav.Bytes()
+}
+
+func TestComputeWaveform(t *testing.T) {
+	// One second of silence followed by one second of a full-scale square
+	// wave and one second at half scale
+	samples := make([]int16, 3*8000)
+	for i := 8000; i < len(samples); i++ {
+		level := int16(32767)
+		if i >= 16000 {
+			level = 16384
+		}
+		if i%2 == 1 {
+			level = -level
+		}
+		samples[i] = level
+	}
+
+	waveform, err := media.ComputeWaveform(bytes.NewReader(testWAV16(8000, samples)), 3)
+	require.NoError(t, err)
+	assert.Equal(t, 8000, waveform.SampleRate)
+	assert.InDelta(t, 3.0, waveform.DurationSeconds, 0.001)
+	assert.Equal(t, media.Levels{0, 255, 128}, waveform.Peaks)
+	assert.Equal(t, media.Levels{0, 255, 128}, waveform.RMS)
+
+	// The binary encoding round-trips
+	encoded, err := waveform.MarshalBinary()
+	require.NoError(t, err)
+	decoded := &media.Waveform{}
+	require.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, waveform, decoded)
+
+	// JSON levels are numbers, not base64
+	encodedJSON, err := json.Marshal(waveform)
+	require.NoError(t, err)
+	assert.Contains(t, string(encodedJSON), `"peaks":[0,255,128]`)
+
+	// Silent MPEG-1 Layer III frames decode to a flat waveform
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	waveform, err = media.ComputeWaveform(bytes.NewReader(bytes.Repeat(frame, 100)), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 44100, waveform.SampleRate)
+	assert.InDelta(t, 2.6, waveform.DurationSeconds, 0.1)
+	assert.Equal(t, make(media.Levels, 10), waveform.Peaks)
+
+	// Formats that cannot be decoded in Go are reported as unsupported
+	flac := append([]byte("fLaC\x80\x00\x00\x22"), make([]byte, 34)...)
+	_, err = media.ComputeWaveform(bytes.NewReader(flac), 10)
+	assert.ErrorIs(t, err, media.ErrUnsupported)
+}
+
+func TestWriteID3Tags(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 8)
+	cover := []byte("\xFF\xD8\xFFjpeg data")
+	tags := &media.Tags{Title: "Café", Artist: "Band", Comment: "https://example.com/v", Cover: cover}
+
+	// An existing tag is replaced rather than kept after the new one
+	old := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x05TIT2x"), audio...)
+	for _, src := range [][]byte{audio, old} {
+		var out bytes.Buffer
+		require.NoError(t, media.WriteTags(&out, bytes.NewReader(src), "mp3", tags))
+
+		tagged := out.Bytes()
+		require.True(t, bytes.HasPrefix(tagged, []byte("ID3\x04\x00\x00")))
+		size := int(tagged[6])<<21 | int(tagged[7])<<14 | int(tagged[8])<<7 | int(tagged[9])
+		frames := tagged[10 : 10+size]
+		assert.Equal(t, audio, tagged[10+size:])
+
+		assert.Contains(t, string(frames), "TIT2\x00\x00\x00\x06\x00\x00\x03Café")
+		assert.Contains(t, string(frames), "TPE1\x00\x00\x00\x05\x00\x00\x03Band")
+		assert.Contains(t, string(frames), "COMM\x00\x00\x00\x1A\x00\x00\x03eng\x00https://example.com/v")
+		assert.Contains(t, string(frames), "APIC\x00\x00\x00\x1A\x00\x00\x03image/jpeg\x00\x03\x00"+string(cover))
+		assert.Equal(t, 1, bytes.Count(tagged, []byte("TIT2")))
+	}
+
+	// Cover art must be an image and formats must be known
+	assert.ErrorIs(t, media.WriteTags(&bytes.Buffer{}, bytes.NewReader(audio), "mp3", &media.Tags{Cover: []byte("GIF89a")}), media.ErrInvalidTags)
+	assert.ErrorIs(t, media.WriteTags(&bytes.Buffer{}, bytes.NewReader(audio), "ogg", tags), media.ErrUnsupported)
+}
+
+// testMP4 builds an MP4 file whose single chunk holds payload, with the
+// movie box before or after the media data
+func testMP4(payload []byte, udta []byte, moovFirst bool) []byte {
+	ftyp := box("ftyp", []byte("M4A \x00\x00\x00\x00M4A "))
+	moov := func(offset uint32) []byte {
+		stco := make([]byte, 12)
+		binary.BigEndian.PutUint32(stco[4:8], 1)
+		binary.BigEndian.PutUint32(stco[8:12], offset)
+		stbl := box("stbl", box("stco", stco))
+		trak := box("trak", box("mdia", box("minf", stbl)))
+		body := append(box("mvhd", make([]byte, 100)), trak...)
+		if udta != nil {
+			body = append(body, box("udta", udta)...)
+		}
+		return box("moov", body)
+	}
+	mdat := box("mdat", payload)
+
+	if moovFirst {
+		offset := len(ftyp) + len(moov(0)) + 8
+		return slices.Concat(ftyp, moov(uint32(offset)), mdat)
+	}
+	return slices.Concat(ftyp, mdat, moov(uint32(len(ftyp)+8)))
+}
+
+// chunkPayload follows a tagged MP4 file's chunk offset to its payload
+func chunkPayload(t *testing.T, file []byte, n int) []byte {
+	i := bytes.Index(file, []byte("stco"))
+	require.Positive(t, i)
+	offset := int(binary.BigEndian.Uint32(file[i+12 : i+16]))
+	require.LessOrEqual(t, offset+n, len(file))
+	return file[offset : offset+n]
+}
+
+func TestWriteMP4Tags(t *testing.T) {
+	payload := []byte("AAC AUDIO PAYLOAD")
+	tags := &media.Tags{Title: "Café", Artist: "Band", Comment: "https://example.com/v", Cover: []byte("\x89PNG\r\n\x1a\npng data")}
+
+	for _, moovFirst := range []bool{true, false} {
+		// Existing metadata is replaced and other user data kept
+		src := testMP4(payload, slices.Concat(box("name", []byte("keep")), box("meta", []byte("old metadata"))), moovFirst)
+
+		var out bytes.Buffer
+		require.NoError(t, media.WriteTags(&out, bytes.NewReader(src), "m4r", tags))
+		tagged := out.Bytes()
+
+		// The chunk still points at the audio, wherever it moved
+		assert.Equal(t, payload, chunkPayload(t, tagged, len(payload)))
+		assert.Contains(t, string(tagged), "\xa9nam\x00\x00\x00\x15data\x00\x00\x00\x01\x00\x00\x00\x00Café")
+		assert.Contains(t, string(tagged), "\xa9ART")
+		assert.Contains(t, string(tagged), "\xa9cmt\x00\x00\x00\x25data\x00\x00\x00\x01\x00\x00\x00\x00https://example.com/v")
+		assert.Contains(t, string(tagged), "covr\x00\x00\x00\x20data\x00\x00\x00\x0E")
+		assert.Contains(t, string(tagged), "mdirappl")
+		assert.Contains(t, string(tagged), "keep")
+		assert.NotContains(t, string(tagged), "old metadata")
+
+		// The result is still a valid MP4 file
+		info, err := media.Probe(bytes.NewReader(tagged))
+		require.NoError(t, err)
+		assert.Equal(t, "audio/mp4", info.MIMEType)
+
+		// Tagging again replaces the tags instead of adding to them
+		var again bytes.Buffer
+		require.NoError(t, media.WriteTags(&again, bytes.NewReader(tagged), "m4a", tags))
+		assert.Equal(t, tagged, again.Bytes())
+	}
+
+	// Files without a movie box cannot be tagged
+	assert.ErrorIs(t, media.WriteTags(&bytes.Buffer{}, bytes.NewReader(box("ftyp", []byte("M4A "))), "m4a", tags), media.ErrUnsupported)
+}
+
+// testTone returns a mono sine wave as 16-bit samples
+func testTone(sampleRate int, seconds, frequency, amplitude, phase float64) []int16 {
+	samples := make([]int16, int(seconds*float64(sampleRate)))
+	for i := range samples {
+		samples[i] = int16(math.Round(32767 * amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate)+phase)))
+	}
+	return samples
+}
+
+func TestMeasureLoudness(t *testing.T) {
+	// A 1kHz sine at -20dBFS in a single channel reads -23 LUFS
+	wav := testWAV16(48000, testTone(48000, 5, 1000, 0.1, 0))
+	loudness, err := media.MeasureLoudness(bytes.NewReader(wav))
+	require.NoError(t, err)
+	assert.InDelta(t, -23.0, loudness.IntegratedLUFS, 0.1)
+	assert.InDelta(t, -20.0, loudness.TruePeakDBTP, 0.1)
+	assert.InDelta(t, 0.0, loudness.RangeLU, 0.1)
+
+	// Five seconds 10dB quieter give a range of about 10 LU, and the
+	// relative gate keeps the quiet half in the integrated loudness
+	samples := append(testTone(48000, 5, 1000, 0.1, 0), testTone(48000, 5, 1000, 0.1/math.Sqrt(10), 0)...)
+	loudness, err = media.MeasureLoudness(bytes.NewReader(testWAV16(48000, samples)))
+	require.NoError(t, err)
+	assert.InDelta(t, 10.0, loudness.RangeLU, 0.5)
+	assert.InDelta(t, -25.6, loudness.IntegratedLUFS, 0.2)
+
+	// A sine at a quarter of the sample rate, sampled away from its crests,
+	// peaks 3dB above its samples
+	wav = testWAV16(48000, testTone(48000, 1, 12000, 0.5, math.Pi/4))
+	loudness, err = media.MeasureLoudness(bytes.NewReader(wav))
+	require.NoError(t, err)
+	assert.InDelta(t, -6.0, loudness.TruePeakDBTP, 0.3)
+
+	// Silence and audio shorter than a block cannot be measured
+	_, err = media.MeasureLoudness(bytes.NewReader(testWAV16(48000, make([]int16, 48000))))
+	assert.ErrorIs(t, err, media.ErrTooQuiet)
+	_, err = media.MeasureLoudness(bytes.NewReader(testWAV16(48000, testTone(48000, 0.3, 1000, 0.1, 0))))
+	assert.ErrorIs(t, err, media.ErrTooQuiet)
+
+	flac := append([]byte("fLaC\x80\x00\x00\x22"), make([]byte, 34)...)
+	_, err = media.MeasureLoudness(bytes.NewReader(flac))
+	assert.ErrorIs(t, err, media.ErrUnsupported)
+}
+
+func TestSuggestTrims(t *testing.T) {
+	// A quiet, steady intro and outro around ten seconds of loud beats,
+	// with the beat repeated more quietly near the end
+	const rate = 8000
+	beats := func(seconds int, amplitude float64) []int16 {
+		samples := make([]int16, seconds*rate)
+		for i := range samples {
+			// Four beats a second, each a decaying burst of tone
+			decay := math.Exp(-float64(i%(rate/4)) / 200)
+			samples[i] = int16(32767 * amplitude * decay * math.Sin(2*math.Pi*440*float64(i)/rate))
+		}
+		return samples
+	}
+	var samples []int16
+	samples = append(samples, testTone(rate, 20, 220, 0.05, 0)...)
+	samples = append(samples, beats(10, 0.9)...)
+	samples = append(samples, testTone(rate, 10, 220, 0.05, 0)...)
+	samples = append(samples, beats(10, 0.8)...)
+	samples = append(samples, testTone(rate, 10, 220, 0.05, 0)...)
+	wav := testWAV16(rate, samples)
+
+	analysis, err := media.SuggestTrims(bytes.NewReader(wav), 10*time.Second, 3)
+	require.NoError(t, err)
+	assert.InDelta(t, 60.0, analysis.DurationSeconds, 0.1)
+	require.Len(t, analysis.Candidates, 3)
+
+	best := analysis.Candidates[0]
+	assert.Equal(t, 20, best.StartSeconds)
+	assert.Equal(t, 30, best.EndSeconds)
+	assert.Equal(t, 1.0, best.Energy)
+	// The quieter repeat of the beat comes next
+	assert.Equal(t, 40, analysis.Candidates[1].StartSeconds)
+
+	// Candidates are ranked and overlap by no more than half a window
+	for i, candidate := range analysis.Candidates[1:] {
+		assert.LessOrEqual(t, candidate.Score, analysis.Candidates[i].Score)
+		for _, other := range analysis.Candidates[:i+1] {
+			assert.GreaterOrEqual(t, max(candidate.StartSeconds-other.StartSeconds, other.StartSeconds-candidate.StartSeconds), 5)
+		}
+	}
+
+	// Audio shorter than the window is suggested whole
+	analysis, err = media.SuggestTrims(bytes.NewReader(testWAV16(rate, beats(5, 0.5))), 30*time.Second, 3)
+	require.NoError(t, err)
+	require.Len(t, analysis.Candidates, 1)
+	assert.Equal(t, 0, analysis.Candidates[0].StartSeconds)
+	assert.Equal(t, 5, analysis.Candidates[0].EndSeconds)
+
+	flac := append([]byte("fLaC\x80\x00\x00\x22"), make([]byte, 34)...)
+	_, err = media.SuggestTrims(bytes.NewReader(flac), 30*time.Second, 3)
+	assert.ErrorIs(t, err, media.ErrUnsupported)
+}
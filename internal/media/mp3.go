@@ -0,0 +1,181 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// mp3Bitrates holds bitrates in kbit/s, indexed by [MPEG-1][layer-1][index]
+var mp3Bitrates = [2][3][15]int{
+	// MPEG-2 and MPEG-2.5
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+	// MPEG-1
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+}
+
+// mp3SampleRates holds sample rates in Hz for MPEG-1; MPEG-2 halves and
+// MPEG-2.5 quarters them
+var mp3SampleRates = [3]int{44100, 48000, 32000}
+
+// mp3Frame is a parsed MPEG audio frame header
+type mp3Frame struct {
+	mpeg1      bool
+	layer      int
+	bitrate    int // bit/s
+	sampleRate int
+	mono       bool
+	length     int // bytes, including the header
+	samples    int // per channel
+}
+
+// parseMP3Frame parses the four-byte MPEG audio frame header at the start of b
+func parseMP3Frame(b []byte) (*mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return nil, false
+	}
+
+	version := (b[1] >> 3) & 0x03
+	layerBits := (b[1] >> 1) & 0x03
+	bitrateIndex := b[2] >> 4
+	rateIndex := (b[2] >> 2) & 0x03
+	if version == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return nil, false
+	}
+
+	frame := &mp3Frame{
+		mpeg1: version == 3,
+		layer: 4 - int(layerBits),
+		mono:  b[3]>>6 == 3,
+	}
+
+	mpeg := 0
+	if frame.mpeg1 {
+		mpeg = 1
+	}
+	frame.bitrate = mp3Bitrates[mpeg][frame.layer-1][bitrateIndex] * 1000
+
+	frame.sampleRate = mp3SampleRates[rateIndex]
+	switch version {
+	case 2:
+		frame.sampleRate /= 2
+	case 0:
+		frame.sampleRate /= 4
+	}
+
+	padding := int(b[2]>>1) & 0x01
+	switch {
+	case frame.layer == 1:
+		frame.samples = 384
+		frame.length = (12*frame.bitrate/frame.sampleRate + padding) * 4
+	case frame.layer == 3 && !frame.mpeg1:
+		frame.samples = 576
+		frame.length = 72*frame.bitrate/frame.sampleRate + padding
+	default:
+		frame.samples = 1152
+		frame.length = 144*frame.bitrate/frame.sampleRate + padding
+	}
+
+	return frame, true
+}
+
+// isMP3Frame reports whether b starts with an MPEG audio frame header
+func isMP3Frame(b []byte) bool {
+	_, ok := parseMP3Frame(b)
+	return ok
+}
+
+// id3Size returns the size of an ID3v2 tag at the start of b, or zero
+func id3Size(b []byte) int64 {
+	if len(b) < 10 || !bytes.HasPrefix(b, []byte("ID3")) {
+		return 0
+	}
+	// The size is a 28-bit "syncsafe" integer
+	size := int64(b[6]&0x7F)<<21 | int64(b[7]&0x7F)<<14 | int64(b[8]&0x7F)<<7 | int64(b[9]&0x7F)
+	size += 10
+	if b[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return size
+}
+
+// mp3Duration reads the duration of an MP3 file from its Xing, Info or VBRI
+// header, or estimates it from the first frame's bitrate
+func mp3Duration(r io.ReadSeeker, size int64) (time.Duration, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, err
+	}
+	start := id3Size(head)
+
+	// Find the first frame within a short distance of the tag
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	window := make([]byte, 64*1024)
+	n, err := io.ReadFull(r, window)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	window = window[:n]
+
+	for i := 0; i+4 <= len(window); i++ {
+		frame, ok := parseMP3Frame(window[i:])
+		if !ok {
+			continue
+		}
+		data := window[i:]
+
+		if frames, ok := vbrFrameCount(frame, data); ok {
+			return seconds(uint64(frames)*uint64(frame.samples), uint64(frame.sampleRate)), nil
+		}
+
+		audioBytes := size - start - int64(i)
+		return time.Duration(float64(audioBytes*8) / float64(frame.bitrate) * float64(time.Second)), nil
+	}
+
+	return 0, ErrUnsupported
+}
+
+// vbrFrameCount reads the total frame count from a Xing/Info or VBRI header
+// in the first frame
+func vbrFrameCount(frame *mp3Frame, data []byte) (uint32, bool) {
+	// The Xing header follows the side information
+	sideInfo := 32
+	switch {
+	case frame.mpeg1 && frame.mono:
+		sideInfo = 17
+	case !frame.mpeg1 && frame.mono:
+		sideInfo = 9
+	case !frame.mpeg1:
+		sideInfo = 17
+	}
+
+	xing := 4 + sideInfo
+	if len(data) >= xing+12 && (bytes.Equal(data[xing:xing+4], []byte("Xing")) || bytes.Equal(data[xing:xing+4], []byte("Info"))) {
+		flags := binary.BigEndian.Uint32(data[xing+4 : xing+8])
+		if flags&0x01 != 0 {
+			return binary.BigEndian.Uint32(data[xing+8 : xing+12]), true
+		}
+	}
+
+	// The VBRI header has a fixed position
+	const vbri = 36
+	if len(data) >= vbri+18 && bytes.Equal(data[vbri:vbri+4], []byte("VBRI")) {
+		return binary.BigEndian.Uint32(data[vbri+14 : vbri+18]), true
+	}
+
+	return 0, false
+}
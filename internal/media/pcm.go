@@ -0,0 +1,166 @@
+package media
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// pcmStream yields decoded audio as interleaved samples in [-1, 1]
+type pcmStream struct {
+	sampleRate int
+	channels   int
+	// frames is the total number of sample frames, per channel
+	frames int64
+	// next returns the next sample, or io.EOF after the last one
+	next func() (float64, error)
+}
+
+// WAVE format tags
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// openWAV decodes integer and floating point PCM in a RIFF/WAVE file
+func openWAV(r io.ReadSeeker) (*pcmStream, error) {
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var (
+		format         uint16
+		channels       int
+		sampleRate     int
+		bitsPerSample  int
+		haveFormatInfo bool
+	)
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, ErrUnsupported
+		}
+		id := string(chunk[0:4])
+		length := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			if length < 16 {
+				return nil, ErrUnsupported
+			}
+			body := make([]byte, length)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, ErrUnsupported
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			// The real format of an extensible file is at the start of its sub-format GUID
+			if format == wavFormatExtensible && length >= 26 {
+				format = binary.LittleEndian.Uint16(body[24:26])
+			}
+			haveFormatInfo = true
+			length = 0
+
+		case "data":
+			if !haveFormatInfo || channels == 0 || sampleRate == 0 {
+				return nil, ErrUnsupported
+			}
+			decode, err := wavSampleDecoder(format, bitsPerSample)
+			if err != nil {
+				return nil, err
+			}
+
+			width := bitsPerSample / 8
+			data := bufio.NewReader(io.LimitReader(r, length))
+			sample := make([]byte, width)
+			return &pcmStream{
+				sampleRate: sampleRate,
+				channels:   channels,
+				frames:     length / int64(width*channels),
+				next: func() (float64, error) {
+					if _, err := io.ReadFull(data, sample); err != nil {
+						if err == io.ErrUnexpectedEOF {
+							err = io.EOF
+						}
+						return 0, err
+					}
+					return decode(sample), nil
+				},
+			}, nil
+		}
+
+		// Chunks are padded to an even length
+		if _, err := r.Seek(length+length%2, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wavSampleDecoder returns a function converting one little-endian sample
+// of the given format to [-1, 1]
+func wavSampleDecoder(format uint16, bitsPerSample int) (func([]byte) float64, error) {
+	switch {
+	case format == wavFormatPCM && bitsPerSample == 8:
+		// 8-bit samples are unsigned
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }, nil
+	case format == wavFormatPCM && bitsPerSample == 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }, nil
+	case format == wavFormatPCM && bitsPerSample == 24:
+		return func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}, nil
+	case format == wavFormatPCM && bitsPerSample == 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }, nil
+	case format == wavFormatFloat && bitsPerSample == 32:
+		return func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }, nil
+	case format == wavFormatFloat && bitsPerSample == 64:
+		return func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// openMP3 decodes an MPEG audio file. The decoder always produces 16-bit
+// stereo.
+func openMP3(r io.ReadSeeker) (*pcmStream, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	decoder, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	data := bufio.NewReader(decoder)
+	sample := make([]byte, 2)
+	return &pcmStream{
+		sampleRate: decoder.SampleRate(),
+		channels:   2,
+		frames:     decoder.Length() / 4,
+		next: func() (float64, error) {
+			if _, err := io.ReadFull(data, sample); err != nil {
+				if err == io.ErrUnexpectedEOF {
+					err = io.EOF
+				}
+				return 0, err
+			}
+			return float64(int16(binary.LittleEndian.Uint16(sample))) / (1 << 15), nil
+		},
+	}, nil
+}
+
+// openPCM decodes audio that Probe identified as mimeType
+func openPCM(r io.ReadSeeker, mimeType string) (*pcmStream, error) {
+	switch mimeType {
+	case "audio/wav":
+		return openWAV(r)
+	case "audio/mpeg":
+		return openMP3(r)
+	}
+	return nil, ErrUnsupported
+}
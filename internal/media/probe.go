@@ -0,0 +1,288 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrUnsupported is returned for content that is not a recognized audio or
+// video format
+var ErrUnsupported = errors.New("unsupported media type")
+
+// Info describes probed media content
+type Info struct {
+	MIMEType string
+	// Duration is zero when the container does not declare it in a way
+	// that can be read without decoding
+	Duration time.Duration
+}
+
+// sniffLength is the number of leading bytes inspected to identify content
+const sniffLength = 512
+
+// Probe identifies audio or video content from its bytes, ignoring any
+// client-supplied name or type, and reads its duration where the format
+// allows it
+func Probe(r io.ReadSeeker) (*Info, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return nil, ErrUnsupported
+		}
+		return nil, err
+	}
+	header = header[:n]
+
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		duration, _ := wavDuration(r)
+		return &Info{MIMEType: "audio/wav", Duration: duration}, nil
+
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		duration, _ := flacDuration(header)
+		return &Info{MIMEType: "audio/flac", Duration: duration}, nil
+
+	case bytes.HasPrefix(header, []byte("OggS")):
+		duration, _ := oggDuration(r, header, size)
+		return &Info{MIMEType: "audio/ogg", Duration: duration}, nil
+
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		mimeType := "video/mp4"
+		switch string(header[8:12]) {
+		case "M4A ", "M4B ", "M4P ":
+			mimeType = "audio/mp4"
+		case "qt  ":
+			mimeType = "video/quicktime"
+		}
+		duration, _ := mp4Duration(r, size)
+		return &Info{MIMEType: mimeType, Duration: duration}, nil
+
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return &Info{MIMEType: "video/webm"}, nil
+
+	case bytes.HasPrefix(header, []byte("ID3")) || isMP3Frame(header):
+		duration, _ := mp3Duration(r, size)
+		return &Info{MIMEType: "audio/mpeg", Duration: duration}, nil
+	}
+
+	// Fall back to the standard sniffer for the remaining audio types
+	switch mimeType := http.DetectContentType(header); mimeType {
+	case "audio/aiff", "audio/basic", "audio/midi", "video/avi":
+		return &Info{MIMEType: mimeType}, nil
+	}
+
+	return nil, ErrUnsupported
+}
+
+// Extension returns the file extension, including the dot, for a MIME type
+// returned by Probe
+func Extension(mimeType string) string {
+	switch mimeType {
+	case "audio/wav":
+		return ".wav"
+	case "audio/flac":
+		return ".flac"
+	case "audio/ogg":
+		return ".ogg"
+	case "audio/mp4":
+		return ".m4a"
+	case "video/mp4":
+		return ".mp4"
+	case "video/quicktime":
+		return ".mov"
+	case "video/webm":
+		return ".webm"
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/aiff":
+		return ".aiff"
+	case "audio/basic":
+		return ".au"
+	case "audio/midi":
+		return ".mid"
+	case "video/avi":
+		return ".avi"
+	}
+	return ""
+}
+
+// seconds converts a sample count at a sample rate to a duration
+func seconds(samples uint64, rate uint64) time.Duration {
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(samples) / float64(rate) * float64(time.Second))
+}
+
+// wavDuration reads the duration of a RIFF/WAVE file from its fmt and data chunks
+func wavDuration(r io.ReadSeeker) (time.Duration, error) {
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var byteRate uint32
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return 0, err
+		}
+		id := string(chunk[0:4])
+		length := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			format := make([]byte, 16)
+			if length < 16 {
+				return 0, ErrUnsupported
+			}
+			if _, err := io.ReadFull(r, format); err != nil {
+				return 0, err
+			}
+			byteRate = binary.LittleEndian.Uint32(format[8:12])
+			length -= 16
+		case "data":
+			if byteRate == 0 {
+				return 0, ErrUnsupported
+			}
+			return seconds(uint64(length), uint64(byteRate)), nil
+		}
+
+		// Chunks are padded to an even length
+		if _, err := r.Seek(length+length%2, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// flacDuration reads the duration from the STREAMINFO block that must
+// follow the "fLaC" marker
+func flacDuration(header []byte) (time.Duration, error) {
+	// marker (4) + block header (4) + STREAMINFO fields up to total samples (18)
+	if len(header) < 26 || header[4]&0x7F != 0 {
+		return 0, ErrUnsupported
+	}
+	info := header[8:]
+	rate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+	samples := uint64(info[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(info[14:18]))
+	return seconds(samples, rate), nil
+}
+
+// oggDuration reads the duration of an Ogg Vorbis or Opus stream from the
+// granule position of its last page
+func oggDuration(r io.ReadSeeker, header []byte, size int64) (time.Duration, error) {
+	var rate uint64
+	switch {
+	case bytes.Contains(header, []byte("OpusHead")):
+		// Opus granule positions always count 48 kHz samples
+		rate = 48000
+	case bytes.Contains(header, []byte("\x01vorbis")):
+		i := bytes.Index(header, []byte("\x01vorbis"))
+		if len(header) < i+16 {
+			return 0, ErrUnsupported
+		}
+		rate = uint64(binary.LittleEndian.Uint32(header[i+12 : i+16]))
+	default:
+		return 0, ErrUnsupported
+	}
+
+	// Pages are at most 64 KiB, so the last one starts within the tail
+	tailSize := int64(65307)
+	if tailSize > size {
+		tailSize = size
+	}
+	if _, err := r.Seek(size-tailSize, io.SeekStart); err != nil {
+		return 0, err
+	}
+	tail := make([]byte, tailSize)
+	if _, err := io.ReadFull(r, tail); err != nil {
+		return 0, err
+	}
+
+	last := bytes.LastIndex(tail, []byte("OggS"))
+	if last < 0 || len(tail) < last+14 {
+		return 0, ErrUnsupported
+	}
+	granule := binary.LittleEndian.Uint64(tail[last+6 : last+14])
+	return seconds(granule, rate), nil
+}
+
+// mp4Duration reads the duration from the movie header ("moov/mvhd") of an
+// ISO base media file
+func mp4Duration(r io.ReadSeeker, size int64) (time.Duration, error) {
+	moov, moovSize, err := findBox(r, 0, size, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhd, _, err := findBox(r, moov, moov+moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := r.Seek(mvhd, io.SeekStart); err != nil {
+		return 0, err
+	}
+	body := make([]byte, 32)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, err
+	}
+
+	// Version 1 headers use 64-bit times and durations
+	if body[0] == 1 {
+		timescale := uint64(binary.BigEndian.Uint32(body[20:24]))
+		duration := binary.BigEndian.Uint64(body[24:32])
+		return seconds(duration, timescale), nil
+	}
+	timescale := uint64(binary.BigEndian.Uint32(body[12:16]))
+	duration := uint64(binary.BigEndian.Uint32(body[16:20]))
+	return seconds(duration, timescale), nil
+}
+
+// findBox returns the offset and size of the body of the first box of the
+// given type between start and end
+func findBox(r io.ReadSeeker, start, end int64, boxType string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return 0, 0, err
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			// The box extends to the end of the file
+			boxSize = end - offset
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return 0, 0, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return 0, 0, ErrUnsupported
+		}
+
+		if string(header[4:8]) == boxType {
+			return offset + headerSize, boxSize - headerSize, nil
+		}
+		offset += boxSize
+	}
+	return 0, 0, ErrUnsupported
+}
@@ -0,0 +1,395 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// ErrInvalidTags is returned when tags cannot be written into a file
+var ErrInvalidTags = errors.New("invalid tags")
+
+// maxMovieBoxSize bounds the movie header read into memory to retag MP4 files
+const maxMovieBoxSize = 64 << 20
+
+// Tags describes a ringtone to players and phones. Empty fields are left out.
+type Tags struct {
+	Title   string
+	Artist  string
+	Comment string
+	// Cover is a JPEG or PNG image embedded as front cover art
+	Cover []byte
+}
+
+// IsEmpty reports whether there is nothing to write
+func (t *Tags) IsEmpty() bool {
+	return t.Title == "" && t.Artist == "" && t.Comment == "" && len(t.Cover) == 0
+}
+
+// CoverMIMEType returns the type of cover art, or "" if it is neither JPEG
+// nor PNG
+func CoverMIMEType(image []byte) string {
+	switch {
+	case bytes.HasPrefix(image, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(image, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	default:
+		return ""
+	}
+}
+
+// CanTag reports whether WriteTags supports an output format, named by its
+// file extension
+func CanTag(format string) bool {
+	return slices.Contains([]string{"mp3", "m4a", "m4r"}, format)
+}
+
+// WriteTags copies audio from src to dst with tags written into it: an
+// ID3v2.4 tag for MP3, replacing any existing ID3v2 tag, and iTunes-style
+// metadata atoms for M4A and M4R, replacing any existing ones. The audio
+// itself is copied unchanged.
+func WriteTags(dst io.Writer, src io.ReadSeeker, format string, tags *Tags) error {
+	if len(tags.Cover) > 0 && CoverMIMEType(tags.Cover) == "" {
+		return fmt.Errorf("%w: cover art is not a JPEG or PNG image", ErrInvalidTags)
+	}
+
+	switch format {
+	case "mp3":
+		return writeID3(dst, src, tags)
+	case "m4a", "m4r":
+		return writeMP4Tags(dst, src, tags)
+	default:
+		return ErrUnsupported
+	}
+}
+
+// writeID3 writes an ID3v2.4 tag followed by the audio of src
+func writeID3(dst io.Writer, src io.ReadSeeker, tags *Tags) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := make([]byte, 10)
+	n, err := io.ReadFull(src, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if _, err := src.Seek(id3Size(header[:n]), io.SeekStart); err != nil {
+		return err
+	}
+
+	// Text is UTF-8 (encoding 3), which ID3v2.4 introduced
+	var frames bytes.Buffer
+	if tags.Title != "" {
+		writeID3Frame(&frames, "TIT2", []byte("\x03"+tags.Title))
+	}
+	if tags.Artist != "" {
+		writeID3Frame(&frames, "TPE1", []byte("\x03"+tags.Artist))
+	}
+	if tags.Comment != "" {
+		// Language and an empty description precede the text
+		writeID3Frame(&frames, "COMM", []byte("\x03eng\x00"+tags.Comment))
+	}
+	if len(tags.Cover) > 0 {
+		// MIME type, picture type 3 (front cover) and an empty description
+		body := []byte("\x03" + CoverMIMEType(tags.Cover) + "\x00\x03\x00")
+		writeID3Frame(&frames, "APIC", append(body, tags.Cover...))
+	}
+	if frames.Len() >= 1<<28 {
+		return fmt.Errorf("%w: ID3 tag is too large", ErrInvalidTags)
+	}
+
+	tag := append([]byte("ID3\x04\x00\x00"), syncsafe(frames.Len())...)
+	if _, err := dst.Write(tag); err != nil {
+		return err
+	}
+	if _, err := frames.WriteTo(dst); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// writeID3Frame appends an ID3v2.4 frame, whose size is syncsafe
+func writeID3Frame(b *bytes.Buffer, id string, body []byte) {
+	b.WriteString(id)
+	b.Write(syncsafe(len(body)))
+	b.Write([]byte{0, 0})
+	b.Write(body)
+}
+
+// syncsafe encodes n in four bytes of seven bits each
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// mp4Box is a box held in memory. Boxes are only parsed down to the ones
+// that retagging changes.
+type mp4Box struct {
+	boxType  string
+	body     []byte
+	children []*mp4Box
+}
+
+// mp4Containers are the boxes descended into on the way to the metadata
+// and the chunk offset tables
+var mp4Containers = []string{"moov", "trak", "mdia", "minf", "stbl", "udta"}
+
+// writeMP4Tags rewrites the movie box ("moov") of an ISO base media file
+// with a "udta/meta/ilst" metadata list. If the movie box comes before the
+// media data, the chunk offsets of its tracks are shifted by the change in
+// its size.
+func writeMP4Tags(dst io.Writer, src io.ReadSeeker, tags *Tags) error {
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	moovStart, moovEnd, err := findTopLevelBox(src, size, "moov")
+	if err != nil {
+		return err
+	}
+	if moovEnd-moovStart > maxMovieBoxSize {
+		return fmt.Errorf("%w: movie box is too large", ErrInvalidTags)
+	}
+
+	if _, err := src.Seek(moovStart, io.SeekStart); err != nil {
+		return err
+	}
+	raw := make([]byte, moovEnd-moovStart)
+	if _, err := io.ReadFull(src, raw); err != nil {
+		return err
+	}
+	boxes, err := parseMP4Boxes(raw)
+	if err != nil || len(boxes) != 1 {
+		return ErrUnsupported
+	}
+	moov := boxes[0]
+
+	// Replace the metadata, keeping any other user data
+	meta := &mp4Box{boxType: "meta", body: mp4MetaBody(tags)}
+	udta := slices.IndexFunc(moov.children, func(b *mp4Box) bool { return b.boxType == "udta" })
+	if udta < 0 {
+		moov.children = append(moov.children, &mp4Box{boxType: "udta", children: []*mp4Box{meta}})
+	} else {
+		children := slices.DeleteFunc(moov.children[udta].children, func(b *mp4Box) bool { return b.boxType == "meta" })
+		moov.children[udta].children = append(children, meta)
+	}
+
+	delta := int64(len(moov.encode())) - (moovEnd - moovStart)
+	if delta != 0 && moovEnd < size {
+		if err := shiftChunkOffsets(moov, moovEnd, delta); err != nil {
+			return err
+		}
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(dst, src, moovStart); err != nil {
+		return err
+	}
+	if _, err := dst.Write(moov.encode()); err != nil {
+		return err
+	}
+	if _, err := src.Seek(moovEnd, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// findTopLevelBox returns the start and end offsets of the first top-level
+// box of the given type, headers included
+func findTopLevelBox(r io.ReadSeeker, size int64, boxType string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= size; {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return 0, 0, err
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return 0, 0, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if boxSize < 8 || offset+boxSize > size {
+			return 0, 0, ErrUnsupported
+		}
+
+		if string(header[4:8]) == boxType {
+			return offset, offset + boxSize, nil
+		}
+		offset += boxSize
+	}
+	return 0, 0, ErrUnsupported
+}
+
+// parseMP4Boxes parses a sequence of boxes, descending into containers
+func parseMP4Boxes(b []byte) ([]*mp4Box, error) {
+	var boxes []*mp4Box
+	// QuickTime may end a list with a short zero terminator, which is dropped
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b[0:4]))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, ErrUnsupported
+			}
+			size = binary.BigEndian.Uint64(b[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			return nil, ErrUnsupported
+		}
+
+		box := &mp4Box{boxType: string(b[4:8]), body: b[headerSize:size]}
+		if slices.Contains(mp4Containers, box.boxType) {
+			children, err := parseMP4Boxes(box.body)
+			if err != nil {
+				return nil, err
+			}
+			box.children, box.body = children, nil
+		}
+		boxes = append(boxes, box)
+		b = b[size:]
+	}
+	return boxes, nil
+}
+
+// encode serializes a box and its children
+func (b *mp4Box) encode() []byte {
+	body := b.body
+	if b.children != nil {
+		body = nil
+		for _, child := range b.children {
+			body = append(body, child.encode()...)
+		}
+	}
+	return encodeMP4Box(b.boxType, body)
+}
+
+// encodeMP4Box serializes a box, using a 64-bit size only where needed
+func encodeMP4Box(boxType string, body []byte) []byte {
+	size := uint64(len(body)) + 8
+	if size > math.MaxUint32 {
+		header := make([]byte, 16)
+		binary.BigEndian.PutUint32(header[0:4], 1)
+		copy(header[4:8], boxType)
+		binary.BigEndian.PutUint64(header[8:16], size+8)
+		return append(header, body...)
+	}
+	header := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(header[0:4], uint32(size))
+	copy(header[4:8], boxType)
+	return append(header, body...)
+}
+
+// shiftChunkOffsets adds delta to the chunk offsets ("stco" and "co64") of
+// box and its descendants that point at or past from
+func shiftChunkOffsets(box *mp4Box, from, delta int64) error {
+	for _, child := range box.children {
+		if err := shiftChunkOffsets(child, from, delta); err != nil {
+			return err
+		}
+	}
+
+	var width int
+	switch box.boxType {
+	case "stco":
+		width = 4
+	case "co64":
+		width = 8
+	default:
+		return nil
+	}
+
+	// Version and flags, then the entry count
+	if len(box.body) < 8 {
+		return ErrUnsupported
+	}
+	count := int(binary.BigEndian.Uint32(box.body[4:8]))
+	if len(box.body) < 8+count*width {
+		return ErrUnsupported
+	}
+
+	// The body shares its bytes with the file that was read, so it is copied
+	// before it is changed
+	body := slices.Clone(box.body)
+	for i := 0; i < count; i++ {
+		entry := body[8+i*width:]
+		if width == 4 {
+			offset := int64(binary.BigEndian.Uint32(entry))
+			if offset < from {
+				continue
+			}
+			if offset+delta < 0 || offset+delta > math.MaxUint32 {
+				return fmt.Errorf("%w: chunk offset out of range", ErrInvalidTags)
+			}
+			binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+		} else {
+			offset := int64(binary.BigEndian.Uint64(entry))
+			if offset < from {
+				continue
+			}
+			binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+		}
+	}
+	box.body = body
+	return nil
+}
+
+// mp4MetaBody builds the body of an iTunes-style "meta" box: a handler of
+// type "mdir" and an "ilst" list of items
+func mp4MetaBody(tags *Tags) []byte {
+	var items []byte
+	// Item types start with the copyright sign in MacRoman, byte 0xA9
+	if tags.Title != "" {
+		items = append(items, mp4Item("\xa9nam", 1, []byte(tags.Title))...)
+	}
+	if tags.Artist != "" {
+		items = append(items, mp4Item("\xa9ART", 1, []byte(tags.Artist))...)
+	}
+	if tags.Comment != "" {
+		items = append(items, mp4Item("\xa9cmt", 1, []byte(tags.Comment))...)
+	}
+	if len(tags.Cover) > 0 {
+		// Well-known data types 13 (JPEG) and 14 (PNG)
+		dataType := uint32(13)
+		if CoverMIMEType(tags.Cover) == "image/png" {
+			dataType = 14
+		}
+		items = append(items, mp4Item("covr", dataType, tags.Cover)...)
+	}
+
+	// Version and flags, then the handler: pre-defined, handler type,
+	// reserved (by convention starting "appl") and an empty name
+	body := make([]byte, 4)
+	hdlr := append(make([]byte, 4), "\x00\x00\x00\x00mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00"...)
+	body = append(body, encodeMP4Box("hdlr", hdlr)...)
+	return append(body, encodeMP4Box("ilst", items)...)
+}
+
+// mp4Item encodes a metadata item holding a single "data" box of the given
+// well-known type
+func mp4Item(itemType string, dataType uint32, value []byte) []byte {
+	// The type occupies the version and flags, followed by an empty locale
+	data := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(data[0:4], dataType)
+	data = append(data, value...)
+	return encodeMP4Box(itemType, encodeMP4Box("data", data))
+}
@@ -0,0 +1,262 @@
+package media
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// Trim analysis resolution: energy is measured over frames and features
+// are gathered per segment, the step between candidate start times
+const (
+	trimFrame   = 50 * time.Millisecond
+	trimSegment = time.Second
+	// trimContext is the stretch of audio compared when looking for
+	// repeated material, such as a chorus
+	trimContext = 4
+)
+
+// Weights of the features in a window's score
+const (
+	energyWeight     = 0.5
+	onsetWeight      = 0.25
+	repetitionWeight = 0.25
+)
+
+// TrimCandidate is a suggested window of audio. Score and its features
+// range from 0 to 1, relative to the rest of the audio.
+type TrimCandidate struct {
+	StartSeconds int     `json:"start_seconds"`
+	EndSeconds   int     `json:"end_seconds"`
+	Score        float64 `json:"score"`
+	// Energy is the window's loudness
+	Energy float64 `json:"energy"`
+	// Onsets is how busy the window is with beats and note attacks
+	Onsets float64 `json:"onsets"`
+	// Repetition is how closely the window recurs elsewhere in the audio
+	Repetition float64 `json:"repetition"`
+}
+
+// TrimAnalysis holds the best windows of a piece of audio, best first
+type TrimAnalysis struct {
+	DurationSeconds float64         `json:"duration_seconds"`
+	Candidates      []TrimCandidate `json:"candidates"`
+}
+
+// SuggestTrims decodes WAV or MP3 audio and ranks windows of the given
+// length by energy, onset density and repetition, returning up to count
+// windows that overlap each other by no more than half their length.
+// Audio shorter than the window yields a single window covering all of
+// it. Other formats return ErrUnsupported.
+func SuggestTrims(r io.ReadSeeker, window time.Duration, count int) (*TrimAnalysis, error) {
+	if window < trimSegment {
+		return nil, fmt.Errorf("window must be at least %s", trimSegment)
+	}
+	if count < 1 {
+		return nil, fmt.Errorf("count must be at least 1")
+	}
+
+	info, err := Probe(r)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := openPCM(r, info.MIMEType)
+	if err != nil {
+		return nil, err
+	}
+	if stream.sampleRate <= 0 || stream.channels <= 0 {
+		return nil, ErrUnsupported
+	}
+
+	frames, err := frameEnergies(stream)
+	if err != nil {
+		return nil, err
+	}
+	frameSeconds := trimFrame.Seconds()
+	analysis := &TrimAnalysis{DurationSeconds: float64(len(frames)) * frameSeconds}
+
+	segments := segmentFeatures(frames)
+	windowSegments := int(window / trimSegment)
+	if len(segments) <= windowSegments {
+		analysis.Candidates = []TrimCandidate{{
+			EndSeconds: int(math.Ceil(analysis.DurationSeconds)),
+			Score:      1,
+			Energy:     1,
+			Onsets:     1,
+			Repetition: 1,
+		}}
+		return analysis, nil
+	}
+
+	windows := scoreWindows(segments, windowSegments)
+	slices.SortStableFunc(windows, func(a, b TrimCandidate) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+
+	// Keep the best windows that are not near-duplicates of better ones
+	minDistance := (windowSegments + 1) / 2
+	for _, candidate := range windows {
+		distinct := true
+		for _, kept := range analysis.Candidates {
+			if abs(candidate.StartSeconds-kept.StartSeconds) < minDistance {
+				distinct = false
+				break
+			}
+		}
+		if distinct {
+			candidate.Score = roundScore(candidate.Score)
+			candidate.Energy = roundScore(candidate.Energy)
+			candidate.Onsets = roundScore(candidate.Onsets)
+			candidate.Repetition = roundScore(candidate.Repetition)
+			analysis.Candidates = append(analysis.Candidates, candidate)
+			if len(analysis.Candidates) == count {
+				break
+			}
+		}
+	}
+	return analysis, nil
+}
+
+// frameEnergies mixes audio down to mono and returns the mean square of
+// each frame. A trailing partial frame is dropped.
+func frameEnergies(stream *pcmStream) ([]float64, error) {
+	frameLength := max(int(float64(stream.sampleRate)*trimFrame.Seconds()), 1)
+
+	var (
+		energies []float64
+		sum      float64
+		mix      float64
+		channel  int
+		frames   int
+	)
+	for {
+		sample, err := stream.next()
+		if err == io.EOF {
+			return energies, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode audio: %w", err)
+		}
+
+		mix += sample
+		channel++
+		if channel < stream.channels {
+			continue
+		}
+		mix /= float64(stream.channels)
+		sum += mix * mix
+		mix = 0
+		channel = 0
+		frames++
+		if frames == frameLength {
+			energies = append(energies, sum/float64(frameLength))
+			sum = 0
+			frames = 0
+		}
+	}
+}
+
+// trimSegmentFeatures describes one segment of audio
+type trimSegmentFeatures struct {
+	energy     float64
+	onsets     float64
+	repetition float64
+	// level is the segment's loudness in dB, compared for repetition
+	level float64
+}
+
+// segmentFeatures groups frames into segments and measures their energy,
+// onset strength and repetition, each normalized to the range 0 to 1
+func segmentFeatures(frames []float64) []trimSegmentFeatures {
+	perSegment := int(trimSegment / trimFrame)
+	segments := make([]trimSegmentFeatures, len(frames)/perSegment)
+
+	previous := math.Inf(-1)
+	for i := range segments {
+		for _, energy := range frames[i*perSegment : (i+1)*perSegment] {
+			segments[i].energy += math.Sqrt(energy)
+			// Onsets show as rises in frame level; falls are ignored
+			level := 10 * math.Log10(energy+1e-10)
+			if rise := level - previous; rise > 0 && !math.IsInf(previous, -1) {
+				segments[i].onsets += rise
+			}
+			previous = level
+		}
+		segments[i].energy /= float64(perSegment)
+		segments[i].level = 20 * math.Log10(segments[i].energy+1e-5)
+	}
+
+	// A segment repeats if the stretch of audio starting at it closely
+	// matches the level contour of a stretch elsewhere, away from itself
+	for i := range segments {
+		best := 0.0
+		for j := range segments {
+			if abs(i-j) < trimContext || i+trimContext > len(segments) || j+trimContext > len(segments) {
+				continue
+			}
+			var distance float64
+			for k := 0; k < trimContext; k++ {
+				d := segments[i+k].level - segments[j+k].level
+				distance += d * d
+			}
+			best = max(best, 1/(1+math.Sqrt(distance/trimContext)/3))
+		}
+		segments[i].repetition = best
+	}
+
+	normalize := func(get func(*trimSegmentFeatures) *float64) {
+		var peak float64
+		for i := range segments {
+			peak = max(peak, *get(&segments[i]))
+		}
+		if peak == 0 {
+			return
+		}
+		for i := range segments {
+			*get(&segments[i]) /= peak
+		}
+	}
+	normalize(func(s *trimSegmentFeatures) *float64 { return &s.energy })
+	normalize(func(s *trimSegmentFeatures) *float64 { return &s.onsets })
+	normalize(func(s *trimSegmentFeatures) *float64 { return &s.repetition })
+	return segments
+}
+
+// scoreWindows scores every window of n segments, in order of start
+func scoreWindows(segments []trimSegmentFeatures, n int) []TrimCandidate {
+	windows := make([]TrimCandidate, 0, len(segments)-n+1)
+	for start := 0; start+n <= len(segments); start++ {
+		candidate := TrimCandidate{StartSeconds: start, EndSeconds: start + n}
+		for _, segment := range segments[start : start+n] {
+			candidate.Energy += segment.energy
+			candidate.Onsets += segment.onsets
+			candidate.Repetition += segment.repetition
+		}
+		candidate.Energy /= float64(n)
+		candidate.Onsets /= float64(n)
+		candidate.Repetition /= float64(n)
+		candidate.Score = energyWeight*candidate.Energy + onsetWeight*candidate.Onsets + repetitionWeight*candidate.Repetition
+		windows = append(windows, candidate)
+	}
+	return windows
+}
+
+// roundScore rounds a score to three decimal places for reporting
+func roundScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
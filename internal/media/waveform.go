@@ -0,0 +1,141 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// MaxWaveformBuckets bounds the resolution of a waveform
+const MaxWaveformBuckets = 4096
+
+// waveformMagic starts the binary encoding of a waveform, including its version
+var waveformMagic = []byte("RTW1")
+
+// Levels are amplitudes quantized to 0-255, where 255 is full scale
+type Levels []uint8
+
+// MarshalJSON encodes levels as an array of numbers rather than base64
+func (l Levels) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, level := range l {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(int(level)))
+	}
+	b.WriteByte(']')
+	return b.Bytes(), nil
+}
+
+// Waveform holds the peak and RMS amplitude of equal-length slices of audio,
+// across all channels
+type Waveform struct {
+	SampleRate      int     `json:"sample_rate"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Buckets         int     `json:"buckets"`
+	Peaks           Levels  `json:"peaks"`
+	RMS             Levels  `json:"rms"`
+}
+
+// ComputeWaveform decodes WAV or MP3 audio and reduces it to the given
+// number of buckets. Other formats return ErrUnsupported.
+func ComputeWaveform(r io.ReadSeeker, buckets int) (*Waveform, error) {
+	if buckets < 1 || buckets > MaxWaveformBuckets {
+		return nil, fmt.Errorf("buckets must be between 1 and %d", MaxWaveformBuckets)
+	}
+
+	info, err := Probe(r)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := openPCM(r, info.MIMEType)
+	if err != nil {
+		return nil, err
+	}
+	if stream.frames <= 0 {
+		return nil, ErrUnsupported
+	}
+
+	peaks := make([]float64, buckets)
+	sums := make([]float64, buckets)
+	counts := make([]int64, buckets)
+	for i := int64(0); ; i++ {
+		sample, err := stream.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode audio: %w", err)
+		}
+
+		bucket := int(i / int64(stream.channels) * int64(buckets) / stream.frames)
+		if bucket >= buckets {
+			bucket = buckets - 1
+		}
+		if abs := math.Abs(sample); abs > peaks[bucket] {
+			peaks[bucket] = abs
+		}
+		sums[bucket] += sample * sample
+		counts[bucket]++
+	}
+
+	waveform := &Waveform{
+		SampleRate:      stream.sampleRate,
+		DurationSeconds: float64(stream.frames) / float64(stream.sampleRate),
+		Buckets:         buckets,
+		Peaks:           make(Levels, buckets),
+		RMS:             make(Levels, buckets),
+	}
+	for i := range peaks {
+		waveform.Peaks[i] = quantize(peaks[i])
+		if counts[i] > 0 {
+			waveform.RMS[i] = quantize(math.Sqrt(sums[i] / float64(counts[i])))
+		}
+	}
+	return waveform, nil
+}
+
+// quantize maps an amplitude in [0, 1] to a level
+func quantize(amplitude float64) uint8 {
+	return uint8(math.Round(math.Min(amplitude, 1) * 255))
+}
+
+// MarshalBinary encodes a waveform as a header followed by one byte per
+// bucket of peaks and then of RMS levels. All header fields are
+// little-endian uint32: sample rate, duration in milliseconds, buckets.
+func (w *Waveform) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, len(waveformMagic)+12+2*w.Buckets)
+	b = append(b, waveformMagic...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(w.SampleRate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(math.Round(w.DurationSeconds*1000)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(w.Buckets))
+	b = append(b, w.Peaks...)
+	b = append(b, w.RMS...)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a waveform encoded by MarshalBinary
+func (w *Waveform) UnmarshalBinary(data []byte) error {
+	header := len(waveformMagic) + 12
+	if len(data) < header || !bytes.Equal(data[:len(waveformMagic)], waveformMagic) {
+		return errors.New("invalid waveform encoding")
+	}
+	fields := data[len(waveformMagic):header]
+	buckets := int(binary.LittleEndian.Uint32(fields[8:12]))
+	if len(data) != header+2*buckets {
+		return errors.New("invalid waveform encoding")
+	}
+
+	w.SampleRate = int(binary.LittleEndian.Uint32(fields[0:4]))
+	w.DurationSeconds = float64(binary.LittleEndian.Uint32(fields[4:8])) / 1000
+	w.Buckets = buckets
+	w.Peaks = Levels(append([]byte(nil), data[header:header+buckets]...))
+	w.RMS = Levels(append([]byte(nil), data[header+buckets:]...))
+	return nil
+}
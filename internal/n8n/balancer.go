@@ -0,0 +1,212 @@
+package n8n
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Balancing strategies
+const (
+	// RoundRobin sends jobs to endpoints in turn, in proportion to their weights
+	RoundRobin = "round_robin"
+	// LeastInFlight sends each job to the endpoint with the fewest webhooks
+	// in flight relative to its weight
+	LeastInFlight = "least_in_flight"
+)
+
+// Endpoint is an n8n webhook URL and its share of the jobs
+type Endpoint struct {
+	URL    string
+	Weight int
+}
+
+// BalancerConfig configures how jobs are spread over several endpoints
+type BalancerConfig struct {
+	Endpoints []Endpoint
+	// Strategy is RoundRobin or LeastInFlight; empty means RoundRobin
+	Strategy string
+	// EjectFor is how long an endpoint that failed is passed over
+	EjectFor time.Duration
+}
+
+// EndpointStatus reports the state of an endpoint
+type EndpointStatus struct {
+	URL          string     `json:"url"`
+	Weight       int        `json:"weight"`
+	InFlight     int        `json:"in_flight"`
+	Dispatched   int64      `json:"dispatched"`
+	Failures     int64      `json:"failures"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+}
+
+// ParseEndpoints parses a comma-separated list of webhook URLs, each
+// optionally followed by "*weight"
+func ParseEndpoints(spec string) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		endpoint := Endpoint{URL: entry, Weight: 1}
+		if i := strings.LastIndex(entry, "*"); i >= 0 {
+			weight, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+			if err != nil || weight < 1 {
+				return nil, fmt.Errorf("invalid weight for endpoint %q: must be a positive integer", entry)
+			}
+			endpoint = Endpoint{URL: strings.TrimSpace(entry[:i]), Weight: weight}
+		}
+
+		parsed, err := url.Parse(endpoint.URL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint URL: %q", endpoint.URL)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("at least one endpoint is required")
+	}
+	return endpoints, nil
+}
+
+// balancer chooses the endpoint for each webhook
+type balancer struct {
+	strategy string
+	ejectFor time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	endpoints []*endpointState
+}
+
+// endpointState tracks one endpoint
+type endpointState struct {
+	Endpoint
+	// credit is the endpoint's standing in smooth weighted round-robin
+	credit       int
+	inFlight     int
+	dispatched   int64
+	failures     int64
+	ejectedUntil time.Time
+}
+
+func newBalancer(config BalancerConfig) (*balancer, error) {
+	switch config.Strategy {
+	case "":
+		config.Strategy = RoundRobin
+	case RoundRobin, LeastInFlight:
+	default:
+		return nil, fmt.Errorf("unknown balancing strategy: %s", config.Strategy)
+	}
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("at least one endpoint is required")
+	}
+
+	b := &balancer{
+		strategy: config.Strategy,
+		ejectFor: config.EjectFor,
+		now:      time.Now,
+	}
+	for _, endpoint := range config.Endpoints {
+		if endpoint.Weight < 1 {
+			return nil, fmt.Errorf("endpoint %s needs a positive weight", endpoint.URL)
+		}
+		b.endpoints = append(b.endpoints, &endpointState{Endpoint: endpoint})
+	}
+	return b, nil
+}
+
+// pick chooses an endpoint that has not been tried yet and marks it in
+// flight. Ejected endpoints are only chosen when no other is left, as
+// trying one beats failing the job outright. It returns nil once every
+// endpoint has been tried.
+func (b *balancer) pick(tried map[*endpointState]bool) *endpointState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	var healthy, ejected []*endpointState
+	for _, endpoint := range b.endpoints {
+		switch {
+		case tried[endpoint]:
+		case now.Before(endpoint.ejectedUntil):
+			ejected = append(ejected, endpoint)
+		default:
+			healthy = append(healthy, endpoint)
+		}
+	}
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var chosen *endpointState
+	switch b.strategy {
+	case LeastInFlight:
+		for _, endpoint := range candidates {
+			// Compare inFlight/weight without dividing
+			if chosen == nil || endpoint.inFlight*chosen.Weight < chosen.inFlight*endpoint.Weight {
+				chosen = endpoint
+			}
+		}
+	default:
+		total := 0
+		for _, endpoint := range candidates {
+			endpoint.credit += endpoint.Weight
+			total += endpoint.Weight
+			if chosen == nil || endpoint.credit > chosen.credit {
+				chosen = endpoint
+			}
+		}
+		chosen.credit -= total
+	}
+
+	chosen.inFlight++
+	chosen.dispatched++
+	return chosen
+}
+
+// done records the outcome of a webhook sent to an endpoint, ejecting the
+// endpoint if it failed
+func (b *balancer) done(endpoint *endpointState, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	endpoint.inFlight--
+	if !ok {
+		endpoint.failures++
+		endpoint.ejectedUntil = b.now().Add(b.ejectFor)
+	}
+}
+
+// status reports the state of every endpoint
+func (b *balancer) status() []EndpointStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	statuses := make([]EndpointStatus, len(b.endpoints))
+	for i, endpoint := range b.endpoints {
+		statuses[i] = EndpointStatus{
+			URL:        endpoint.URL,
+			Weight:     endpoint.Weight,
+			InFlight:   endpoint.inFlight,
+			Dispatched: endpoint.dispatched,
+			Failures:   endpoint.failures,
+		}
+		if now.Before(endpoint.ejectedUntil) {
+			ejectedUntil := endpoint.ejectedUntil
+			statuses[i].EjectedUntil = &ejectedUntil
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,230 @@
+package n8n
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"ringtonic-backend/internal/log"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed = "closed"
+	CircuitOpen   = "open"
+)
+
+// ErrCircuitOpen is returned instead of sending a webhook while n8n is
+// considered down
+var ErrCircuitOpen = errors.New("n8n circuit is open")
+
+// BreakerConfig configures a circuit breaker
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed webhooks that
+	// open the circuit
+	FailureThreshold int
+	// ProbeInterval is the time between health probes while the circuit
+	// is open
+	ProbeInterval time.Duration
+	// HealthURLs are probed while the circuit is open; a 2xx response
+	// from any of them closes it
+	HealthURLs []string
+}
+
+// BreakerStatus reports the state of a circuit breaker
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	LastProbeAt         *time.Time `json:"last_probe_at,omitempty"`
+	LastProbeError      string     `json:"last_probe_error,omitempty"`
+	// Trips counts how often the circuit has opened since the server started
+	Trips int64 `json:"trips"`
+}
+
+// DefaultHealthURL returns n8n's health endpoint on the host of a webhook URL
+func DefaultHealthURL(webhookURL string) (string, error) {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse webhook URL: %w", err)
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", fmt.Errorf("webhook URL must be absolute: %s", webhookURL)
+	}
+	return (&url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: "/healthz"}).String(), nil
+}
+
+// DefaultHealthURLs returns n8n's health endpoint on the host of each
+// endpoint, once per host
+func DefaultHealthURLs(endpoints []Endpoint) ([]string, error) {
+	var healthURLs []string
+	seen := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		healthURL, err := DefaultHealthURL(endpoint.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[healthURL] {
+			seen[healthURL] = true
+			healthURLs = append(healthURLs, healthURL)
+		}
+	}
+	return healthURLs, nil
+}
+
+// Breaker stops webhooks to n8n after consecutive failures. While the
+// circuit is open, webhooks fail fast with ErrCircuitOpen and n8n's health
+// endpoint is probed in the background until it answers again.
+type Breaker struct {
+	config     BreakerConfig
+	httpClient *http.Client
+	logger     *log.Logger
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	openedAt    time.Time
+	lastProbeAt time.Time
+	probeErr    error
+	trips       int64
+	// ready is closed while the circuit is closed, and replaced when it opens
+	ready chan struct{}
+}
+
+// NewBreaker creates a closed circuit breaker
+func NewBreaker(config BreakerConfig, logger *log.Logger) *Breaker {
+	ready := make(chan struct{})
+	close(ready)
+	return &Breaker{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		logger: logger,
+		state:  CircuitClosed,
+		ready:  ready,
+	}
+}
+
+// Allow returns ErrCircuitOpen if webhooks may not be sent
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// RecordSuccess resets the count of consecutive failures
+func (b *Breaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// RecordFailure counts a failed webhook, opening the circuit once the
+// threshold is reached
+func (b *Breaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == CircuitOpen || b.failures < b.config.FailureThreshold {
+		return
+	}
+
+	b.state = CircuitOpen
+	b.openedAt = time.Now()
+	b.trips++
+	b.ready = make(chan struct{})
+	b.logger.Warn("n8n circuit opened", "consecutive_failures", b.failures)
+	go b.probe()
+}
+
+// Available returns a channel that is closed once the circuit is closed
+func (b *Breaker) Available() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ready
+}
+
+// Status returns the breaker's current state
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+	}
+	if b.state == CircuitOpen {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	if !b.lastProbeAt.IsZero() {
+		lastProbeAt := b.lastProbeAt
+		status.LastProbeAt = &lastProbeAt
+	}
+	if b.probeErr != nil {
+		status.LastProbeError = b.probeErr.Error()
+	}
+	return status
+}
+
+// probe checks n8n's health until it answers, then closes the circuit
+func (b *Breaker) probe() {
+	for {
+		time.Sleep(b.config.ProbeInterval)
+
+		err := b.checkHealth()
+
+		b.mu.Lock()
+		b.lastProbeAt = time.Now()
+		b.probeErr = err
+		if err == nil {
+			b.state = CircuitClosed
+			b.failures = 0
+			close(b.ready)
+			openFor := time.Since(b.openedAt)
+			b.mu.Unlock()
+			b.logger.Info("n8n circuit closed", "open_for", openFor.String())
+			return
+		}
+		b.mu.Unlock()
+		b.logger.Warn("n8n health probe failed", "error", err)
+	}
+}
+
+// checkHealth requests each of n8n's health endpoints until one answers, as
+// the balancer can dispatch to any instance that is up
+func (b *Breaker) checkHealth() error {
+	var errs []error
+	for _, healthURL := range b.config.HealthURLs {
+		err := b.checkHealthURL(healthURL)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", healthURL, err))
+	}
+	return errors.Join(errs...)
+}
+
+// checkHealthURL requests one n8n health endpoint
+func (b *Breaker) checkHealthURL(healthURL string) error {
+	resp, err := b.httpClient.Get(healthURL)
+	if err != nil {
+		return fmt.Errorf("failed to reach n8n: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("health check returned non-2xx status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,30 +2,54 @@ package n8n
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
+	"ringtonic-backend/internal/contract"
 	"ringtonic-backend/internal/log"
 )
 
 // Client handles communication with n8n
 type Client struct {
-	webhookURL string
-	secret     string
+	balancer   *balancer
+	signer     *Signer
 	httpClient *http.Client
 	logger     *log.Logger
+	breaker    *Breaker
 }
 
-// New creates a new n8n client
-func New(webhookURL, secret string, logger *log.Logger) *Client {
+// alwaysAvailable is the availability of a client without a breaker
+var alwaysAvailable = func() chan struct{} {
+	ready := make(chan struct{})
+	close(ready)
+	return ready
+}()
+
+// New creates a new n8n client for a single webhook URL whose requests are
+// signed by signer
+func New(webhookURL string, signer *Signer, logger *log.Logger) *Client {
+	balancer, _ := newBalancer(BalancerConfig{Endpoints: []Endpoint{{URL: webhookURL, Weight: 1}}})
+	return newClient(balancer, signer, logger)
+}
+
+// NewBalanced creates a new n8n client spreading webhooks over several
+// endpoints. An endpoint that cannot be reached, or answers with a server
+// error, is ejected for a while; one that cannot be reached is failed over
+// from at once.
+func NewBalanced(config BalancerConfig, signer *Signer, logger *log.Logger) (*Client, error) {
+	balancer, err := newBalancer(config)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(balancer, signer, logger), nil
+}
+
+func newClient(balancer *balancer, signer *Signer, logger *log.Logger) *Client {
 	return &Client{
-		webhookURL: webhookURL,
-		secret:     secret,
+		balancer: balancer,
+		signer:   signer,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -33,62 +57,126 @@ func New(webhookURL, secret string, logger *log.Logger) *Client {
 	}
 }
 
+// SetBreaker stops webhooks while n8n is down. Without a breaker, every
+// webhook is sent.
+func (c *Client) SetBreaker(breaker *Breaker) {
+	c.breaker = breaker
+}
+
+// Available returns a channel that is closed while webhooks may be sent
+func (c *Client) Available() <-chan struct{} {
+	if c.breaker == nil {
+		return alwaysAvailable
+	}
+	return c.breaker.Available()
+}
+
+// Endpoints reports the state of each webhook endpoint
+func (c *Client) Endpoints() []EndpointStatus {
+	return c.balancer.status()
+}
+
 // TriggerWebhook sends a webhook request to n8n
-func (c *Client) TriggerWebhook(payload map[string]interface{}) error {
+func (c *Client) TriggerWebhook(request *contract.JobRequest) error {
+	_, err := c.trigger(request)
+	return err
+}
+
+// Process dispatches a job to the n8n workflow
+func (c *Client) Process(request *contract.JobRequest) error {
+	return c.TriggerWebhook(request)
+}
+
+// ProcessRouted dispatches a job to the n8n workflow and returns the URL of
+// the endpoint that accepted it
+func (c *Client) ProcessRouted(request *contract.JobRequest) (string, error) {
+	return c.trigger(request)
+}
+
+// trigger sends a webhook, failing over between endpoints that cannot be
+// reached, and returns the URL of the endpoint that answered
+func (c *Client) trigger(request *contract.JobRequest) (string, error) {
+	if c.breaker != nil {
+		if err := c.breaker.Allow(); err != nil {
+			return "", err
+		}
+	}
+
 	// Marshal payload to JSON
-	jsonData, err := json.Marshal(payload)
+	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	tried := make(map[*endpointState]bool)
+	var sendErr error
+	for endpoint := c.balancer.pick(tried); endpoint != nil; endpoint = c.balancer.pick(tried) {
+		tried[endpoint] = true
+
+		resp, err := c.send(endpoint.URL, request.JobID, jsonData)
+		if err != nil {
+			c.balancer.done(endpoint, false)
+			c.logger.Warn("n8n endpoint unreachable", "url", endpoint.URL, "error", err)
+			sendErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		// Only server errors suggest n8n is down; a rejected request is the
+		// request's fault. Either way the workflow may have seen the job, so
+		// it is not sent elsewhere.
+		healthy := resp.StatusCode < 500
+		c.balancer.done(endpoint, healthy)
+		c.recordResult(healthy)
+
+		// Check response status
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return "", fmt.Errorf("webhook returned non-2xx status: %d", resp.StatusCode)
+		}
+
+		c.logger.Info("Webhook sent successfully", "url", endpoint.URL, "status_code", resp.StatusCode)
+		return endpoint.URL, nil
 	}
 
+	c.recordResult(false)
+	return "", sendErr
+}
+
+// send posts a signed webhook to one endpoint
+func (c *Client) send(webhookURL, jobID string, jsonData []byte) (*http.Response, error) {
 	// Create HTTP request
-	req, err := http.NewRequest("POST", c.webhookURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Webhook-Token", c.secret)
+	c.signer.Sign(req.Header, jsonData)
 
 	// Add request ID for tracing
-	if jobID, ok := payload["job_id"].(string); ok {
+	if jobID != "" {
 		req.Header.Set("X-Request-ID", jobID)
 	}
 
-	c.logger.Info("Sending webhook to n8n", "url", c.webhookURL, "payload_size", len(jsonData))
+	c.logger.Info("Sending webhook to n8n", "url", webhookURL, "payload_size", len(jsonData))
 
 	// Send request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("webhook returned non-2xx status: %d", resp.StatusCode)
-	}
-
-	c.logger.Info("Webhook sent successfully", "status_code", resp.StatusCode)
-	return nil
-}
-
-// VerifyWebhookSignature verifies the webhook signature from n8n callback
-func (c *Client) VerifyWebhookSignature(token string) bool {
-	// For simple token-based authentication
-	return hmac.Equal([]byte(token), []byte(c.secret))
+	return resp, nil
 }
 
-// GenerateSignature generates HMAC signature for payload (if needed for future)
-func (c *Client) GenerateSignature(payload []byte) string {
-	h := hmac.New(sha256.New, []byte(c.secret))
-	h.Write(payload)
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-// VerifyPayloadSignature verifies HMAC signature of payload (if needed for future)
-func (c *Client) VerifyPayloadSignature(payload []byte, signature string) bool {
-	expectedSignature := c.GenerateSignature(payload)
-	return hmac.Equal([]byte(signature), []byte(expectedSignature))
+// recordResult reports the outcome of a webhook to the breaker
+func (c *Client) recordResult(ok bool) {
+	if c.breaker == nil {
+		return
+	}
+	if ok {
+		c.breaker.RecordSuccess()
+	} else {
+		c.breaker.RecordFailure()
+	}
 }
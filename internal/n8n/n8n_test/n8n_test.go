@@ -0,0 +1,329 @@
+package n8n_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ringtonic-backend/internal/contract"
+	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/n8n"
+	"ringtonic-backend/internal/signing"
+)
+
+func newSigner(t *testing.T, secrets string, legacyToken bool) *n8n.Signer {
+	keys, err := signing.ParseKeyring(secrets)
+	require.NoError(t, err)
+	return n8n.NewSigner(keys, time.Minute, legacyToken)
+}
+
+func TestClientSignsWebhooks(t *testing.T) {
+	verifier := newSigner(t, "k1:secret", false)
+	received := make(chan error, 1)
+	workflow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = verifier.Verify(r.Header, body)
+		}
+		assert.Empty(t, r.Header.Get(n8n.HeaderToken))
+		received <- err
+	}))
+	defer workflow.Close()
+
+	client := n8n.New(workflow.URL, newSigner(t, "k1:secret", false), log.New("error"))
+	require.NoError(t, client.Process(&contract.JobRequest{JobID: "job-1"}))
+	assert.NoError(t, <-received)
+
+	// Every request gets a fresh nonce
+	require.NoError(t, client.Process(&contract.JobRequest{JobID: "job-1"}))
+	assert.NoError(t, <-received)
+}
+
+func TestSignerVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	signer := newSigner(t, "k1:secret", false)
+	signer.SetClock(func() time.Time { return now })
+	body := []byte(`{"job_id":"job-1"}`)
+
+	header := http.Header{}
+	signer.Sign(header, body)
+	assert.NoError(t, signer.Verify(header, body))
+	assert.ErrorIs(t, signer.Verify(header, body), n8n.ErrReplayedNonce)
+
+	// Once the tolerance has passed, the request is stale
+	now = now.Add(2 * time.Minute)
+	assert.ErrorIs(t, signer.Verify(header, body), n8n.ErrStaleTimestamp)
+
+	header = http.Header{}
+	signer.Sign(header, body)
+	header.Set(n8n.HeaderNonce, "0123456789abcdef0123")
+	assert.ErrorIs(t, signer.Verify(header, body), n8n.ErrInvalidSignature)
+	header.Set(n8n.HeaderNonce, "short")
+	assert.ErrorIs(t, signer.Verify(header, body), n8n.ErrInvalidSignature)
+
+	assert.ErrorIs(t, signer.Verify(http.Header{n8n.HeaderToken: {"secret"}}, body), n8n.ErrMissingSignature)
+
+	// Legacy mode accepts and sends the static token
+	legacy := newSigner(t, "k1:secret", true)
+	assert.NoError(t, legacy.Verify(http.Header{n8n.HeaderToken: {"secret"}}, body))
+	assert.ErrorIs(t, legacy.Verify(http.Header{n8n.HeaderToken: {"secre"}}, body), n8n.ErrInvalidSignature)
+	header = http.Header{}
+	legacy.Sign(header, body)
+	assert.Equal(t, "secret", header.Get(n8n.HeaderToken))
+	assert.NoError(t, legacy.Verify(header, body))
+}
+
+func TestSignerRotation(t *testing.T) {
+	body := []byte(`{"job_id":"job-1"}`)
+	verifier := newSigner(t, "new:new-secret,old:old-secret", false)
+
+	// Requests are signed with the primary secret and name its key ID
+	header := http.Header{}
+	verifier.Sign(header, body)
+	assert.Equal(t, "new", header.Get(n8n.HeaderKeyID))
+	assert.NoError(t, verifier.Verify(header, body))
+
+	// Senders still on the old secret are accepted, with or without a key ID
+	old := newSigner(t, "old:old-secret", false)
+	header = http.Header{}
+	old.Sign(header, body)
+	assert.NoError(t, verifier.Verify(header, body))
+	header = http.Header{}
+	old.Sign(header, body)
+	header.Del(n8n.HeaderKeyID)
+	assert.NoError(t, verifier.Verify(header, body))
+
+	// A named key must match, and unknown keys never verify
+	header = http.Header{}
+	old.Sign(header, body)
+	header.Set(n8n.HeaderKeyID, "new")
+	assert.ErrorIs(t, verifier.Verify(header, body), n8n.ErrInvalidSignature)
+	header = http.Header{}
+	newSigner(t, "gone:gone-secret", false).Sign(header, body)
+	assert.ErrorIs(t, verifier.Verify(header, body), n8n.ErrInvalidSignature)
+
+	assert.Equal(t, map[string]int64{"new": 1, "old": 2}, verifier.KeyUsage())
+
+	// Legacy tokens are counted under the secret they match
+	legacy := newSigner(t, "new:new-secret,old:old-secret", true)
+	header = http.Header{}
+	legacy.Sign(header, body)
+	assert.Equal(t, "new-secret", header.Get(n8n.HeaderToken))
+	assert.NoError(t, legacy.Verify(http.Header{n8n.HeaderToken: {"old-secret"}}, body))
+	assert.Equal(t, map[string]int64{"new": 0, "old": 1}, legacy.KeyUsage())
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var workflowUp, healthy atomic.Bool
+	var webhooks atomic.Int32
+	workflow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			if !healthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		webhooks.Add(1)
+		if !workflowUp.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer workflow.Close()
+
+	healthURL, err := n8n.DefaultHealthURL(workflow.URL + "/webhook/ringtonic")
+	require.NoError(t, err)
+	assert.Equal(t, workflow.URL+"/healthz", healthURL)
+
+	breaker := n8n.NewBreaker(n8n.BreakerConfig{
+		FailureThreshold: 2,
+		ProbeInterval:    10 * time.Millisecond,
+		HealthURLs:       []string{healthURL},
+	}, log.New("error"))
+	client := n8n.New(workflow.URL+"/webhook/ringtonic", newSigner(t, "k1:secret", false), log.New("error"))
+	client.SetBreaker(breaker)
+	payload := &contract.JobRequest{JobID: "job-1"}
+
+	// Consecutive failures open the circuit, after which nothing is sent
+	assert.Error(t, client.Process(payload))
+	assert.Equal(t, n8n.CircuitClosed, breaker.Status().State)
+	assert.Error(t, client.Process(payload))
+	assert.ErrorIs(t, client.Process(payload), n8n.ErrCircuitOpen)
+	assert.Equal(t, int32(2), webhooks.Load())
+
+	status := breaker.Status()
+	assert.Equal(t, n8n.CircuitOpen, status.State)
+	assert.Equal(t, int64(1), status.Trips)
+	assert.NotNil(t, status.OpenedAt)
+	select {
+	case <-client.Available():
+		t.Fatal("client should be unavailable while the circuit is open")
+	default:
+	}
+
+	// The circuit closes once n8n's health check passes
+	require.Eventually(t, func() bool { return breaker.Status().LastProbeError != "" }, time.Second, 5*time.Millisecond)
+	workflowUp.Store(true)
+	healthy.Store(true)
+	select {
+	case <-client.Available():
+	case <-time.After(time.Second):
+		t.Fatal("circuit did not close")
+	}
+	assert.Equal(t, n8n.CircuitClosed, breaker.Status().State)
+	assert.NoError(t, client.Process(payload))
+	assert.Equal(t, 0, breaker.Status().ConsecutiveFailures)
+}
+
+func TestBreakerProbesEveryEndpoint(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	var healthy atomic.Bool
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer up.Close()
+
+	healthURLs, err := n8n.DefaultHealthURLs([]n8n.Endpoint{
+		{URL: down.URL + "/webhook/ringtonic", Weight: 1},
+		{URL: down.URL + "/webhook/other", Weight: 1},
+		{URL: up.URL + "/webhook/ringtonic", Weight: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{down.URL + "/healthz", up.URL + "/healthz"}, healthURLs)
+
+	breaker := n8n.NewBreaker(n8n.BreakerConfig{
+		FailureThreshold: 1,
+		ProbeInterval:    10 * time.Millisecond,
+		HealthURLs:       healthURLs,
+	}, log.New("error"))
+	breaker.RecordFailure()
+	require.Equal(t, n8n.CircuitOpen, breaker.Status().State)
+
+	require.Eventually(t, func() bool { return breaker.Status().LastProbeError != "" }, time.Second, 5*time.Millisecond)
+	assert.Contains(t, breaker.Status().LastProbeError, down.URL)
+	assert.Contains(t, breaker.Status().LastProbeError, up.URL)
+
+	// One instance answering is enough, as jobs can be balanced to it
+	healthy.Store(true)
+	select {
+	case <-breaker.Available():
+	case <-time.After(time.Second):
+		t.Fatal("circuit did not close")
+	}
+	assert.Equal(t, n8n.CircuitClosed, breaker.Status().State)
+}
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := n8n.ParseEndpoints("http://n8n-a:5678/webhook/ringtonic*3, http://n8n-b:5678/webhook/ringtonic")
+	require.NoError(t, err)
+	assert.Equal(t, []n8n.Endpoint{
+		{URL: "http://n8n-a:5678/webhook/ringtonic", Weight: 3},
+		{URL: "http://n8n-b:5678/webhook/ringtonic", Weight: 1},
+	}, endpoints)
+
+	for _, spec := range []string{"", "n8n-a/webhook", "http://n8n-a:5678/webhook*0", "http://n8n-a:5678/webhook*x"} {
+		_, err := n8n.ParseEndpoints(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestClientBalancesEndpoints(t *testing.T) {
+	var hits [2]atomic.Int32
+	workflows := make([]*httptest.Server, 2)
+	for i := range workflows {
+		i := i
+		workflows[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+		}))
+		defer workflows[i].Close()
+	}
+
+	client, err := n8n.NewBalanced(n8n.BalancerConfig{
+		Endpoints: []n8n.Endpoint{
+			{URL: workflows[0].URL, Weight: 3},
+			{URL: workflows[1].URL, Weight: 1},
+		},
+		Strategy: n8n.RoundRobin,
+		EjectFor: time.Minute,
+	}, newSigner(t, "k1:secret", false), log.New("error"))
+	require.NoError(t, err)
+
+	// Endpoints take turns in proportion to their weights
+	var routed []string
+	for i := 0; i < 8; i++ {
+		endpoint, err := client.ProcessRouted(&contract.JobRequest{JobID: "job-1"})
+		require.NoError(t, err)
+		routed = append(routed, endpoint)
+	}
+	assert.Equal(t, int32(6), hits[0].Load())
+	assert.Equal(t, int32(2), hits[1].Load())
+	assert.Equal(t, []string{workflows[0].URL, workflows[0].URL, workflows[1].URL, workflows[0].URL}, routed[:4])
+
+	// An unreachable endpoint is failed over from and ejected
+	workflows[0].Close()
+	for i := 0; i < 4; i++ {
+		endpoint, err := client.ProcessRouted(&contract.JobRequest{JobID: "job-1"})
+		require.NoError(t, err)
+		assert.Equal(t, workflows[1].URL, endpoint)
+	}
+	assert.Equal(t, int32(6), hits[1].Load())
+
+	statuses := client.Endpoints()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, int64(1), statuses[0].Failures)
+	assert.NotNil(t, statuses[0].EjectedUntil)
+	assert.Nil(t, statuses[1].EjectedUntil)
+	assert.Zero(t, statuses[0].InFlight+statuses[1].InFlight)
+
+	// With every endpoint down, the job fails
+	workflows[1].Close()
+	_, err = client.ProcessRouted(&contract.JobRequest{JobID: "job-1"})
+	assert.Error(t, err)
+}
+
+func TestClientLeastInFlight(t *testing.T) {
+	release := make(chan struct{})
+	var slowHits, fastHits atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowHits.Add(1)
+		<-release
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastHits.Add(1)
+	}))
+	defer fast.Close()
+
+	client, err := n8n.NewBalanced(n8n.BalancerConfig{
+		Endpoints: []n8n.Endpoint{{URL: slow.URL, Weight: 1}, {URL: fast.URL, Weight: 1}},
+		Strategy:  n8n.LeastInFlight,
+	}, newSigner(t, "k1:secret", false), log.New("error"))
+	require.NoError(t, err)
+
+	// While the slow endpoint holds a webhook, new ones go to the other
+	done := make(chan error)
+	go func() {
+		_, err := client.ProcessRouted(&contract.JobRequest{JobID: "job-slow"})
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return slowHits.Load() == 1 }, time.Second, 5*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		endpoint, err := client.ProcessRouted(&contract.JobRequest{JobID: "job-fast"})
+		require.NoError(t, err)
+		assert.Equal(t, fast.URL, endpoint)
+	}
+	assert.Equal(t, int32(3), fastHits.Load())
+
+	close(release)
+	assert.NoError(t, <-done)
+}
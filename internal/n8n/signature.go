@@ -0,0 +1,238 @@
+package n8n
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ringtonic-backend/internal/signing"
+)
+
+// Headers carrying webhook signatures
+const (
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderNonce     = "X-Webhook-Nonce"
+	HeaderSignature = "X-Webhook-Signature"
+	// HeaderKeyID names the secret a request was signed with
+	HeaderKeyID = "X-Webhook-Key-ID"
+	// HeaderToken carries the static secret of the legacy scheme
+	HeaderToken = "X-Webhook-Token"
+)
+
+// signaturePrefix names the algorithm in signature headers
+const signaturePrefix = "sha256="
+
+// Nonces are random strings chosen by the sender
+const (
+	minNonceLength = 16
+	maxNonceLength = 128
+)
+
+var (
+	// ErrMissingSignature is returned when a request carries no signature
+	// and, if the legacy scheme is allowed, no token either
+	ErrMissingSignature = errors.New("missing webhook signature")
+	// ErrInvalidSignature is returned when a signature or token does not verify
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrStaleTimestamp is returned when a signed request is too old, or
+	// dated too far in the future
+	ErrStaleTimestamp = errors.New("webhook timestamp outside tolerance")
+	// ErrReplayedNonce is returned when a signed request's nonce was seen before
+	ErrReplayedNonce = errors.New("webhook nonce already used")
+)
+
+// Signer signs requests sent to n8n and verifies callbacks from it. A
+// request is signed with HMAC-SHA256 over its timestamp, nonce and body,
+// joined by dots. Verification rejects timestamps outside the tolerance and
+// nonces seen within it, so a captured request cannot be replayed.
+//
+// Requests are signed with the keyring's primary secret and accepted under
+// any active one, so secrets can be rotated without a coordinated restart.
+// The signer counts the callbacks verified under each key ID, showing when
+// an old secret has fallen out of use.
+type Signer struct {
+	keys      *signing.Keyring
+	tolerance time.Duration
+	// legacyToken also accepts a static secret in X-Webhook-Token, and
+	// sends the primary one alongside signatures
+	legacyToken bool
+	nonces      *nonceCache
+	now         func() time.Time
+
+	mu    sync.Mutex
+	usage map[string]int64
+}
+
+// NewSigner creates a signer for a keyring of shared secrets. Timestamps
+// may differ from the clock by up to tolerance in either direction.
+func NewSigner(keys *signing.Keyring, tolerance time.Duration, legacyToken bool) *Signer {
+	return &Signer{
+		keys:        keys,
+		tolerance:   tolerance,
+		legacyToken: legacyToken,
+		nonces:      newNonceCache(),
+		now:         time.Now,
+		usage:       make(map[string]int64),
+	}
+}
+
+// SetClock replaces the clock used for timestamps, for tests
+func (s *Signer) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// Sign sets the signature headers for a request body
+func (s *Signer) Sign(header http.Header, body []byte) {
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	nonce := randomNonce()
+
+	kid, signature := s.keys.Sign(signedMessage(timestamp, nonce, body))
+
+	header.Set(HeaderTimestamp, timestamp)
+	header.Set(HeaderNonce, nonce)
+	header.Set(HeaderKeyID, kid)
+	header.Set(HeaderSignature, signaturePrefix+signature)
+	if s.legacyToken {
+		header.Set(HeaderToken, string(s.keys.Primary().Secret))
+	}
+}
+
+// Verify checks a request's signature headers against its body. A request
+// naming its key ID is checked against that secret only; one that does not
+// is accepted under any active secret. With the legacy scheme allowed, an
+// unsigned request may instead present one of the secrets itself.
+func (s *Signer) Verify(header http.Header, body []byte) error {
+	signature := header.Get(HeaderSignature)
+	if signature == "" {
+		if token := header.Get(HeaderToken); s.legacyToken && token != "" {
+			kid, ok := s.keys.MatchSecret([]byte(token))
+			if !ok {
+				return ErrInvalidSignature
+			}
+			s.recordUsage(kid)
+			return nil
+		}
+		return ErrMissingSignature
+	}
+
+	timestamp := header.Get(HeaderTimestamp)
+	nonce := header.Get(HeaderNonce)
+	if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+		return fmt.Errorf("%w: nonce must be %d to %d characters", ErrInvalidSignature, minNonceLength, maxNonceLength)
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+
+	kid, ok := s.verifySignature(header.Get(HeaderKeyID), signedMessage(timestamp, nonce, body), strings.TrimPrefix(signature, signaturePrefix))
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	// Freshness is only checked once the request is known to be genuine,
+	// so forged requests cannot fill the nonce cache
+	now := s.now()
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-s.tolerance)) || signedAt.After(now.Add(s.tolerance)) {
+		return ErrStaleTimestamp
+	}
+	// A nonce need only be remembered for as long as its timestamp passes
+	if !s.nonces.add(nonce, signedAt.Add(s.tolerance), now) {
+		return ErrReplayedNonce
+	}
+	s.recordUsage(kid)
+	return nil
+}
+
+// KeyUsage returns the number of callbacks verified under each active key
+// ID since the signer was created
+func (s *Signer) KeyUsage() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make(map[string]int64)
+	for _, kid := range s.keys.IDs() {
+		usage[kid] = s.usage[kid]
+	}
+	return usage
+}
+
+// verifySignature checks a signature under the named key, or under each
+// active key if none is named, returning the ID of the key that verifies
+func (s *Signer) verifySignature(kid string, message []byte, signature string) (string, bool) {
+	if kid != "" {
+		return kid, s.keys.Verify(kid, message, signature)
+	}
+	for _, id := range s.keys.IDs() {
+		if s.keys.Verify(id, message, signature) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// recordUsage counts a callback verified under a key
+func (s *Signer) recordUsage(kid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage[kid]++
+}
+
+// signedMessage joins the signed parts of a request
+func signedMessage(timestamp, nonce string, body []byte) []byte {
+	message := make([]byte, 0, len(timestamp)+len(nonce)+len(body)+2)
+	message = append(message, timestamp...)
+	message = append(message, '.')
+	message = append(message, nonce...)
+	message = append(message, '.')
+	return append(message, body...)
+}
+
+// randomNonce returns a random hex string
+func randomNonce() string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("failed to generate nonce: %v", err))
+	}
+	return hex.EncodeToString(nonce)
+}
+
+// nonceCache remembers nonces until their requests would be rejected as
+// stale anyway. Expired nonces are swept on insertion.
+type nonceCache struct {
+	mu        sync.Mutex
+	expiries  map[string]time.Time
+	nextSweep time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{expiries: make(map[string]time.Time)}
+}
+
+// add records a nonce until expiry, returning false if it is already known
+func (c *nonceCache) add(nonce string, expiry, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !now.Before(c.nextSweep) {
+		for seen, seenExpiry := range c.expiries {
+			if seenExpiry.Before(now) {
+				delete(c.expiries, seen)
+			}
+		}
+		c.nextSweep = now.Add(time.Minute)
+	}
+
+	if seenExpiry, ok := c.expiries[nonce]; ok && !seenExpiry.Before(now) {
+		return false
+	}
+	c.expiries[nonce] = expiry
+	return true
+}
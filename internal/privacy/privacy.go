@@ -0,0 +1,381 @@
+package privacy
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ringtonic-backend/internal/files"
+	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/store"
+)
+
+// StoreInterface defines the database operations needed for data requests
+type StoreInterface interface {
+	ListJobsByUserID(userID string) ([]*store.Job, error)
+	ListRingtonesByJobID(jobID string) ([]*store.Ringtone, error)
+	EraseJob(requestID, jobID string, filesDeleted int) ([]string, error)
+	ListSubjectArtifacts(subjectHash string) ([]*store.DataRequest, error)
+	EraseDataRequestArtifact(requestID, artifactRequestID string) error
+	CreateDataRequest(req *store.DataRequest) error
+	GetDataRequest(id string) (*store.DataRequest, error)
+	GetOpenDataRequest(kind, userID string) (*store.DataRequest, error)
+	ListOpenDataRequests() ([]*store.DataRequest, error)
+	UpdateDataRequestStatus(id, status string, errorMessage *string) error
+	SetDataRequestProgress(id string, jobs, ringtones, files int) error
+	CompleteDataRequest(id string, artifactPath *string) error
+}
+
+// FileStoreInterface defines the file operations needed for data requests
+type FileStoreInterface interface {
+	OpenFile(filename string) (io.ReadCloser, error)
+	SaveFileWithOptions(filename string, content io.Reader, opts files.SaveOptions) (*files.SavedFile, error)
+	DeleteFile(filename string) error
+	DeleteBlob(hash string) error
+}
+
+// Manager runs user data erasure and export requests
+type Manager struct {
+	store   StoreInterface
+	files   FileStoreInterface
+	logger  *log.Logger
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// Receipt is the auditable record returned for a data request
+type Receipt struct {
+	RequestID          string     `json:"request_id"`
+	Kind               string     `json:"kind"`
+	SubjectHash        string     `json:"subject_sha256"`
+	Status             string     `json:"status"`
+	JobsProcessed      int        `json:"jobs_processed"`
+	RingtonesProcessed int        `json:"ringtones_processed"`
+	FilesProcessed     int        `json:"files_processed"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	CompletedAt        *time.Time `json:"completed_at,omitempty"`
+	DownloadURL        *string    `json:"download_url,omitempty"`
+	Error              *string    `json:"error,omitempty"`
+}
+
+// exportRecord is a job and its ringtones as written to an export archive
+type exportRecord struct {
+	Job       *store.Job        `json:"job"`
+	Ringtones []*store.Ringtone `json:"ringtones"`
+}
+
+// New creates a new data request manager
+func New(store StoreInterface, files FileStoreInterface, logger *log.Logger) *Manager {
+	return &Manager{
+		store:   store,
+		files:   files,
+		logger:  logger,
+		running: make(map[string]bool),
+	}
+}
+
+// SubjectHash returns the hash recorded in receipts in place of the user ID
+func SubjectHash(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestErasure creates an erasure request for a user, or returns the
+// unfinished one so that it can be resumed
+func (m *Manager) RequestErasure(userID string) (*store.DataRequest, error) {
+	return m.request(store.DataRequestErasure, userID)
+}
+
+// RequestExport creates an export request for a user, or returns the
+// unfinished one so that it can be resumed
+func (m *Manager) RequestExport(userID string) (*store.DataRequest, error) {
+	return m.request(store.DataRequestExport, userID)
+}
+
+// request creates or reuses a data request of the given kind
+func (m *Manager) request(kind, userID string) (*store.DataRequest, error) {
+	existing, err := m.store.GetOpenDataRequest(kind, userID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		m.logger.Info("Resuming data request", "request_id", existing.ID, "kind", kind)
+		return existing, nil
+	}
+
+	uid := userID
+	req := &store.DataRequest{
+		ID:          uuid.New().String(),
+		Kind:        kind,
+		UserID:      &uid,
+		SubjectHash: SubjectHash(userID),
+		Status:      store.DataRequestPending,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if err := m.store.CreateDataRequest(req); err != nil {
+		return nil, err
+	}
+
+	m.logger.Info("Data request created", "request_id", req.ID, "kind", kind, "subject_hash", req.SubjectHash)
+	return req, nil
+}
+
+// Get retrieves a data request by ID
+func (m *Manager) Get(id string) (*store.DataRequest, error) {
+	return m.store.GetDataRequest(id)
+}
+
+// Resume runs every data request left unfinished by a previous process
+func (m *Manager) Resume() {
+	reqs, err := m.store.ListOpenDataRequests()
+	if err != nil {
+		m.logger.Error("Failed to list unfinished data requests", "error", err)
+		return
+	}
+
+	for _, req := range reqs {
+		if err := m.Run(req.ID); err != nil {
+			m.logger.Error("Failed to resume data request", "request_id", req.ID, "error", err)
+		}
+	}
+}
+
+// Run processes a data request to completion. Running a request that is
+// already in progress in this process is a no-op.
+func (m *Manager) Run(id string) error {
+	m.mu.Lock()
+	if m.running[id] {
+		m.mu.Unlock()
+		return nil
+	}
+	m.running[id] = true
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.running, id)
+		m.mu.Unlock()
+	}()
+
+	req, err := m.store.GetDataRequest(id)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return fmt.Errorf("data request not found")
+	}
+	if req.Status == store.DataRequestCompleted || req.UserID == nil {
+		return nil
+	}
+
+	if err := m.store.UpdateDataRequestStatus(id, store.DataRequestRunning, nil); err != nil {
+		return err
+	}
+
+	switch req.Kind {
+	case store.DataRequestErasure:
+		err = m.runErasure(req)
+	case store.DataRequestExport:
+		err = m.runExport(req)
+	default:
+		err = fmt.Errorf("unknown data request kind: %s", req.Kind)
+	}
+
+	if err != nil {
+		errorMsg := err.Error()
+		if updateErr := m.store.UpdateDataRequestStatus(id, store.DataRequestFailed, &errorMsg); updateErr != nil {
+			m.logger.Error("Failed to mark data request as failed", "request_id", id, "error", updateErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// runErasure deletes a user's files and ringtones and anonymizes their jobs.
+// Each job is erased in its own transaction, so an interrupted run resumes
+// with the jobs that still carry the user ID. Archives of earlier exports,
+// which copy all of it, are deleted too.
+func (m *Manager) runErasure(req *store.DataRequest) error {
+	jobs, err := m.store.ListJobsByUserID(*req.UserID)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		ringtones, err := m.store.ListRingtonesByJobID(job.ID)
+		if err != nil {
+			return err
+		}
+
+		// Files stored before content addressing belong to a single
+		// ringtone and can be removed straight away
+		filesDeleted := 0
+		for _, ringtone := range ringtones {
+			if ringtone.BlobHash != nil {
+				continue
+			}
+			if err := m.files.DeleteFile(ringtone.StorageKey()); err != nil {
+				return fmt.Errorf("failed to delete file for job %s: %w", job.ID, err)
+			}
+			filesDeleted++
+		}
+
+		// Shared blobs are only removed once their last reference is gone.
+		// An interruption here leaves an unreferenced blob in storage,
+		// never a row pointing at deleted content.
+		released, err := m.store.EraseJob(req.ID, job.ID, filesDeleted)
+		if err != nil {
+			return err
+		}
+		for _, hash := range released {
+			if err := m.files.DeleteBlob(hash); err != nil {
+				m.logger.Warn("Failed to delete released blob", "hash", hash, "error", err)
+			}
+		}
+	}
+
+	// As for blobs, the reference goes first, so an interruption leaves an
+	// archive for reconciliation rather than a receipt pointing at nothing
+	exports, err := m.store.ListSubjectArtifacts(req.SubjectHash)
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if err := m.store.EraseDataRequestArtifact(req.ID, export.ID); err != nil {
+			return err
+		}
+		if err := m.files.DeleteFile(*export.ArtifactPath); err != nil {
+			m.logger.Warn("Failed to delete export archive", "request_id", export.ID, "error", err)
+		}
+	}
+
+	if err := m.store.CompleteDataRequest(req.ID, nil); err != nil {
+		return err
+	}
+
+	m.logger.Info("Data erasure completed", "request_id", req.ID, "jobs", len(jobs))
+	return nil
+}
+
+// runExport writes a ZIP archive of a user's records and audio files.
+// The archive is rebuilt from scratch if a previous run was interrupted.
+func (m *Manager) runExport(req *store.DataRequest) error {
+	jobs, err := m.store.ListJobsByUserID(*req.UserID)
+	if err != nil {
+		return err
+	}
+
+	records := make([]exportRecord, 0, len(jobs))
+	ringtoneCount := 0
+	for _, job := range jobs {
+		ringtones, err := m.store.ListRingtonesByJobID(job.ID)
+		if err != nil {
+			return err
+		}
+		records = append(records, exportRecord{Job: job, Ringtones: ringtones})
+		ringtoneCount += len(ringtones)
+	}
+
+	artifactPath := path.Join("exports", req.ID+".zip")
+
+	pr, pw := io.Pipe()
+	filesWritten := make(chan int, 1)
+	go func() {
+		n, err := m.writeExportArchive(pw, records)
+		filesWritten <- n
+		pw.CloseWithError(err)
+	}()
+
+	// Archives hold many files, so the per-file size limit does not apply
+	if _, err := m.files.SaveFileWithOptions(artifactPath, pr, files.SaveOptions{Unbounded: true}); err != nil {
+		pr.CloseWithError(err)
+		<-filesWritten
+		return fmt.Errorf("failed to write export archive: %w", err)
+	}
+
+	if err := m.store.SetDataRequestProgress(req.ID, len(jobs), ringtoneCount, <-filesWritten); err != nil {
+		return err
+	}
+	if err := m.store.CompleteDataRequest(req.ID, &artifactPath); err != nil {
+		return err
+	}
+
+	m.logger.Info("Data export completed", "request_id", req.ID, "jobs", len(jobs))
+	return nil
+}
+
+// writeExportArchive streams the export ZIP to w and returns the number of
+// audio files included. Files that are already missing from storage are
+// skipped rather than failing the export.
+func (m *Manager) writeExportArchive(w io.Writer, records []exportRecord) (int, error) {
+	zw := zip.NewWriter(w)
+
+	recordsFile, err := zw.Create("records.json")
+	if err != nil {
+		return 0, err
+	}
+	encoder := json.NewEncoder(recordsFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(records); err != nil {
+		return 0, err
+	}
+
+	filesWritten := 0
+	for _, record := range records {
+		for _, ringtone := range record.Ringtones {
+			file, err := m.files.OpenFile(ringtone.StorageKey())
+			if err != nil {
+				m.logger.Warn("Skipping missing file in export", "job_id", record.Job.ID, "file_name", ringtone.FileName)
+				continue
+			}
+
+			entry, err := zw.Create(path.Join("audio", record.Job.ID, path.Base(ringtone.DownloadName())))
+			if err == nil {
+				_, err = io.Copy(entry, file)
+			}
+			file.Close()
+			if err != nil {
+				return filesWritten, err
+			}
+			filesWritten++
+		}
+	}
+
+	return filesWritten, zw.Close()
+}
+
+// NewReceipt builds the receipt for a data request
+func NewReceipt(req *store.DataRequest) *Receipt {
+	receipt := &Receipt{
+		RequestID:          req.ID,
+		Kind:               req.Kind,
+		SubjectHash:        req.SubjectHash,
+		Status:             req.Status,
+		JobsProcessed:      req.JobsProcessed,
+		RingtonesProcessed: req.RingtonesProcessed,
+		FilesProcessed:     req.FilesProcessed,
+		CreatedAt:          req.CreatedAt,
+		UpdatedAt:          req.UpdatedAt,
+		CompletedAt:        req.CompletedAt,
+		Error:              req.ErrorMessage,
+	}
+
+	if req.Kind == store.DataRequestExport && req.Status == store.DataRequestCompleted {
+		downloadURL := fmt.Sprintf("/api/v1/data-requests/%s/download", req.ID)
+		receipt.DownloadURL = &downloadURL
+	}
+
+	return receipt
+}
@@ -0,0 +1,331 @@
+package processor
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ringtonic-backend/internal/contract"
+	"ringtonic-backend/internal/jobs"
+	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/store"
+)
+
+var (
+	// ErrTimeout is returned when a job's commands run past the timeout
+	ErrTimeout = errors.New("processing timed out")
+	// ErrNoCompleter is returned when jobs are dispatched before SetCompleter
+	ErrNoCompleter = errors.New("local processor has no completer")
+)
+
+// stderrLimit bounds how much of a command's stderr is kept for errors
+const stderrLimit = 4096
+
+// formatPattern restricts output formats to plain file extensions
+var formatPattern = regexp.MustCompile(`^[a-z0-9]{1,8}$`)
+
+// Completer receives the outcome of locally processed jobs. It is
+// implemented by jobs.Manager, so local results take the same completion
+// path as the n8n workflow's.
+type Completer interface {
+	OpenSource(jobID string) (io.ReadCloser, error)
+	HandleUpload(req *jobs.UploadRequest, content io.Reader) (*store.Ringtone, error)
+	HandleFormatUpload(req *jobs.UploadRequest, format string, content io.Reader) (*store.RingtoneAsset, error)
+	HandleCallback(req *jobs.CallbackRequest) error
+}
+
+// LocalConfig configures the local processor
+type LocalConfig struct {
+	// YTDLPPath and FFmpegPath name the commands, resolved through PATH
+	// unless they contain a slash
+	YTDLPPath  string
+	FFmpegPath string
+	// WorkDir holds a temporary directory per job; empty uses the system default
+	WorkDir string
+	// Timeout bounds all commands of one job; zero disables it
+	Timeout time.Duration
+	// Concurrency limits the number of jobs processed at once
+	Concurrency int
+}
+
+// Local processes jobs in-process with yt-dlp and ffmpeg instead of the
+// n8n workflow. Each job runs in its own temporary directory, which is
+// removed when the job finishes.
+type Local struct {
+	config    LocalConfig
+	completer Completer
+	slots     chan struct{}
+	logger    *log.Logger
+}
+
+// localJob is the part of a job request the local processor uses
+type localJob struct {
+	JobID     string
+	SourceURL string
+	Options   store.JobOptions
+}
+
+// NewLocal creates a local processor
+func NewLocal(config LocalConfig, logger *log.Logger) *Local {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	return &Local{
+		config: config,
+		slots:  make(chan struct{}, config.Concurrency),
+		logger: logger,
+	}
+}
+
+// SetCompleter sets where results are reported. It must be called before
+// the first job is dispatched.
+func (l *Local) SetCompleter(completer Completer) {
+	l.completer = completer
+}
+
+// Process starts processing a job in the background
+func (l *Local) Process(request *contract.JobRequest) error {
+	if l.completer == nil {
+		return ErrNoCompleter
+	}
+
+	if request.JobID == "" {
+		return fmt.Errorf("invalid payload: missing job_id")
+	}
+	job := &localJob{JobID: request.JobID, SourceURL: request.SourceURL}
+	if request.Options != nil {
+		job.Options = *request.Options
+	}
+
+	go l.run(job)
+	return nil
+}
+
+// run processes a job and reports its outcome
+func (l *Local) run(job *localJob) {
+	l.slots <- struct{}{}
+	defer func() { <-l.slots }()
+
+	logger := l.logger.WithJobID(job.JobID)
+	logger.Info("Processing job locally")
+
+	ctx := context.Background()
+	if l.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.config.Timeout)
+		defer cancel()
+	}
+
+	dir, err := os.MkdirTemp(l.config.WorkDir, "job-*")
+	if err != nil {
+		l.fail(job.JobID, fmt.Errorf("failed to create work directory: %w", err))
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	outputs, err := l.produce(ctx, dir, job)
+	if err != nil {
+		l.fail(job.JobID, err)
+		return
+	}
+
+	// The first output completes the job; the others are renditions of it
+	err = l.deliver(job, outputs[0], func(req *jobs.UploadRequest, content io.Reader) error {
+		_, err := l.completer.HandleUpload(req, content)
+		return err
+	})
+	switch {
+	case errors.Is(err, jobs.ErrJobNotPending):
+		logger.Warn("Job finished elsewhere, discarding local output")
+		return
+	case err != nil:
+		l.fail(job.JobID, fmt.Errorf("failed to store output: %w", err))
+		return
+	}
+
+	for _, output := range outputs[1:] {
+		format := strings.TrimPrefix(filepath.Ext(output), ".")
+		err := l.deliver(job, output, func(req *jobs.UploadRequest, content io.Reader) error {
+			_, err := l.completer.HandleFormatUpload(req, format, content)
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to store output format", "format", format, "error", err)
+		}
+	}
+
+	logger.Info("Job processed locally", "formats", len(outputs))
+}
+
+// deliver hands one of a job's output files to the completer
+func (l *Local) deliver(job *localJob, output string, handle func(*jobs.UploadRequest, io.Reader) error) error {
+	file, err := os.Open(output)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer file.Close()
+
+	return handle(&jobs.UploadRequest{
+		JobID:           job.JobID,
+		FileName:        filepath.Base(output),
+		DurationSeconds: job.Options.DurationSeconds,
+	}, file)
+}
+
+// produce fetches a job's source into dir and cuts the ringtone from it in
+// each requested format, returning the paths of the output files with the
+// primary format first
+func (l *Local) produce(ctx context.Context, dir string, job *localJob) ([]string, error) {
+	formats := job.Options.Formats
+	if len(formats) == 0 {
+		formats = []string{cmp.Or(job.Options.Format, "mp3")}
+	}
+	for _, format := range formats {
+		if !formatPattern.MatchString(format) {
+			return nil, fmt.Errorf("unsupported format: %q", format)
+		}
+	}
+
+	source := filepath.Join(dir, "source")
+	content, err := l.completer.OpenSource(job.JobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source: %w", err)
+	}
+	if content != nil {
+		err = copyFile(source, content)
+		content.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy source: %w", err)
+		}
+	} else {
+		err = l.command(ctx, dir, l.config.YTDLPPath,
+			"--no-playlist", "--no-part", "-f", "bestaudio/best", "-o", source, "--", job.SourceURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	outputs := make([]string, len(formats))
+	for i, format := range formats {
+		outputs[i] = filepath.Join(dir, "ringtone."+format)
+		if err := l.command(ctx, dir, l.config.FFmpegPath, ffmpegArgs(source, outputs[i], &job.Options)...); err != nil {
+			return nil, err
+		}
+	}
+	return outputs, nil
+}
+
+// Limits for the loudnorm filter when normalizing: true peak ceiling in
+// dBTP and the loudness range it aims to keep within, in LU
+const (
+	normalizeTruePeakDBTP = -1.5
+	normalizeRangeLU      = 11.0
+)
+
+// ffmpegArgs builds the arguments that cut, fade and normalize a ringtone.
+// A fade out needs the clip's duration, so it only applies when a duration
+// is set. Normalization runs last so that it measures the faded clip.
+func ffmpegArgs(source, output string, options *store.JobOptions) []string {
+	args := []string{"-hide_banner", "-nostdin", "-y"}
+	// Seeking before the input restarts timestamps at zero for the filters
+	if options.StartSeconds != nil {
+		args = append(args, "-ss", strconv.Itoa(*options.StartSeconds))
+	}
+	args = append(args, "-i", source)
+	if options.DurationSeconds != nil {
+		args = append(args, "-t", strconv.Itoa(*options.DurationSeconds))
+	}
+
+	var filters []string
+	if options.FadeIn {
+		filters = append(filters, "afade=t=in:st=0:d=1")
+	}
+	if options.FadeOut && options.DurationSeconds != nil {
+		filters = append(filters, fmt.Sprintf("afade=t=out:st=%d:d=1", max(*options.DurationSeconds-1, 0)))
+	}
+	if options.Normalize && options.TargetLUFS != nil {
+		filters = append(filters, fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", *options.TargetLUFS, normalizeTruePeakDBTP, normalizeRangeLU))
+	}
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+
+	return append(args, "-vn", output)
+}
+
+// command runs a command inside a job's work directory. The command sees
+// the work directory as its home and temporary directory and inherits no
+// environment but PATH. Failures carry the end of its stderr.
+func (l *Local) command(ctx context.Context, dir, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir, "TMPDIR=" + dir}
+	stderr := &tailBuffer{limit: stderrLimit}
+	cmd.Stderr = stderr
+	// Children that keep stderr open must not hold up a cancelled job
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %s ran past %s", ErrTimeout, filepath.Base(name), l.config.Timeout)
+	}
+	if err != nil {
+		message := strings.TrimSpace(string(stderr.data))
+		if message == "" {
+			return fmt.Errorf("%s failed: %w", filepath.Base(name), err)
+		}
+		return fmt.Errorf("%s failed: %w: %s", filepath.Base(name), err, message)
+	}
+	return nil
+}
+
+// fail reports a job as failed through the callback path
+func (l *Local) fail(jobID string, cause error) {
+	logger := l.logger.WithJobID(jobID)
+	logger.Error("Local processing failed", "error", cause)
+
+	err := l.completer.HandleCallback(&jobs.CallbackRequest{
+		JobID:    jobID,
+		Status:   store.StatusFailed,
+		Metadata: &contract.CallbackMetadata{Error: cause.Error()},
+	})
+	if err != nil {
+		logger.Error("Failed to record job failure", "error", err)
+	}
+}
+
+// copyFile writes content to a new file at path
+func copyFile(path string, content io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,286 @@
+package processor_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ringtonic-backend/internal/contract"
+	"ringtonic-backend/internal/jobs"
+	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/processor"
+	"ringtonic-backend/internal/store"
+)
+
+// upload is an output file reported to the fake completer
+type upload struct {
+	req     *jobs.UploadRequest
+	format  string
+	content string
+}
+
+// fakeCompleter records the outcome of jobs
+type fakeCompleter struct {
+	sources   map[string]string
+	uploads   chan upload
+	formats   chan upload
+	callbacks chan *jobs.CallbackRequest
+}
+
+func newFakeCompleter() *fakeCompleter {
+	return &fakeCompleter{
+		sources:   map[string]string{},
+		uploads:   make(chan upload, 1),
+		formats:   make(chan upload, 8),
+		callbacks: make(chan *jobs.CallbackRequest, 1),
+	}
+}
+
+func (f *fakeCompleter) OpenSource(jobID string) (io.ReadCloser, error) {
+	source, ok := f.sources[jobID]
+	if !ok {
+		return nil, nil
+	}
+	return io.NopCloser(strings.NewReader(source)), nil
+}
+
+func (f *fakeCompleter) HandleUpload(req *jobs.UploadRequest, content io.Reader) (*store.Ringtone, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	f.uploads <- upload{req: req, content: string(data)}
+	return &store.Ringtone{JobID: req.JobID}, nil
+}
+
+func (f *fakeCompleter) HandleFormatUpload(req *jobs.UploadRequest, format string, content io.Reader) (*store.RingtoneAsset, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	f.formats <- upload{req: req, format: format, content: string(data)}
+	return &store.RingtoneAsset{Kind: store.FormatAsset(format)}, nil
+}
+
+func (f *fakeCompleter) HandleCallback(req *jobs.CallbackRequest) error {
+	f.callbacks <- req
+	return nil
+}
+
+// stubCommand writes an executable shell script standing in for a tool
+func stubCommand(t *testing.T, name, script string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+	return path
+}
+
+// The stubs copy their input to their output, which is always the last
+// argument for ffmpeg and follows -o for yt-dlp, and record their arguments
+const (
+	ytdlpStub = `while [ $# -gt 0 ]; do
+  if [ "$1" = "-o" ]; then out="$2"; fi
+  url="$1"
+  shift
+done
+echo "downloaded $url" > "$out"
+`
+	ffmpegStub = `for arg; do out="$arg"; done
+while [ $# -gt 0 ]; do
+  if [ "$1" = "-i" ]; then in="$2"; fi
+  shift
+done
+{ cat "$in"; echo "$HOME"; } > "$out"
+`
+)
+
+func newLocal(t *testing.T, config processor.LocalConfig) (*processor.Local, *fakeCompleter) {
+	if config.YTDLPPath == "" {
+		config.YTDLPPath = stubCommand(t, "yt-dlp", ytdlpStub)
+	}
+	if config.FFmpegPath == "" {
+		config.FFmpegPath = stubCommand(t, "ffmpeg", ffmpegStub)
+	}
+	if config.WorkDir == "" {
+		config.WorkDir = t.TempDir()
+	}
+	local := processor.NewLocal(config, log.New("error"))
+	completer := newFakeCompleter()
+	local.SetCompleter(completer)
+	return local, completer
+}
+
+func waitForUpload(t *testing.T, completer *fakeCompleter) upload {
+	select {
+	case u := <-completer.uploads:
+		return u
+	case req := <-completer.callbacks:
+		t.Fatalf("job failed: %v", req.Metadata.Error)
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not finish")
+	}
+	return upload{}
+}
+
+func waitForFailure(t *testing.T, completer *fakeCompleter) *jobs.CallbackRequest {
+	select {
+	case req := <-completer.callbacks:
+		return req
+	case <-completer.uploads:
+		t.Fatal("job succeeded unexpectedly")
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not finish")
+	}
+	return nil
+}
+
+func TestLocalProcessesURLSource(t *testing.T) {
+	workDir := t.TempDir()
+	local, completer := newLocal(t, processor.LocalConfig{WorkDir: workDir, Timeout: 10 * time.Second})
+
+	duration := 20
+	require.NoError(t, local.Process(&contract.JobRequest{
+		JobID:     "job-1",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{DurationSeconds: &duration, FadeIn: true, Format: "m4a"},
+	}))
+
+	u := waitForUpload(t, completer)
+	assert.Equal(t, "job-1", u.req.JobID)
+	assert.Equal(t, "ringtone.m4a", u.req.FileName)
+	assert.Equal(t, &duration, u.req.DurationSeconds)
+	lines := strings.Split(strings.TrimSpace(u.content), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "downloaded https://www.youtube.com/watch?v=test", lines[0])
+	// Commands run with the job's work directory as their home
+	assert.True(t, strings.HasPrefix(lines[1], workDir))
+
+	// The work directory is removed once the job is done
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(workDir)
+		return err == nil && len(entries) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestLocalProcessesUploadedSource(t *testing.T) {
+	local, completer := newLocal(t, processor.LocalConfig{
+		YTDLPPath: stubCommand(t, "yt-dlp", "echo 'must not download' >&2\nexit 1\n"),
+	})
+	completer.sources["job-1"] = "uploaded audio\n"
+
+	require.NoError(t, local.Process(&contract.JobRequest{
+		JobID:     "job-1",
+		SourceURL: "http://backend:8080/api/v1/sources/job-1?token=secret",
+	}))
+
+	u := waitForUpload(t, completer)
+	assert.Equal(t, "ringtone.mp3", u.req.FileName)
+	assert.True(t, strings.HasPrefix(u.content, "uploaded audio\n"))
+}
+
+func TestLocalProducesEveryFormat(t *testing.T) {
+	local, completer := newLocal(t, processor.LocalConfig{})
+
+	require.NoError(t, local.Process(&contract.JobRequest{
+		JobID:     "job-1",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{Format: "m4r", Formats: []string{"m4r", "ogg", "mp3"}},
+	}))
+
+	// The primary format completes the job and the others follow it
+	u := waitForUpload(t, completer)
+	assert.Equal(t, "ringtone.m4r", u.req.FileName)
+
+	var formats []string
+	for range 2 {
+		select {
+		case f := <-completer.formats:
+			assert.Equal(t, "ringtone."+f.format, f.req.FileName)
+			formats = append(formats, f.format)
+		case <-time.After(5 * time.Second):
+			t.Fatal("formats were not delivered")
+		}
+	}
+	assert.Equal(t, []string{"ogg", "mp3"}, formats)
+}
+
+func TestLocalReportsCommandFailures(t *testing.T) {
+	local, completer := newLocal(t, processor.LocalConfig{
+		YTDLPPath: stubCommand(t, "yt-dlp", "echo 'ERROR: Video unavailable' >&2\nexit 1\n"),
+	})
+
+	require.NoError(t, local.Process(&contract.JobRequest{
+		JobID:     "job-1",
+		SourceURL: "https://www.youtube.com/watch?v=gone",
+	}))
+
+	req := waitForFailure(t, completer)
+	assert.Equal(t, "job-1", req.JobID)
+	assert.Equal(t, store.StatusFailed, req.Status)
+	assert.Contains(t, req.Metadata.Error, "yt-dlp failed")
+	assert.Contains(t, req.Metadata.Error, "ERROR: Video unavailable")
+}
+
+func TestLocalTimesOut(t *testing.T) {
+	local, completer := newLocal(t, processor.LocalConfig{
+		FFmpegPath: stubCommand(t, "ffmpeg", "exec sleep 10\n"),
+		Timeout:    200 * time.Millisecond,
+	})
+
+	require.NoError(t, local.Process(&contract.JobRequest{
+		JobID:     "job-1",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+	}))
+
+	req := waitForFailure(t, completer)
+	assert.Contains(t, req.Metadata.Error, processor.ErrTimeout.Error())
+	assert.Contains(t, req.Metadata.Error, "ffmpeg")
+}
+
+func TestLocalRejectsInvalidJobs(t *testing.T) {
+	local, completer := newLocal(t, processor.LocalConfig{})
+
+	// Payloads without a job cannot be reported back
+	assert.Error(t, local.Process(&contract.JobRequest{SourceURL: "https://example.com"}))
+
+	// Formats become file names, so only plain extensions are accepted
+	require.NoError(t, local.Process(&contract.JobRequest{
+		JobID:     "job-1",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{Format: "../mp3"},
+	}))
+	req := waitForFailure(t, completer)
+	assert.Contains(t, req.Metadata.Error, "unsupported format")
+
+	// Jobs cannot be dispatched before results have somewhere to go
+	unwired := processor.NewLocal(processor.LocalConfig{}, log.New("error"))
+	assert.ErrorIs(t, unwired.Process(&contract.JobRequest{JobID: "job-2"}), processor.ErrNoCompleter)
+}
+
+func TestLocalNormalizesLoudness(t *testing.T) {
+	// The stub writes the filter chain it was given as its output
+	local, completer := newLocal(t, processor.LocalConfig{
+		FFmpegPath: stubCommand(t, "ffmpeg", `for arg; do out="$arg"; done
+while [ $# -gt 0 ]; do
+  if [ "$1" = "-af" ]; then filters="$2"; fi
+  shift
+done
+echo "$filters" > "$out"
+`),
+	})
+
+	target := -14.0
+	require.NoError(t, local.Process(&contract.JobRequest{
+		JobID:     "job-1",
+		SourceURL: "https://www.youtube.com/watch?v=test",
+		Options:   &store.JobOptions{FadeIn: true, Normalize: true, TargetLUFS: &target},
+	}))
+
+	u := waitForUpload(t, completer)
+	assert.Equal(t, "afade=t=in:st=0:d=1,loudnorm=I=-14:TP=-1.5:LRA=11\n", u.content)
+}
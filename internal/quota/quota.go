@@ -0,0 +1,188 @@
+package quota
+
+import (
+	"errors"
+	"sync"
+
+	"ringtonic-backend/internal/files"
+	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/store"
+)
+
+var (
+	// ErrQuotaExceeded is returned when a user has used up their storage quota
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	// ErrStorageLow is returned while free disk space is below the low watermark
+	ErrStorageLow = errors.New("storage is low on free space")
+)
+
+// StoreInterface defines the database operations needed for quota checks
+type StoreInterface interface {
+	GetUserUsage(userID string) (*store.Usage, error)
+	CountPendingJobs(userID string) (int, error)
+	GetStorageUsage() (*store.Usage, error)
+}
+
+// DiskInterface reports the capacity of local storage
+type DiskInterface interface {
+	DiskUsage() (*files.DiskUsage, error)
+}
+
+// Limits configures quotas and watermarks. Zero values disable a limit.
+type Limits struct {
+	// UserBytes is the maximum total size of a user's ringtones
+	UserBytes int64 `json:"user_bytes"`
+	// UserFiles is the maximum number of ringtones a user may keep,
+	// counting jobs that have yet to produce theirs
+	UserFiles int `json:"user_files"`
+	// LowWatermarkPercent stops accepting jobs when free disk space drops below it
+	LowWatermarkPercent float64 `json:"low_watermark_percent"`
+	// HighWatermarkPercent resumes accepting jobs once free disk space recovers
+	// above it; it defaults to the low watermark
+	HighWatermarkPercent float64 `json:"high_watermark_percent"`
+}
+
+// Guard admits or rejects new jobs based on user quotas and free disk space
+type Guard struct {
+	store  StoreInterface
+	disk   DiskInterface
+	limits Limits
+	logger *log.Logger
+
+	mu      sync.Mutex
+	lowDisk bool
+}
+
+// Status is the storage usage reported in metrics
+type Status struct {
+	AcceptingJobs bool             `json:"accepting_jobs"`
+	Disk          *files.DiskUsage `json:"disk,omitempty"`
+	FreePercent   *float64         `json:"free_percent,omitempty"`
+	Blobs         *store.Usage     `json:"blobs"`
+	Limits        Limits           `json:"limits"`
+}
+
+// New creates a new quota guard
+func New(store StoreInterface, disk DiskInterface, limits Limits, logger *log.Logger) *Guard {
+	if limits.HighWatermarkPercent < limits.LowWatermarkPercent {
+		limits.HighWatermarkPercent = limits.LowWatermarkPercent
+	}
+	return &Guard{
+		store:  store,
+		disk:   disk,
+		limits: limits,
+		logger: logger,
+	}
+}
+
+// AdmitJob checks whether a new job may be accepted for a user
+func (g *Guard) AdmitJob(userID *string) error {
+	if err := g.checkDisk(); err != nil {
+		return err
+	}
+	if userID == nil {
+		return nil
+	}
+	return g.checkUser(*userID)
+}
+
+// checkUser rejects users at or over their quota. Queued and processing
+// jobs count as the ringtones they will produce.
+func (g *Guard) checkUser(userID string) error {
+	if g.limits.UserBytes <= 0 && g.limits.UserFiles <= 0 {
+		return nil
+	}
+
+	usage, err := g.store.GetUserUsage(userID)
+	if err != nil {
+		return err
+	}
+
+	if g.limits.UserBytes > 0 && usage.Bytes >= g.limits.UserBytes {
+		return ErrQuotaExceeded
+	}
+	if g.limits.UserFiles > 0 {
+		pending, err := g.store.CountPendingJobs(userID)
+		if err != nil {
+			return err
+		}
+		if usage.Files+pending >= g.limits.UserFiles {
+			return ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// checkDisk rejects jobs while free space is below the low watermark,
+// until it recovers above the high watermark
+func (g *Guard) checkDisk() error {
+	if g.limits.LowWatermarkPercent <= 0 {
+		return nil
+	}
+
+	freePercent, err := g.freePercent()
+	if err != nil {
+		return err
+	}
+	if freePercent == nil {
+		return nil
+	}
+
+	if g.updateLowDisk(*freePercent) {
+		return ErrStorageLow
+	}
+	return nil
+}
+
+// updateLowDisk applies the watermarks to the current free space and
+// reports whether the service is in low-disk mode
+func (g *Guard) updateLowDisk(freePercent float64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch {
+	case !g.lowDisk && freePercent < g.limits.LowWatermarkPercent:
+		g.lowDisk = true
+		g.logger.Warn("Free disk space below low watermark, rejecting new jobs", "free_percent", freePercent)
+	case g.lowDisk && freePercent >= g.limits.HighWatermarkPercent:
+		g.lowDisk = false
+		g.logger.Info("Free disk space recovered, accepting new jobs", "free_percent", freePercent)
+	}
+	return g.lowDisk
+}
+
+// freePercent returns the percentage of free disk space, or nil if the
+// storage backend has no local disk
+func (g *Guard) freePercent() (*float64, error) {
+	usage, err := g.disk.DiskUsage()
+	if err != nil || usage == nil || usage.TotalBytes == 0 {
+		return nil, err
+	}
+	percent := float64(usage.FreeBytes) / float64(usage.TotalBytes) * 100
+	return &percent, nil
+}
+
+// Status returns current storage usage
+func (g *Guard) Status() (*Status, error) {
+	blobs, err := g.store.GetStorageUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{AcceptingJobs: true, Blobs: blobs, Limits: g.limits}
+
+	disk, err := g.disk.DiskUsage()
+	if err != nil {
+		g.logger.Warn("Failed to get disk usage", "error", err)
+	}
+	if disk != nil && disk.TotalBytes > 0 {
+		status.Disk = disk
+		freePercent := float64(disk.FreeBytes) / float64(disk.TotalBytes) * 100
+		status.FreePercent = &freePercent
+		if g.limits.LowWatermarkPercent > 0 {
+			status.AcceptingJobs = !g.updateLowDisk(freePercent)
+		}
+	}
+
+	return status, nil
+}
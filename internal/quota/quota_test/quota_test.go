@@ -0,0 +1,101 @@
+package quota_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ringtonic-backend/internal/files"
+	"ringtonic-backend/internal/log"
+	"ringtonic-backend/internal/quota"
+	"ringtonic-backend/internal/store"
+)
+
+type fakeStore struct {
+	users   map[string]*store.Usage
+	pending map[string]int
+}
+
+func (f *fakeStore) GetUserUsage(userID string) (*store.Usage, error) {
+	if usage, ok := f.users[userID]; ok {
+		return usage, nil
+	}
+	return &store.Usage{}, nil
+}
+
+func (f *fakeStore) CountPendingJobs(userID string) (int, error) {
+	return f.pending[userID], nil
+}
+
+func (f *fakeStore) GetStorageUsage() (*store.Usage, error) {
+	return &store.Usage{Bytes: 300, Files: 3}, nil
+}
+
+type fakeDisk struct {
+	usage *files.DiskUsage
+}
+
+func (f *fakeDisk) DiskUsage() (*files.DiskUsage, error) {
+	return f.usage, nil
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestGuard_UserQuota(t *testing.T) {
+	db := &fakeStore{
+		users: map[string]*store.Usage{
+			"heavy": {Bytes: 1000, Files: 1},
+			"many":  {Bytes: 10, Files: 5},
+			"busy":  {Bytes: 10, Files: 3},
+		},
+		pending: map[string]int{"busy": 2, "light": 4},
+	}
+	guard := quota.New(db, &fakeDisk{}, quota.Limits{UserBytes: 1000, UserFiles: 5}, log.New("error"))
+
+	assert.ErrorIs(t, guard.AdmitJob(stringPtr("heavy")), quota.ErrQuotaExceeded)
+	assert.ErrorIs(t, guard.AdmitJob(stringPtr("many")), quota.ErrQuotaExceeded)
+	// Jobs in progress count as the ringtones they will produce
+	assert.ErrorIs(t, guard.AdmitJob(stringPtr("busy")), quota.ErrQuotaExceeded)
+	assert.NoError(t, guard.AdmitJob(stringPtr("light")))
+	assert.NoError(t, guard.AdmitJob(nil))
+}
+
+func TestGuard_DiskWatermarks(t *testing.T) {
+	disk := &fakeDisk{usage: &files.DiskUsage{TotalBytes: 100, FreeBytes: 50}}
+	guard := quota.New(&fakeStore{}, disk, quota.Limits{LowWatermarkPercent: 5, HighWatermarkPercent: 10}, log.New("error"))
+
+	assert.NoError(t, guard.AdmitJob(nil))
+
+	// Dropping below the low watermark stops admission
+	disk.usage.FreeBytes = 4
+	assert.ErrorIs(t, guard.AdmitJob(nil), quota.ErrStorageLow)
+
+	// Recovering between the watermarks is not enough
+	disk.usage.FreeBytes = 8
+	assert.ErrorIs(t, guard.AdmitJob(stringPtr("user")), quota.ErrStorageLow)
+
+	status, err := guard.Status()
+	require.NoError(t, err)
+	assert.False(t, status.AcceptingJobs)
+	require.NotNil(t, status.FreePercent)
+	assert.InDelta(t, 8.0, *status.FreePercent, 0.001)
+	assert.Equal(t, int64(300), status.Blobs.Bytes)
+
+	// Recovering above the high watermark resumes admission
+	disk.usage.FreeBytes = 10
+	assert.NoError(t, guard.AdmitJob(nil))
+}
+
+func TestGuard_NoLocalDisk(t *testing.T) {
+	guard := quota.New(&fakeStore{}, &fakeDisk{}, quota.Limits{LowWatermarkPercent: 5}, log.New("error"))
+
+	assert.NoError(t, guard.AdmitJob(nil))
+
+	status, err := guard.Status()
+	require.NoError(t, err)
+	assert.True(t, status.AcceptingJobs)
+	assert.Nil(t, status.Disk)
+}
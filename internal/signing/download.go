@@ -0,0 +1,167 @@
+package signing
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissingSignature is returned when a download URL carries no signature
+	ErrMissingSignature = errors.New("download URL is not signed")
+	// ErrInvalidSignature is returned when a download URL signature does not verify
+	ErrInvalidSignature = errors.New("invalid download URL signature")
+	// ErrExpired is returned when a download URL is past its expiry
+	ErrExpired = errors.New("download URL has expired")
+)
+
+// DownloadSigner signs and verifies download URLs. A signature binds the
+// file name to its job, the job's owner and an expiry time.
+type DownloadSigner struct {
+	keys *Keyring
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+// NewDownloadSigner creates a download URL signer issuing URLs valid for ttl
+func NewDownloadSigner(keys *Keyring, ttl time.Duration) *DownloadSigner {
+	return &DownloadSigner{
+		keys: keys,
+		ttl:  ttl,
+		now:  time.Now,
+	}
+}
+
+// SignDownloadURL returns a signed download path for a file
+func (s *DownloadSigner) SignDownloadURL(filename, jobID string, userID *string) string {
+	query := s.sign(downloadResource(filename), jobID, userID)
+	return fmt.Sprintf("/download/%s?%s", url.PathEscape(filename), query.Encode())
+}
+
+// VerifyDownloadURL checks the signature query parameters of a download
+// request against the file's job and owner
+func (s *DownloadSigner) VerifyDownloadURL(filename, jobID string, userID *string, query url.Values) error {
+	return s.verify(downloadResource(filename), jobID, userID, query)
+}
+
+// SignPreviewURL returns a signed path for a ringtone's preview rendition
+func (s *DownloadSigner) SignPreviewURL(ringtoneID int, jobID string, userID *string) string {
+	return s.signRingtoneURL("preview", ringtoneID, jobID, userID)
+}
+
+// VerifyPreviewURL checks the signature query parameters of a preview
+// request against the ringtone's job and owner
+func (s *DownloadSigner) VerifyPreviewURL(ringtoneID int, jobID string, userID *string, query url.Values) error {
+	return s.verify(ringtoneResource("preview", ringtoneID), jobID, userID, query)
+}
+
+// SignWaveformURL returns a signed path for a ringtone's waveform data
+func (s *DownloadSigner) SignWaveformURL(ringtoneID int, jobID string, userID *string) string {
+	return s.signRingtoneURL("waveform", ringtoneID, jobID, userID)
+}
+
+// VerifyWaveformURL checks the signature query parameters of a waveform
+// request against the ringtone's job and owner. Other query parameters,
+// such as the bucket count, are not signed.
+func (s *DownloadSigner) VerifyWaveformURL(ringtoneID int, jobID string, userID *string, query url.Values) error {
+	return s.verify(ringtoneResource("waveform", ringtoneID), jobID, userID, query)
+}
+
+// SignBundleURL returns a signed path for a ZIP bundle of ringtones. A
+// bundle has no single job, so the signature binds the ringtone IDs, in
+// order, to their common owner.
+func (s *DownloadSigner) SignBundleURL(ringtoneIDs []int, userID *string) string {
+	query := s.sign(bundleResource(ringtoneIDs), "", userID)
+	query.Set("ids", joinIDs(ringtoneIDs))
+	return "/api/v1/ringtones/bundle?" + query.Encode()
+}
+
+// VerifyBundleURL checks the signature query parameters of a bundle
+// request against the ringtones' owner
+func (s *DownloadSigner) VerifyBundleURL(ringtoneIDs []int, userID *string, query url.Values) error {
+	return s.verify(bundleResource(ringtoneIDs), "", userID, query)
+}
+
+// signRingtoneURL returns a signed path for a resource of a ringtone
+func (s *DownloadSigner) signRingtoneURL(resource string, ringtoneID int, jobID string, userID *string) string {
+	query := s.sign(ringtoneResource(resource, ringtoneID), jobID, userID)
+	return fmt.Sprintf("/api/v1/ringtones/%d/%s?%s", ringtoneID, resource, query.Encode())
+}
+
+// sign returns the signature query parameters for a resource
+func (s *DownloadSigner) sign(resource, jobID string, userID *string) url.Values {
+	expires := s.now().Add(s.ttl).Unix()
+	kid, signature := s.keys.Sign(signedMessage(resource, jobID, userID, expires))
+
+	query := url.Values{}
+	if jobID != "" {
+		query.Set("job", jobID)
+	}
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	query.Set("kid", kid)
+	query.Set("sig", signature)
+	return query
+}
+
+// verify checks the signature query parameters for a resource
+func (s *DownloadSigner) verify(resource, jobID string, userID *string, query url.Values) error {
+	signature := query.Get("sig")
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	if query.Get("job") != jobID {
+		return ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !s.keys.Verify(query.Get("kid"), signedMessage(resource, jobID, userID, expires), signature) {
+		return ErrInvalidSignature
+	}
+
+	if s.now().Unix() > expires {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// downloadResource names a file download in a signed message
+func downloadResource(filename string) string {
+	return "download\n" + filename
+}
+
+// ringtoneResource names a resource of a ringtone in a signed message
+func ringtoneResource(resource string, ringtoneID int) string {
+	return resource + "\n" + strconv.Itoa(ringtoneID)
+}
+
+// bundleResource names a bundle of ringtones in a signed message
+func bundleResource(ringtoneIDs []int) string {
+	return "bundle\n" + joinIDs(ringtoneIDs)
+}
+
+// joinIDs formats ringtone IDs as a comma-separated list
+func joinIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// signedMessage builds the signed message for a resource URL
+func signedMessage(resource, jobID string, userID *string, expires int64) []byte {
+	owner := ""
+	if userID != nil {
+		owner = *userID
+	}
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%d", resource, jobID, owner, expires))
+}
@@ -0,0 +1,127 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Key is an HMAC secret identified by a key ID
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring holds the active HMAC keys. The primary key signs; every key
+// verifies, so a new key can be introduced before the old one is retired.
+type Keyring struct {
+	keys    []Key
+	primary Key
+}
+
+// NewKeyring creates a keyring whose first key is the primary
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keyring needs at least one key")
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.ID == "" || len(key.Secret) == 0 {
+			return nil, fmt.Errorf("keys need an ID and a secret")
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate key ID: %s", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	return &Keyring{keys: keys, primary: keys[0]}, nil
+}
+
+// ParseKeyring parses a comma-separated list of "kid:secret" pairs,
+// primary key first
+func ParseKeyring(spec string) (*Keyring, error) {
+	var keys []Key
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q: expected kid:secret", id)
+		}
+		keys = append(keys, Key{ID: strings.TrimSpace(id), Secret: []byte(strings.TrimSpace(secret))})
+	}
+	return NewKeyring(keys...)
+}
+
+// RandomKeyring creates a keyring with a single random key, for deployments
+// that have not configured keys. Signatures do not survive a restart.
+func RandomKeyring() *Keyring {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to generate signing key: %v", err))
+	}
+	keyring, _ := NewKeyring(Key{ID: "ephemeral", Secret: secret})
+	return keyring
+}
+
+// PrimaryID returns the ID of the signing key
+func (k *Keyring) PrimaryID() string {
+	return k.primary.ID
+}
+
+// IDs returns the IDs of all active keys, primary first
+func (k *Keyring) IDs() []string {
+	ids := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		ids[i] = key.ID
+	}
+	return ids
+}
+
+// Primary returns the signing key, for schemes that send the secret itself
+func (k *Keyring) Primary() Key {
+	return k.primary
+}
+
+// MatchSecret compares secret against every active key in constant time,
+// returning the ID of the key it matches
+func (k *Keyring) MatchSecret(secret []byte) (string, bool) {
+	for _, key := range k.keys {
+		if hmac.Equal(secret, key.Secret) {
+			return key.ID, true
+		}
+	}
+	return "", false
+}
+
+// Sign signs message with the primary key and returns the key ID and the
+// hex-encoded HMAC-SHA256 signature
+func (k *Keyring) Sign(message []byte) (string, string) {
+	return k.primary.ID, hex.EncodeToString(mac(k.primary.Secret, message))
+}
+
+// Verify checks a hex-encoded signature made with the key kid. Unknown key
+// IDs never verify.
+func (k *Keyring) Verify(kid string, message []byte, signature string) bool {
+	for _, key := range k.keys {
+		if key.ID == kid {
+			expected := hex.EncodeToString(mac(key.Secret, message))
+			return hmac.Equal([]byte(signature), []byte(expected))
+		}
+	}
+	return false
+}
+
+// mac computes an HMAC-SHA256 digest
+func mac(secret, message []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(message)
+	return h.Sum(nil)
+}
@@ -0,0 +1,102 @@
+package signing_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ringtonic-backend/internal/signing"
+)
+
+func TestParseKeyring(t *testing.T) {
+	keys, err := signing.ParseKeyring("new:secret-2, old:secret-1")
+	require.NoError(t, err)
+	assert.Equal(t, "new", keys.PrimaryID())
+	assert.Equal(t, []string{"new", "old"}, keys.IDs())
+
+	_, err = signing.ParseKeyring("")
+	assert.Error(t, err)
+
+	_, err = signing.ParseKeyring("missing-secret")
+	assert.Error(t, err)
+
+	_, err = signing.ParseKeyring("a:1,a:2")
+	assert.Error(t, err)
+}
+
+func TestKeyringSignAndVerify(t *testing.T) {
+	keys, err := signing.ParseKeyring("k1:secret")
+	require.NoError(t, err)
+
+	kid, signature := keys.Sign([]byte("message"))
+	assert.Equal(t, "k1", kid)
+	assert.True(t, keys.Verify(kid, []byte("message"), signature))
+	assert.False(t, keys.Verify(kid, []byte("tampered"), signature))
+	assert.False(t, keys.Verify("unknown", []byte("message"), signature))
+}
+
+func TestDownloadURLKeyRotation(t *testing.T) {
+	oldKeys, err := signing.ParseKeyring("old:secret-1")
+	require.NoError(t, err)
+	rotatedKeys, err := signing.ParseKeyring("new:secret-2,old:secret-1")
+	require.NoError(t, err)
+	retiredKeys, err := signing.ParseKeyring("new:secret-2")
+	require.NoError(t, err)
+
+	userID := "user-1"
+	signedURL := signing.NewDownloadSigner(oldKeys, time.Hour).SignDownloadURL("a.mp3", "job-1", &userID)
+	parsed, err := url.Parse(signedURL)
+	require.NoError(t, err)
+
+	// URLs signed with the old key stay valid while it is still listed
+	assert.NoError(t, signing.NewDownloadSigner(rotatedKeys, time.Hour).VerifyDownloadURL("a.mp3", "job-1", &userID, parsed.Query()))
+	assert.Equal(t, signing.ErrInvalidSignature,
+		signing.NewDownloadSigner(retiredKeys, time.Hour).VerifyDownloadURL("a.mp3", "job-1", &userID, parsed.Query()))
+
+	// The job binding is checked
+	assert.Equal(t, signing.ErrInvalidSignature,
+		signing.NewDownloadSigner(rotatedKeys, time.Hour).VerifyDownloadURL("a.mp3", "job-2", &userID, parsed.Query()))
+}
+
+func TestBundleURL(t *testing.T) {
+	keys, err := signing.ParseKeyring("k1:secret")
+	require.NoError(t, err)
+	signer := signing.NewDownloadSigner(keys, time.Hour)
+
+	userID := "user-1"
+	parsed, err := url.Parse(signer.SignBundleURL([]int{3, 1}, &userID))
+	require.NoError(t, err)
+	assert.Equal(t, "/api/v1/ringtones/bundle", parsed.Path)
+	assert.Equal(t, "3,1", parsed.Query().Get("ids"))
+	assert.NoError(t, signer.VerifyBundleURL([]int{3, 1}, &userID, parsed.Query()))
+
+	// The ringtones, their order and their owner are all bound
+	assert.Equal(t, signing.ErrInvalidSignature, signer.VerifyBundleURL([]int{3, 1, 2}, &userID, parsed.Query()))
+	assert.Equal(t, signing.ErrInvalidSignature, signer.VerifyBundleURL([]int{1, 3}, &userID, parsed.Query()))
+	assert.Equal(t, signing.ErrInvalidSignature, signer.VerifyBundleURL([]int{3, 1}, nil, parsed.Query()))
+}
+
+func TestJobTokens(t *testing.T) {
+	keys, err := signing.ParseKeyring("k1:secret")
+	require.NoError(t, err)
+	tokens := signing.NewJobTokens(keys, signing.PurposeUpload)
+
+	token := tokens.Token("job-1")
+	assert.True(t, tokens.Verify("job-1", token))
+	assert.False(t, tokens.Verify("job-2", token))
+	assert.False(t, tokens.Verify("job-1", ""))
+	assert.False(t, tokens.Verify("job-1", "k1"))
+
+	// Tokens are not interchangeable between purposes or with download signatures
+	assert.False(t, signing.NewJobTokens(keys, signing.PurposeSource).Verify("job-1", token))
+	kid, signature := keys.Sign([]byte("job-1"))
+	assert.False(t, tokens.Verify("job-1", kid+"."+signature))
+
+	// Tokens issued before a key rotation keep working
+	rotated, err := signing.ParseKeyring("k2:new-secret,k1:secret")
+	require.NoError(t, err)
+	assert.True(t, signing.NewJobTokens(rotated, signing.PurposeUpload).Verify("job-1", token))
+}
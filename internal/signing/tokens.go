@@ -0,0 +1,47 @@
+package signing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Token purposes. A token issued for one purpose is not valid for another.
+const (
+	// PurposeUpload authorizes a worker to upload a job's output
+	PurposeUpload = "upload"
+	// PurposeSource authorizes a worker to fetch a job's uploaded source
+	PurposeSource = "source"
+)
+
+// JobTokens issues and verifies per-job bearer tokens for a single purpose.
+// A token only authorizes acting on the job it was issued for; it is derived
+// from the keyring, so no token state needs to be stored.
+type JobTokens struct {
+	keys    *Keyring
+	purpose string
+}
+
+// NewJobTokens creates a token issuer for a purpose
+func NewJobTokens(keys *Keyring, purpose string) *JobTokens {
+	return &JobTokens{keys: keys, purpose: purpose}
+}
+
+// Token returns the token for a job
+func (t *JobTokens) Token(jobID string) string {
+	kid, signature := t.keys.Sign(t.message(jobID))
+	return kid + "." + signature
+}
+
+// Verify reports whether token is the token for a job
+func (t *JobTokens) Verify(jobID, token string) bool {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return false
+	}
+	return t.keys.Verify(token[:i], t.message(jobID), token[i+1:])
+}
+
+// message builds the signed message for a job token
+func (t *JobTokens) message(jobID string) []byte {
+	return []byte(fmt.Sprintf("%s\n%s", t.purpose, jobID))
+}
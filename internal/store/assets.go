@@ -0,0 +1,225 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Ringtone asset kinds
+const (
+	// AssetPreview is a low-bitrate rendition for auditioning a ringtone
+	AssetPreview = "preview"
+	// assetFormatPrefix starts the kind of a rendition in an additional
+	// output format, such as "format:m4r"
+	assetFormatPrefix = "format:"
+)
+
+// FormatAsset returns the asset kind of a ringtone's rendition in format
+func FormatAsset(format string) string {
+	return assetFormatPrefix + format
+}
+
+// RingtoneAsset is an additional rendition stored alongside a ringtone's
+// final file. Each ringtone has at most one asset of each kind.
+type RingtoneAsset struct {
+	ID         int        `json:"id"`
+	RingtoneID int        `json:"ringtone_id"`
+	Kind       string     `json:"kind"`
+	FileName   string     `json:"file_name"`
+	FilePath   string     `json:"file_path"`
+	BlobHash   string     `json:"blob_hash"`
+	SizeBytes  int64      `json:"size_bytes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Format returns the output format of a format rendition, or false for
+// other kinds of asset
+func (a *RingtoneAsset) Format() (string, bool) {
+	return strings.CutPrefix(a.Kind, assetFormatPrefix)
+}
+
+const ringtoneAssetColumns = `id, ringtone_id, kind, file_name, file_path, blob_hash, size_bytes, created_at, expires_at`
+
+// scanRingtoneAsset scans a ringtone asset row
+func scanRingtoneAsset(row interface{ Scan(...interface{}) error }) (*RingtoneAsset, error) {
+	asset := &RingtoneAsset{}
+	err := row.Scan(
+		&asset.ID,
+		&asset.RingtoneID,
+		&asset.Kind,
+		&asset.FileName,
+		&asset.FilePath,
+		&asset.BlobHash,
+		&asset.SizeBytes,
+		&asset.CreatedAt,
+		&asset.ExpiresAt,
+	)
+	return asset, err
+}
+
+// PutRingtoneAsset records an asset, replacing any existing asset of the
+// same kind on the ringtone. The new blob's reference is taken and the
+// replaced blob's released in the same transaction; released content is
+// left to storage reconciliation.
+func (s *Store) PutRingtoneAsset(asset *RingtoneAsset) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	replaced, err := deleteRingtoneAssets(tx, `ringtone_id = ? AND kind = ?`, asset.RingtoneID, asset.Kind)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO ringtone_assets (ringtone_id, kind, file_name, file_path, blob_hash, size_bytes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, asset.RingtoneID, asset.Kind, asset.FileName, asset.FilePath, asset.BlobHash, asset.SizeBytes, asset.CreatedAt, asset.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create ringtone asset: %w", err)
+	}
+
+	if err := addBlobRef(tx, asset.BlobHash, &asset.SizeBytes); err != nil {
+		return err
+	}
+	for _, hash := range replaced {
+		if _, err := releaseBlobRef(tx, hash); err != nil {
+			return err
+		}
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get ringtone asset ID: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit ringtone asset: %w", err)
+	}
+
+	asset.ID = int(id)
+	return nil
+}
+
+// GetRingtoneAsset retrieves a ringtone's asset of the given kind. Expired
+// assets are treated as missing.
+func (s *Store) GetRingtoneAsset(ringtoneID int, kind string) (*RingtoneAsset, error) {
+	query := `SELECT ` + ringtoneAssetColumns + ` FROM ringtone_assets
+		WHERE ringtone_id = ? AND kind = ? AND (expires_at IS NULL OR expires_at > ?)`
+
+	asset, err := scanRingtoneAsset(s.db.QueryRow(query, ringtoneID, kind, time.Now()))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ringtone asset: %w", err)
+	}
+
+	return asset, nil
+}
+
+// GetRingtoneAssets retrieves a ringtone's unexpired assets
+func (s *Store) GetRingtoneAssets(ringtoneID int) ([]*RingtoneAsset, error) {
+	query := `SELECT ` + ringtoneAssetColumns + ` FROM ringtone_assets
+		WHERE ringtone_id = ? AND (expires_at IS NULL OR expires_at > ?) ORDER BY id`
+
+	rows, err := s.db.Query(query, ringtoneID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ringtone assets: %w", err)
+	}
+	defer rows.Close()
+
+	var assets []*RingtoneAsset
+	for rows.Next() {
+		asset, err := scanRingtoneAsset(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ringtone asset: %w", err)
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, rows.Err()
+}
+
+// ListRingtoneAssets retrieves every ringtone asset record
+func (s *Store) ListRingtoneAssets() ([]*RingtoneAsset, error) {
+	query := `SELECT ` + ringtoneAssetColumns + ` FROM ringtone_assets ORDER BY id`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ringtone assets: %w", err)
+	}
+	defer rows.Close()
+
+	var assets []*RingtoneAsset
+	for rows.Next() {
+		asset, err := scanRingtoneAsset(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ringtone asset: %w", err)
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, rows.Err()
+}
+
+// ExpireRingtoneAssets deletes assets whose retention ended before now and
+// releases their blobs. It returns the number of assets deleted; released
+// content is left to storage reconciliation.
+func (s *Store) ExpireRingtoneAssets(now time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	expired, err := deleteRingtoneAssets(tx, `expires_at IS NOT NULL AND expires_at <= ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, hash := range expired {
+		if _, err := releaseBlobRef(tx, hash); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit asset expiry: %w", err)
+	}
+
+	return len(expired), nil
+}
+
+// deleteRingtoneAssets deletes the assets matching a condition and returns
+// their blob hashes, one per deleted asset. The caller releases the blobs.
+func deleteRingtoneAssets(tx *sql.Tx, condition string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(`SELECT blob_hash FROM ringtone_assets WHERE `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ringtone assets: %w", err)
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan ringtone asset: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list ringtone assets: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM ringtone_assets WHERE `+condition, args...); err != nil {
+		return nil, fmt.Errorf("failed to delete ringtone assets: %w", err)
+	}
+
+	return hashes, nil
+}
@@ -1,7 +1,9 @@
 package store
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -26,6 +28,16 @@ type Job struct {
 	Attempts     int       `json:"attempts"`
 	N8NPayload   *string   `json:"n8n_payload,omitempty"`
 	ErrorMessage *string   `json:"error_message,omitempty"`
+	// Uploaded sources are stored as blobs referenced by the job
+	SourceBlobHash  *string `json:"source_blob_hash,omitempty"`
+	SourceSizeBytes *int64  `json:"source_size_bytes,omitempty"`
+	SourceMIMEType  *string `json:"source_mime_type,omitempty"`
+	// N8NEndpoint is the webhook endpoint that accepted the job
+	N8NEndpoint *string `json:"n8n_endpoint,omitempty"`
+	// CallbackTokenHash is the hex SHA-256 of the job's callback token. It
+	// is empty once the token is revoked, and nil for jobs created before
+	// callback tokens.
+	CallbackTokenHash *string `json:"-"`
 }
 
 // Ringtone represents a processed ringtone file
@@ -36,16 +48,91 @@ type Ringtone struct {
 	FilePath        string    `json:"file_path"`
 	Format          string    `json:"format"`
 	DurationSeconds *int      `json:"duration_seconds,omitempty"`
+	BlobHash        *string   `json:"blob_hash,omitempty"`
+	SizeBytes       *int64    `json:"size_bytes,omitempty"`
+	DisplayName     *string   `json:"display_name,omitempty"`
+	DownloadCount   int       `json:"download_count"`
+	LoudnessLUFS    *float64  `json:"loudness_lufs,omitempty"`
+	TruePeakDBTP    *float64  `json:"true_peak_dbtp,omitempty"`
+	LoudnessRangeLU *float64  `json:"loudness_range_lu,omitempty"`
 	CreatedAt       time.Time `json:"created_at"`
 }
 
-// JobOptions represents processing options for a job
+// StorageKey returns the storage key holding the ringtone's content.
+// Content-addressed ringtones keep the blob key in FilePath; older rows
+// were stored under their file name.
+func (r *Ringtone) StorageKey() string {
+	if r.BlobHash != nil {
+		return r.FilePath
+	}
+	return r.FileName
+}
+
+// DownloadName returns the name a ringtone is offered for download under
+func (r *Ringtone) DownloadName() string {
+	if r.DisplayName != nil {
+		return *r.DisplayName
+	}
+	return r.FileName
+}
+
+// JobOptions represents processing options for a job. Format is the
+// primary output format; Formats lists every requested format, primary first.
+// Normalize asks the processor to bring the ringtone to TargetLUFS.
+// AutoStart is set by a start_seconds of "auto" and is resolved to a
+// StartSeconds before the job is dispatched.
 type JobOptions struct {
-	StartSeconds    *int   `json:"start_seconds,omitempty"`
-	DurationSeconds *int   `json:"duration_seconds,omitempty"`
-	FadeIn          bool   `json:"fade_in"`
-	FadeOut         bool   `json:"fade_out"`
-	Format          string `json:"format"`
+	StartSeconds    *int     `json:"start_seconds,omitempty"`
+	AutoStart       bool     `json:"-"`
+	DurationSeconds *int     `json:"duration_seconds,omitempty"`
+	FadeIn          bool     `json:"fade_in"`
+	FadeOut         bool     `json:"fade_out"`
+	Format          string   `json:"format"`
+	Formats         []string `json:"formats,omitempty"`
+	Normalize       bool     `json:"normalize"`
+	TargetLUFS      *float64 `json:"target_lufs,omitempty"`
+}
+
+// UnmarshalJSON accepts "auto" for start_seconds besides a number of seconds
+func (o *JobOptions) UnmarshalJSON(data []byte) error {
+	type plain JobOptions
+	options := struct {
+		*plain
+		StartSeconds json.RawMessage `json:"start_seconds,omitempty"`
+	}{plain: (*plain)(o)}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return err
+	}
+
+	o.StartSeconds = nil
+	o.AutoStart = false
+	switch start := bytes.TrimSpace(options.StartSeconds); {
+	case len(start) == 0 || string(start) == "null":
+	case string(start) == `"auto"`:
+		o.AutoStart = true
+	default:
+		if err := json.Unmarshal(start, &o.StartSeconds); err != nil {
+			return fmt.Errorf("start_seconds must be a number of seconds or \"auto\": %w", err)
+		}
+	}
+	return nil
+}
+
+// DataRequest represents a user data erasure or export request
+type DataRequest struct {
+	ID                 string     `json:"id"`
+	Kind               string     `json:"kind"`
+	UserID             *string    `json:"-"`
+	SubjectHash        string     `json:"subject_hash"`
+	Status             string     `json:"status"`
+	JobsProcessed      int        `json:"jobs_processed"`
+	RingtonesProcessed int        `json:"ringtones_processed"`
+	FilesProcessed     int        `json:"files_processed"`
+	ArtifactPath       *string    `json:"artifact_path,omitempty"`
+	ErrorMessage       *string    `json:"error_message,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	CompletedAt        *time.Time `json:"completed_at,omitempty"`
 }
 
 const (
@@ -53,8 +140,28 @@ const (
 	StatusProcessing = "processing"
 	StatusCompleted  = "completed"
 	StatusFailed     = "failed"
+	// StatusDegraded marks completed jobs whose stored file has gone missing
+	StatusDegraded = "degraded"
 )
 
+// Data request kinds and statuses
+const (
+	DataRequestErasure = "erasure"
+	DataRequestExport  = "export"
+
+	DataRequestPending   = "pending"
+	DataRequestRunning   = "running"
+	DataRequestCompleted = "completed"
+	DataRequestFailed    = "failed"
+)
+
+// ErasedSourceURL replaces the source URL of anonymized jobs
+const ErasedSourceURL = "erased"
+
+// ErasedJobError is the error message of jobs failed because their user's
+// data was erased while they were in progress
+const ErasedJobError = "erased while in progress"
+
 // New creates a new database connection
 func New(dbPath string) (*Store, error) {
 	// Ensure directory exists
@@ -63,7 +170,9 @@ func New(dbPath string) (*Store, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", dbPath+"?_foreign_keys=on")
+	// Background dispatch writes while requests read, so wait for locks
+	// rather than failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite", dbPath+"?_foreign_keys=on&_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -105,9 +214,46 @@ func (s *Store) Migrate() error {
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (job_id) REFERENCES jobs (id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS blobs (
+			hash TEXT PRIMARY KEY,
+			size INTEGER NOT NULL,
+			ref_count INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS data_requests (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			user_id TEXT,
+			subject_hash TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			jobs_processed INTEGER NOT NULL DEFAULT 0,
+			ringtones_processed INTEGER NOT NULL DEFAULT 0,
+			files_processed INTEGER NOT NULL DEFAULT 0,
+			artifact_path TEXT,
+			error_message TEXT,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			completed_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS ringtone_assets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ringtone_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			file_name TEXT NOT NULL,
+			file_path TEXT NOT NULL,
+			blob_hash TEXT NOT NULL,
+			size_bytes INTEGER NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME,
+			UNIQUE (ringtone_id, kind),
+			FOREIGN KEY (ringtone_id) REFERENCES ringtones (id)
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs (status)`,
 		`CREATE INDEX IF NOT EXISTS idx_jobs_created_at ON jobs (created_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_ringtones_job_id ON ringtones (job_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_user_id ON jobs (user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_data_requests_status ON data_requests (status)`,
+		`CREATE INDEX IF NOT EXISTS idx_ringtone_assets_expires_at ON ringtone_assets (expires_at)`,
 	}
 
 	for _, migration := range migrations {
@@ -116,17 +262,82 @@ func (s *Store) Migrate() error {
 		}
 	}
 
+	columns := []struct {
+		table, name, definition string
+	}{
+		{"ringtones", "blob_hash", "TEXT"},
+		{"ringtones", "size_bytes", "INTEGER"},
+		{"ringtones", "display_name", "TEXT"},
+		{"ringtones", "download_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"ringtones", "loudness_lufs", "REAL"},
+		{"ringtones", "true_peak_dbtp", "REAL"},
+		{"ringtones", "loudness_range_lu", "REAL"},
+		{"jobs", "source_blob_hash", "TEXT"},
+		{"jobs", "source_size_bytes", "INTEGER"},
+		{"jobs", "source_mime_type", "TEXT"},
+		{"jobs", "n8n_endpoint", "TEXT"},
+		{"jobs", "callback_token_hash", "TEXT"},
+	}
+
+	for _, column := range columns {
+		if err := s.addColumn(column.table, column.name, column.definition); err != nil {
+			return fmt.Errorf("failed to run migration: %w", err)
+		}
+	}
+
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_ringtones_blob_hash ON ringtones (blob_hash)`); err != nil {
+		return fmt.Errorf("failed to run migration: %w", err)
+	}
+
 	return nil
 }
 
-// CreateJob creates a new job
+// addColumn adds a column to an existing table unless it is already present
+func (s *Store) addColumn(table, name, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			colName, colType string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if colName == name {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition))
+	return err
+}
+
+// CreateJob creates a new job. If the job's source is an uploaded blob, the
+// blob's reference count is incremented in the same transaction.
 func (s *Store) CreateJob(job *Job) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO jobs (id, source_url, user_id, status, created_at, updated_at, attempts, n8n_payload)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO jobs (id, source_url, user_id, status, created_at, updated_at, attempts, n8n_payload,
+			source_blob_hash, source_size_bytes, source_mime_type, callback_token_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := s.db.Exec(query,
+	_, err = tx.Exec(query,
 		job.ID,
 		job.SourceURL,
 		job.UserID,
@@ -135,25 +346,37 @@ func (s *Store) CreateJob(job *Job) error {
 		job.UpdatedAt,
 		job.Attempts,
 		job.N8NPayload,
+		job.SourceBlobHash,
+		job.SourceSizeBytes,
+		job.SourceMIMEType,
+		job.CallbackTokenHash,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to create job: %w", err)
 	}
 
+	if job.SourceBlobHash != nil {
+		if err := addBlobRef(tx, *job.SourceBlobHash, job.SourceSizeBytes); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit job: %w", err)
+	}
+
 	return nil
 }
 
-// GetJob retrieves a job by ID
-func (s *Store) GetJob(id string) (*Job, error) {
-	query := `
-		SELECT id, source_url, user_id, status, created_at, updated_at, attempts, n8n_payload, error_message
-		FROM jobs
-		WHERE id = ?
-	`
+// jobColumns lists the job columns in the order scanned by scanJob
+const jobColumns = `id, source_url, user_id, status, created_at, updated_at, attempts, n8n_payload, error_message,
+	source_blob_hash, source_size_bytes, source_mime_type, n8n_endpoint, callback_token_hash`
 
+// scanJob scans a job row
+func scanJob(row interface{ Scan(...interface{}) error }) (*Job, error) {
 	job := &Job{}
-	err := s.db.QueryRow(query, id).Scan(
+	err := row.Scan(
 		&job.ID,
 		&job.SourceURL,
 		&job.UserID,
@@ -163,7 +386,20 @@ func (s *Store) GetJob(id string) (*Job, error) {
 		&job.Attempts,
 		&job.N8NPayload,
 		&job.ErrorMessage,
+		&job.SourceBlobHash,
+		&job.SourceSizeBytes,
+		&job.SourceMIMEType,
+		&job.N8NEndpoint,
+		&job.CallbackTokenHash,
 	)
+	return job, err
+}
+
+// GetJob retrieves a job by ID
+func (s *Store) GetJob(id string) (*Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ?`
+
+	job, err := scanJob(s.db.QueryRow(query, id))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -191,6 +427,94 @@ func (s *Store) UpdateJobStatus(id, status string, errorMessage *string) error {
 	return nil
 }
 
+// StartJob moves a queued job to processing. It reports false if the job
+// has already moved on, for example because a fast processor completed it
+// before the dispatch returned.
+func (s *Store) StartJob(id string) (bool, error) {
+	query := `
+		UPDATE jobs
+		SET status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+	`
+
+	result, err := s.db.Exec(query, StatusProcessing, id, StatusQueued)
+	if err != nil {
+		return false, fmt.Errorf("failed to start job: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count started jobs: %w", err)
+	}
+
+	return updated > 0, nil
+}
+
+// SetJobEndpoint records the webhook endpoint that accepted a job
+func (s *Store) SetJobEndpoint(id, endpoint string) error {
+	query := `
+		UPDATE jobs
+		SET n8n_endpoint = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	_, err := s.db.Exec(query, endpoint, id)
+	if err != nil {
+		return fmt.Errorf("failed to set job endpoint: %w", err)
+	}
+
+	return nil
+}
+
+// ResetCallbackToken replaces the callback token of a queued job, revoking
+// the previous one. It reports false if the job is no longer queued.
+func (s *Store) ResetCallbackToken(id, tokenHash string) (bool, error) {
+	query := `
+		UPDATE jobs
+		SET callback_token_hash = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+	`
+
+	result, err := s.db.Exec(query, tokenHash, id, StatusQueued)
+	if err != nil {
+		return false, fmt.Errorf("failed to reset callback token: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count reset callback tokens: %w", err)
+	}
+
+	return updated > 0, nil
+}
+
+// revokeCallbackToken is the assignment that revokes a job's callback token
+// as it finishes. Jobs created before callback tokens are left without one.
+const revokeCallbackToken = `callback_token_hash = CASE WHEN callback_token_hash IS NULL THEN NULL ELSE '' END`
+
+// FailJob marks a job failed and revokes its callback token. It reports
+// false if the job is no longer queued or processing, so a job finishes
+// only once.
+func (s *Store) FailJob(id string, errorMessage *string) (bool, error) {
+	query := `
+		UPDATE jobs
+		SET status = ?, updated_at = CURRENT_TIMESTAMP, error_message = ?, ` + revokeCallbackToken + `
+		WHERE id = ? AND status IN (?, ?)
+	`
+
+	result, err := s.db.Exec(query, StatusFailed, errorMessage, id, StatusQueued, StatusProcessing)
+	if err != nil {
+		return false, fmt.Errorf("failed to fail job: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count failed jobs: %w", err)
+	}
+
+	return updated > 0, nil
+}
+
 // IncrementJobAttempts increments the attempts counter for a job
 func (s *Store) IncrementJobAttempts(id string) error {
 	query := `
@@ -207,53 +531,233 @@ func (s *Store) IncrementJobAttempts(id string) error {
 	return nil
 }
 
-// CreateRingtone creates a new ringtone record
+// CreateRingtone creates a new ringtone record. If the ringtone points at a
+// content-addressed blob, the blob's reference count is incremented in the
+// same transaction.
 func (s *Store) CreateRingtone(ringtone *Ringtone) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := insertRingtone(tx, ringtone)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit ringtone: %w", err)
+	}
+
+	ringtone.ID = id
+	return nil
+}
+
+// CompleteJob records a job's ringtone, marks the job completed and revokes
+// its callback token in one transaction. It returns false, without creating
+// the ringtone, if the job is no longer queued or processing.
+func (s *Store) CompleteJob(ringtone *Ringtone) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE jobs
+		SET status = ?, updated_at = CURRENT_TIMESTAMP, error_message = NULL, `+revokeCallbackToken+`
+		WHERE id = ? AND status IN (?, ?)
+	`, StatusCompleted, ringtone.JobID, StatusQueued, StatusProcessing)
+	if err != nil {
+		return false, fmt.Errorf("failed to update job status: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update job status: %w", err)
+	}
+	if updated == 0 {
+		return false, nil
+	}
+
+	id, err := insertRingtone(tx, ringtone)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit job completion: %w", err)
+	}
+
+	ringtone.ID = id
+	return true, nil
+}
+
+// insertRingtone inserts a ringtone row and takes its blob reference
+func insertRingtone(tx *sql.Tx, ringtone *Ringtone) (int, error) {
 	query := `
-		INSERT INTO ringtones (job_id, file_name, file_path, format, duration_seconds, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO ringtones (job_id, file_name, file_path, format, duration_seconds, blob_hash, size_bytes, display_name,
+			loudness_lufs, true_peak_dbtp, loudness_range_lu, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := s.db.Exec(query,
+	result, err := tx.Exec(query,
 		ringtone.JobID,
 		ringtone.FileName,
 		ringtone.FilePath,
 		ringtone.Format,
 		ringtone.DurationSeconds,
+		ringtone.BlobHash,
+		ringtone.SizeBytes,
+		ringtone.DisplayName,
+		ringtone.LoudnessLUFS,
+		ringtone.TruePeakDBTP,
+		ringtone.LoudnessRangeLU,
 		ringtone.CreatedAt,
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to create ringtone: %w", err)
+		return 0, fmt.Errorf("failed to create ringtone: %w", err)
+	}
+
+	if ringtone.BlobHash != nil {
+		if err := addBlobRef(tx, *ringtone.BlobHash, ringtone.SizeBytes); err != nil {
+			return 0, err
+		}
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
-		return fmt.Errorf("failed to get ringtone ID: %w", err)
+		return 0, fmt.Errorf("failed to get ringtone ID: %w", err)
+	}
+
+	return int(id), nil
+}
+
+// DeleteRingtone deletes a ringtone record. If it held the last reference
+// to its blob, the blob row is removed and its hash is returned so that the
+// caller can delete the stored content.
+func (s *Store) DeleteRingtone(id int) (*string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var blobHash *string
+	err = tx.QueryRow(`SELECT blob_hash FROM ringtones WHERE id = ?`, id).Scan(&blobHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ringtone: %w", err)
+	}
+
+	// Released asset content is left to storage reconciliation
+	assetHashes, err := deleteRingtoneAssets(tx, `ringtone_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range assetHashes {
+		if _, err := releaseBlobRef(tx, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM ringtones WHERE id = ?`, id); err != nil {
+		return nil, fmt.Errorf("failed to delete ringtone: %w", err)
 	}
 
-	ringtone.ID = int(id)
+	var released *string
+	if blobHash != nil {
+		ok, err := releaseBlobRef(tx, *blobHash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			released = blobHash
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit ringtone deletion: %w", err)
+	}
+
+	return released, nil
+}
+
+// GetBlobRefCount returns the reference count of a blob, or 0 if it is unknown
+func (s *Store) GetBlobRefCount(hash string) (int, error) {
+	var refCount int
+	err := s.db.QueryRow(`SELECT ref_count FROM blobs WHERE hash = ?`, hash).Scan(&refCount)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get blob: %w", err)
+	}
+	return refCount, nil
+}
+
+// addBlobRef records a new reference to a blob
+func addBlobRef(tx *sql.Tx, hash string, size *int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO blobs (hash, size, ref_count) VALUES (?, COALESCE(?, 0), 1)
+		ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1
+	`, hash, size)
+	if err != nil {
+		return fmt.Errorf("failed to add blob reference: %w", err)
+	}
 	return nil
 }
 
-// GetRingtoneByJobID retrieves a ringtone by job ID
-func (s *Store) GetRingtoneByJobID(jobID string) (*Ringtone, error) {
-	query := `
-		SELECT id, job_id, file_name, file_path, format, duration_seconds, created_at
-		FROM ringtones
-		WHERE job_id = ?
-	`
+// releaseBlobRef drops a reference to a blob and reports whether it was the last one
+func releaseBlobRef(tx *sql.Tx, hash string) (bool, error) {
+	if _, err := tx.Exec(`UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = ?`, hash); err != nil {
+		return false, fmt.Errorf("failed to release blob reference: %w", err)
+	}
+
+	result, err := tx.Exec(`DELETE FROM blobs WHERE hash = ? AND ref_count <= 0`, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete blob: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count deleted blobs: %w", err)
+	}
+	return deleted > 0, nil
+}
+
+const ringtoneColumns = `id, job_id, file_name, file_path, format, duration_seconds, blob_hash, size_bytes, display_name,
+	download_count, loudness_lufs, true_peak_dbtp, loudness_range_lu, created_at`
 
+// scanRingtone scans a ringtone row
+func scanRingtone(row interface{ Scan(...interface{}) error }) (*Ringtone, error) {
 	ringtone := &Ringtone{}
-	err := s.db.QueryRow(query, jobID).Scan(
+	err := row.Scan(
 		&ringtone.ID,
 		&ringtone.JobID,
 		&ringtone.FileName,
 		&ringtone.FilePath,
 		&ringtone.Format,
 		&ringtone.DurationSeconds,
+		&ringtone.BlobHash,
+		&ringtone.SizeBytes,
+		&ringtone.DisplayName,
+		&ringtone.DownloadCount,
+		&ringtone.LoudnessLUFS,
+		&ringtone.TruePeakDBTP,
+		&ringtone.LoudnessRangeLU,
 		&ringtone.CreatedAt,
 	)
+	return ringtone, err
+}
+
+// GetRingtoneByJobID retrieves a ringtone by job ID
+func (s *Store) GetRingtoneByJobID(jobID string) (*Ringtone, error) {
+	query := `SELECT ` + ringtoneColumns + ` FROM ringtones WHERE job_id = ? ORDER BY id LIMIT 1`
+
+	ringtone, err := scanRingtone(s.db.QueryRow(query, jobID))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -267,22 +771,9 @@ func (s *Store) GetRingtoneByJobID(jobID string) (*Ringtone, error) {
 
 // GetRingtoneByFileName retrieves a ringtone by file name
 func (s *Store) GetRingtoneByFileName(fileName string) (*Ringtone, error) {
-	query := `
-		SELECT id, job_id, file_name, file_path, format, duration_seconds, created_at
-		FROM ringtones
-		WHERE file_name = ?
-	`
+	query := `SELECT ` + ringtoneColumns + ` FROM ringtones WHERE file_name = ?`
 
-	ringtone := &Ringtone{}
-	err := s.db.QueryRow(query, fileName).Scan(
-		&ringtone.ID,
-		&ringtone.JobID,
-		&ringtone.FileName,
-		&ringtone.FilePath,
-		&ringtone.Format,
-		&ringtone.DurationSeconds,
-		&ringtone.CreatedAt,
-	)
+	ringtone, err := scanRingtone(s.db.QueryRow(query, fileName))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -294,6 +785,39 @@ func (s *Store) GetRingtoneByFileName(fileName string) (*Ringtone, error) {
 	return ringtone, nil
 }
 
+// GetRingtone retrieves a ringtone by ID
+func (s *Store) GetRingtone(id int) (*Ringtone, error) {
+	query := `SELECT ` + ringtoneColumns + ` FROM ringtones WHERE id = ?`
+
+	ringtone, err := scanRingtone(s.db.QueryRow(query, id))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ringtone: %w", err)
+	}
+
+	return ringtone, nil
+}
+
+// IncrementDownloadCount records a download of a ringtone's final file
+func (s *Store) IncrementDownloadCount(id int) error {
+	if _, err := s.db.Exec(`UPDATE ringtones SET download_count = download_count + 1 WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to increment download count: %w", err)
+	}
+	return nil
+}
+
+// GetDownloadCount returns the total number of ringtone downloads
+func (s *Store) GetDownloadCount() (int64, error) {
+	var count int64
+	if err := s.db.QueryRow(`SELECT COALESCE(SUM(download_count), 0) FROM ringtones`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to get download count: %w", err)
+	}
+	return count, nil
+}
+
 // GetJobStats returns basic statistics about jobs
 func (s *Store) GetJobStats() (map[string]int, error) {
 	query := `
@@ -320,3 +844,593 @@ func (s *Store) GetJobStats() (map[string]int, error) {
 
 	return stats, nil
 }
+
+// ListJobsByUserID retrieves all jobs belonging to a user, oldest first
+func (s *Store) ListJobsByUserID(userID string) ([]*Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE user_id = ? ORDER BY created_at, id`
+
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// ListQueuedJobs retrieves all jobs that have not been handed to the
+// processor yet, oldest first
+func (s *Store) ListQueuedJobs() ([]*Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE status = ? ORDER BY created_at, id`
+
+	rows, err := s.db.Query(query, StatusQueued)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queued jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// ListJobSources returns the blob hashes of uploaded job sources
+func (s *Store) ListJobSources() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT source_blob_hash FROM jobs WHERE source_blob_hash IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list job sources: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan job source: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
+
+// ListExpiredJobSources returns the IDs of jobs that finished before
+// finishedBefore and still hold an uploaded source
+func (s *Store) ListExpiredJobSources(finishedBefore time.Time) ([]string, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE source_blob_hash IS NOT NULL AND status NOT IN (?, ?) ORDER BY id`
+
+	rows, err := s.db.Query(query, StatusQueued, StatusProcessing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list job sources: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		// Timestamps are compared here, as rows hold them in more than one format
+		if job.UpdatedAt.Before(finishedBefore) {
+			ids = append(ids, job.ID)
+		}
+	}
+
+	return ids, rows.Err()
+}
+
+// ExpireJobSource drops a finished job's uploaded source and releases its
+// blob reference in one transaction. It reports false if the job holds no
+// source or is still pending.
+func (s *Store) ExpireJobSource(jobID string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var hash sql.NullString
+	err = tx.QueryRow(`SELECT source_blob_hash FROM jobs WHERE id = ? AND status NOT IN (?, ?)`,
+		jobID, StatusQueued, StatusProcessing).Scan(&hash)
+	if err == sql.ErrNoRows || (err == nil && !hash.Valid) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get job source: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE jobs
+		SET source_blob_hash = NULL, source_size_bytes = NULL, source_mime_type = NULL
+		WHERE id = ?
+	`, jobID)
+	if err != nil {
+		return false, fmt.Errorf("failed to expire job source: %w", err)
+	}
+	if _, err := releaseBlobRef(tx, hash.String); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit source expiry: %w", err)
+	}
+
+	return true, nil
+}
+
+// ListRingtones retrieves every ringtone record
+func (s *Store) ListRingtones() ([]*Ringtone, error) {
+	query := `SELECT ` + ringtoneColumns + ` FROM ringtones ORDER BY id`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ringtones: %w", err)
+	}
+	defer rows.Close()
+
+	var ringtones []*Ringtone
+	for rows.Next() {
+		ringtone, err := scanRingtone(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ringtone: %w", err)
+		}
+		ringtones = append(ringtones, ringtone)
+	}
+
+	return ringtones, rows.Err()
+}
+
+// MarkJobDegraded flags a completed job whose file is missing from storage.
+// It reports whether the job was changed.
+func (s *Store) MarkJobDegraded(jobID, reason string) (bool, error) {
+	query := `
+		UPDATE jobs
+		SET status = ?, error_message = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+	`
+
+	result, err := s.db.Exec(query, StatusDegraded, reason, jobID, StatusCompleted)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark job degraded: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count degraded jobs: %w", err)
+	}
+
+	return updated > 0, nil
+}
+
+// ListDataRequestArtifacts returns the storage keys of export archives
+func (s *Store) ListDataRequestArtifacts() ([]string, error) {
+	rows, err := s.db.Query(`SELECT artifact_path FROM data_requests WHERE artifact_path IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data request artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	var artifacts []string
+	for rows.Next() {
+		var artifact string
+		if err := rows.Scan(&artifact); err != nil {
+			return nil, fmt.Errorf("failed to scan data request artifact: %w", err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	return artifacts, rows.Err()
+}
+
+// ListSubjectArtifacts retrieves the data requests of a subject whose
+// artifacts are still kept. Completed requests no longer carry the user ID,
+// so they are found by subject hash.
+func (s *Store) ListSubjectArtifacts(subjectHash string) ([]*DataRequest, error) {
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests
+		WHERE subject_hash = ? AND artifact_path IS NOT NULL
+		ORDER BY created_at`
+
+	rows, err := s.db.Query(query, subjectHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subject artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	var reqs []*DataRequest
+	for rows.Next() {
+		req, err := scanDataRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data request: %w", err)
+		}
+		reqs = append(reqs, req)
+	}
+
+	return reqs, rows.Err()
+}
+
+// EraseDataRequestArtifact forgets the artifact of a data request and
+// counts it as a file processed by the erasure request
+func (s *Store) EraseDataRequestArtifact(requestID, artifactRequestID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE data_requests
+		SET artifact_path = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND artifact_path IS NOT NULL
+	`, artifactRequestID)
+	if err != nil {
+		return fmt.Errorf("failed to clear data request artifact: %w", err)
+	}
+	cleared, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count cleared artifacts: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE data_requests
+		SET files_processed = files_processed + ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, cleared, requestID)
+	if err != nil {
+		return fmt.Errorf("failed to record erasure progress: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit artifact erasure: %w", err)
+	}
+
+	return nil
+}
+
+// ListRingtonesByJobID retrieves all ringtones produced by a job
+func (s *Store) ListRingtonesByJobID(jobID string) ([]*Ringtone, error) {
+	query := `SELECT ` + ringtoneColumns + ` FROM ringtones WHERE job_id = ? ORDER BY id`
+
+	rows, err := s.db.Query(query, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ringtones: %w", err)
+	}
+	defer rows.Close()
+
+	var ringtones []*Ringtone
+	for rows.Next() {
+		ringtone, err := scanRingtone(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ringtone: %w", err)
+		}
+		ringtones = append(ringtones, ringtone)
+	}
+
+	return ringtones, rows.Err()
+}
+
+// EraseJob deletes a job's ringtone records and anonymizes the job row.
+// Progress is recorded against the data request in the same transaction so
+// that an interrupted erasure can resume without double counting. The
+// hashes of blobs that lost their last reference are returned so that the
+// caller can delete their content; they are counted as processed files.
+// A job still in progress is failed with ErasedJobError and its callback
+// token revoked; uploads for it need that token or its deleted ringtone.
+func (s *Store) EraseJob(requestID, jobID string, filesDeleted int) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT blob_hash FROM ringtones WHERE job_id = ? AND blob_hash IS NOT NULL`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ringtone blobs: %w", err)
+	}
+	var blobHashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan ringtone blob: %w", err)
+		}
+		blobHashes = append(blobHashes, hash)
+	}
+	rows.Close()
+
+	var sourceHash sql.NullString
+	if err := tx.QueryRow(`SELECT source_blob_hash FROM jobs WHERE id = ?`, jobID).Scan(&sourceHash); err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get job source: %w", err)
+	}
+	if sourceHash.Valid {
+		blobHashes = append(blobHashes, sourceHash.String)
+	}
+
+	assetHashes, err := deleteRingtoneAssets(tx, `ringtone_id IN (SELECT id FROM ringtones WHERE job_id = ?)`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	blobHashes = append(blobHashes, assetHashes...)
+
+	result, err := tx.Exec(`DELETE FROM ringtones WHERE job_id = ?`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete ringtones: %w", err)
+	}
+	ringtonesDeleted, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count deleted ringtones: %w", err)
+	}
+
+	var released []string
+	for _, hash := range blobHashes {
+		ok, err := releaseBlobRef(tx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			released = append(released, hash)
+		}
+	}
+
+	// Jobs in progress are failed and their callback token revoked, so a
+	// late result cannot bring back a ringtone for the erased user
+	_, err = tx.Exec(`
+		UPDATE jobs
+		SET user_id = NULL, source_url = ?, n8n_payload = NULL,
+			error_message = CASE WHEN status IN (?, ?) THEN ? ELSE NULL END,
+			status = CASE WHEN status IN (?, ?) THEN ? ELSE status END,
+			source_blob_hash = NULL, source_size_bytes = NULL, source_mime_type = NULL,
+			`+revokeCallbackToken+`, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, ErasedSourceURL, StatusQueued, StatusProcessing, ErasedJobError,
+		StatusQueued, StatusProcessing, StatusFailed, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize job: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE data_requests
+		SET jobs_processed = jobs_processed + 1,
+			ringtones_processed = ringtones_processed + ?,
+			files_processed = files_processed + ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, ringtonesDeleted, filesDeleted+len(released), requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record erasure progress: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit erasure: %w", err)
+	}
+
+	return released, nil
+}
+
+// CreateDataRequest creates a new data erasure or export request
+func (s *Store) CreateDataRequest(req *DataRequest) error {
+	query := `
+		INSERT INTO data_requests (id, kind, user_id, subject_hash, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.Exec(query,
+		req.ID,
+		req.Kind,
+		req.UserID,
+		req.SubjectHash,
+		req.Status,
+		req.CreatedAt,
+		req.UpdatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create data request: %w", err)
+	}
+
+	return nil
+}
+
+const dataRequestColumns = `id, kind, user_id, subject_hash, status, jobs_processed, ringtones_processed,
+		files_processed, artifact_path, error_message, created_at, updated_at, completed_at`
+
+// scanDataRequest scans a data request row
+func scanDataRequest(row interface{ Scan(...interface{}) error }) (*DataRequest, error) {
+	req := &DataRequest{}
+	err := row.Scan(
+		&req.ID,
+		&req.Kind,
+		&req.UserID,
+		&req.SubjectHash,
+		&req.Status,
+		&req.JobsProcessed,
+		&req.RingtonesProcessed,
+		&req.FilesProcessed,
+		&req.ArtifactPath,
+		&req.ErrorMessage,
+		&req.CreatedAt,
+		&req.UpdatedAt,
+		&req.CompletedAt,
+	)
+	return req, err
+}
+
+// GetDataRequest retrieves a data request by ID
+func (s *Store) GetDataRequest(id string) (*DataRequest, error) {
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests WHERE id = ?`
+
+	req, err := scanDataRequest(s.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data request: %w", err)
+	}
+
+	return req, nil
+}
+
+// GetOpenDataRequest retrieves an unfinished or failed request of the given
+// kind for a user, so that a repeated request resumes the earlier one
+func (s *Store) GetOpenDataRequest(kind, userID string) (*DataRequest, error) {
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests
+		WHERE kind = ? AND user_id = ? AND status IN (?, ?, ?)
+		ORDER BY created_at
+		LIMIT 1`
+
+	req, err := scanDataRequest(s.db.QueryRow(query, kind, userID, DataRequestPending, DataRequestRunning, DataRequestFailed))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get open data request: %w", err)
+	}
+
+	return req, nil
+}
+
+// ListOpenDataRequests retrieves all unfinished data requests
+func (s *Store) ListOpenDataRequests() ([]*DataRequest, error) {
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests
+		WHERE status IN (?, ?)
+		ORDER BY created_at`
+
+	rows, err := s.db.Query(query, DataRequestPending, DataRequestRunning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data requests: %w", err)
+	}
+	defer rows.Close()
+
+	var reqs []*DataRequest
+	for rows.Next() {
+		req, err := scanDataRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data request: %w", err)
+		}
+		reqs = append(reqs, req)
+	}
+
+	return reqs, rows.Err()
+}
+
+// UpdateDataRequestStatus updates a data request's status
+func (s *Store) UpdateDataRequestStatus(id, status string, errorMessage *string) error {
+	query := `
+		UPDATE data_requests
+		SET status = ?, error_message = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	if _, err := s.db.Exec(query, status, errorMessage, id); err != nil {
+		return fmt.Errorf("failed to update data request status: %w", err)
+	}
+
+	return nil
+}
+
+// SetDataRequestProgress overwrites the progress counters of a data request
+func (s *Store) SetDataRequestProgress(id string, jobs, ringtones, files int) error {
+	query := `
+		UPDATE data_requests
+		SET jobs_processed = ?, ringtones_processed = ?, files_processed = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	if _, err := s.db.Exec(query, jobs, ringtones, files, id); err != nil {
+		return fmt.Errorf("failed to update data request progress: %w", err)
+	}
+
+	return nil
+}
+
+// CompleteDataRequest marks a data request as completed. The user ID is
+// cleared so that the receipt outlives the data it describes.
+func (s *Store) CompleteDataRequest(id string, artifactPath *string) error {
+	query := `
+		UPDATE data_requests
+		SET status = ?, user_id = NULL, artifact_path = ?, error_message = NULL,
+			completed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	if _, err := s.db.Exec(query, DataRequestCompleted, artifactPath, id); err != nil {
+		return fmt.Errorf("failed to complete data request: %w", err)
+	}
+
+	return nil
+}
+
+// Usage summarizes stored ringtone files
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// GetUserUsage returns the bytes and number of ringtone files a user owns.
+// Renditions in additional formats count towards the bytes of their
+// ringtone, and uploaded sources towards the bytes of the user while their
+// jobs keep them. Deduplicated content is counted against every owner.
+func (s *Store) GetUserUsage(userID string) (*Usage, error) {
+	query := `
+		SELECT COALESCE(SUM(r.size_bytes), 0) + (
+			SELECT COALESCE(SUM(a.size_bytes), 0)
+			FROM ringtone_assets a
+			JOIN ringtones ar ON ar.id = a.ringtone_id
+			JOIN jobs aj ON aj.id = ar.job_id
+			WHERE aj.user_id = ? AND a.kind LIKE ?
+		) + (
+			SELECT COALESCE(SUM(sj.source_size_bytes), 0)
+			FROM jobs sj
+			WHERE sj.user_id = ?
+		), COUNT(r.id)
+		FROM ringtones r
+		JOIN jobs j ON j.id = r.job_id
+		WHERE j.user_id = ?
+	`
+
+	usage := &Usage{}
+	if err := s.db.QueryRow(query, userID, assetFormatPrefix+"%", userID, userID).Scan(&usage.Bytes, &usage.Files); err != nil {
+		return nil, fmt.Errorf("failed to get user usage: %w", err)
+	}
+
+	return usage, nil
+}
+
+// CountPendingJobs returns the number of a user's jobs that are still
+// queued or processing
+func (s *Store) CountPendingJobs(userID string) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE user_id = ? AND status IN (?, ?)`,
+		userID, StatusQueued, StatusProcessing).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending jobs: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetStorageUsage returns the physical size and count of stored blobs
+func (s *Store) GetStorageUsage() (*Usage, error) {
+	usage := &Usage{}
+	if err := s.db.QueryRow(`SELECT COALESCE(SUM(size), 0), COUNT(*) FROM blobs`).Scan(&usage.Bytes, &usage.Files); err != nil {
+		return nil, fmt.Errorf("failed to get storage usage: %w", err)
+	}
+
+	return usage, nil
+}
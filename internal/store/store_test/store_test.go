@@ -2,6 +2,7 @@ package store_test
 
 import (
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -91,6 +92,208 @@ func TestStore_UpdateJobStatus(t *testing.T) {
 	assert.Equal(t, errorMsg, *retrieved.ErrorMessage)
 }
 
+func TestStore_StartJob(t *testing.T) {
+	dbPath := "./test_ringtonic.db"
+	defer os.Remove(dbPath)
+
+	database, err := store.New(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	require.NoError(t, database.CreateJob(&store.Job{
+		ID:        "test-job-id",
+		SourceURL: "https://youtube.com/watch?v=test",
+		Status:    store.StatusQueued,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+
+	require.NoError(t, database.SetJobEndpoint("test-job-id", "http://n8n-b:5678/webhook/ringtonic"))
+	started, err := database.StartJob("test-job-id")
+	require.NoError(t, err)
+	assert.True(t, started)
+
+	// A job that has already finished is left alone
+	require.NoError(t, database.UpdateJobStatus("test-job-id", store.StatusFailed, nil))
+	started, err = database.StartJob("test-job-id")
+	require.NoError(t, err)
+	assert.False(t, started)
+
+	retrieved, err := database.GetJob("test-job-id")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusFailed, retrieved.Status)
+	require.NotNil(t, retrieved.N8NEndpoint)
+	assert.Equal(t, "http://n8n-b:5678/webhook/ringtonic", *retrieved.N8NEndpoint)
+}
+
+func TestStore_QueuedJobs(t *testing.T) {
+	dbPath := "./test_ringtonic.db"
+	defer os.Remove(dbPath)
+
+	database, err := store.New(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	hash := "old-hash"
+	for i, job := range []*store.Job{
+		{ID: "job-second", Status: store.StatusQueued, CallbackTokenHash: &hash},
+		{ID: "job-first", Status: store.StatusQueued, CallbackTokenHash: &hash},
+		{ID: "job-running", Status: store.StatusProcessing, CallbackTokenHash: &hash},
+	} {
+		job.SourceURL = "https://youtube.com/watch?v=test"
+		job.CreatedAt = time.Now().Add(-time.Duration(i) * time.Minute)
+		job.UpdatedAt = job.CreatedAt
+		require.NoError(t, database.CreateJob(job))
+	}
+
+	queued, err := database.ListQueuedJobs()
+	require.NoError(t, err)
+	require.Len(t, queued, 2)
+	assert.Equal(t, "job-first", queued[0].ID)
+	assert.Equal(t, "job-second", queued[1].ID)
+
+	// Only queued jobs get a new callback token
+	reset, err := database.ResetCallbackToken("job-first", "new-hash")
+	require.NoError(t, err)
+	assert.True(t, reset)
+	reset, err = database.ResetCallbackToken("job-running", "new-hash")
+	require.NoError(t, err)
+	assert.False(t, reset)
+
+	job, err := database.GetJob("job-first")
+	require.NoError(t, err)
+	assert.Equal(t, "new-hash", *job.CallbackTokenHash)
+	job, err = database.GetJob("job-running")
+	require.NoError(t, err)
+	assert.Equal(t, "old-hash", *job.CallbackTokenHash)
+}
+
+func TestStore_FinishingJobRevokesCallbackToken(t *testing.T) {
+	dbPath := "./test_ringtonic.db"
+	defer os.Remove(dbPath)
+
+	database, err := store.New(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	for _, job := range []*store.Job{
+		{ID: "job-token", CallbackTokenHash: &hash},
+		{ID: "job-failed", CallbackTokenHash: &hash},
+		{ID: "job-legacy"},
+	} {
+		job.SourceURL = "https://youtube.com/watch?v=test"
+		job.Status = store.StatusProcessing
+		job.CreatedAt = time.Now()
+		job.UpdatedAt = time.Now()
+		require.NoError(t, database.CreateJob(job))
+	}
+
+	retrieved, err := database.GetJob("job-token")
+	require.NoError(t, err)
+	require.NotNil(t, retrieved.CallbackTokenHash)
+	assert.Equal(t, hash, *retrieved.CallbackTokenHash)
+
+	// Revoked tokens stay distinguishable from jobs that never had one
+	completed, err := database.CompleteJob(&store.Ringtone{JobID: "job-token", FileName: "a.mp3", FilePath: "a.mp3", Format: "mp3", CreatedAt: time.Now()})
+	require.NoError(t, err)
+	require.True(t, completed)
+	errorMsg := "failed"
+	failed, err := database.FailJob("job-failed", &errorMsg)
+	require.NoError(t, err)
+	require.True(t, failed)
+	completed, err = database.CompleteJob(&store.Ringtone{JobID: "job-legacy", FileName: "b.mp3", FilePath: "b.mp3", Format: "mp3", CreatedAt: time.Now()})
+	require.NoError(t, err)
+	require.True(t, completed)
+
+	for _, id := range []string{"job-token", "job-failed"} {
+		retrieved, err = database.GetJob(id)
+		require.NoError(t, err)
+		require.NotNil(t, retrieved.CallbackTokenHash)
+		assert.Empty(t, *retrieved.CallbackTokenHash)
+	}
+	retrieved, err = database.GetJob("job-failed")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusFailed, retrieved.Status)
+	assert.Equal(t, errorMsg, *retrieved.ErrorMessage)
+	retrieved, err = database.GetJob("job-legacy")
+	require.NoError(t, err)
+	assert.Nil(t, retrieved.CallbackTokenHash)
+
+	// A finished job cannot be failed or completed again
+	failed, err = database.FailJob("job-token", &errorMsg)
+	require.NoError(t, err)
+	assert.False(t, failed)
+	retrieved, err = database.GetJob("job-token")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusCompleted, retrieved.Status)
+}
+
+func TestStore_ConcurrentJobCompletion(t *testing.T) {
+	dbPath := "./test_ringtonic.db"
+	defer os.Remove(dbPath)
+
+	database, err := store.New(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	require.NoError(t, database.CreateJob(&store.Job{
+		ID:        "test-job-id",
+		SourceURL: "https://youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+
+	// Duplicate completions and a failure race; exactly one finishes the job
+	const racers = 8
+	var wg sync.WaitGroup
+	results := make(chan bool, racers)
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var finished bool
+			var err error
+			if i == 0 {
+				errorMsg := "failed"
+				finished, err = database.FailJob("test-job-id", &errorMsg)
+			} else {
+				finished, err = database.CompleteJob(&store.Ringtone{
+					JobID: "test-job-id", FileName: "a.mp3", FilePath: "a.mp3", Format: "mp3", CreatedAt: time.Now(),
+				})
+			}
+			assert.NoError(t, err)
+			results <- finished
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	winners := 0
+	for finished := range results {
+		if finished {
+			winners++
+		}
+	}
+	assert.Equal(t, 1, winners)
+
+	retrieved, err := database.GetJob("test-job-id")
+	require.NoError(t, err)
+	ringtones, err := database.ListRingtonesByJobID("test-job-id")
+	require.NoError(t, err)
+	if retrieved.Status == store.StatusCompleted {
+		assert.Len(t, ringtones, 1)
+	} else {
+		assert.Equal(t, store.StatusFailed, retrieved.Status)
+		assert.Empty(t, ringtones)
+	}
+}
+
 func TestStore_CreateAndGetRingtone(t *testing.T) {
 	// Create temporary database
 	dbPath := "./test_ringtonic.db"
@@ -187,3 +390,270 @@ func TestStore_GetJobStats(t *testing.T) {
 	assert.Equal(t, 1, stats[store.StatusCompleted])
 	assert.Equal(t, 1, stats[store.StatusFailed])
 }
+
+func TestStore_CompleteJob(t *testing.T) {
+	// Create temporary database
+	dbPath := "./test_ringtonic.db"
+	defer os.Remove(dbPath)
+
+	database, err := store.New(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+
+	err = database.Migrate()
+	require.NoError(t, err)
+
+	job := &store.Job{
+		ID:        "test-job-id",
+		SourceURL: "https://youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	require.NoError(t, database.CreateJob(job))
+
+	hash := "abc123"
+	size := int64(5)
+	ringtone := &store.Ringtone{
+		JobID:     "test-job-id",
+		FileName:  "test-job-id.mp3",
+		FilePath:  "blobs/ab/abc123",
+		Format:    "mp3",
+		BlobHash:  &hash,
+		SizeBytes: &size,
+		CreatedAt: time.Now(),
+	}
+
+	// The ringtone and the job status are recorded together
+	completed, err := database.CompleteJob(ringtone)
+	require.NoError(t, err)
+	assert.True(t, completed)
+	assert.NotZero(t, ringtone.ID)
+
+	retrieved, err := database.GetJob("test-job-id")
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusCompleted, retrieved.Status)
+
+	refs, err := database.GetBlobRefCount(hash)
+	require.NoError(t, err)
+	assert.Equal(t, 1, refs)
+
+	// A finished job cannot be completed again
+	duplicate := *ringtone
+	completed, err = database.CompleteJob(&duplicate)
+	require.NoError(t, err)
+	assert.False(t, completed)
+
+	refs, err = database.GetBlobRefCount(hash)
+	require.NoError(t, err)
+	assert.Equal(t, 1, refs)
+}
+
+func TestStore_JobSource(t *testing.T) {
+	// Create temporary database
+	dbPath := "./test_ringtonic.db"
+	defer os.Remove(dbPath)
+
+	database, err := store.New(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+
+	err = database.Migrate()
+	require.NoError(t, err)
+
+	hash := "def456"
+	size := int64(1024)
+	mimeType := "audio/wav"
+	job := &store.Job{
+		ID:              "test-job-id",
+		SourceURL:       "http://backend:8080/api/v1/sources/test-job-id",
+		Status:          store.StatusQueued,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+		SourceBlobHash:  &hash,
+		SourceSizeBytes: &size,
+		SourceMIMEType:  &mimeType,
+	}
+	require.NoError(t, database.CreateJob(job))
+
+	retrieved, err := database.GetJob("test-job-id")
+	require.NoError(t, err)
+	require.NotNil(t, retrieved.SourceBlobHash)
+	assert.Equal(t, hash, *retrieved.SourceBlobHash)
+	assert.Equal(t, size, *retrieved.SourceSizeBytes)
+	assert.Equal(t, mimeType, *retrieved.SourceMIMEType)
+
+	// The job holds a reference to its source
+	refs, err := database.GetBlobRefCount(hash)
+	require.NoError(t, err)
+	assert.Equal(t, 1, refs)
+
+	sources, err := database.ListJobSources()
+	require.NoError(t, err)
+	assert.Equal(t, []string{hash}, sources)
+
+	// Erasure releases the source
+	released, err := database.EraseJob("test-request", "test-job-id", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{hash}, released)
+
+	retrieved, err = database.GetJob("test-job-id")
+	require.NoError(t, err)
+	assert.Nil(t, retrieved.SourceBlobHash)
+
+	sources, err = database.ListJobSources()
+	require.NoError(t, err)
+	assert.Empty(t, sources)
+}
+
+func TestStore_JobSourceRetention(t *testing.T) {
+	dbPath := "./test_ringtonic.db"
+	defer os.Remove(dbPath)
+
+	database, err := store.New(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	userID := "uploader"
+	hash := "def456"
+	size := int64(1024)
+	require.NoError(t, database.CreateJob(&store.Job{
+		ID:              "test-job-id",
+		SourceURL:       "http://backend:8080/api/v1/sources/test-job-id",
+		UserID:          &userID,
+		Status:          store.StatusProcessing,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+		SourceBlobHash:  &hash,
+		SourceSizeBytes: &size,
+	}))
+
+	// Sources count towards their user's usage
+	usage, err := database.GetUserUsage(userID)
+	require.NoError(t, err)
+	assert.Equal(t, size, usage.Bytes)
+	assert.Equal(t, 0, usage.Files)
+
+	// Sources of pending jobs are kept
+	expired, err := database.ListExpiredJobSources(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	dropped, err := database.ExpireJobSource("test-job-id")
+	require.NoError(t, err)
+	assert.False(t, dropped)
+
+	// Once the job finishes, its source expires after the cutoff
+	failed, err := database.FailJob("test-job-id", nil)
+	require.NoError(t, err)
+	require.True(t, failed)
+	expired, err = database.ListExpiredJobSources(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	expired, err = database.ListExpiredJobSources(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test-job-id"}, expired)
+
+	dropped, err = database.ExpireJobSource("test-job-id")
+	require.NoError(t, err)
+	assert.True(t, dropped)
+	dropped, err = database.ExpireJobSource("test-job-id")
+	require.NoError(t, err)
+	assert.False(t, dropped)
+
+	refs, err := database.GetBlobRefCount(hash)
+	require.NoError(t, err)
+	assert.Equal(t, 0, refs)
+	sources, err := database.ListJobSources()
+	require.NoError(t, err)
+	assert.Empty(t, sources)
+	usage, err = database.GetUserUsage(userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), usage.Bytes)
+}
+
+func TestStore_RingtoneAssets(t *testing.T) {
+	// Create temporary database
+	dbPath := "./test_ringtonic.db"
+	defer os.Remove(dbPath)
+
+	database, err := store.New(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+
+	err = database.Migrate()
+	require.NoError(t, err)
+
+	require.NoError(t, database.CreateJob(&store.Job{
+		ID:        "test-job-id",
+		SourceURL: "https://youtube.com/watch?v=test",
+		Status:    store.StatusProcessing,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}))
+	ringtone := &store.Ringtone{
+		JobID:     "test-job-id",
+		FileName:  "test-job-id.mp3",
+		FilePath:  "test-job-id.mp3",
+		Format:    "mp3",
+		CreatedAt: time.Now(),
+	}
+	completed, err := database.CompleteJob(ringtone)
+	require.NoError(t, err)
+	require.True(t, completed)
+
+	preview := func(hash string, expiresAt *time.Time) *store.RingtoneAsset {
+		return &store.RingtoneAsset{
+			RingtoneID: ringtone.ID,
+			Kind:       store.AssetPreview,
+			FileName:   "test-job-id-preview.mp3",
+			FilePath:   "blobs/" + hash,
+			BlobHash:   hash,
+			SizeBytes:  3,
+			CreatedAt:  time.Now(),
+			ExpiresAt:  expiresAt,
+		}
+	}
+
+	// A new preview replaces the old one and takes over its reference
+	require.NoError(t, database.PutRingtoneAsset(preview("first", nil)))
+	require.NoError(t, database.PutRingtoneAsset(preview("second", nil)))
+
+	asset, err := database.GetRingtoneAsset(ringtone.ID, store.AssetPreview)
+	require.NoError(t, err)
+	require.NotNil(t, asset)
+	assert.Equal(t, "second", asset.BlobHash)
+
+	refs, err := database.GetBlobRefCount("first")
+	require.NoError(t, err)
+	assert.Equal(t, 0, refs)
+	refs, err = database.GetBlobRefCount("second")
+	require.NoError(t, err)
+	assert.Equal(t, 1, refs)
+
+	// Expired previews are hidden, then dropped
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, database.PutRingtoneAsset(preview("third", &past)))
+
+	asset, err = database.GetRingtoneAsset(ringtone.ID, store.AssetPreview)
+	require.NoError(t, err)
+	assert.Nil(t, asset)
+
+	expired, err := database.ExpireRingtoneAssets(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	refs, err = database.GetBlobRefCount("third")
+	require.NoError(t, err)
+	assert.Equal(t, 0, refs)
+
+	// Erasure releases previews with their ringtone
+	require.NoError(t, database.PutRingtoneAsset(preview("fourth", nil)))
+	released, err := database.EraseJob("test-request", "test-job-id", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fourth"}, released)
+
+	assets, err := database.ListRingtoneAssets()
+	require.NoError(t, err)
+	assert.Empty(t, assets)
+}
@@ -12,7 +12,9 @@ YELLOW='\033[1;33m'
 NC='\033[0m' # No Color
 
 BASE_URL="http://localhost:8080"
-WEBHOOK_SECRET="your-secure-secret-here"
+WEBHOOK_SECRET="${N8N_WEBHOOK_SECRET:-your-secure-secret-here}"
+# The callback token is only sent to n8n, so take it from the webhook payload
+CALLBACK_TOKEN="${CALLBACK_TOKEN:-}"
 
 # Function to print colored output
 print_status() {
@@ -115,18 +117,19 @@ test_n8n_callback() {
     # Create a dummy file for testing
     echo "dummy audio content" > "./storage/${job_id}.mp3"
     
-    response=$(curl -s -w "%{http_code}" -X POST "$BASE_URL/api/v1/n8n-callback" \
+    # Callbacks are signed like n8n's: HMAC-SHA256 over "{timestamp}.{nonce}.{body}"
+    body="{\"schema_version\":1,\"job_id\":\"$job_id\",\"status\":\"completed\",\"file_path\":\"${job_id}.mp3\",\"metadata\":{\"duration\":20,\"file_size\":1024}}"
+    ts=$(date +%s)
+    nonce=$(openssl rand -hex 16)
+    sig=$(printf '%s.%s.%s' "$ts" "$nonce" "$body" | openssl dgst -sha256 -hmac "$WEBHOOK_SECRET" | sed 's/^.* //')
+
+    response=$(curl -s -w "%{http_code}" -X POST "$BASE_URL/api/v1/n8n-callback/$job_id" \
         -H "Content-Type: application/json" \
-        -H "X-Webhook-Token: $WEBHOOK_SECRET" \
-        -d "{
-            \"job_id\": \"$job_id\",
-            \"status\": \"completed\",
-            \"file_path\": \"${job_id}.mp3\",
-            \"metadata\": {
-                \"duration\": 20,
-                \"file_size\": 1024
-            }
-        }")
+        -H "X-Webhook-Timestamp: $ts" \
+        -H "X-Webhook-Nonce: $nonce" \
+        -H "X-Webhook-Signature: sha256=$sig" \
+        -H "X-Callback-Token: $CALLBACK_TOKEN" \
+        -d "$body")
     
     http_code="${response: -3}"
     if [[ "$http_code" == "200" ]]; then